@@ -0,0 +1,56 @@
+package gofast
+
+import (
+	"net"
+	"strconv"
+	"strings"
+)
+
+// ParseAddress parses an address string into network and address that
+// fits the use of net.Dial or net.Listen (e.g. SimpleConnFactory).
+//
+// Supported formats:
+//  "unix:/path/to/sock"              unix socket
+//  "unix:@name" or "@name"           Linux abstract unix socket
+//  "tcp:127.0.0.1:9000"              tcp address
+//  "127.0.0.1:9000" or "[::1]:9000"  tcp address
+//  "9000"                            tcp port on all interfaces
+//  "/path/to/sock" (or others)       unix socket
+//
+// Abstract unix socket address may also begin with a NUL byte instead of
+// "@". It is always returned in the "@name" form, which net package
+// understands on Linux.
+func ParseAddress(s string) (network, address string) {
+	switch {
+	case strings.HasPrefix(s, "unix:"):
+		return "unix", normalizeUnixAddress(strings.TrimPrefix(s[len("unix:"):], "//"))
+	case strings.HasPrefix(s, "tcp:"):
+		return "tcp", strings.TrimPrefix(s[len("tcp:"):], "//")
+	case IsAbstractUnixAddress(s):
+		return "unix", normalizeUnixAddress(s)
+	}
+	if _, err := strconv.ParseUint(s, 10, 16); err == nil {
+		return "tcp", ":" + s
+	}
+	if _, port, err := net.SplitHostPort(s); err == nil {
+		if _, err := strconv.ParseUint(port, 10, 16); err == nil {
+			return "tcp", s
+		}
+	}
+	return "unix", s
+}
+
+// IsAbstractUnixAddress reports if the address is in the Linux abstract
+// unix socket namespace (i.e. begins with "@" or NUL byte).
+func IsAbstractUnixAddress(address string) bool {
+	return len(address) > 1 && (address[0] == '@' || address[0] == 0)
+}
+
+// normalizeUnixAddress converts the NUL byte prefixed abstract
+// unix socket address to the "@" prefixed form
+func normalizeUnixAddress(address string) string {
+	if len(address) > 1 && address[0] == 0 {
+		return "@" + address[1:]
+	}
+	return address
+}
@@ -0,0 +1,70 @@
+package gofast_test
+
+import (
+	"net"
+	"runtime"
+	"testing"
+
+	"github.com/yookoala/gofast"
+)
+
+func TestParseAddress(t *testing.T) {
+	tests := []struct {
+		input   string
+		network string
+		address string
+	}{
+		{"unix:/run/php/php-fpm.sock", "unix", "/run/php/php-fpm.sock"},
+		{"unix:///run/php/php-fpm.sock", "unix", "/run/php/php-fpm.sock"},
+		{"unix:@php-fpm", "unix", "@php-fpm"},
+		{"@php-fpm", "unix", "@php-fpm"},
+		{"\x00php-fpm", "unix", "@php-fpm"},
+		{"tcp:127.0.0.1:9000", "tcp", "127.0.0.1:9000"},
+		{"tcp://127.0.0.1:9000", "tcp", "127.0.0.1:9000"},
+		{"127.0.0.1:9000", "tcp", "127.0.0.1:9000"},
+		{"[::1]:9000", "tcp", "[::1]:9000"},
+		{"php:9000", "tcp", "php:9000"},
+		{"9000", "tcp", ":9000"},
+		{"/run/php/php-fpm.sock", "unix", "/run/php/php-fpm.sock"},
+		{"php-fpm.sock", "unix", "php-fpm.sock"},
+	}
+	for _, test := range tests {
+		network, address := gofast.ParseAddress(test.input)
+		if want, have := test.network, network; want != have {
+			t.Errorf("%#v: expected %#v, got %#v", test.input, want, have)
+		}
+		if want, have := test.address, address; want != have {
+			t.Errorf("%#v: expected %#v, got %#v", test.input, want, have)
+		}
+	}
+}
+
+func TestSimpleConnFactory_abstract(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("abstract unix socket is only available on Linux")
+	}
+
+	l, err := net.Listen(gofast.ParseAddress("@gofast-test-abstract"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	for _, address := range []string{"@gofast-test-abstract", "\x00gofast-test-abstract"} {
+		conn, err := gofast.SimpleConnFactory("unix", address)()
+		if err != nil {
+			t.Errorf("%#v: unexpected error: %s", address, err)
+			continue
+		}
+		conn.Close()
+	}
+}
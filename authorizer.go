@@ -2,6 +2,7 @@ package gofast
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -63,10 +64,23 @@ type Authorizer struct {
 	sessionHandler SessionHandler
 }
 
+// authCtxKey is the type of the context keys of Authorizer
+type authCtxKey int
+
+const ctxKeyAuthVariables authCtxKey = iota
+
 // Wrap method is a generic http.Handler middleware. Requests
 // to wrapped hander would go through the fastcgi authorizer
 // first. If not authorized, the request will not reach wrapped
 // hander.
+//
+// If authorized (status 200), the "Variable-*" headers of the
+// authorizer response are passed to the wrapped handler as the
+// request headers without the prefix (e.g. "Variable-User" as
+// "User"). They are also available as AuthVariables, and may be
+// mapped to the params of a FastCGI responder with MapAuthVariables.
+// Otherwise, the authorizer response (e.g. 403 Forbidden) is
+// the response to the client.
 func (ar Authorizer) Wrap(inner http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
@@ -83,16 +97,17 @@ func (ar Authorizer) Wrap(inner http.Handler) http.Handler {
 		c, err := ar.clientFactory()
 		if err != nil {
 			w.Header().Add("Content-Type", "text/html; charset=utf8")
-			w.WriteHeader(http.StatusInternalServerError)
+			w.WriteHeader(errorStatus(err, http.StatusInternalServerError))
 			fmt.Fprintf(w, "unable to connect to authorizer: %s", err)
 			return
 		}
+		defer c.Close()
 
 		// make request with client
 		resp, err := ar.sessionHandler(c, req)
 		if err != nil {
 			w.Header().Add("Content-Type", "text/html; charset=utf8")
-			w.WriteHeader(http.StatusInternalServerError)
+			w.WriteHeader(errorStatus(err, http.StatusInternalServerError))
 			fmt.Fprintf(w, "error with authorizer request: %s", err)
 			return
 		}
@@ -105,8 +120,13 @@ func (ar Authorizer) Wrap(inner http.Handler) http.Handler {
 			fmt.Fprint(w, http.StatusText(http.StatusInternalServerError))
 			return
 		}
+		if ew.Len() > 0 {
+			log.Printf("gofast: error stream from application process %s",
+				ew.String())
+		}
 
-		// if code is not http.StatusOK (200)
+		// if code is not http.StatusOK (200),
+		// respond with the authorizer response
 		if rw.Code != http.StatusOK {
 			// copy header map
 			for k, m := range rw.Header() {
@@ -116,35 +136,61 @@ func (ar Authorizer) Wrap(inner http.Handler) http.Handler {
 			}
 			w.WriteHeader(rw.Code)
 			fmt.Fprint(w, rw.Body.String())
-
-			// if error stream is not empty
-			// also write to response
-			// TODO: add option to suppress this?
-			if ew.Len() > 0 {
-				w.Header().Add("Content-Type", "text/html; charset=utf8")
-				w.WriteHeader(http.StatusInternalServerError)
-				fmt.Fprintf(w, "error reading authorizer response: %s", err)
-				log.Printf("gofast: error stream from application process %s",
-					ew.String())
-				return
-			}
 			return
 		}
 
 		// no problem from authorizer
 		// pass down variable to the inner handler
 		// and discard the authorizer stdout and stderr
+		innerReq.Header = make(http.Header, len(r.Header))
+		for k, m := range r.Header {
+			innerReq.Header[k] = append([]string(nil), m...)
+		}
+		variables := make(map[string]string)
 		for k, m := range rw.Header() {
 			// looking for header with keys "Variable-*"
 			// strip the prefix and pass to the inner header
 			if len(k) > 9 && strings.HasPrefix(strings.ToLower(k), "variable-") {
 				innerKey := k[9:]
 				for _, v := range m {
-					log.Printf("k: %s, innerKey: %s, v: %s", k, innerKey, v)
 					innerReq.Header.Add(innerKey, v)
 				}
+				variables[authVariableName(innerKey)] = m[0]
 			}
 		}
-		inner.ServeHTTP(w, innerReq)
+		ctx := context.WithValue(innerReq.Context(), ctxKeyAuthVariables, variables)
+		inner.ServeHTTP(w, innerReq.WithContext(ctx))
 	})
 }
+
+// authVariableName returns the name of the variable of the
+// header name, upper-cased with "-" replaced by "_"
+// (e.g. "Remote-User" as "REMOTE_USER")
+func authVariableName(name string) string {
+	return strings.Replace(strings.ToUpper(name), "-", "_", -1)
+}
+
+// AuthVariables returns the variables of the "Variable-*" headers of
+// the authorizer response, if the request is authorized by Authorizer.
+// The names are upper-cased with "-" replaced by "_" (e.g.
+// "Variable-Remote-User" as "REMOTE_USER").
+func AuthVariables(r *http.Request) map[string]string {
+	if variables, ok := r.Context().Value(ctxKeyAuthVariables).(map[string]string); ok {
+		return variables
+	}
+	return nil
+}
+
+// MapAuthVariables implements Middleware. It maps the variables of the
+// Authorizer (see AuthVariables) to fastcgi parameters of the same
+// names, as the FastCGI specification requires of the web server, so a
+// responder behind the Authorizer sees them (e.g. $_SERVER["REMOTE_USER"]
+// of PHP).
+func MapAuthVariables(inner SessionHandler) SessionHandler {
+	return func(client Client, req *Request) (*ResponsePipe, error) {
+		for k, v := range AuthVariables(req.Raw) {
+			req.Params[k] = v
+		}
+		return inner(client, req)
+	}
+}
@@ -1,12 +1,17 @@
 package gofast_test
 
 import (
+	"context"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 
 	"github.com/yookoala/gofast"
+	"github.com/yookoala/gofast/fcgitest"
 )
 
 func TestNewAuthRequest(t *testing.T) {
@@ -58,3 +63,73 @@ func TestNewAuthRequest(t *testing.T) {
 		t.Errorf("expected: %#v, got %#v", want, have)
 	}
 }
+
+func TestAuthorizer_Wrap(t *testing.T) {
+	auth := fcgitest.NewServer(func(ctx context.Context, req *gofast.Request, stdout, stderr io.Writer) int {
+		if req.Params["HTTP_AUTHORIZATION"] != "Bearer secret" {
+			return fcgitest.Reply(fcgitest.Status(http.StatusForbidden), fcgitest.Body("forbidden"))(ctx, req, stdout, stderr)
+		}
+		return fcgitest.Reply(fcgitest.Header("Variable-Remote-User", "alice"))(ctx, req, stdout, stderr)
+	})
+	defer auth.Close()
+	authorizer := gofast.NewAuthorizer(auth.ClientFactory(), gofast.NewAuthPrepare()(gofast.BasicSession))
+
+	var innerReq *http.Request
+	h := authorizer.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		innerReq = r
+		fmt.Fprint(w, "welcome")
+	}))
+
+	// authorized
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if want, have := "welcome", w.Body.String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if innerReq == nil {
+		t.Fatalf("expected the inner handler to be called")
+	}
+	if want, have := "alice", innerReq.Header.Get("Remote-User"); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "alice", gofast.AuthVariables(innerReq)["REMOTE_USER"]; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "", r.Header.Get("Remote-User"); want != have {
+		t.Errorf("expected the original request unchanged, got %#v", have)
+	}
+
+	// forbidden
+	innerReq = nil
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if want, have := http.StatusForbidden, w.Code; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "forbidden", w.Body.String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if innerReq != nil {
+		t.Errorf("expected the inner handler not to be called")
+	}
+}
+
+func TestMapAuthVariables(t *testing.T) {
+	auth := fcgitest.NewServer(fcgitest.Reply(fcgitest.Header("Variable-Remote-User", "alice")))
+	defer auth.Close()
+	app := fcgitest.NewServer(fcgitest.Reply(fcgitest.Body("hello")))
+	defer app.Close()
+
+	authorizer := gofast.NewAuthorizer(auth.ClientFactory(), gofast.NewAuthPrepare()(gofast.BasicSession))
+	h := authorizer.Wrap(gofast.NewHandler(
+		gofast.Chain(gofast.BasicParamsMap, gofast.MapAuthVariables)(gofast.BasicSession),
+		app.ClientFactory(),
+	))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	if want, have := "alice", app.LastRequest().Params["REMOTE_USER"]; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
@@ -0,0 +1,285 @@
+package gofast
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// defaultEjectTime is the default EjectTime of MultiClientFactory
+const defaultEjectTime = 10 * time.Second
+
+// Backend is a FastCGI application of a MultiClientFactory
+type Backend struct {
+	Network string
+	Address string
+
+	// Weight of the backend for the Weighted strategy. 1 if 0.
+	Weight int
+}
+
+// BackendStatus is the status of a backend of a MultiClientFactory
+type BackendStatus struct {
+	Backend
+
+	// Outstanding is the number of the clients of the backend
+	// created and not closed yet
+	Outstanding int
+
+	// Ejected is true if the backend failed to connect (or
+	// the probe) recently, and is not picked until EjectTime
+	// passed
+	Ejected bool
+}
+
+// BalanceStrategy picks the backend for a new client. It returns the
+// index of the backend in the given backends, which are the ones not
+// ejected (or all of them, if all are ejected). The strategy is called
+// by one goroutine at a time.
+type BalanceStrategy func(backends []BackendStatus) int
+
+// RoundRobin returns a BalanceStrategy which picks the backends in turn
+func RoundRobin() BalanceStrategy {
+	var next int
+	return func(backends []BackendStatus) int {
+		i := next % len(backends)
+		next++
+		return i
+	}
+}
+
+// LeastOutstanding returns a BalanceStrategy which picks the backend
+// with the fewest outstanding clients. The backends of the same number
+// are picked in turn.
+func LeastOutstanding() BalanceStrategy {
+	var next int
+	return func(backends []BackendStatus) int {
+		least := -1
+		for n := range backends {
+			i := (next + n) % len(backends)
+			if least < 0 || backends[i].Outstanding < backends[least].Outstanding {
+				least = i
+			}
+		}
+		next = least + 1
+		return least
+	}
+}
+
+// Weighted returns a BalanceStrategy which picks the backends in turn
+// in proportion to the Weight, evenly spread (i.e. the smooth weighted
+// round-robin of nginx). The turns start over when the backends
+// given change (e.g. a backend is ejected or back).
+func Weighted() BalanceStrategy {
+	current, keys := make(map[string]int), ""
+	return func(backends []BackendStatus) int {
+		var k string
+		for _, b := range backends {
+			k += b.key() + "\n"
+		}
+		if k != keys {
+			current, keys = make(map[string]int), k
+		}
+		picked, total := 0, 0
+		for i, b := range backends {
+			current[b.key()] += weightOf(b.Backend)
+			total += weightOf(b.Backend)
+			if current[b.key()] > current[backends[picked].key()] {
+				picked = i
+			}
+		}
+		current[backends[picked].key()] -= total
+		return picked
+	}
+}
+
+// key identifies the backend
+func (b Backend) key() string {
+	return b.Network + ":" + b.Address
+}
+
+func weightOf(b Backend) int {
+	if b.Weight <= 0 {
+		return 1
+	}
+	return b.Weight
+}
+
+// NewMultiClientFactory returns a *MultiClientFactory of the backends,
+// picked by the strategy (RoundRobin if nil). The clients of a backend
+// are of SimpleClientFactory with the options.
+func NewMultiClientFactory(backends []Backend, strategy BalanceStrategy, options ...ClientOption) *MultiClientFactory {
+	if strategy == nil {
+		strategy = RoundRobin()
+	}
+	m := &MultiClientFactory{strategy: strategy}
+	for _, b := range backends {
+		m.backends = append(m.backends, &backendState{
+			Backend:       b,
+			clientFactory: SimpleClientFactory(SimpleConnFactory(b.Network, b.Address), options...),
+		})
+	}
+	return m
+}
+
+// MultiClientFactory creates clients of multiple FastCGI applications
+// (e.g. php-fpm pools on different hosts), balanced by a
+// BalanceStrategy. Use its CreateClient as the ClientFactory of a
+// Handler or a ClientPool.
+//
+// If a backend fails to connect, it is ejected for EjectTime and the
+// client is created with another backend instead. The backend is tried
+// again after EjectTime, and probed with a FCGI_GET_VALUES round-trip
+// on a connection of its own before the client is created, so a backend
+// accepting connections but not serving (e.g. hung) is ejected again.
+// Only the backends ejected are probed. The errors of the requests
+// (e.g. FCGI_OVERLOADED) do not eject the backends.
+type MultiClientFactory struct {
+
+	// EjectTime is the duration a backend is ejected after
+	// failing to connect. 10 seconds if 0.
+	EjectTime time.Duration
+
+	// Clock of the ejection. SystemClock if nil.
+	Clock Clock
+
+	strategy BalanceStrategy
+	mutex    sync.Mutex
+	backends []*backendState
+}
+
+// backendState is the state of a backend of MultiClientFactory
+type backendState struct {
+	Backend
+	clientFactory ClientFactory
+	outstanding   int
+	ejectedUntil  time.Time
+}
+
+// CreateClient implements ClientFactory
+func (m *MultiClientFactory) CreateClient() (Client, error) {
+	tried := make(map[*backendState]bool, len(m.backends))
+	err := error(&wrapError{ErrDial, fmt.Errorf("no backend to connect")})
+	for {
+		b, probe := m.pick(tried)
+		if b == nil {
+			return nil, err
+		}
+		tried[b] = true
+		var c Client
+		if c, err = b.clientFactory(); err != nil {
+			m.eject(b)
+			continue
+		}
+		if probe {
+			// the client probed is not handed out, as the application
+			// may close the connection after answering (e.g. php-fpm)
+			healthy := isHealthy(c)
+			c.Close()
+			if !healthy {
+				err = &wrapError{ErrDial, fmt.Errorf("backend %s failed the probe", b.key())}
+				m.eject(b)
+				continue
+			}
+			if c, err = b.clientFactory(); err != nil {
+				m.eject(b)
+				continue
+			}
+			m.readmit(b)
+		}
+		return &multiClient{Client: c, release: func() { m.release(b) }}, nil
+	}
+}
+
+// Status returns the status of the backends
+func (m *MultiClientFactory) Status() []BackendStatus {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	now := clockOrSystem(m.Clock).Now()
+	status := make([]BackendStatus, len(m.backends))
+	for i, b := range m.backends {
+		status[i] = BackendStatus{b.Backend, b.outstanding, now.Before(b.ejectedUntil)}
+	}
+	return status
+}
+
+// pick picks a backend not tried yet with the strategy, and counts
+// the client as outstanding. Returns nil if all backends are tried.
+// Returns probe if the backend picked was ejected.
+func (m *MultiClientFactory) pick(tried map[*backendState]bool) (b *backendState, probe bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	now := clockOrSystem(m.Clock).Now()
+	var healthy, ejected []*backendState
+	for _, b := range m.backends {
+		switch {
+		case tried[b]:
+		case now.Before(b.ejectedUntil):
+			ejected = append(ejected, b)
+		default:
+			healthy = append(healthy, b)
+		}
+	}
+	if len(healthy) == 0 {
+		// try the ejected ones rather than failing
+		healthy = ejected
+	}
+	if len(healthy) == 0 {
+		return nil, false
+	}
+	status := make([]BackendStatus, len(healthy))
+	for i, b := range healthy {
+		status[i] = BackendStatus{b.Backend, b.outstanding, now.Before(b.ejectedUntil)}
+	}
+	b = healthy[m.strategy(status)]
+	b.outstanding++
+	return b, !b.ejectedUntil.IsZero()
+}
+
+// eject ejects the backend failed to connect
+func (m *MultiClientFactory) eject(b *backendState) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	ejectTime := m.EjectTime
+	if ejectTime <= 0 {
+		ejectTime = defaultEjectTime
+	}
+	b.outstanding--
+	b.ejectedUntil = clockOrSystem(m.Clock).Now().Add(ejectTime)
+}
+
+// readmit ends the ejection of the backend passed the probe
+func (m *MultiClientFactory) readmit(b *backendState) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	b.ejectedUntil = time.Time{}
+}
+
+// release counts the client of the backend as closed
+func (m *MultiClientFactory) release(b *backendState) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	b.outstanding--
+}
+
+// multiClient is a client of MultiClientFactory,
+// which counts the outstanding clients of the backend
+type multiClient struct {
+	Client
+	once    sync.Once
+	release func()
+}
+
+// Close implements Client.Close
+func (c *multiClient) Close() error {
+	c.once.Do(c.release)
+	return c.Client.Close()
+}
+
+// BackendLimits implements LimitsReporter, if the inner client does
+func (c *multiClient) BackendLimits() (BackendLimits, error) {
+	if lr, ok := c.Client.(LimitsReporter); ok {
+		return lr.BackendLimits()
+	}
+	return BackendLimits{}, errNoLimits
+}
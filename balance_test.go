@@ -0,0 +1,227 @@
+package gofast_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/yookoala/gofast"
+	"github.com/yookoala/gofast/fcgitest"
+)
+
+// pickAll returns the addresses picked by the strategy n times
+func pickAll(strategy gofast.BalanceStrategy, backends []gofast.BackendStatus, n int) (picked string) {
+	for i := 0; i < n; i++ {
+		picked += backends[strategy(backends)].Address
+	}
+	return
+}
+
+func TestRoundRobin(t *testing.T) {
+	backends := []gofast.BackendStatus{
+		{Backend: gofast.Backend{Address: "a"}},
+		{Backend: gofast.Backend{Address: "b"}},
+		{Backend: gofast.Backend{Address: "c"}},
+	}
+	if want, have := "abcabca", pickAll(gofast.RoundRobin(), backends, 7); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestLeastOutstanding(t *testing.T) {
+	backends := []gofast.BackendStatus{
+		{Backend: gofast.Backend{Address: "a"}, Outstanding: 2},
+		{Backend: gofast.Backend{Address: "b"}, Outstanding: 1},
+		{Backend: gofast.Backend{Address: "c"}, Outstanding: 1},
+	}
+	if want, have := "bcbc", pickAll(gofast.LeastOutstanding(), backends, 4); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestWeighted(t *testing.T) {
+	backends := []gofast.BackendStatus{
+		{Backend: gofast.Backend{Address: "a", Weight: 5}},
+		{Backend: gofast.Backend{Address: "b", Weight: 1}},
+		{Backend: gofast.Backend{Address: "c", Weight: 1}},
+	}
+	if want, have := "aabacaaaabacaa", pickAll(gofast.Weighted(), backends, 14); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	// the turns start over when the backends change
+	strategy := gofast.Weighted()
+	pickAll(strategy, backends, 3)
+	pickAll(strategy, backends[1:], 1)
+	if want, have := "aabacaaaabacaa", pickAll(strategy, backends, 14); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+// serveCloseOnGetValues serves the connections of the listener with the
+// server, except the ones of FCGI_GET_VALUES, which are answered then
+// closed, as php-fpm does
+func serveCloseOnGetValues(s *gofast.Server, l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			h := make([]byte, 8)
+			if _, err := io.ReadFull(conn, h); err != nil {
+				conn.Close()
+				return
+			}
+			b := make([]byte, int(binary.BigEndian.Uint16(h[4:]))+int(h[6]))
+			if _, err := io.ReadFull(conn, b); err != nil {
+				conn.Close()
+				return
+			}
+			if h[1] == fcgitest.TypeGetValues {
+				conn.Write(fcgitest.Record(fcgitest.TypeGetValuesResult, 0, nil))
+				conn.Close()
+				return
+			}
+			s.ServeConn(struct {
+				io.Reader
+				io.WriteCloser
+			}{io.MultiReader(bytes.NewReader(append(h, b...)), conn), conn})
+		}()
+	}
+}
+
+func TestMultiClientFactory(t *testing.T) {
+	newBackend := func(name string) *fcgitest.Server {
+		return fcgitest.NewUnixServer(func(ctx context.Context, req *gofast.Request, stdout, stderr io.Writer) int {
+			fmt.Fprintf(stdout, "Content-Type: text/plain\r\n\r\n%s", name)
+			return 0
+		})
+	}
+	a, b := newBackend("a"), newBackend("b")
+	defer a.Close()
+	defer b.Close()
+	down := filepath.Join(filepath.Dir(a.Address), "down.sock")
+
+	clock := fcgitest.NewFakeClock(time.Now())
+	m := gofast.NewMultiClientFactory([]gofast.Backend{
+		{Network: a.Network, Address: a.Address},
+		{Network: "unix", Address: down},
+		{Network: b.Network, Address: b.Address},
+	}, gofast.RoundRobin())
+	m.EjectTime, m.Clock = time.Minute, clock
+
+	do := func() string {
+		c, err := m.CreateClient()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		defer c.Close()
+		resp, err := c.Do(gofast.NewRequest(nil))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		w := httptest.NewRecorder()
+		resp.WriteTo(w, ioutil.Discard)
+		return w.Body.String()
+	}
+
+	// the client of the backend down is created with
+	// the next pick instead, and the backend is ejected
+	var have string
+	for i := 0; i < 4; i++ {
+		have += do()
+	}
+	if want := "aaba"; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	status := m.Status()
+	if want, have := true, status[1].Ejected; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	for _, s := range status {
+		if want, have := 0, s.Outstanding; want != have {
+			t.Errorf("expected %#v, got %#v", want, have)
+		}
+	}
+
+	// the backend is tried again after the eject time
+	clock.Advance(2 * time.Minute)
+	if want, have := false, m.Status()[1].Ejected; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	for i := 0; i < 3; i++ {
+		do()
+	}
+	if want, have := true, m.Status()[1].Ejected; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	// the backend accepting connections but not serving
+	// fails the probe, and is ejected again
+	l, err := net.Listen("unix", down)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	clock.Advance(2 * time.Minute)
+	have = ""
+	for i := 0; i < 3; i++ {
+		have += do()
+	}
+	if want := "aba"; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := true, m.Status()[1].Ejected; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	l.Close()
+
+	// the backend serving passes the probe, and
+	// closes the connection of it as php-fpm does
+	l, err = net.Listen("unix", down)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer l.Close()
+	s := gofast.NewServer(func(ctx context.Context, req *gofast.Request, stdout, stderr io.Writer) int {
+		fmt.Fprintf(stdout, "Content-Type: text/plain\r\n\r\nc")
+		return 0
+	})
+	go serveCloseOnGetValues(s, l)
+	defer s.Close()
+	clock.Advance(2 * time.Minute)
+	have = ""
+	for i := 0; i < 3; i++ {
+		have += do()
+	}
+	if want := "cba"; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := false, m.Status()[1].Ejected; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	// all backends down
+	a.Close()
+	b.Close()
+	if _, err := m.CreateClient(); err == nil {
+		t.Errorf("expected error with all backends down")
+	}
+}
@@ -0,0 +1,54 @@
+package gofast_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/yookoala/gofast"
+)
+
+// BenchmarkRoundTrip measures a request through the Handler to an
+// application of gofast.Server over net.Pipe, for the allocations of
+// the record encoding and decoding on both sides
+func BenchmarkRoundTrip(b *testing.B) {
+	for _, size := range []int{0, 1 << 10, 64 << 10, 1 << 20} {
+		body := bytes.Repeat([]byte("x"), size)
+		s := gofast.NewServer(func(ctx context.Context, req *gofast.Request, stdout, stderr io.Writer) int {
+			io.Copy(ioutil.Discard, req.Stdin)
+			fmt.Fprint(stdout, "Content-Type: text/plain\r\n\r\n")
+			stdout.Write(body)
+			return 0
+		})
+		h := gofast.NewHandler(
+			gofast.BasicParamsMap(gofast.MapHeader(gofast.BasicSession)),
+			gofast.SimpleClientFactory(muxConnFactory(s, "", nil)),
+		)
+		b.Run(fmt.Sprintf("GET %d", size), func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(size))
+			for i := 0; i < b.N; i++ {
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+				if w.Body.Len() != size {
+					b.Fatalf("expected %d bytes, got %d", size, w.Body.Len())
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("POST %d", size), func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(2 * size))
+			for i := 0; i < b.N; i++ {
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, httptest.NewRequest("POST", "/", bytes.NewReader(body)))
+				if w.Body.Len() != size {
+					b.Fatalf("expected %d bytes, got %d", size, w.Body.Len())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package gofast
+
+import (
+	"sync"
+	"time"
+)
+
+// defaultBreakerFailures is the default Failures of CircuitBreakerOptions
+const defaultBreakerFailures = 5
+
+// defaultBreakerOpenTime is the default OpenTime of CircuitBreakerOptions
+const defaultBreakerOpenTime = 10 * time.Second
+
+// CircuitBreakerOptions are the options of NewCircuitBreaker
+type CircuitBreakerOptions struct {
+
+	// Failures is the number of consecutive failures to open
+	// the circuit. 5 if 0.
+	Failures int
+
+	// OpenTime is the duration the circuit stays open before a
+	// request is let through to try the application again.
+	// 10 seconds if 0.
+	OpenTime time.Duration
+
+	// Clock of the circuit. SystemClock if nil.
+	Clock Clock
+}
+
+// NewCircuitBreaker returns a *CircuitBreaker of the clients of the
+// ClientFactory, with the options
+func NewCircuitBreaker(clientFactory ClientFactory, opts CircuitBreakerOptions) *CircuitBreaker {
+	if opts.Failures <= 0 {
+		opts.Failures = defaultBreakerFailures
+	}
+	if opts.OpenTime <= 0 {
+		opts.OpenTime = defaultBreakerOpenTime
+	}
+	return &CircuitBreaker{
+		clientFactory: clientFactory,
+		opts:          opts,
+	}
+}
+
+// CircuitBreaker stops creating clients of an application which keeps
+// failing (e.g. a dead php-fpm), so the Handler responds 503 Service
+// Unavailable fast instead of piling up the dials. Use its CreateClient
+// as the ClientFactory of a Handler, or of NewRetrySessionHandler.
+//
+// A failure is an ErrDial of the ClientFactory, or a request failing
+// on the connection (e.g. closed before FCGI_END_REQUEST) before any
+// output. After Failures consecutive failures, the circuit is open and
+// CreateClient returns ErrCircuitOpen for OpenTime. Then one client is
+// created to try the application: the circuit is closed if its request
+// succeeds, or open again if it fails.
+//
+// The breaker works on the ClientFactory, not a Middleware, since the
+// Handler dials the application before the SessionHandler is called.
+type CircuitBreaker struct {
+	clientFactory ClientFactory
+	opts          CircuitBreakerOptions
+
+	mutex     sync.Mutex
+	failures  int
+	openUntil time.Time
+	trial     bool
+}
+
+// CreateClient implements ClientFactory
+func (cb *CircuitBreaker) CreateClient() (Client, error) {
+	trial, err := cb.allow()
+	if err != nil {
+		return nil, err
+	}
+	c, err := cb.clientFactory()
+	if err != nil {
+		if isError(err, ErrDial) {
+			cb.record(trial, true)
+		} else if trial {
+			cb.endTrial()
+		}
+		return nil, err
+	}
+	return &breakerClient{Client: c, cb: cb, trial: trial}, nil
+}
+
+// Open reports whether the circuit is open, i.e. CreateClient
+// fails with ErrCircuitOpen
+func (cb *CircuitBreaker) Open() bool {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	if cb.failures < cb.opts.Failures {
+		return false
+	}
+	return cb.trial || clockOrSystem(cb.opts.Clock).Now().Before(cb.openUntil)
+}
+
+// allow checks if a client may be created. Returns true
+// if the client is the trial of the open circuit.
+func (cb *CircuitBreaker) allow() (trial bool, err error) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	if cb.failures < cb.opts.Failures {
+		return false, nil
+	}
+	if cb.trial || clockOrSystem(cb.opts.Clock).Now().Before(cb.openUntil) {
+		return false, ErrCircuitOpen
+	}
+	cb.trial = true
+	return true, nil
+}
+
+// record records the result of a request (or dial)
+func (cb *CircuitBreaker) record(trial, failed bool) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	if trial {
+		cb.trial = false
+	}
+	if !failed {
+		cb.failures = 0
+		return
+	}
+	cb.failures++
+	if cb.failures >= cb.opts.Failures {
+		cb.openUntil = clockOrSystem(cb.opts.Clock).Now().Add(cb.opts.OpenTime)
+	}
+}
+
+// endTrial lets another client try the application,
+// if the trial client made no request
+func (cb *CircuitBreaker) endTrial() {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	cb.trial = false
+}
+
+// breakerClient is a client of CircuitBreaker,
+// which records the results of the requests
+type breakerClient struct {
+	Client
+	cb *CircuitBreaker
+
+	mutex sync.Mutex
+	trial bool
+}
+
+// Do implements Client.Do
+func (c *breakerClient) Do(req *Request) (*ResponsePipe, error) {
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		c.record(isError(err, ErrDial))
+		return resp, err
+	}
+
+	// the result is known on the first output, or
+	// the end of the response without any
+	var once sync.Once
+	r := resp.stdOutReader
+	resp.stdOutReader = readerFunc(func(p []byte) (int, error) {
+		n, err := r.Read(p)
+		if n > 0 {
+			once.Do(func() { c.record(false) })
+		} else if err != nil {
+			once.Do(func() { c.record(connFailure(resp.Err())) })
+		}
+		return n, err
+	})
+	return resp, nil
+}
+
+// record records the result of the request
+// to the breaker, as the trial if it is
+func (c *breakerClient) record(failed bool) {
+	c.mutex.Lock()
+	trial := c.trial
+	c.trial = false
+	c.mutex.Unlock()
+	c.cb.record(trial, failed)
+}
+
+// Close implements Client.Close
+func (c *breakerClient) Close() error {
+	c.mutex.Lock()
+	trial := c.trial
+	c.trial = false
+	c.mutex.Unlock()
+	if trial {
+		c.cb.endTrial()
+	}
+	return c.Client.Close()
+}
+
+// BackendLimits implements LimitsReporter, if the inner client does
+func (c *breakerClient) BackendLimits() (BackendLimits, error) {
+	if lr, ok := c.Client.(LimitsReporter); ok {
+		return lr.BackendLimits()
+	}
+	return BackendLimits{}, errNoLimits
+}
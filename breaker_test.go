@@ -0,0 +1,102 @@
+package gofast_test
+
+import (
+	"net"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/yookoala/gofast"
+	"github.com/yookoala/gofast/fcgitest"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	s := gofast.NewServer(echoName)
+	var up, dials int32
+	connFactory := muxConnFactory(s, "", nil)
+	down := gofast.SimpleConnFactory("unix", "/nonexistent/fcgi.sock")
+	clientFactory := gofast.SimpleClientFactory(func() (net.Conn, error) {
+		atomic.AddInt32(&dials, 1)
+		if atomic.LoadInt32(&up) == 0 {
+			return down()
+		}
+		return connFactory()
+	})
+	clock := fcgitest.NewFakeClock(time.Now())
+	cb := gofast.NewCircuitBreaker(clientFactory, gofast.CircuitBreakerOptions{
+		Failures: 2,
+		OpenTime: time.Minute,
+		Clock:    clock,
+	})
+	h := gofast.NewHandler(gofast.BasicSession, cb.CreateClient)
+	serve := func() int {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		return w.Code
+	}
+
+	// the circuit opens after the consecutive failures,
+	// and responds 503 without dialing
+	for i := 0; i < 2; i++ {
+		if want, have := 502, serve(); want != have {
+			t.Errorf("expected %#v, got %#v", want, have)
+		}
+	}
+	if want, have := true, cb.Open(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := 503, serve(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if c, err := cb.CreateClient(); err != gofast.ErrCircuitOpen {
+		t.Errorf("expected %#v, got %#v (client: %#v)", gofast.ErrCircuitOpen, err, c)
+	}
+	if want, have := int32(2), atomic.LoadInt32(&dials); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	// the trial after the open time fails, and opens again
+	clock.Advance(2 * time.Minute)
+	if want, have := false, cb.Open(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := 502, serve(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := 503, serve(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	// the trial succeeds, and closes the circuit
+	atomic.StoreInt32(&up, 1)
+	clock.Advance(2 * time.Minute)
+	if have, err := doName(cb.CreateClient, "hello"); err != nil || have != "hello" {
+		t.Errorf("expected %#v, got %#v (error: %v)", "hello", have, err)
+	}
+	if want, have := false, cb.Open(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := 200, serve(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestCircuitBreaker_connFailure(t *testing.T) {
+	var dials int32
+	cb := gofast.NewCircuitBreaker(
+		gofast.SimpleClientFactory(brokenConnFactory(&dials)),
+		gofast.CircuitBreakerOptions{Failures: 3},
+	)
+	for i := 0; i < 3; i++ {
+		if _, err := doName(cb.CreateClient, "hello"); err == nil {
+			t.Errorf("expected error of the broken connection")
+		}
+	}
+	if want, have := true, cb.Open(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := int32(3), atomic.LoadInt32(&dials); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
@@ -0,0 +1,111 @@
+package gofast
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Budget is the time budget of a request through the stages of the
+// Handler. The stages share one deadline of Timeout, so the time spent
+// in a stage deducts from the next stages, and the sum of the stages
+// never exceeds the Timeout promised to the client:
+//
+//	getting a client (the wait of ClientPool and the dial)
+//	    |-- at most Connect, or the budget left
+//	reading the response
+//	    |-- the budget left
+//
+// The zero value of Budget sets no limit.
+type Budget struct {
+
+	// Timeout is the overall time of the request, from the start of
+	// the Handler to the end of the response from the application. No
+	// overall limit if 0.
+	Timeout time.Duration
+
+	// Connect is the maximum time to get a client from the
+	// ClientFactory, including the wait for a client of ClientPool.
+	// Limited only by the budget left if 0.
+	Connect time.Duration
+
+	// Clock of the budget. SystemClock if nil.
+	Clock Clock
+}
+
+// NewHandlerWithBudget returns the default Handler implementation, as
+// NewHandler does, with the time budget of each request
+func NewHandlerWithBudget(sessionHandler SessionHandler, clientFactory ClientFactory, budget Budget, options ...HandlerOption) Handler {
+	return newHandler(sessionHandler, clientFactory, budget, options)
+}
+
+// start starts the budget of the request. The context of the returned
+// request is canceled when the budget runs out, so the stages after
+// getting the client (e.g. client.Do) have the budget left as the
+// deadline. The returned function must be called to release the timer.
+func (b Budget) start(r *http.Request) (*http.Request, time.Time, func()) {
+	if b.Timeout <= 0 {
+		return r, time.Time{}, func() {}
+	}
+	clock := clockOrSystem(b.Clock)
+	deadline := clock.Now().Add(b.Timeout)
+	ctx, cancel := context.WithCancel(r.Context())
+	timer := clock.NewTimer(b.Timeout)
+	go func() {
+		select {
+		case <-timer.C():
+			cancel()
+		case <-ctx.Done():
+			timer.Stop()
+		}
+	}()
+	return r.WithContext(ctx), deadline, cancel
+}
+
+// getClient gets a client from the ClientFactory within the Connect
+// limit and the budget left before the deadline. If the limit is
+// reached first, it returns ErrTimeout and closes the client once
+// created (e.g. returns it to the ClientPool).
+func (b Budget) getClient(ctx context.Context, deadline time.Time, newClient ClientFactory) (Client, error) {
+	wait := b.Connect
+	clock := clockOrSystem(b.Clock)
+	if !deadline.IsZero() {
+		if left := deadline.Sub(clock.Now()); wait <= 0 || left < wait {
+			wait = left
+		}
+	}
+	if wait <= 0 && deadline.IsZero() {
+		return newClient()
+	}
+	if wait <= 0 {
+		return nil, &timeoutError{context.DeadlineExceeded}
+	}
+
+	type result struct {
+		c   Client
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		c, err := newClient()
+		done <- result{c, err}
+	}()
+
+	timer := clock.NewTimer(wait)
+	defer timer.Stop()
+	var err error
+	select {
+	case res := <-done:
+		return res.c, res.err
+	case <-timer.C():
+		err = &timeoutError{context.DeadlineExceeded}
+	case <-ctx.Done():
+		err = &timeoutError{ctx.Err()}
+	}
+	go func() {
+		if res := <-done; res.c != nil {
+			res.c.Close()
+		}
+	}()
+	return nil, err
+}
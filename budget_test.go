@@ -0,0 +1,134 @@
+package gofast_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/yookoala/gofast"
+	"github.com/yookoala/gofast/fcgitest"
+)
+
+// budgetClient returns a Client which reports the request context
+// to the channel, then waits for it to be done
+func budgetClient(ctxs chan<- context.Context) gofast.Client {
+	return gofast.ClientFunc(func(req *gofast.Request) (*gofast.ResponsePipe, error) {
+		ctx := req.Raw.Context()
+		ctxs <- ctx
+		<-ctx.Done()
+		return nil, fmt.Errorf("canceled")
+	})
+}
+
+func TestBudget_connect(t *testing.T) {
+	clock := fcgitest.NewFakeClock(time.Unix(0, 0))
+	closed := make(chan bool, 1)
+	release := make(chan bool)
+	clientFactory := func() (gofast.Client, error) {
+		<-release
+		return &closeClient{closed}, nil
+	}
+	h := gofast.NewHandlerWithBudget(gofast.BasicSession, clientFactory, gofast.Budget{
+		Timeout: 10 * time.Second,
+		Connect: 2 * time.Second,
+		Clock:   clock,
+	})
+
+	w := httptest.NewRecorder()
+	served := make(chan bool)
+	go func() {
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		close(served)
+	}()
+
+	// the overall timer and the connect timer
+	clock.WaitTimers(2)
+	clock.Advance(2 * time.Second)
+	<-served
+	if want, have := http.StatusGatewayTimeout, w.Code; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	// the client created late is closed
+	close(release)
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Errorf("expected the client created after the timeout to be closed")
+	}
+}
+
+func TestBudget_read(t *testing.T) {
+	clock := fcgitest.NewFakeClock(time.Unix(0, 0))
+	ctxs := make(chan context.Context, 1)
+	release := make(chan bool)
+	clientFactory := func() (gofast.Client, error) {
+		<-release
+		return budgetClient(ctxs), nil
+	}
+	h := gofast.NewHandlerWithBudget(gofast.BasicSession, clientFactory, gofast.Budget{
+		Timeout: 10 * time.Second,
+		Connect: 5 * time.Second,
+		Clock:   clock,
+	})
+
+	served := make(chan bool)
+	go func() {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		close(served)
+	}()
+
+	// 3 seconds to connect, which deducts from the read stage
+	clock.WaitTimers(2)
+	clock.Advance(3 * time.Second)
+	close(release)
+	ctx := <-ctxs
+
+	clock.Advance(6 * time.Second)
+	select {
+	case <-ctx.Done():
+		t.Errorf("expected the context not to be done within the budget")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	clock.Advance(time.Second)
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Errorf("expected the context to be done when the budget runs out")
+	}
+	<-served
+}
+
+func TestBudget_zero(t *testing.T) {
+	ctxs := make(chan context.Context, 1)
+	clientFactory := func() (gofast.Client, error) {
+		return gofast.ClientFunc(func(req *gofast.Request) (*gofast.ResponsePipe, error) {
+			ctxs <- req.Raw.Context()
+			return nil, fmt.Errorf("done")
+		}), nil
+	}
+	h := gofast.NewHandlerWithBudget(gofast.BasicSession, clientFactory, gofast.Budget{})
+	r := httptest.NewRequest("GET", "/", nil)
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	if want, have := r.Context(), <-ctxs; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+// closeClient reports to the channel when closed
+type closeClient struct {
+	closed chan<- bool
+}
+
+func (c *closeClient) Do(req *gofast.Request) (*gofast.ResponsePipe, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (c *closeClient) Close() error {
+	c.closed <- true
+	return nil
+}
@@ -0,0 +1,10 @@
+module github.com/yookoala/gofast/caddyadapter
+
+go 1.16
+
+require (
+	github.com/caddyserver/caddy/v2 v2.7.6
+	github.com/yookoala/gofast v0.0.0
+)
+
+replace github.com/yookoala/gofast => ../
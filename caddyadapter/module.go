@@ -0,0 +1,275 @@
+// Package caddyadapter registers gofast as a Caddy HTTP handler module
+// (http.handlers.gofast) with a "gofast" Caddyfile directive.
+//
+// It may replace the reverse_proxy fastcgi transport of Caddy with the
+// session middlewares and client pooling of gofast. To build Caddy with
+// this module:
+//
+//  xcaddy build --with github.com/yookoala/gofast/caddyadapter
+//
+// Caddyfile syntax:
+//
+//  gofast [<backend>] {
+//  	backend         <network address, e.g. localhost:9000 or unix//run/php/php-fpm.sock>
+//  	root            <document root, default to {http.vars.root}>
+//  	endpoint        <single script to handle all requests>
+//  	pool            <size> [<expires>]
+//  	map_remote_host
+//  }
+//
+// Without endpoint, only the requests to PHP scripts (i.e. path of a .php
+// file, or a directory for its index.php) are sent to the application.
+// The others are passed on to the next handler (e.g. file_server).
+//
+// The directive has no default order. Order it in the global options
+// (or use it in a route block):
+//
+//  {
+//  	order gofast before file_server
+//  }
+//
+package caddyadapter
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/yookoala/gofast"
+)
+
+// maxRootHandlers is the maximum of the handlers kept for the
+// roots resolved from a Root with placeholders
+const maxRootHandlers = 64
+
+// pathinfoRe matches the path of a script with PATH_INFO,
+// as gofast.FileSystemRouter does
+var pathinfoRe = regexp.MustCompile(`^(.+\.php)(/?.+)$`)
+
+func init() {
+	caddy.RegisterModule(Handler{})
+	httpcaddyfile.RegisterHandlerDirective("gofast", parseCaddyfile)
+}
+
+// Handler implements an HTTP handler that sends requests to a FastCGI
+// application with gofast.
+type Handler struct {
+
+	// Backend is the network address of the FastCGI application,
+	// in Caddy network address format (e.g. "localhost:9000" or
+	// "unix//run/php/php-fpm.sock").
+	Backend string `json:"backend,omitempty"`
+
+	// Root is the document root of the scripts. Default to
+	// "{http.vars.root}", or the current directory if not set.
+	Root string `json:"root,omitempty"`
+
+	// Endpoint, if set, is the only script file to handle all
+	// requests (see gofast.NewFileEndpoint). Otherwise requests
+	// are routed to script files in Root (see gofast.NewPHPFS),
+	// and the requests not to a script are passed on to the next
+	// handler.
+	Endpoint string `json:"endpoint,omitempty"`
+
+	// PoolSize is the number of pre-created clients. If 0, no
+	// client pool will be used.
+	PoolSize uint `json:"pool_size,omitempty"`
+
+	// PoolExpires is the life span of a pooled client.
+	// Default to 30 seconds.
+	PoolExpires caddy.Duration `json:"pool_expires,omitempty"`
+
+	// MapRemoteHost enables gofast.MapRemoteHost for REMOTE_HOST.
+	MapRemoteHost bool `json:"map_remote_host,omitempty"`
+
+	clientFactory gofast.ClientFactory
+	pool          *gofast.ClientPool
+	handler       http.Handler
+	roots         *rootHandlers
+}
+
+// rootHandlers keeps the handlers by the resolved root, so
+// the session middlewares are not built on every request
+type rootHandlers struct {
+	mutex    sync.Mutex
+	handlers map[string]http.Handler
+}
+
+// CaddyModule returns the Caddy module information.
+func (Handler) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.gofast",
+		New: func() caddy.Module { return new(Handler) },
+	}
+}
+
+// Provision sets up the client factory and the session handler.
+func (h *Handler) Provision(ctx caddy.Context) error {
+	addr, err := caddy.ParseNetworkAddress(h.Backend)
+	if err != nil {
+		return fmt.Errorf("invalid backend address %#v: %s", h.Backend, err)
+	}
+	h.clientFactory = gofast.SimpleClientFactory(
+		gofast.SimpleConnFactory(addr.Network, addr.JoinHostPort(0)),
+	)
+	if h.PoolSize > 0 {
+		expires := time.Duration(h.PoolExpires)
+		if expires == 0 {
+			expires = 30 * time.Second
+		}
+		h.pool = gofast.NewClientPool(h.clientFactory, h.PoolSize, expires)
+		h.clientFactory = h.pool.CreateClient
+	}
+	if h.Root == "" {
+		h.Root = "{http.vars.root}"
+	}
+
+	// root without placeholder can be prepared once and for all
+	if !strings.Contains(h.Root, "{") {
+		h.handler = h.newHandler(h.Root)
+	} else {
+		h.roots = &rootHandlers{handlers: make(map[string]http.Handler)}
+	}
+	return nil
+}
+
+// Cleanup closes the client pool, if any. Caddy provisions a new
+// handler on every config reload and cleans up the previous one.
+func (h *Handler) Cleanup() error {
+	if h.pool != nil {
+		return h.pool.Close()
+	}
+	return nil
+}
+
+// Validate ensures the handler is properly configured.
+func (h *Handler) Validate() error {
+	if h.Backend == "" {
+		return fmt.Errorf("backend address is required")
+	}
+	return nil
+}
+
+func (h *Handler) newHandler(root string) http.Handler {
+	var m gofast.Middleware
+	if h.Endpoint != "" {
+		m = gofast.NewFileEndpoint(h.Endpoint)
+	} else {
+		m = gofast.NewPHPFS(root)
+	}
+	if h.MapRemoteHost {
+		m = gofast.Chain(m, gofast.MapRemoteHost)
+	}
+	return gofast.NewHandler(m(gofast.BasicSession), h.clientFactory)
+}
+
+// rootHandler returns the handler of the Root resolved for the request.
+// Past maxRootHandlers roots, the handlers of new roots are not kept.
+func (h *Handler) rootHandler(r *http.Request) http.Handler {
+	if h.handler != nil {
+		return h.handler
+	}
+	repl := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+	root := repl.ReplaceAll(h.Root, ".")
+
+	h.roots.mutex.Lock()
+	defer h.roots.mutex.Unlock()
+	if handler, ok := h.roots.handlers[root]; ok {
+		return handler
+	}
+	handler := h.newHandler(root)
+	if len(h.roots.handlers) < maxRootHandlers {
+		h.roots.handlers[root] = handler
+	}
+	return handler
+}
+
+// handles returns true if the request is to be sent to the application:
+// every request with Endpoint, or else the requests to PHP scripts.
+func (h *Handler) handles(r *http.Request) bool {
+	if h.Endpoint != "" {
+		return true
+	}
+	p := r.URL.Path
+	return strings.HasSuffix(p, "/") || strings.HasSuffix(p, ".php") || pathinfoRe.MatchString(p)
+}
+
+// ServeHTTP implements caddyhttp.MiddlewareHandler. The requests not
+// to be handled by the FastCGI application are passed on to next.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	if !h.handles(r) {
+		return next.ServeHTTP(w, r)
+	}
+	h.rootHandler(r).ServeHTTP(w, r)
+	return nil
+}
+
+// UnmarshalCaddyfile sets up the handler from Caddyfile tokens.
+func (h *Handler) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		if d.NextArg() {
+			h.Backend = d.Val()
+		}
+		for d.NextBlock(0) {
+			switch d.Val() {
+			case "backend":
+				if !d.Args(&h.Backend) {
+					return d.ArgErr()
+				}
+			case "root":
+				if !d.Args(&h.Root) {
+					return d.ArgErr()
+				}
+			case "endpoint":
+				if !d.Args(&h.Endpoint) {
+					return d.ArgErr()
+				}
+			case "pool":
+				args := d.RemainingArgs()
+				if len(args) < 1 || len(args) > 2 {
+					return d.ArgErr()
+				}
+				size, err := strconv.ParseUint(args[0], 10, 32)
+				if err != nil {
+					return d.Errf("invalid pool size %#v: %s", args[0], err)
+				}
+				h.PoolSize = uint(size)
+				if len(args) == 2 {
+					expires, err := caddy.ParseDuration(args[1])
+					if err != nil {
+						return d.Errf("invalid pool expires %#v: %s", args[1], err)
+					}
+					h.PoolExpires = caddy.Duration(expires)
+				}
+			case "map_remote_host":
+				h.MapRemoteHost = true
+			default:
+				return d.Errf("unrecognized subdirective %s", d.Val())
+			}
+		}
+	}
+	return nil
+}
+
+func parseCaddyfile(helper httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+	h := new(Handler)
+	err := h.UnmarshalCaddyfile(helper.Dispenser)
+	return h, err
+}
+
+// Interface guards
+var (
+	_ caddy.Provisioner           = (*Handler)(nil)
+	_ caddy.Validator             = (*Handler)(nil)
+	_ caddy.CleanerUpper          = (*Handler)(nil)
+	_ caddyhttp.MiddlewareHandler = (*Handler)(nil)
+	_ caddyfile.Unmarshaler       = (*Handler)(nil)
+)
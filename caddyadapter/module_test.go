@@ -0,0 +1,150 @@
+package caddyadapter_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/yookoala/gofast/caddyadapter"
+	"github.com/yookoala/gofast/fcgitest"
+)
+
+func TestHandler_UnmarshalCaddyfile(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`
+	gofast localhost:9000 {
+		root /var/www/html
+		pool 10 1m
+		map_remote_host
+	}`)
+
+	var h caddyadapter.Handler
+	if err := h.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want, have := "localhost:9000", h.Backend; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "/var/www/html", h.Root; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := uint(10), h.PoolSize; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := caddy.Duration(time.Minute), h.PoolExpires; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if !h.MapRemoteHost {
+		t.Errorf("expected map_remote_host to be enabled")
+	}
+}
+
+func TestHandler_UnmarshalCaddyfile_unknown(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`
+	gofast {
+		foo bar
+	}`)
+	var h caddyadapter.Handler
+	if err := h.UnmarshalCaddyfile(d); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+}
+
+func TestHandler_Cleanup(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer l.Close()
+
+	h := caddyadapter.Handler{Backend: l.Addr().String(), Root: "/var/www/html", PoolSize: 1}
+	if err := h.Provision(caddy.Context{}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// the pool connects to the backend in advance
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer conn.Close()
+
+	if err := h.Cleanup(); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("expected the pooled connection closed, got %v", err)
+	}
+}
+
+func TestHandler_ServeHTTP(t *testing.T) {
+	backend := fcgitest.NewUnixServer(fcgitest.Reply(fcgitest.Body("script")))
+	defer backend.Close()
+
+	tests := []struct {
+		desc     string
+		endpoint string
+		root     string
+		path     string
+		body     string
+		filename string
+	}{
+		{"script", "", "/srv/a", "/hello.php", "script", "/srv/a/hello.php"},
+		{"path info", "", "/srv/a", "/hello.php/world", "script", "/srv/a/hello.php"},
+		{"directory index", "", "/srv/b", "/", "script", "/srv/b/index.php"},
+		{"static file", "", "/srv/a", "/style.css", "next", ""},
+		{"endpoint", "/srv/a/index.php", "/srv/a", "/style.css", "script", "/srv/a/index.php"},
+	}
+
+	// the handlers serve all the roots resolved
+	handlers := make(map[string]*caddyadapter.Handler)
+	for _, tc := range tests {
+		if handlers[tc.endpoint] != nil {
+			continue
+		}
+		h := &caddyadapter.Handler{
+			Backend:  "unix/" + backend.Address,
+			Root:     "{http.vars.root}",
+			Endpoint: tc.endpoint,
+		}
+		if err := h.Provision(caddy.Context{}); err != nil {
+			t.Fatalf("%s: unexpected error: %s", tc.desc, err)
+		}
+		handlers[tc.endpoint] = h
+	}
+
+	for _, tc := range tests {
+		h := handlers[tc.endpoint]
+		next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+			io.WriteString(w, "next")
+			return nil
+		})
+
+		repl := caddy.NewReplacer()
+		repl.Set("http.vars.root", tc.root)
+		r := httptest.NewRequest("GET", tc.path, nil)
+		r = r.WithContext(context.WithValue(r.Context(), caddy.ReplacerCtxKey, repl))
+		w := httptest.NewRecorder()
+		requests := len(backend.Requests())
+		if err := h.ServeHTTP(w, r, next); err != nil {
+			t.Errorf("%s: unexpected error: %s", tc.desc, err)
+		}
+		if want, have := tc.body, w.Body.String(); want != have {
+			t.Errorf("%s: expected %#v, got %#v", tc.desc, want, have)
+		}
+		if tc.filename == "" {
+			if want, have := requests, len(backend.Requests()); want != have {
+				t.Errorf("%s: expected no request to the backend", tc.desc)
+			}
+		} else if want, have := tc.filename, backend.LastRequest().Params["SCRIPT_FILENAME"]; want != have {
+			t.Errorf("%s: expected %#v, got %#v", tc.desc, want, have)
+		}
+	}
+}
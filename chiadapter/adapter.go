@@ -0,0 +1,60 @@
+// Package chiadapter mounts gofast handlers under chi routes.
+//
+// chi does not strip the mounted path from the request. The path before
+// the wildcard is stripped and remembered, so gofast.MapMountPrefix may
+// restore it to SCRIPT_NAME and DOCUMENT_URI. Other URL parameters are
+// available to gofast.MapRouteParams.
+//
+//  sess := gofast.Chain(
+//  	gofast.NewPHPFS("/var/www/html"),
+//  	gofast.MapMountPrefix,
+//  	gofast.MapRouteParams,
+//  )(gofast.BasicSession)
+//  h := gofast.NewHandler(sess, clientFactory)
+//
+//  r := chi.NewRouter()
+//  r.Handle("/app/*", chiadapter.Handler(h))
+//
+package chiadapter
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/yookoala/gofast"
+)
+
+// Handler returns a chi compatible http.Handler that serves the given
+// http.Handler (e.g. a gofast.Handler). If the matched route has a
+// wildcard (e.g. "/app/*"), the path before it is stripped from the
+// request path with gofast.MountWildcard.
+func Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rctx := chi.RouteContext(r.Context())
+		if rctx == nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		var wildcard string
+		var hasWildcard bool
+		params := make(map[string]string, len(rctx.URLParams.Keys))
+		for i, key := range rctx.URLParams.Keys {
+			if i >= len(rctx.URLParams.Values) {
+				break
+			}
+			if key == "*" {
+				wildcard, hasWildcard = rctx.URLParams.Values[i], true
+				continue
+			}
+			params[key] = rctx.URLParams.Values[i]
+		}
+		r = gofast.WithRouteParams(r, params)
+
+		if hasWildcard {
+			gofast.MountWildcard(w, r, wildcard, h)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
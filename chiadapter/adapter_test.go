@@ -0,0 +1,35 @@
+package chiadapter_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/yookoala/gofast"
+	"github.com/yookoala/gofast/chiadapter"
+)
+
+func TestHandler(t *testing.T) {
+	var prefix, path string
+	var params map[string]string
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		prefix, path, params = gofast.MountPrefix(r), r.URL.Path, gofast.RouteParams(r)
+	})
+
+	router := chi.NewRouter()
+	router.Handle("/sites/{site}/*", chiadapter.Handler(h))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/sites/hello/index.php/world", nil))
+
+	if want, have := "/sites/hello", prefix; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "/index.php/world", path; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "hello", params["site"]; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
@@ -0,0 +1,10 @@
+module github.com/yookoala/gofast/chiadapter
+
+go 1.16
+
+require (
+	github.com/go-chi/chi/v5 v5.0.11
+	github.com/yookoala/gofast v0.0.0
+)
+
+replace github.com/yookoala/gofast => ../
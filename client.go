@@ -14,14 +14,17 @@ package gofast
 import (
 	"bufio"
 	"context"
+	"encoding/binary"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"runtime"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 // Role for fastcgi application in spec
@@ -64,6 +67,61 @@ type Request struct {
 	Stdin    io.ReadCloser
 	Data     io.ReadCloser
 	KeepConn bool
+
+	// Trace has the hooks to observe the request
+	// (e.g. for metrics), if any
+	Trace *ClientTrace
+
+	// trace of DryRun, if any
+	trace *chainTrace
+
+	// time of getting the client by the Handler, if any
+	connect *connectTime
+
+	// context set by WithContext, if any
+	ctx context.Context
+}
+
+// Context returns the context of the request: the one set by WithContext,
+// or else the context of Raw. If neither, context.Background is returned.
+// The client aborts the request when the context is done.
+func (req *Request) Context() context.Context {
+	if req.ctx != nil {
+		return req.ctx
+	}
+	if req.Raw != nil {
+		return req.Raw.Context()
+	}
+	return context.Background()
+}
+
+// WithContext returns a shallow copy of req with its context changed
+// to ctx, like the WithContext of http.Request. This gives requests
+// without Raw (e.g. relayed by NewRelayHandler) a context to abort.
+func (req *Request) WithContext(ctx context.Context) *Request {
+	r2 := new(Request)
+	*r2 = *req
+	r2.ctx = ctx
+	return r2
+}
+
+// ClientTrace is a set of hooks to observe a request sent by a Client,
+// like the httptrace.ClientTrace of net/http. Any of the hooks may be
+// nil. The hooks are taken when Client.Do is called, and are called from
+// the goroutines of the client.
+type ClientTrace struct {
+
+	// GotStdout is called with the size of the
+	// content of every FCGI_STDOUT record
+	GotStdout func(n int)
+
+	// GotStderr is called with the size of the
+	// content of every FCGI_STDERR record
+	GotStderr func(n int)
+
+	// Done is called when the request ends, with the
+	// error of the response (see ResponsePipe.Err), if any
+	Done func(err error)
 }
 
 type idPool struct {
@@ -114,20 +172,66 @@ func newIDs() *idPool {
 type client struct {
 	conn *conn
 	ids  *idPool
+
+	// mux is the connection shared with other clients,
+	// if the client is of MuxClientFactory
+	mux *muxConn
+
+	// drained is closed when the last request on the
+	// connection ends, or is dropped after abort
+	drained chan struct{}
+
+	// stdinBufferSize is the most bytes of the request
+	// body buffered, and of a FCGI_STDIN record
+	stdinBufferSize int
+
+	// maxHeaderBytes is the most bytes of the CGI header of
+	// a response, and maxStderrBytes of the FCGI_STDERR of a
+	// request passed on. Of the defaults if 0.
+	maxHeaderBytes int
+	maxStderrBytes int
+
+	// the timeouts of the options, none if 0
+	readTimeout     time.Duration
+	writeTimeout    time.Duration
+	responseTimeout time.Duration
 }
 
-// writeRequest writes params and stdin to the FastCGI application
-func (c *client) writeRequest(reqID uint16, req *Request) (err error) {
+// readDeadliner is a connection supporting read deadlines
+type readDeadliner interface {
+	SetReadDeadline(t time.Time) error
+}
 
-	// end request whenever the function block ends
-	defer func() {
-		if err != nil {
-			// abort the request if there is any error
-			// in previous request writing process.
-			c.conn.writeAbortRequest(reqID)
-			return
+// abortTimeout is the time to wait for the application to end a request
+// after FCGI_ABORT_REQUEST, before the connection is closed instead
+const abortTimeout = 2 * time.Second
+
+// ctxReader returns a reader of r which stops reading
+// once the ctx is done, with the error of the ctx
+func ctxReader(ctx context.Context, r io.Reader) io.Reader {
+	if r == nil {
+		return nil
+	}
+	return readerFunc(func(p []byte) (int, error) {
+		if err := ctx.Err(); err != nil {
+			return 0, err
 		}
-	}()
+		return r.Read(p)
+	})
+}
+
+// readerFunc implements io.Reader with a function
+type readerFunc func(p []byte) (int, error)
+
+// Read implements io.Reader
+func (f readerFunc) Read(p []byte) (int, error) {
+	return f(p)
+}
+
+// writeRequest writes params and stdin (and data for filter role)
+// to the FastCGI application. The streaming of stdin and data stops
+// once the ctx is done.
+func (c *client) writeRequest(ctx context.Context, reqID uint16, req *Request) (err error) {
 
 	// write request header with specified role
 	err = c.conn.writeBeginRequest(reqID, req.Role, 1)
@@ -140,104 +244,100 @@ func (c *client) writeRequest(reqID uint16, req *Request) (err error) {
 	}
 
 	// write the stdin stream
-	stdinWriter := newWriter(c.conn, typeStdin, reqID)
 	if req.Stdin != nil {
 		defer req.Stdin.Close()
-		p := make([]byte, 1024)
-		var count int
-		for {
-			count, err = req.Stdin.Read(p)
-			if err == io.EOF {
-				err = nil
-			} else if err != nil {
-				stdinWriter.Close()
-				return
-			}
-			if count == 0 {
-				break
-			}
-
-			_, err = stdinWriter.Write(p[:count])
-			if err != nil {
-				stdinWriter.Close()
-				return
-			}
-		}
 	}
-	if err = stdinWriter.Close(); err != nil {
+	if err = c.conn.copyStream(typeStdin, reqID, ctxReader(ctx, req.Stdin), c.stdinBufferSize); err != nil {
 		return
 	}
 
 	// for filter role, also add the data stream
 	if req.Role == RoleFilter {
-		// write the data stream
-		dataWriter := newWriter(c.conn, typeData, reqID)
 		defer req.Data.Close()
-		p := make([]byte, 1024)
-		var count int
-		for {
-			count, err = req.Data.Read(p)
-			if err == io.EOF {
-				err = nil
-			} else if err != nil {
-				return
-			}
-			if count == 0 {
-				break
-			}
-
-			_, err = dataWriter.Write(p[:count])
-			if err != nil {
-				return
-			}
-		}
-		if err = dataWriter.Close(); err != nil {
-			return
-		}
+		err = c.conn.copyStream(typeData, reqID, ctxReader(ctx, req.Data), 0)
 	}
 	return
 }
 
 // readResponse read the FastCGI stdout and stderr, then write
-// to the response pipe. Protocol error will also be written
-// to the error writer in ResponsePipe.
-func (c *client) readResponse(ctx context.Context, resp *ResponsePipe, req *Request) (err error) {
+// to the response pipe, until FCGI_END_REQUEST. Protocol error
+// will also be written to the error writer in ResponsePipe.
+func (c *client) readResponse(conn *conn, reqID uint16, resp *ResponsePipe, trace *ClientTrace) {
+
+	rec := newRecord()
+	defer rec.free()
+
+	// the read loop may outlive the client on abort,
+	// when Close sets c.conn to nil
+	rwc := conn.rwc
+	read := func(rec *record) error { return rec.read(rwc) }
+	if c.mux != nil {
+		read = c.mux.stream(reqID).read
+	} else if d, ok := rwc.(readDeadliner); ok && c.readTimeout > 0 {
+		read = func(rec *record) error {
+			d.SetReadDeadline(time.Now().Add(c.readTimeout))
+			return rec.read(rwc)
+		}
+	}
 
-	var rec record
-	done := make(chan int)
+	stderrBytes := 0
+	for {
+		if err := read(rec); err == io.EOF {
+			resp.stdErrWriter.Write([]byte("gofast: connection closed before FCGI_END_REQUEST"))
+			resp.setErr(&ProtocolError{ReqID: reqID, Reason: "connection closed before FCGI_END_REQUEST"})
+			return
+		} else if isTimeout(err) {
+			// the record may be read in part,
+			// so the connection is of no more use
+			resp.stdErrWriter.Write([]byte("gofast: timeout reading response: " + err.Error()))
+			resp.setErr(&timeoutError{err})
+			rwc.Close()
+			return
+		} else if err != nil {
+			resp.stdErrWriter.Write([]byte("gofast: error reading response: " + err.Error()))
+			resp.setErr(err)
+			return
+		}
 
-	// readloop in goroutine
-	go func() {
-	readLoop:
-		for {
-			if err := rec.read(c.conn.rwc); err != nil {
+		// different output type for different stream
+		switch rec.h.Type {
+		case typeStdout:
+			if trace != nil && trace.GotStdout != nil {
+				trace.GotStdout(len(rec.content()))
+			}
+			resp.stdOutWriter.Write(rec.content())
+		case typeStderr:
+			if trace != nil && trace.GotStderr != nil {
+				trace.GotStderr(len(rec.content()))
+			}
+			b := rec.content()
+			if max := c.maxStderrBytes; max > 0 && stderrBytes+len(b) > max {
+				// pass on the output up to the limit,
+				// then drop the rest of the request
+				if stderrBytes < max {
+					resp.stdErrWriter.Write(b[:max-stderrBytes])
+					resp.stdErrWriter.Write([]byte("gofast: error stream truncated"))
+				}
+				stderrBytes = max
 				break
 			}
-
-			// different output type for different stream
-			switch rec.h.Type {
-			case typeStdout:
-				resp.stdOutWriter.Write(rec.content())
-			case typeStderr:
-				resp.stdErrWriter.Write(rec.content())
-			case typeEndRequest:
-				break readLoop
-			default:
-				err := fmt.Sprintf("unexpected type %#v in readLoop", rec.h.Type)
-				resp.stdErrWriter.Write([]byte(err))
+			stderrBytes += len(b)
+			resp.stdErrWriter.Write(b)
+		case typeEndRequest:
+			b := rec.content()
+			if len(b) >= 4 {
+				resp.setAppStatus(binary.BigEndian.Uint32(b))
 			}
+			if len(b) >= 5 && b[4] != statusRequestComplete {
+				resp.setErr(&BackendError{Status: b[4], AppStatus: binary.BigEndian.Uint32(b)})
+			}
+			return
+		default:
+			err := fmt.Sprintf("unexpected type %#v in readLoop", rec.h.Type)
+			resp.stdErrWriter.Write([]byte(err))
+			resp.setErr(&ProtocolError{Type: uint8(rec.h.Type), ReqID: rec.h.ID, Reason: "unexpected record type"})
 		}
-		close(done)
-	}()
-
-	select {
-	case <-ctx.Done():
-		// do nothing, let client.Do handle
-		err = fmt.Errorf("gofast: timeout or canceled")
-	case <-done:
-		// do nothing and end the function
 	}
-	return
 }
 
 // Do implements Client.Do
@@ -271,19 +371,51 @@ func (c *client) Do(req *Request) (resp *ResponsePipe, err error) {
 		return
 	}
 
+	// let the connection know the original request
+	// before anything is written (e.g. for PROXY protocol)
+	if s, ok := c.conn.rwc.(proxySourceSetter); ok && req.Raw != nil {
+		s.setProxySource(req.Raw)
+	}
+
+	// wait for the previous request on the connection,
+	// if it is still draining after abort
+	if c.drained != nil {
+		<-c.drained
+	}
+	conn := c.conn
+	trace := req.Trace
+
 	// allocate request ID
 	reqID := c.ids.Alloc()
+	if c.mux != nil {
+		c.mux.open(reqID)
+	}
 
 	// create response pipe
 	resp = NewResponsePipe()
-	rwError, allDone := make(chan error), make(chan int)
+	resp.maxHeaderBytes = c.maxHeaderBytes
+	rwError, allDone, readDone := make(chan error, 1), make(chan int), make(chan int)
+	drained := make(chan struct{})
+	if c.mux == nil {
+		c.drained = drained
+	}
 
-	// if there is a raw request, use the context deadline
-	var ctx context.Context
-	if req.Raw != nil {
-		ctx = req.Raw.Context()
-	} else {
-		ctx = context.TODO()
+	// the request is aborted when its context is done
+	ctx := req.Context()
+
+	// the response timeout of the client, if any
+	cancel := func() {}
+	if c.responseTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, c.responseTimeout)
+	}
+
+	// the request is aborted once, on error writing the
+	// request or the ctx done before the request ends
+	var abortOnce sync.Once
+	abort := func() {
+		abortOnce.Do(func() {
+			conn.writeAbortRequest(reqID)
+		})
 	}
 
 	// wait group to wait for both read and write to end
@@ -299,17 +431,25 @@ func (c *client) Do(req *Request) (resp *ResponsePipe, err error) {
 
 	// write the request through request pipe
 	go func() {
-		if err := c.writeRequest(reqID, req); err != nil {
-			rwError <- err
+		if err := c.writeRequest(ctx, reqID, req); err != nil {
+			if c.writeTimeout > 0 && isTimeout(err) && ctx.Err() == nil {
+				// the record may be written in part,
+				// so the connection is of no more use
+				resp.setErr(&timeoutError{err})
+				conn.rwc.Close()
+			}
+			abort()
+			if ctx.Err() == nil {
+				rwError <- err
+			}
 		}
 		wg.Done()
 	}()
 
 	// get response from client and write through response pipe
 	go func() {
-		if err := c.readResponse(ctx, resp, req); err != nil {
-			rwError <- err
-		}
+		c.readResponse(conn, reqID, resp, trace)
+		close(readDone)
 		wg.Done()
 	}()
 
@@ -317,25 +457,62 @@ func (c *client) Do(req *Request) (resp *ResponsePipe, err error) {
 	// and return the response pipes
 	// (or else would be block by the response pipes not being used)
 	go func() {
-		// wait until context deadline
-		// or until writeError is not blocked.
-	loop:
-		for {
+		select {
+		case <-allDone:
+		case <-ctx.Done():
+			// abort the request, and end the response
+			// without waiting for the application
+			abort()
+			err := &timeoutError{ctx.Err()}
+			resp.setErr(err)
+			resp.stdErrWriter.Write([]byte(err.Error()))
+			resp.Close()
+			if c.mux != nil {
+				// the request ID is released on the
+				// FCGI_END_REQUEST of the application
+				c.mux.finish(reqID)
+			}
+
+			// unblock the stdin being read, if any
+			if req.Stdin != nil {
+				go req.Stdin.Close()
+			}
+
+			// the connection is kept if the application ends the
+			// request in time. Else it is closed, and the writing
+			// of the request may only stop on the next read.
+			timer := time.NewTimer(abortTimeout)
 			select {
-			case err := <-rwError:
-				// pass the read / write error to error stream
-				resp.stdErrWriter.Write([]byte(err.Error()))
-				continue
 			case <-allDone:
-				break loop
-				// do nothing
+			case <-timer.C:
+				if c.mux == nil {
+					conn.Close()
+				}
+				<-readDone
 			}
+			timer.Stop()
+		}
+
+		// pass the write error to error stream
+		select {
+		case err := <-rwError:
+			resp.stdErrWriter.Write([]byte(err.Error()))
+			resp.setErr(err)
+		default:
 		}
 
 		// clean up
-		c.ids.Release(reqID)
+		if c.mux != nil {
+			c.mux.finish(reqID)
+		} else {
+			c.ids.Release(reqID)
+		}
+		if trace != nil && trace.Done != nil {
+			trace.Done(resp.Err())
+		}
 		resp.Close()
-		close(rwError)
+		cancel()
+		close(drained)
 	}()
 	return
 }
@@ -347,6 +524,11 @@ func (c *client) Close() (err error) {
 	if c.conn == nil {
 		return
 	}
+	if c.mux != nil {
+		// the connection is shared with other clients
+		c.conn = nil
+		return
+	}
 	err = c.conn.Close()
 	c.conn = nil
 	return
@@ -374,7 +556,13 @@ type Client interface {
 type ConnFactory func() (net.Conn, error)
 
 // SimpleConnFactory creates the simplest ConnFactory implementation.
+//
+// For unix networks, Linux abstract socket address may be given as
+// either "@name" or "\x00name". See ParseAddress.
 func SimpleConnFactory(network, address string) ConnFactory {
+	if strings.HasPrefix(network, "unix") {
+		address = normalizeUnixAddress(address)
+	}
 	return func() (net.Conn, error) {
 		return net.Dial(network, address)
 	}
@@ -386,23 +574,100 @@ type ClientFactory func() (Client, error)
 
 // SimpleClientFactory returns a ClientFactory implementation
 // with the given ConnFactory.
-func SimpleClientFactory(connFactory ConnFactory) ClientFactory {
+func SimpleClientFactory(connFactory ConnFactory, options ...ClientOption) ClientFactory {
 	return func() (c Client, err error) {
 		// connect to given network address
 		conn, err := connFactory()
 		if err != nil {
+			err = &wrapError{ErrDial, err}
 			return
 		}
 
 		// create client
-		c = &client{
-			conn: newConn(conn),
-			ids:  newIDs(),
-		}
+		c = newClient(newConn(conn), newIDs(), options)
 		return
 	}
 }
 
+// newClient returns a client of the connection with the options
+func newClient(conn *conn, ids *idPool, options []ClientOption) *client {
+	c := &client{conn: conn, ids: ids}
+	for _, option := range options {
+		option(c)
+	}
+	if c.writeTimeout > 0 {
+		conn.setWriteTimeout(c.writeTimeout)
+	}
+	return c
+}
+
+// ClientOption configures the clients of a ClientFactory
+// (e.g. SimpleClientFactory, MuxClientFactory)
+type ClientOption func(c *client)
+
+// WithStdinBufferSize sets the size of the buffer of the request body
+// (req.Stdin) of the client, which is also the most bytes of a
+// FCGI_STDIN record. The body is streamed to the application a buffer at
+// a time, as fast as the application reads it. Defaults to, and is at
+// most, 65535 bytes.
+func WithStdinBufferSize(size int) ClientOption {
+	return func(c *client) {
+		c.stdinBufferSize = size
+	}
+}
+
+// WithMaxHeaderBytes sets the most bytes of the CGI header of a response
+// of the client (1MB by default). A larger header is of the error
+// ErrHeaderTooLarge, so the handler responds 502 Bad Gateway.
+func WithMaxHeaderBytes(n int) ClientOption {
+	return func(c *client) {
+		c.maxHeaderBytes = n
+	}
+}
+
+// WithMaxStderrBytes sets the most bytes of the FCGI_STDERR output of a
+// request passed on to the error stream of the response (e.g. buffered
+// by the Handler to be logged). The rest of the output is dropped with a
+// note, and the request goes on. Unlimited by default.
+func WithMaxStderrBytes(n int) ClientOption {
+	return func(c *client) {
+		c.maxStderrBytes = n
+	}
+}
+
+// WithReadTimeout sets the most time to wait for each record of the
+// response once the request is sent, if the connection supports
+// deadlines (e.g. a net.Conn). On the timeout, the request ends with
+// ErrTimeout (504 Gateway Timeout) and the connection is closed. It is
+// not of the clients sharing a connection of MuxClientFactory.
+func WithReadTimeout(d time.Duration) ClientOption {
+	return func(c *client) {
+		c.readTimeout = d
+	}
+}
+
+// WithWriteTimeout sets the most time to write each record of the
+// request, if the connection supports deadlines (e.g. a net.Conn), so
+// an application not reading the request does not block the client. On
+// the timeout, the request ends with ErrTimeout (504 Gateway Timeout)
+// and the connection is closed, with the other requests on it, if any.
+func WithWriteTimeout(d time.Duration) ClientOption {
+	return func(c *client) {
+		c.writeTimeout = d
+	}
+}
+
+// WithResponseTimeout sets the most time of a request of the client,
+// from the request sent to the end of the response, on top of the
+// context of the http request. On the timeout, the request is aborted
+// like the context done (see ErrTimeout), so the handler responds 504
+// Gateway Timeout if the response has not started.
+func WithResponseTimeout(d time.Duration) ClientOption {
+	return func(c *client) {
+		c.responseTimeout = d
+	}
+}
+
 // NewResponsePipe returns an initialized new ResponsePipe struct
 func NewResponsePipe() (p *ResponsePipe) {
 	p = new(ResponsePipe)
@@ -418,6 +683,53 @@ type ResponsePipe struct {
 	stdOutWriter io.WriteCloser
 	stdErrReader io.Reader
 	stdErrWriter io.WriteCloser
+
+	mutex     sync.Mutex
+	err       error
+	appStatus uint32
+
+	// maxHeaderBytes is the most bytes of the
+	// CGI header, or maxHeaderBytes if 0
+	maxHeaderBytes int
+
+	// streaming is true if the body is flushed
+	// as it is read (see WithResponseStreaming)
+	streaming bool
+}
+
+// setErr sets the error of the request, if not yet set
+func (pipes *ResponsePipe) setErr(err error) {
+	pipes.mutex.Lock()
+	defer pipes.mutex.Unlock()
+	if pipes.err == nil {
+		pipes.err = err
+	}
+}
+
+// Err returns the first error of the request to the application, if
+// any (e.g. ErrTimeout, *ProtocolError or *BackendError). The error is
+// final once the response is read to the end (e.g. WriteTo returns).
+// The errors are also written to the error stream as text.
+func (pipes *ResponsePipe) Err() error {
+	pipes.mutex.Lock()
+	defer pipes.mutex.Unlock()
+	return pipes.err
+}
+
+// setAppStatus sets the appStatus of FCGI_END_REQUEST
+func (pipes *ResponsePipe) setAppStatus(appStatus uint32) {
+	pipes.mutex.Lock()
+	defer pipes.mutex.Unlock()
+	pipes.appStatus = appStatus
+}
+
+// AppStatus returns the appStatus reported by the application in
+// FCGI_END_REQUEST (e.g. the exit status of a CGI script). It is
+// final once the response is read to the end, as with Err.
+func (pipes *ResponsePipe) AppStatus() int {
+	pipes.mutex.Lock()
+	defer pipes.mutex.Unlock()
+	return int(pipes.appStatus)
 }
 
 // Close close all writers
@@ -460,28 +772,73 @@ func (pipes *ResponsePipe) writeError(w io.Writer) (err error) {
 	return
 }
 
+// maxHeaderBytes is the maximum size of the CGI header from
+// the application, like http.DefaultMaxHeaderBytes of net/http
+const maxHeaderBytes = 1 << 20
+
+// validHeaderName returns true if the name is a valid
+// HTTP header field name (i.e. a non-empty token)
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c <= ' ' || c >= 0x7f || strings.IndexByte("\"(),/:;<=>?@[\\]{}", c) >= 0 {
+			return false
+		}
+	}
+	return true
+}
+
 // writeTo writes the given output into http.ResponseWriter
 func (pipes *ResponsePipe) writeResponse(w http.ResponseWriter) (err error) {
 	linebody := bufio.NewReaderSize(pipes.stdOutReader, 1024)
+	wroteHeader := false
+	defer func() {
+		// drain the stdout on error so the client
+		// would not be blocked writing the pipe, then
+		// respond with the status of the request error
+		if err != nil {
+			io.Copy(ioutil.Discard, pipes.stdOutReader)
+			if !wroteHeader {
+				w.WriteHeader(errorStatus(pipes.Err(), http.StatusInternalServerError))
+			}
+		}
+	}()
 	headers := make(http.Header)
 	statusCode := 0
 	headerLines := 0
+	headerBytes := 0
 	sawBlankLine := false
+	maxBytes := pipes.maxHeaderBytes
+	if maxBytes <= 0 {
+		maxBytes = maxHeaderBytes
+	}
 
 	for {
 		var line []byte
 		var isPrefix bool
 		line, isPrefix, err = linebody.ReadLine()
 		if isPrefix {
-			w.WriteHeader(http.StatusInternalServerError)
-			err = fmt.Errorf("gofast: long header line from subprocess")
+			// a line longer than the buffer is read
+			// in pieces, up to the limit of the header
+			long := append([]byte(nil), line...)
+			for isPrefix && err == nil && headerBytes+len(long) <= maxBytes {
+				line, isPrefix, err = linebody.ReadLine()
+				long = append(long, line...)
+			}
+			line = long
+		}
+		if isPrefix {
+			pipes.setErr(ErrHeaderTooLarge)
+			err = ErrHeaderTooLarge
 			return
 		}
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
 			err = fmt.Errorf("gofast: error reading headers: %v", err)
 			return
 		}
@@ -489,6 +846,11 @@ func (pipes *ResponsePipe) writeResponse(w http.ResponseWriter) (err error) {
 			sawBlankLine = true
 			break
 		}
+		if headerBytes += len(line) + 2; headerBytes > maxBytes {
+			pipes.setErr(ErrHeaderTooLarge)
+			err = ErrHeaderTooLarge
+			return
+		}
 		headerLines++
 		parts := strings.SplitN(string(line), ":", 2)
 		if len(parts) < 2 {
@@ -498,6 +860,10 @@ func (pipes *ResponsePipe) writeResponse(w http.ResponseWriter) (err error) {
 		header, val := parts[0], parts[1]
 		header = strings.TrimSpace(header)
 		val = strings.TrimSpace(val)
+		if !validHeaderName(header) {
+			err = fmt.Errorf("gofast: bogus header name: %q", header)
+			return
+		}
 		switch {
 		case header == "Status":
 			if len(val) < 3 {
@@ -506,7 +872,7 @@ func (pipes *ResponsePipe) writeResponse(w http.ResponseWriter) (err error) {
 			}
 			var code int
 			code, err = strconv.Atoi(val[0:3])
-			if err != nil {
+			if err != nil || code < 100 {
 				err = fmt.Errorf("gofast: bogus status: %q\nline was %q",
 					val, line)
 				return
@@ -517,7 +883,6 @@ func (pipes *ResponsePipe) writeResponse(w http.ResponseWriter) (err error) {
 		}
 	}
 	if headerLines == 0 || !sawBlankLine {
-		w.WriteHeader(http.StatusInternalServerError)
 		err = fmt.Errorf("gofast: no headers")
 		return
 	}
@@ -535,7 +900,6 @@ func (pipes *ResponsePipe) writeResponse(w http.ResponseWriter) (err error) {
 	}
 
 	if statusCode == 0 && headers.Get("Content-Type") == "" {
-		w.WriteHeader(http.StatusInternalServerError)
 		err = fmt.Errorf("gofast: missing required Content-Type in headers")
 		return
 	}
@@ -554,14 +918,44 @@ func (pipes *ResponsePipe) writeResponse(w http.ResponseWriter) (err error) {
 	}
 
 	w.WriteHeader(statusCode)
+	wroteHeader = true
+
+	// the event stream, or any body if streaming, is
+	// flushed as it is read, starting with the headers
+	var bw io.Writer = w
+	if f, ok := w.(http.Flusher); ok && (pipes.streaming || isEventStream(headers)) {
+		f.Flush()
+		bw = &flushWriter{w: w, f: f}
+	}
 
-	_, err = io.Copy(w, linebody)
+	// the body buffered with the headers, then the rest
+	// of the stdout copied without the line buffer (which
+	// may be the stdout itself, if it is a bufio.Reader)
+	if n := linebody.Buffered(); n > 0 {
+		body, _ := linebody.Peek(n)
+		if _, err = bw.Write(body); err == nil {
+			linebody.Discard(n)
+		}
+	}
+	if err == nil {
+		buf := copyBufPool.Get().(*[]byte)
+		_, err = io.CopyBuffer(bw, pipes.stdOutReader, *buf)
+		copyBufPool.Put(buf)
+	}
 	if err != nil {
 		err = fmt.Errorf("gofast: copy error: %v", err)
 	}
 	return
 }
 
+// copyBufPool pools the buffers copying the body of the responses
+var copyBufPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 32*1024)
+		return &b
+	},
+}
+
 // ClientFunc is a function wrapper of a Client interface
 // shortcut implementation. Mainly for testing and development
 // purpose.
@@ -1,7 +1,11 @@
 package gofast
 
 import (
+	"io"
 	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 )
@@ -108,3 +112,129 @@ func TestIDPool_block(t *testing.T) {
 		t.Errorf("unexpected blocking")
 	}
 }
+
+func TestResponsePipe_writeResponse_drain(t *testing.T) {
+	pipes := NewResponsePipe()
+	done := make(chan struct{})
+	go func() {
+		// a bogus header line, then a body larger than the pipe
+		// buffers, which would block if the stdout is not drained
+		io.WriteString(pipes.stdOutWriter, strings.Repeat("a", 2048)+"\r\n\r\n")
+		io.WriteString(pipes.stdOutWriter, strings.Repeat("b", 1<<20))
+		pipes.stdOutWriter.Close()
+		close(done)
+	}()
+
+	if err := pipes.writeResponse(httptest.NewRecorder()); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Errorf("expected the stdout drained on error")
+	}
+}
+
+func TestResponsePipe_writeResponse_maxHeaderBytes(t *testing.T) {
+	tests := []struct {
+		desc           string
+		maxHeaderBytes int
+		lines          int
+		err            error
+	}{
+		{"default", 0, 1000, nil},
+		{"default exceeded", 0, 1100, ErrHeaderTooLarge},
+		{"limit", 10000, 9, nil},
+		{"limit exceeded", 10000, 10, ErrHeaderTooLarge},
+	}
+	for _, test := range tests {
+		pipes := NewResponsePipe()
+		pipes.maxHeaderBytes = test.maxHeaderBytes
+		go func(lines int) {
+			io.WriteString(pipes.stdOutWriter, "Content-Type: text/plain\r\n")
+			io.WriteString(pipes.stdOutWriter, strings.Repeat("X-Foo: "+strings.Repeat("a", 1000)+"\r\n", lines)+"\r\n")
+			pipes.stdOutWriter.Close()
+		}(test.lines)
+
+		w := httptest.NewRecorder()
+		if want, have := test.err, pipes.writeResponse(w); want != have {
+			t.Errorf("%s: expected %#v, got %#v", test.desc, want, have)
+		}
+		if test.err == nil {
+			continue
+		}
+		if want, have := http.StatusBadGateway, w.Code; want != have {
+			t.Errorf("%s: expected %#v, got %#v", test.desc, want, have)
+		}
+	}
+}
+
+func TestResponsePipe_writeResponse_longHeader(t *testing.T) {
+	tests := []struct {
+		desc   string
+		length int
+		err    error
+	}{
+		{"4 KB", 4 * 1024, nil},
+		{"exceeded", 20 * 1024, ErrHeaderTooLarge},
+	}
+	for _, test := range tests {
+		pipes := NewResponsePipe()
+		pipes.maxHeaderBytes = 16 * 1024
+		value := strings.Repeat("a", test.length)
+		go func() {
+			io.WriteString(pipes.stdOutWriter, "Content-Type: text/plain\r\nX-Foo: "+value+"\r\n\r\nbody")
+			pipes.stdOutWriter.Close()
+		}()
+
+		w := httptest.NewRecorder()
+		if want, have := test.err, pipes.writeResponse(w); want != have {
+			t.Errorf("%s: expected %#v, got %#v", test.desc, want, have)
+		}
+		if test.err != nil {
+			continue
+		}
+		if want, have := value, w.Header().Get("X-Foo"); want != have {
+			t.Errorf("%s: expected a header of %d bytes, got %d", test.desc, len(want), len(have))
+		}
+		if want, have := "body", w.Body.String(); want != have {
+			t.Errorf("%s: expected %#v, got %#v", test.desc, want, have)
+		}
+	}
+}
+
+func TestResponsePipe_writeResponse_malformed(t *testing.T) {
+	tests := []struct {
+		desc   string
+		stdout string
+		err    string
+	}{
+		{"no headers", "", "gofast: no headers"},
+		{"no blank line", "Content-Type: text/html", "gofast: no headers"},
+		{"bogus header line", "no colon\r\n\r\n", "gofast: bogus header line: no colon"},
+		{"empty header name", ": value\r\n\r\n", `gofast: bogus header name: ""`},
+		{"invalid header name", "Bad Name: value\r\n\r\n", `gofast: bogus header name: "Bad Name"`},
+		{"short status", "Status: 20\r\n\r\n", `gofast: bogus status (short): "20"`},
+		{"status below 100", "Status: 099\r\n\r\n", `gofast: bogus status: "099"`},
+		{"negative status", "Status: -12\r\n\r\n", `gofast: bogus status: "-12"`},
+		{"no content type", "X-Foo: bar\r\n\r\n", "gofast: missing required Content-Type in headers"},
+	}
+	for _, test := range tests {
+		pipes := NewResponsePipe()
+		go func(stdout string) {
+			io.WriteString(pipes.stdOutWriter, stdout)
+			pipes.stdOutWriter.Close()
+		}(test.stdout)
+
+		w := httptest.NewRecorder()
+		err := pipes.writeResponse(w)
+		if err == nil {
+			t.Errorf("%s: expected error, got nil", test.desc)
+		} else if !strings.HasPrefix(err.Error(), test.err) {
+			t.Errorf("%s: expected %#v, got %#v", test.desc, test.err, err.Error())
+		}
+		if want, have := http.StatusInternalServerError, w.Code; want != have {
+			t.Errorf("%s: expected %#v, got %#v", test.desc, want, have)
+		}
+	}
+}
@@ -3,7 +3,11 @@ package gofast_test
 import (
 	"bytes"
 	"context"
+	"encoding/binary"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
 	"math/rand"
 	"net"
 	"net/http"
@@ -11,10 +15,13 @@ import (
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/yookoala/gofast"
+	"github.com/yookoala/gofast/fcgitest"
 )
 
 func init() {
@@ -250,3 +257,282 @@ func TestClient_StdErr(t *testing.T) {
 		t.Errorf("expected %#v, got %#v", want, have)
 	}
 }
+
+func TestClient_abort(t *testing.T) {
+	aborted := make(chan bool, 1)
+	s := gofast.NewServer(func(ctx context.Context, req *gofast.Request, stdout, stderr io.Writer) int {
+		if req.Params["NAME"] != "block" {
+			return echoName(ctx, req, stdout, stderr)
+		}
+
+		// mimic long running process, until aborted
+		ioutil.ReadAll(req.Stdin)
+		<-ctx.Done()
+		aborted <- true
+		return 1
+	})
+	appConns := make(chan net.Conn, 2)
+	c, err := gofast.SimpleClientFactory(muxConnFactory(s, "", appConns))()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer c.Close()
+
+	tests := []struct {
+		desc  string
+		stdin bool
+	}{
+		{"running", false},
+		{"streaming stdin", true},
+	}
+	for _, tc := range tests {
+		ctx, cancel := context.WithCancel(context.Background())
+		req := gofast.NewRequest(httptest.NewRequest("POST", "/", nil).WithContext(ctx))
+		req.Params["NAME"] = "block"
+		if tc.stdin {
+			// the stdin never ends before cancel
+			stdinR, stdinW := io.Pipe()
+			defer stdinW.Close()
+			req.Stdin = stdinR
+		}
+		resp, err := c.Do(req)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", tc.desc, err)
+		}
+		time.AfterFunc(50*time.Millisecond, cancel)
+
+		// the response ends on cancel, without
+		// waiting for the application
+		done := make(chan struct{})
+		go func() {
+			resp.WriteTo(httptest.NewRecorder(), ioutil.Discard)
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("%s: response does not end after cancel", tc.desc)
+		}
+		if want, have := "gofast: timeout or canceled", fmt.Sprint(resp.Err()); want != have {
+			t.Errorf("%s: expected %#v, got %#v", tc.desc, want, have)
+		}
+		select {
+		case <-aborted:
+		case <-time.After(time.Second):
+			t.Errorf("%s: application is not aborted", tc.desc)
+		}
+	}
+
+	// the connection serves the next request
+	req := gofast.NewRequest(nil)
+	req.Params["NAME"] = "hello"
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	w := httptest.NewRecorder()
+	resp.WriteTo(w, ioutil.Discard)
+	if want, have := "hello", w.Body.String(); want != have {
+		t.Errorf("expected %#v, got %#v (error: %v)", want, have, resp.Err())
+	}
+	if want, have := 1, len(appConns); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+// countingReader counts the bytes read of the inner reader
+type countingReader struct {
+	io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	atomic.AddInt64(&r.n, int64(n))
+	return n, err
+}
+
+func TestWithStdinBufferSize(t *testing.T) {
+	const size, total = 1000, 1 << 18
+	body := &countingReader{Reader: io.LimitReader(zeroReader{}, total)}
+
+	appConn, webConn := net.Pipe()
+	clientFactory := gofast.SimpleClientFactory(func() (net.Conn, error) {
+		return webConn, nil
+	}, gofast.WithStdinBufferSize(size))
+	c, err := clientFactory()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer c.Close()
+	req := gofast.NewRequest(nil)
+	req.Stdin = ioutil.NopCloser(body)
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// read the records as a slow application
+	var received int64
+	h := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(appConn, h); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		length := int(binary.BigEndian.Uint16(h[4:]))
+		if _, err := io.ReadFull(appConn, make([]byte, length+int(h[6]))); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if h[1] != fcgitest.TypeStdin {
+			continue
+		}
+		if length == 0 {
+			break
+		}
+		if length > size {
+			t.Fatalf("expected records of at most %d bytes, got %d", size, length)
+		}
+		received += int64(length)
+
+		// the body is read no more than a buffer ahead
+		time.Sleep(time.Millisecond / 10)
+		if ahead := atomic.LoadInt64(&body.n) - received; ahead > 2*size {
+			t.Fatalf("expected body read at most %d bytes ahead, got %d", 2*size, ahead)
+		}
+	}
+	if want, have := int64(total), received; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	appConn.Write(fcgitest.Record(fcgitest.TypeEndRequest, 1, make([]byte, 8)))
+	resp.WriteTo(httptest.NewRecorder(), ioutil.Discard)
+	if err := resp.Err(); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestWithMaxStderrBytes(t *testing.T) {
+	s := gofast.NewServer(func(ctx context.Context, req *gofast.Request, stdout, stderr io.Writer) int {
+		for _, p := range []string{"abc", "def", "ghi"} {
+			io.WriteString(stderr, p)
+		}
+		io.WriteString(stdout, "Content-Type: text/plain\r\n\r\nbody")
+		return 0
+	})
+	c, err := gofast.SimpleClientFactory(muxConnFactory(s, "", nil), gofast.WithMaxStderrBytes(5))()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer c.Close()
+	resp, err := c.Do(gofast.NewRequest(nil))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	w := httptest.NewRecorder()
+	var stderr bytes.Buffer
+	resp.WriteTo(w, &stderr)
+	if want, have := "abcdegofast: error stream truncated", stderr.String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "body", w.Body.String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if err := resp.Err(); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+// hangingConnFactory connects to an application reading the requests
+// (if read) but never responding, closing the conns of appConns
+func hangingConnFactory(read bool, appConns chan<- net.Conn) gofast.ConnFactory {
+	return func() (net.Conn, error) {
+		appConn, webConn := net.Pipe()
+		if read {
+			go io.Copy(ioutil.Discard, appConn)
+		}
+		appConns <- appConn
+		return webConn, nil
+	}
+}
+
+func TestClient_timeouts(t *testing.T) {
+	tests := []struct {
+		desc   string
+		read   bool
+		option gofast.ClientOption
+	}{
+		{"read timeout", true, gofast.WithReadTimeout(20 * time.Millisecond)},
+		{"write timeout", false, gofast.WithWriteTimeout(20 * time.Millisecond)},
+		{"response timeout", true, gofast.WithResponseTimeout(20 * time.Millisecond)},
+	}
+	for _, test := range tests {
+		appConns := make(chan net.Conn, 1)
+		h := gofast.NewHandler(gofast.BasicSession, gofast.SimpleClientFactory(
+			hangingConnFactory(test.read, appConns), test.option))
+		h.SetLogger(log.New(ioutil.Discard, "", 0))
+
+		w := httptest.NewRecorder()
+		done := make(chan struct{})
+		go func() {
+			h.ServeHTTP(w, httptest.NewRequest("POST", "/", strings.NewReader("data")))
+			close(done)
+		}()
+		appConn := <-appConns
+		select {
+		case <-done:
+			if want, have := http.StatusGatewayTimeout, w.Code; want != have {
+				t.Errorf("%s: expected %#v, got %#v", test.desc, want, have)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("%s: unexpected blocking", test.desc)
+		}
+		appConn.Close()
+	}
+}
+
+// zeroReader reads endless zero bytes
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+func TestClientTrace(t *testing.T) {
+	s := gofast.NewServer(func(ctx context.Context, req *gofast.Request, stdout, stderr io.Writer) int {
+		fmt.Fprint(stderr, "warning")
+		fmt.Fprint(stdout, "Content-Type: text/plain\r\n\r\nhello")
+		return 0
+	})
+	c, err := gofast.SimpleClientFactory(muxConnFactory(s, "", nil))()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer c.Close()
+
+	var stdout, stderr int
+	done := make(chan error, 1)
+	req := gofast.NewRequest(nil)
+	req.Trace = &gofast.ClientTrace{
+		GotStdout: func(n int) { stdout += n },
+		GotStderr: func(n int) { stderr += n },
+		Done:      func(err error) { done <- err },
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	w := httptest.NewRecorder()
+	resp.WriteTo(w, ioutil.Discard)
+	if err := <-done; err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if want, have := len("Content-Type: text/plain\r\n\r\nhello"), stdout; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := len("warning"), stderr; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
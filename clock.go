@@ -0,0 +1,71 @@
+package gofast
+
+import (
+	"time"
+)
+
+// Clock tells the time and waits. The time dependent logics (e.g. the
+// expiry of ClientPool, the delay of Probes.PreStop) take a Clock, so
+// tests may use a fake one (e.g. fcgitest.FakeClock) instead of real
+// sleeps.
+type Clock interface {
+
+	// Now returns the current time
+	Now() time.Time
+
+	// After returns a channel that receives the
+	// current time after the duration
+	After(d time.Duration) <-chan time.Time
+
+	// NewTimer returns a Timer that fires after the duration
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is a timer of a Clock, like *time.Timer
+type Timer interface {
+
+	// C returns the channel that receives the time when the timer fires
+	C() <-chan time.Time
+
+	// Stop prevents the Timer from firing. Returns false if the
+	// timer has already fired or been stopped.
+	Stop() bool
+
+	// Reset changes the timer to fire after the duration. Returns
+	// true if the timer had been active.
+	Reset(d time.Duration) bool
+}
+
+// SystemClock is the Clock of the system time,
+// with the functions of package time.
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (systemClock) NewTimer(d time.Duration) Timer {
+	return systemTimer{time.NewTimer(d)}
+}
+
+type systemTimer struct {
+	*time.Timer
+}
+
+func (t systemTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
+
+// clockOrSystem returns the clock, or SystemClock if nil
+func clockOrSystem(clock Clock) Clock {
+	if clock == nil {
+		return SystemClock
+	}
+	return clock
+}
@@ -0,0 +1,61 @@
+package gofast_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/yookoala/gofast"
+	"github.com/yookoala/gofast/fcgitest"
+)
+
+func TestSystemClock(t *testing.T) {
+	before := time.Now()
+	if now := gofast.SystemClock.Now(); now.Before(before) {
+		t.Errorf("expected time after %s, got %s", before, now)
+	}
+	select {
+	case <-gofast.SystemClock.After(time.Millisecond):
+	case <-time.After(time.Second):
+		t.Errorf("expected After to fire")
+	}
+
+	timer := gofast.SystemClock.NewTimer(time.Hour)
+	if want, have := true, timer.Stop(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	timer.Reset(time.Millisecond)
+	select {
+	case <-timer.C():
+	case <-time.After(time.Second):
+		t.Errorf("expected the timer to fire")
+	}
+}
+
+func TestNewClientPoolWithClock(t *testing.T) {
+	clock := fcgitest.NewFakeClock(time.Unix(0, 0))
+	cp := gofast.NewClientPoolWithClock(
+		gofast.SimpleClientFactory(func() (net.Conn, error) {
+			appConn, webConn := net.Pipe()
+			appConn.Close()
+			return webConn, nil
+		}),
+		1, time.Minute, clock,
+	)
+	c, err := cp.CreateClient()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	pc := c.(*gofast.PoolClient)
+	if want, have := false, pc.Expired(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	clock.Advance(time.Minute - time.Second)
+	if want, have := false, pc.Expired(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	clock.Advance(2 * time.Second)
+	if want, have := true, pc.Expired(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// accessLogger logs the requests in the Combined Log Format
+type accessLogger struct {
+	mutex sync.Mutex
+	out   io.Writer
+	inner http.Handler
+}
+
+// newAccessLogger returns an http.Handler that serves
+// with inner and logs the requests to out
+func newAccessLogger(out io.Writer, inner http.Handler) http.Handler {
+	return &accessLogger{out: out, inner: inner}
+}
+
+// ServeHTTP implements http.Handler
+func (l *accessLogger) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	rw := &statusRecorder{ResponseWriter: w}
+	l.inner.ServeHTTP(rw, r)
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if host == "" {
+		host = "-"
+	}
+	user := "-"
+	if r.URL.User != nil && r.URL.User.Username() != "" {
+		user = r.URL.User.Username()
+	} else if u, _, ok := r.BasicAuth(); ok && u != "" {
+		user = u
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	fmt.Fprintf(l.out, "%s - %s [%s] %q %d %d %q %q\n",
+		host,
+		user,
+		start.Format("02/Jan/2006:15:04:05 -0700"),
+		r.Method+" "+r.RequestURI+" "+r.Proto,
+		rw.status,
+		rw.size,
+		r.Referer(),
+		r.UserAgent(),
+	)
+}
+
+// statusRecorder records the status code and
+// body size of the response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int64
+}
+
+func (w *statusRecorder) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.size += int64(n)
+	return n, err
+}
+
+// Flush implements http.Flusher
+func (w *statusRecorder) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
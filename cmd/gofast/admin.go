@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/yookoala/gofast"
+)
+
+// log levels of LogConfig.Level
+const (
+	levelError int32 = iota
+	levelInfo
+	levelDebug
+)
+
+// logLevels are the log levels by name
+var logLevels = map[string]int32{
+	"":      levelInfo,
+	"error": levelError,
+	"info":  levelInfo,
+	"debug": levelDebug,
+}
+
+// levelName returns the name of the log level
+func levelName(level int32) string {
+	for name, l := range logLevels {
+		if name != "" && l == level {
+			return name
+		}
+	}
+	return "info"
+}
+
+// logf logs to ErrorLog if the log level is at least the given level
+func (g *Gateway) logf(level int32, format string, v ...interface{}) {
+	if atomic.LoadInt32(&g.level) >= level {
+		g.ErrorLog.Printf(format, v...)
+	}
+}
+
+// backend is the runtime state of a backend,
+// which is kept across reloads
+type backend struct {
+	inFlight int64
+	requests int64
+	errors   int64
+	disabled int32
+}
+
+// backend returns the state of the backend of the name
+func (g *Gateway) backend(name string) *backend {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	b, ok := g.backends[name]
+	if !ok {
+		b = &backend{}
+		g.backends[name] = b
+	}
+	return b
+}
+
+// backendHandler serves the FastCGI requests of the backend with the
+// inner handler, unless the backend is disabled, and counts them
+func (g *Gateway) backendHandler(name string, inner http.Handler) http.Handler {
+	b := g.backend(name)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&b.disabled) != 0 {
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		atomic.AddInt64(&b.inFlight, 1)
+		defer atomic.AddInt64(&b.inFlight, -1)
+		rw := &statusRecorder{ResponseWriter: w}
+		inner.ServeHTTP(rw, r)
+		if rw.status == 0 {
+			rw.status = http.StatusOK
+		}
+		atomic.AddInt64(&b.requests, 1)
+		if rw.status >= 500 {
+			atomic.AddInt64(&b.errors, 1)
+		}
+		g.logf(levelDebug, "gofast: %s %s by backend %s: %d", r.Method, r.RequestURI, name, rw.status)
+	})
+}
+
+// Stats are the live statistics of the gateway
+type Stats struct {
+	Draining bool                    `json:"draining"`
+	InFlight int                     `json:"in_flight"`
+	Requests int64                   `json:"requests"`
+	Uptime   string                  `json:"uptime"`
+	Reloads  int64                   `json:"reloads"`
+	LogLevel string                  `json:"log_level"`
+	Backends map[string]BackendStats `json:"backends"`
+}
+
+// BackendStats are the live statistics of a backend. Errors
+// are the responses of status 5xx (e.g. 502 if the backend is
+// not reachable).
+type BackendStats struct {
+	Address  string `json:"address"`
+	Enabled  bool   `json:"enabled"`
+	InFlight int64  `json:"in_flight"`
+	Requests int64  `json:"requests"`
+	Errors   int64  `json:"errors"`
+}
+
+// Stats returns the live statistics of the gateway
+func (g *Gateway) Stats() Stats {
+	stats := Stats{
+		Draining: g.drainer.Draining(),
+		InFlight: g.drainer.InFlight(),
+		Requests: atomic.LoadInt64(&g.requests),
+		Uptime:   time.Since(g.started).Round(time.Second).String(),
+		Reloads:  atomic.LoadInt64(&g.reloads),
+		LogLevel: levelName(atomic.LoadInt32(&g.level)),
+		Backends: make(map[string]BackendStats),
+	}
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+	for name, bc := range g.config.Backends {
+		b, ok := g.backends[name]
+		if !ok {
+			b = &backend{}
+		}
+		stats.Backends[name] = BackendStats{
+			Address:  bc.Address,
+			Enabled:  atomic.LoadInt32(&b.disabled) == 0,
+			InFlight: atomic.LoadInt64(&b.inFlight),
+			Requests: atomic.LoadInt64(&b.requests),
+			Errors:   atomic.LoadInt64(&b.errors),
+		}
+	}
+	return stats
+}
+
+// writeJSON writes the value as the JSON response
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// adminError writes the error as the JSON response
+func adminError(w http.ResponseWriter, code int, format string, v ...interface{}) {
+	writeJSON(w, code, map[string]string{"error": fmt.Sprintf(format, v...)})
+}
+
+// adminHandler returns the http.Handler of the admin API. See
+// README.md for the endpoints.
+func (g *Gateway) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stats", g.adminMethod("GET", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, g.Stats())
+	}))
+	mux.HandleFunc("/ready", g.adminMethod("GET", func(w http.ResponseWriter, r *http.Request) {
+		if g.drainer.Draining() {
+			adminError(w, http.StatusServiceUnavailable, "draining")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]bool{"ready": true})
+	}))
+	mux.HandleFunc("/drain", g.adminMethod("POST", g.adminDrain))
+	mux.HandleFunc("/reload", g.adminMethod("POST", g.adminReload))
+	mux.HandleFunc("/log-level", g.adminMethod("POST", g.adminLogLevel))
+	mux.HandleFunc("/backends/", g.adminMethod("POST", g.adminBackend))
+	return g.adminAuth(mux)
+}
+
+// adminAuth requires the bearer token of the admin config, if any
+func (g *Gateway) adminAuth(inner http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		g.mutex.RLock()
+		token := g.config.Admin.Token
+		g.mutex.RUnlock()
+		if token != "" {
+			auth := r.Header.Get("Authorization")
+			if !strings.HasPrefix(auth, "Bearer ") ||
+				subtle.ConstantTimeCompare([]byte(auth[len("Bearer "):]), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="gofast admin"`)
+				adminError(w, http.StatusUnauthorized, "unauthorized")
+				return
+			}
+		}
+		inner.ServeHTTP(w, r)
+	})
+}
+
+// adminMethod only allows the method for the handler
+func (g *Gateway) adminMethod(method string, inner http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			adminError(w, http.StatusMethodNotAllowed, "method %s not allowed", r.Method)
+			return
+		}
+		inner(w, r)
+	}
+}
+
+// adminDrain marks the gateway as draining. With ?wait=<duration>, it
+// waits for the in-flight requests to finish, up to the duration.
+func (g *Gateway) adminDrain(w http.ResponseWriter, r *http.Request) {
+	g.drainer.Drain()
+	g.logf(levelInfo, "gofast: draining")
+	if wait := r.URL.Query().Get("wait"); wait != "" {
+		timeout, err := time.ParseDuration(wait)
+		if err != nil {
+			adminError(w, http.StatusBadRequest, "invalid wait %q", wait)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		g.drainer.Wait(ctx)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"draining":  true,
+		"in_flight": g.drainer.InFlight(),
+	})
+}
+
+// adminReload reloads the config file
+func (g *Gateway) adminReload(w http.ResponseWriter, r *http.Request) {
+	if g.ConfigFile == "" {
+		adminError(w, http.StatusBadRequest, "no config file to reload")
+		return
+	}
+	if err := reloadConfig(g, g.ConfigFile); err != nil {
+		g.ErrorLog.Printf("gofast: failed to reload %s, keeping the old config: %s", g.ConfigFile, err)
+		adminError(w, http.StatusUnprocessableEntity, "%s", err)
+		return
+	}
+	g.logf(levelInfo, "gofast: reloaded %s", g.ConfigFile)
+	writeJSON(w, http.StatusOK, map[string]bool{"reloaded": true})
+}
+
+// adminLogLevel sets the log level of ?level=<level>
+// until the next reload
+func (g *Gateway) adminLogLevel(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("level")
+	level, ok := logLevels[name]
+	if !ok || name == "" {
+		adminError(w, http.StatusBadRequest, "unknown level %q", name)
+		return
+	}
+	atomic.StoreInt32(&g.level, level)
+	writeJSON(w, http.StatusOK, map[string]string{"log_level": name})
+}
+
+// adminBackend enables or disables the backend of
+// /backends/<name>/enable or /backends/<name>/disable
+func (g *Gateway) adminBackend(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/backends/"), "/")
+	if len(parts) != 2 || (parts[1] != "enable" && parts[1] != "disable") {
+		adminError(w, http.StatusNotFound, "not found")
+		return
+	}
+	name := parts[0]
+	g.mutex.RLock()
+	_, ok := g.config.Backends[name]
+	g.mutex.RUnlock()
+	if !ok {
+		adminError(w, http.StatusNotFound, "unknown backend %q", name)
+		return
+	}
+	var disabled int32
+	if parts[1] == "disable" {
+		disabled = 1
+	}
+	atomic.StoreInt32(&g.backend(name).disabled, disabled)
+	g.logf(levelInfo, "gofast: backend %s %sd", name, parts[1])
+	writeJSON(w, http.StatusOK, map[string]bool{"enabled": disabled == 0})
+}
+
+// listenAdmin listens to the address of the admin API. The unix
+// socket is made accessible by its owner only.
+func listenAdmin(address string) (net.Listener, error) {
+	network, address := gofast.ParseAddress(address)
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+	if network == "unix" && !gofast.IsAbstractUnixAddress(address) {
+		if err = os.Chmod(address, 0600); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+	return l, nil
+}
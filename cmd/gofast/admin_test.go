@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// adminDo sends a request to the admin API of the gateway
+func adminDo(g *Gateway, method, path, token string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	g.adminHandler().ServeHTTP(w, r)
+	return w
+}
+
+func get(g *Gateway, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	g.Handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+	return w
+}
+
+func TestAdmin_auth(t *testing.T) {
+	g, s, root := newTestGateway(t)
+	defer os.RemoveAll(root)
+	defer s.Close()
+	defer g.Close()
+	g.config.Admin.Token = "secret"
+
+	for _, token := range []string{"", "wrong"} {
+		w := adminDo(g, "GET", "/stats", token)
+		if want, have := http.StatusUnauthorized, w.Code; want != have {
+			t.Errorf("token %#v: expected %#v, got %#v", token, want, have)
+		}
+	}
+	if want, have := http.StatusOK, adminDo(g, "GET", "/stats", "secret").Code; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := http.StatusMethodNotAllowed, adminDo(g, "GET", "/drain", "secret").Code; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestAdmin_backends(t *testing.T) {
+	g, s, root := newTestGateway(t)
+	defer os.RemoveAll(root)
+	defer s.Close()
+	defer g.Close()
+
+	if want, have := http.StatusOK, adminDo(g, "POST", "/backends/app/disable", "").Code; want != have {
+		t.Fatalf("expected %#v, got %#v", want, have)
+	}
+	if want, have := http.StatusServiceUnavailable, get(g, "/index.php").Code; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	// static files are still served
+	if want, have := "body {}", get(g, "/style.css").Body.String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := false, g.Stats().Backends["app"].Enabled; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	// the state is kept across reloads
+	if err := g.Reload(g.config); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want, have := http.StatusServiceUnavailable, get(g, "/index.php").Code; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	adminDo(g, "POST", "/backends/app/enable", "")
+	if want, have := "hello", get(g, "/index.php").Body.String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	stats := g.Stats().Backends["app"]
+	if want, have := (BackendStats{Address: "unix:" + s.Address, Enabled: true, Requests: 1}), stats; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	for _, path := range []string{"/backends/nonsense/disable", "/backends/app/restart", "/backends/app"} {
+		if want, have := http.StatusNotFound, adminDo(g, "POST", path, "").Code; want != have {
+			t.Errorf("%s: expected %#v, got %#v", path, want, have)
+		}
+	}
+}
+
+func TestAdmin_drain(t *testing.T) {
+	g, s, root := newTestGateway(t)
+	defer os.RemoveAll(root)
+	defer s.Close()
+	defer g.Close()
+
+	if want, have := http.StatusOK, adminDo(g, "GET", "/ready", "").Code; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	w := adminDo(g, "POST", "/drain?wait=1s", "")
+	if want, have := "{\"draining\":true,\"in_flight\":0}\n", w.Body.String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := http.StatusServiceUnavailable, adminDo(g, "GET", "/ready", "").Code; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	// still served, but the connections are closed
+	w = get(g, "/index.php")
+	if want, have := "hello", w.Body.String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "close", w.Header().Get("Connection"); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := http.StatusBadRequest, adminDo(g, "POST", "/drain?wait=soon", "").Code; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestAdmin_reload(t *testing.T) {
+	g, s, root := newTestGateway(t)
+	defer os.RemoveAll(root)
+	defer s.Close()
+	defer g.Close()
+
+	w := adminDo(g, "POST", "/reload", "")
+	if want, have := http.StatusBadRequest, w.Code; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	g.ConfigFile = filepath.Join(root, "gofast.json")
+	ioutil.WriteFile(g.ConfigFile, []byte(`{
+		"listeners": [{"address": ":8080"}],
+		"routes": [{"type": "static", "docroot": "`+root+`"}],
+		"log": {"error": "off"}
+	}`), 0644)
+	if want, have := http.StatusOK, adminDo(g, "POST", "/reload", "").Code; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := http.StatusNotFound, get(g, "/api/users").Code; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := int64(1), g.Stats().Reloads; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	ioutil.WriteFile(g.ConfigFile, []byte(`{"listeners": []}`), 0644)
+	w = adminDo(g, "POST", "/reload", "")
+	if want, have := http.StatusUnprocessableEntity, w.Code; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "no listener", w.Body.String(); !strings.Contains(have, want) {
+		t.Errorf("expected %#v in %#v", want, have)
+	}
+}
+
+func TestAdmin_logLevel(t *testing.T) {
+	g, s, root := newTestGateway(t)
+	defer os.RemoveAll(root)
+	defer s.Close()
+	defer g.Close()
+	out := new(bytes.Buffer)
+	g.ErrorLog.SetOutput(out)
+
+	get(g, "/index.php")
+	adminDo(g, "POST", "/backends/app/disable", "")
+	if want, have := "gofast: backend app disabled\n", out.String(); !strings.HasSuffix(have, want) || strings.Count(have, "\n") != 1 {
+		t.Errorf("expected only %#v, got %#v", want, have)
+	}
+	adminDo(g, "POST", "/backends/app/enable", "")
+
+	if want, have := http.StatusOK, adminDo(g, "POST", "/log-level?level=debug", "").Code; want != have {
+		t.Fatalf("expected %#v, got %#v", want, have)
+	}
+	out.Reset()
+	get(g, "/index.php")
+	if want, have := "gofast: GET /index.php by backend app: 200\n", out.String(); !strings.HasSuffix(have, want) {
+		t.Errorf("expected suffix %#v, got %#v", want, have)
+	}
+
+	adminDo(g, "POST", "/log-level?level=error", "")
+	out.Reset()
+	adminDo(g, "POST", "/backends/app/disable", "")
+	if want, have := "", out.String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "error", g.Stats().LogLevel; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := http.StatusBadRequest, adminDo(g, "POST", "/log-level?level=loud", "").Code; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestAdmin_Serve(t *testing.T) {
+	g, s, root := newTestGateway(t)
+	defer os.RemoveAll(root)
+	defer s.Close()
+	defer g.Close()
+	sock := filepath.Join(root, "admin.sock")
+	g.config.Admin.Address = "unix:" + sock
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- g.Serve(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", sock)
+			},
+		},
+	}
+	var resp *http.Response
+	var err error
+	for i := 0; i < 50; i++ {
+		if resp, err = client.Get("http://admin/stats"); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer resp.Body.Close()
+	var stats Stats
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want, have := "unix:"+s.Address, stats.Backends["app"].Address; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	info, err := os.Stat(sock)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want, have := os.FileMode(0600), info.Mode().Perm(); want != have {
+		t.Errorf("expected %v, got %v", want, have)
+	}
+}
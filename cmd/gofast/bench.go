@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/yookoala/gofast"
+)
+
+func runBench(args []string, stdout, stderr io.Writer) error {
+	fs := newFlagSet("bench", stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: gofast bench -connect <address> [flags] [request URI]\n\n"+
+			"Sends FastCGI requests to the application directly, without HTTP, and\n"+
+			"reports the throughput and the latency percentiles.\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	connect := fs.String("connect", "", "address of the FastCGI application (e.g. 127.0.0.1:9000, unix:/run/php/php-fpm.sock)")
+	concurrency := fs.Int("c", 10, "number of concurrent requests")
+	requests := fs.Int("n", 0, "number of requests. Unlimited if 0, then -d applies")
+	duration := fs.Duration("d", 10*time.Second, "duration of the benchmark, if -n is 0")
+	size := fs.Int("size", 0, "size of the request body in bytes. POST if larger than 0")
+	script := fs.String("script", "", "script file that serves all request paths (e.g. /var/www/index.php)")
+	host := fs.String("host", "localhost", "host of the requests")
+	timeout := fs.Duration("timeout", 30*time.Second, "timeout of every request")
+	reuse := fs.Bool("reuse", true, "reuse the connections with FCGI_KEEP_CONN, instead of a new connection for every request")
+	var params listFlag
+	fs.Var(&params, "param", "extra FastCGI param as NAME=value, overriding the generated ones (repeatable)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if *connect == "" {
+		fmt.Fprintf(stderr, "gofast bench: -connect is required\n")
+		return errUsage
+	}
+	if *concurrency < 1 {
+		fmt.Fprintf(stderr, "gofast bench: -c must be at least 1\n")
+		return errUsage
+	}
+	if fs.NArg() > 1 {
+		fmt.Fprintf(stderr, "gofast bench: only one request URI is allowed\n")
+		return errUsage
+	}
+	uri := "/"
+	if fs.NArg() == 1 {
+		uri = fs.Arg(0)
+	}
+
+	extra := make(map[string]string, len(params))
+	for _, p := range params {
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("invalid param %q", p)
+		}
+		extra[kv[0]] = kv[1]
+	}
+	middlewares := []gofast.Middleware{gofast.BasicParamsMap, gofast.MapHeader}
+	if *script != "" {
+		middlewares = []gofast.Middleware{gofast.NewFileEndpoint(*script)}
+	}
+	middlewares = append(middlewares, func(inner gofast.SessionHandler) gofast.SessionHandler {
+		return func(client gofast.Client, req *gofast.Request) (*gofast.ResponsePipe, error) {
+			for k, v := range extra {
+				req.Params[k] = v
+			}
+			return inner(client, req)
+		}
+	})
+
+	method, payload := "GET", []byte(nil)
+	if *size > 0 {
+		method, payload = "POST", bytes.Repeat([]byte("x"), *size)
+	}
+	if _, err := http.NewRequest(method, "http://"+*host+uri, nil); err != nil {
+		return err
+	}
+
+	b := &bench{
+		clientFactory:  gofast.SimpleClientFactory(gofast.SimpleConnFactory(gofast.ParseAddress(*connect))),
+		sessionHandler: gofast.Chain(middlewares...)(gofast.BasicSession),
+		newRequest: func(ctx context.Context) *http.Request {
+			r, _ := http.NewRequest(method, "http://"+*host+uri, bytes.NewReader(payload))
+			r.RequestURI = uri
+			r.RemoteAddr = "127.0.0.1:0"
+			if len(payload) > 0 {
+				r.Header.Set("Content-Length", strconv.Itoa(len(payload)))
+				r.Header.Set("Content-Type", "application/octet-stream")
+			}
+			return r.WithContext(ctx)
+		},
+		concurrency: *concurrency,
+		requests:    *requests,
+		duration:    *duration,
+		timeout:     *timeout,
+		reuse:       *reuse,
+	}
+	fmt.Fprintf(stdout, "Benchmarking %s %s of %s with %d concurrent requests\n\n", method, uri, *connect, *concurrency)
+	report := b.run()
+	if _, err := report.WriteTo(stdout); err != nil {
+		return err
+	}
+	if report.Requests > 0 && report.Errors == report.Requests {
+		return fmt.Errorf("all requests failed: %s", report.FirstError)
+	}
+	return nil
+}
+
+// bench sends requests with concurrent workers
+type bench struct {
+	clientFactory  gofast.ClientFactory
+	sessionHandler gofast.SessionHandler
+	newRequest     func(ctx context.Context) *http.Request
+
+	concurrency int
+	requests    int
+	duration    time.Duration
+	timeout     time.Duration
+	reuse       bool
+}
+
+// benchReport is the result of a benchmark
+type benchReport struct {
+	Requests   int
+	Errors     int
+	FirstError error
+	Statuses   map[int]int
+	Bytes      int64
+	Elapsed    time.Duration
+
+	// Latencies of the requests without error, sorted
+	Latencies []time.Duration
+}
+
+// run runs the benchmark until the number of requests
+// are sent, or the duration has passed
+func (b *bench) run() *benchReport {
+	var issued int64
+	start := time.Now()
+	deadline := start.Add(b.duration)
+	next := func() bool {
+		if b.requests > 0 {
+			return atomic.AddInt64(&issued, 1) <= int64(b.requests)
+		}
+		return time.Now().Before(deadline)
+	}
+
+	reports := make([]*benchReport, b.concurrency)
+	var wg sync.WaitGroup
+	wg.Add(b.concurrency)
+	for i := range reports {
+		reports[i] = &benchReport{Statuses: make(map[int]int)}
+		go func(report *benchReport) {
+			defer wg.Done()
+			b.work(report, next)
+		}(reports[i])
+	}
+	wg.Wait()
+
+	report := &benchReport{Statuses: make(map[int]int), Elapsed: time.Since(start)}
+	for _, r := range reports {
+		report.Requests += r.Requests
+		report.Errors += r.Errors
+		if report.FirstError == nil {
+			report.FirstError = r.FirstError
+		}
+		for status, n := range r.Statuses {
+			report.Statuses[status] += n
+		}
+		report.Bytes += r.Bytes
+		report.Latencies = append(report.Latencies, r.Latencies...)
+	}
+	sort.Slice(report.Latencies, func(i, j int) bool {
+		return report.Latencies[i] < report.Latencies[j]
+	})
+	return report
+}
+
+// work sends requests one after another, while next returns true
+func (b *bench) work(report *benchReport, next func() bool) {
+	var client gofast.Client
+	defer func() {
+		if client != nil {
+			client.Close()
+		}
+	}()
+	for next() {
+		if client == nil {
+			var err error
+			if client, err = b.clientFactory(); err != nil {
+				report.Requests++
+				report.fail(err)
+				client = nil
+				continue
+			}
+		}
+		status, n, latency, err := b.do(client)
+		report.Requests++
+		if err != nil {
+			report.fail(err)
+		} else {
+			report.Statuses[status]++
+			report.Bytes += n
+			report.Latencies = append(report.Latencies, latency)
+		}
+
+		// the connection is not reused after error
+		if err != nil || !b.reuse {
+			client.Close()
+			client = nil
+		}
+	}
+}
+
+// do sends a request and reads the whole response
+func (b *bench) do(client gofast.Client) (status int, n int64, latency time.Duration, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), b.timeout)
+	defer cancel()
+	start := time.Now()
+	resp, err := b.sessionHandler(client, gofast.NewRequest(b.newRequest(ctx)))
+	if err != nil {
+		return
+	}
+	w := &benchResponseWriter{header: make(http.Header)}
+	if err = resp.WriteTo(w, ioutil.Discard); err != nil {
+		return
+	}
+	latency = time.Since(start)
+	if ctx.Err() != nil {
+		err = fmt.Errorf("request timeout after %s", b.timeout)
+		return
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.status, w.n, latency, nil
+}
+
+// fail counts a failed request
+func (r *benchReport) fail(err error) {
+	r.Errors++
+	if r.FirstError == nil {
+		r.FirstError = err
+	}
+}
+
+// percentile returns the latency of the percentile (0 to 100)
+func (r *benchReport) percentile(p float64) time.Duration {
+	if len(r.Latencies) == 0 {
+		return 0
+	}
+	i := int(float64(len(r.Latencies))*p/100+0.5) - 1
+	if i < 0 {
+		i = 0
+	} else if i >= len(r.Latencies) {
+		i = len(r.Latencies) - 1
+	}
+	return r.Latencies[i]
+}
+
+// WriteTo writes the report as text. Implements io.WriterTo.
+func (r *benchReport) WriteTo(w io.Writer) (int64, error) {
+	buf := new(bytes.Buffer)
+	seconds := r.Elapsed.Seconds()
+	fmt.Fprintf(buf, "Requests:      %d (%d errors)\n", r.Requests, r.Errors)
+	fmt.Fprintf(buf, "Elapsed:       %s\n", r.Elapsed.Round(time.Millisecond))
+	if seconds > 0 {
+		fmt.Fprintf(buf, "Throughput:    %.2f requests/s, %.2f KiB/s\n",
+			float64(r.Requests-r.Errors)/seconds, float64(r.Bytes)/1024/seconds)
+	}
+
+	statuses := make([]int, 0, len(r.Statuses))
+	for status := range r.Statuses {
+		statuses = append(statuses, status)
+	}
+	sort.Ints(statuses)
+	for _, status := range statuses {
+		fmt.Fprintf(buf, "Status %d:    %d\n", status, r.Statuses[status])
+	}
+	if r.FirstError != nil {
+		fmt.Fprintf(buf, "First error:   %s\n", r.FirstError)
+	}
+
+	if len(r.Latencies) > 0 {
+		var total time.Duration
+		for _, l := range r.Latencies {
+			total += l
+		}
+		fmt.Fprintf(buf, "\nLatency:\n")
+		fmt.Fprintf(buf, "  min    %s\n", r.Latencies[0])
+		fmt.Fprintf(buf, "  mean   %s\n", total/time.Duration(len(r.Latencies)))
+		for _, p := range []float64{50, 90, 95, 99} {
+			fmt.Fprintf(buf, "  p%-5g %s\n", p, r.percentile(p))
+		}
+		fmt.Fprintf(buf, "  max    %s\n", r.Latencies[len(r.Latencies)-1])
+	}
+	n, err := w.Write(buf.Bytes())
+	return int64(n), err
+}
+
+// benchResponseWriter is an http.ResponseWriter
+// that discards the response and counts the bytes
+type benchResponseWriter struct {
+	header http.Header
+	status int
+	n      int64
+}
+
+func (w *benchResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *benchResponseWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+}
+
+func (w *benchResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.n += int64(len(b))
+	return len(b), nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yookoala/gofast/fcgitest"
+)
+
+func TestBench(t *testing.T) {
+	s := fcgitest.NewUnixServer(fcgitest.Reply(fcgitest.Status(404), fcgitest.Body("hello")))
+	defer s.Close()
+
+	stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+	code := run([]string{
+		"bench",
+		"-connect", "unix:" + s.Address,
+		"-c", "4",
+		"-n", "20",
+		"-size", "1000",
+		"-script", "/srv/www/index.php",
+		"-param", "APP_ENV=bench",
+		"/hello",
+	}, stdout, stderr)
+	if want, have := 0, code; want != have {
+		t.Fatalf("expected %#v, got %#v (stderr: %s)", want, have, stderr.String())
+	}
+	for _, want := range []string{
+		"Benchmarking POST /hello of unix:" + s.Address + " with 4 concurrent requests\n",
+		"Requests:      20 (0 errors)\n",
+		"Status 404:    20\n",
+		"  p99    ",
+	} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Errorf("expected %#v in %#v", want, stdout.String())
+		}
+	}
+
+	requests := s.Requests()
+	if want, have := 20, len(requests); want != have {
+		t.Fatalf("expected %#v, got %#v", want, have)
+	}
+	req := s.LastRequest()
+	if want, have := 1000, len(req.Stdin); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	for name, want := range map[string]string{
+		"REQUEST_METHOD":  "POST",
+		"SCRIPT_FILENAME": "/srv/www/index.php",
+		"REQUEST_URI":     "/hello",
+		"CONTENT_LENGTH":  "1000",
+		"APP_ENV":         "bench",
+	} {
+		if have := req.Params[name]; want != have {
+			t.Errorf("%s: expected %#v, got %#v", name, want, have)
+		}
+	}
+}
+
+func TestBench_failed(t *testing.T) {
+	stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+	code := run([]string{"bench", "-connect", "unix:/nonexistent/fcgi.sock", "-c", "2", "-d", "50ms"}, stdout, stderr)
+	if want, have := 1, code; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want := "gofast: all requests failed: "; !strings.HasPrefix(stderr.String(), want) {
+		t.Errorf("expected prefix %#v, got %#v", want, stderr.String())
+	}
+}
+
+func TestBench_usage(t *testing.T) {
+	stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+	if want, have := 2, run([]string{"bench"}, stdout, stderr); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := 2, run([]string{"bench", "-connect", "127.0.0.1:9000", "-c", "0"}, stdout, stderr); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestBenchReport_percentile(t *testing.T) {
+	r := &benchReport{}
+	for i := 1; i <= 100; i++ {
+		r.Latencies = append(r.Latencies, time.Duration(i)*time.Millisecond)
+	}
+	for p, want := range map[float64]time.Duration{
+		50:  50 * time.Millisecond,
+		90:  90 * time.Millisecond,
+		99:  99 * time.Millisecond,
+		100: 100 * time.Millisecond,
+		0:   1 * time.Millisecond,
+	} {
+		if have := r.percentile(p); want != have {
+			t.Errorf("p%g: expected %s, got %s", p, want, have)
+		}
+	}
+	if want, have := time.Duration(0), (&benchReport{}).percentile(50); want != have {
+		t.Errorf("expected %s, got %s", want, have)
+	}
+}
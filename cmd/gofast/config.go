@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/yookoala/gofast"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the configuration of the gateway
+type Config struct {
+	Listeners []ListenerConfig         `yaml:"listeners" toml:"listeners" json:"listeners"`
+	Backends  map[string]BackendConfig `yaml:"backends" toml:"backends" json:"backends"`
+	Routes    []RouteConfig            `yaml:"routes" toml:"routes" json:"routes"`
+	Limits    LimitsConfig             `yaml:"limits" toml:"limits" json:"limits"`
+	Log       LogConfig                `yaml:"log" toml:"log" json:"log"`
+	Admin     AdminConfig              `yaml:"admin" toml:"admin" json:"admin"`
+}
+
+// ListenerConfig configures an HTTP listener
+type ListenerConfig struct {
+
+	// Address to listen to, in the format of gofast.ParseAddress
+	// (e.g. ":8080", "unix:/run/gofast.sock"). Use "systemd" (or
+	// "systemd:name" for the sockets of the FileDescriptorName=)
+	// to use the sockets passed by systemd socket activation.
+	Address string `yaml:"address" toml:"address" json:"address"`
+
+	// TLSCert and TLSKey are the certificate and key files.
+	// The listener serves HTTPS if both are set.
+	TLSCert string `yaml:"tls_cert" toml:"tls_cert" json:"tls_cert"`
+	TLSKey  string `yaml:"tls_key" toml:"tls_key" json:"tls_key"`
+}
+
+// BackendConfig configures a FastCGI application
+type BackendConfig struct {
+
+	// Address of the FastCGI application, in the format
+	// of gofast.ParseAddress (e.g. "127.0.0.1:9000",
+	// "unix:/run/php/php-fpm.sock").
+	Address string `yaml:"address" toml:"address" json:"address"`
+
+	// Pool of clients. No pool if the size is 0.
+	Pool PoolConfig `yaml:"pool" toml:"pool" json:"pool"`
+}
+
+// PoolConfig configures a gofast.ClientPool
+type PoolConfig struct {
+	Size    uint     `yaml:"size" toml:"size" json:"size"`
+	Expires Duration `yaml:"expires" toml:"expires" json:"expires"`
+}
+
+// RouteConfig configures how requests of a path prefix are served
+type RouteConfig struct {
+
+	// Path prefix of the route (e.g. "/", "/blog/"). Path without
+	// trailing slash only matches the exact path. Default "/".
+	Path string `yaml:"path" toml:"path" json:"path"`
+
+	// Host of the route (e.g. "example.com"). Matches all hosts if empty.
+	Host string `yaml:"host" toml:"host" json:"host"`
+
+	// Type of the route:
+	//  php:      PHP scripts in DocRoot with Backend, other files
+	//            served as static files (default).
+	//  endpoint: all requests served by the Endpoint script with Backend.
+	//  static:   static files in DocRoot only.
+	Type string `yaml:"type" toml:"type" json:"type"`
+
+	// Backend is the name of the backend in Backends
+	Backend string `yaml:"backend" toml:"backend" json:"backend"`
+
+	// DocRoot is the document root of the route
+	DocRoot string `yaml:"docroot" toml:"docroot" json:"docroot"`
+
+	// Endpoint is the script file of an endpoint route
+	Endpoint string `yaml:"endpoint" toml:"endpoint" json:"endpoint"`
+
+	// TryFiles, if true, passes the requests of missing static
+	// files to Backend (see gofast.FileServerHandler.FallThrough).
+	TryFiles bool `yaml:"try_files" toml:"try_files" json:"try_files"`
+
+	// StripPrefix, if true, strips Path from the request path before
+	// looking up files in DocRoot (see gofast.Mount). The application
+	// still sees the full path in SCRIPT_NAME and DOCUMENT_URI.
+	StripPrefix bool `yaml:"strip_prefix" toml:"strip_prefix" json:"strip_prefix"`
+
+	// Rules to pick the backend, rewrite the path and add
+	// params by the request. See RuleConfig.
+	Rules []RuleConfig `yaml:"rules" toml:"rules" json:"rules"`
+}
+
+// LimitsConfig configures the limits of the HTTP servers
+type LimitsConfig struct {
+	MaxBodyBytes    int64    `yaml:"max_body_bytes" toml:"max_body_bytes" json:"max_body_bytes"`
+	MaxHeaderBytes  int      `yaml:"max_header_bytes" toml:"max_header_bytes" json:"max_header_bytes"`
+	ReadTimeout     Duration `yaml:"read_timeout" toml:"read_timeout" json:"read_timeout"`
+	WriteTimeout    Duration `yaml:"write_timeout" toml:"write_timeout" json:"write_timeout"`
+	IdleTimeout     Duration `yaml:"idle_timeout" toml:"idle_timeout" json:"idle_timeout"`
+	ShutdownTimeout Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout" json:"shutdown_timeout"`
+}
+
+// LogConfig configures the logs. Each log can be a file path,
+// "stdout", "stderr" or "off". Access log is off by default, and
+// error log goes to stderr by default.
+type LogConfig struct {
+	Access string `yaml:"access" toml:"access" json:"access"`
+	Error  string `yaml:"error" toml:"error" json:"error"`
+
+	// Level of the error log: "error" for errors only, "info"
+	// for the notices of the gateway too (default), or "debug"
+	// for each request to the backends too.
+	Level string `yaml:"level" toml:"level" json:"level"`
+}
+
+// AdminConfig configures the admin API of the gateway
+type AdminConfig struct {
+
+	// Address to listen to, in the format of gofast.ParseAddress
+	// (e.g. "unix:/run/gofast-admin.sock", "127.0.0.1:9901"). The
+	// admin API is off if empty.
+	Address string `yaml:"address" toml:"address" json:"address"`
+
+	// Token is the bearer token required by the admin API. Required
+	// unless the address is a unix socket file, which is made
+	// accessible by its owner only.
+	Token string `yaml:"token" toml:"token" json:"token"`
+}
+
+// Duration is a time.Duration in the format of
+// time.ParseDuration (e.g. "30s") in config files
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (d *Duration) UnmarshalText(text []byte) (err error) {
+	d.Duration, err = time.ParseDuration(string(text))
+	return
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.Duration.String()), nil
+}
+
+// LoadConfig reads the config file. The format is determined by the
+// file extension: ".yaml" / ".yml" for YAML, ".toml" for TOML and
+// ".json" for JSON. Unknown fields are errors.
+func LoadConfig(filename string) (*Config, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	c, err := ParseConfig(b, strings.TrimPrefix(filepath.Ext(filename), "."))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+	return c, nil
+}
+
+// ParseConfig parses the config in the given format ("yaml",
+// "yml", "toml" or "json") and validates it.
+func ParseConfig(b []byte, format string) (c *Config, err error) {
+	c = &Config{}
+	switch strings.ToLower(format) {
+	case "yaml", "yml":
+		dec := yaml.NewDecoder(bytes.NewReader(b))
+		dec.KnownFields(true)
+		err = dec.Decode(c)
+	case "toml":
+		var md toml.MetaData
+		if md, err = toml.Decode(string(b), c); err == nil {
+			if undecoded := md.Undecoded(); len(undecoded) > 0 {
+				err = fmt.Errorf("unknown field %q", undecoded[0].String())
+			}
+		}
+	case "json":
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(c)
+	default:
+		err = fmt.Errorf("unknown config format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = c.validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// validate checks the config and fills in the defaults
+func (c *Config) validate() error {
+	if len(c.Listeners) == 0 {
+		return fmt.Errorf("no listener")
+	}
+	for i, l := range c.Listeners {
+		if l.Address == "" {
+			return fmt.Errorf("listeners[%d]: no address", i)
+		}
+		if (l.TLSCert == "") != (l.TLSKey == "") {
+			return fmt.Errorf("listeners[%d]: tls_cert and tls_key must be both set", i)
+		}
+	}
+	for name, b := range c.Backends {
+		if b.Address == "" {
+			return fmt.Errorf("backends.%s: no address", name)
+		}
+	}
+	if c.Admin.Address != "" && c.Admin.Token == "" {
+		// the abstract sockets have no file permissions
+		if network, address := gofast.ParseAddress(c.Admin.Address); network != "unix" || gofast.IsAbstractUnixAddress(address) {
+			return fmt.Errorf("admin: token is required for address %q", c.Admin.Address)
+		}
+	}
+	if _, ok := logLevels[c.Log.Level]; !ok {
+		return fmt.Errorf("log: unknown level %q", c.Log.Level)
+	}
+	if len(c.Routes) == 0 {
+		return fmt.Errorf("no route")
+	}
+	for i := range c.Routes {
+		r := &c.Routes[i]
+		if r.Path == "" {
+			r.Path = "/"
+		}
+		if r.Path[0] != '/' {
+			return fmt.Errorf("routes[%d]: path %q does not start with \"/\"", i, r.Path)
+		}
+		if r.Type == "" {
+			r.Type = "php"
+		}
+		switch r.Type {
+		case "php", "static":
+			if r.DocRoot == "" {
+				return fmt.Errorf("routes[%d]: no docroot", i)
+			}
+		case "endpoint":
+			if r.Endpoint == "" {
+				return fmt.Errorf("routes[%d]: no endpoint", i)
+			}
+		default:
+			return fmt.Errorf("routes[%d]: unknown type %q", i, r.Type)
+		}
+		if r.Type != "static" {
+			if _, ok := c.Backends[r.Backend]; !ok {
+				return fmt.Errorf("routes[%d]: unknown backend %q", i, r.Backend)
+			}
+		}
+		for j := range r.Rules {
+			rule := &r.Rules[j]
+			if err := rule.compile(); err != nil {
+				return fmt.Errorf("routes[%d].rules[%d]: %s", i, j, err)
+			}
+			if rule.Backend == "" && len(rule.Params) == 0 {
+				continue
+			}
+			if r.Type == "static" {
+				return fmt.Errorf("routes[%d].rules[%d]: backend and params are not for static route", i, j)
+			}
+			if _, ok := c.Backends[rule.Backend]; rule.Backend != "" && !ok {
+				return fmt.Errorf("routes[%d].rules[%d]: unknown backend %q", i, j, rule.Backend)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func checkExampleConfig(t *testing.T, c *Config) {
+	if want, have := ":8080", c.Listeners[0].Address; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "unix:/run/php/php-fpm.sock", c.Backends["php"].Address; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := uint(16), c.Backends["php"].Pool.Size; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := 30*time.Second, c.Backends["php"].Pool.Expires.Duration; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := 2, len(c.Routes); want != have {
+		t.Fatalf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "endpoint", c.Routes[0].Type; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "php", c.Routes[1].Type; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := true, c.Routes[1].TryFiles; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := int64(10485760), c.Limits.MaxBodyBytes; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := 2*time.Minute, c.Limits.IdleTimeout.Duration; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "stdout", c.Log.Access; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestLoadConfig_YAML(t *testing.T) {
+	c, err := LoadConfig("gofast.example.yaml")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	checkExampleConfig(t, c)
+}
+
+func TestLoadConfig_TOML(t *testing.T) {
+	c, err := LoadConfig("gofast.example.toml")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	checkExampleConfig(t, c)
+}
+
+func TestParseConfig_JSON(t *testing.T) {
+	c, err := ParseConfig([]byte(`{
+		"listeners": [{"address": ":8080"}],
+		"backends": {"php": {"address": "127.0.0.1:9000", "pool": {"size": 4, "expires": "1m"}}},
+		"routes": [{"backend": "php", "docroot": "/var/www/html"}]
+	}`), "json")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want, have := time.Minute, c.Backends["php"].Pool.Expires.Duration; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	// defaults
+	if want, have := "/", c.Routes[0].Path; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "php", c.Routes[0].Type; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestParseConfig_errors(t *testing.T) {
+	tests := []struct {
+		desc   string
+		config string
+		err    string
+	}{
+		{
+			desc:   "unknown field",
+			config: `{"listeners": [{"address": ":8080"}], "route": []}`,
+			err:    `unknown field "route"`,
+		},
+		{
+			desc:   "no listener",
+			config: `{"routes": [{"type": "static", "docroot": "/var/www"}]}`,
+			err:    "no listener",
+		},
+		{
+			desc:   "no route",
+			config: `{"listeners": [{"address": ":8080"}]}`,
+			err:    "no route",
+		},
+		{
+			desc:   "tls key only",
+			config: `{"listeners": [{"address": ":8443", "tls_key": "site.key"}], "routes": [{"type": "static", "docroot": "/var/www"}]}`,
+			err:    "listeners[0]: tls_cert and tls_key must be both set",
+		},
+		{
+			desc:   "unknown backend",
+			config: `{"listeners": [{"address": ":8080"}], "routes": [{"backend": "php", "docroot": "/var/www"}]}`,
+			err:    `routes[0]: unknown backend "php"`,
+		},
+		{
+			desc:   "unknown type",
+			config: `{"listeners": [{"address": ":8080"}], "routes": [{"type": "cgi", "docroot": "/var/www"}]}`,
+			err:    `routes[0]: unknown type "cgi"`,
+		},
+		{
+			desc:   "endpoint without script",
+			config: `{"listeners": [{"address": ":8080"}], "backends": {"php": {"address": ":9000"}}, "routes": [{"type": "endpoint", "backend": "php"}]}`,
+			err:    "routes[0]: no endpoint",
+		},
+		{
+			desc:   "admin without token",
+			config: `{"listeners": [{"address": ":8080"}], "admin": {"address": "127.0.0.1:9901"}, "routes": [{"type": "static", "docroot": "/var/www"}]}`,
+			err:    `admin: token is required for address "127.0.0.1:9901"`,
+		},
+		{
+			desc:   "admin of abstract socket without token",
+			config: `{"listeners": [{"address": ":8080"}], "admin": {"address": "unix:@gofast-admin"}, "routes": [{"type": "static", "docroot": "/var/www"}]}`,
+			err:    `admin: token is required for address "unix:@gofast-admin"`,
+		},
+		{
+			desc:   "unknown log level",
+			config: `{"listeners": [{"address": ":8080"}], "log": {"level": "loud"}, "routes": [{"type": "static", "docroot": "/var/www"}]}`,
+			err:    `log: unknown level "loud"`,
+		},
+		{
+			desc:   "invalid rule expression",
+			config: `{"listeners": [{"address": ":8080"}], "routes": [{"type": "static", "docroot": "/var/www", "rules": [{"if": "path"}]}]}`,
+			err:    `routes[0].rules[0]: if: expression "path" is string, not bool`,
+		},
+		{
+			desc:   "rule of unknown backend",
+			config: `{"listeners": [{"address": ":8080"}], "backends": {"php": {"address": ":9000"}}, "routes": [{"backend": "php", "docroot": "/var/www", "rules": [{"backend": "canary"}]}]}`,
+			err:    `routes[0].rules[0]: unknown backend "canary"`,
+		},
+		{
+			desc:   "rule params of static route",
+			config: `{"listeners": [{"address": ":8080"}], "routes": [{"type": "static", "docroot": "/var/www", "rules": [{"params": {"A": "path"}}]}]}`,
+			err:    "routes[0].rules[0]: backend and params are not for static route",
+		},
+		{
+			desc:   "bad duration",
+			config: `{"listeners": [{"address": ":8080"}], "limits": {"read_timeout": "soon"}}`,
+			err:    "invalid duration",
+		},
+	}
+	for _, test := range tests {
+		_, err := ParseConfig([]byte(test.config), "json")
+		if err == nil {
+			t.Errorf("%s: expected error, got nil", test.desc)
+		} else if !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expected error containing %#v, got %#v", test.desc, test.err, err.Error())
+		}
+	}
+
+	if _, err := ParseConfig([]byte(`{}`), "ini"); err == nil {
+		t.Errorf("expected error, got nil")
+	} else if want, have := `unknown config format "ini"`, err.Error(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
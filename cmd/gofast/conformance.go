@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/yookoala/gofast"
+	"github.com/yookoala/gofast/tools/conformance"
+)
+
+func runConformance(args []string, stdout, stderr io.Writer) error {
+	fs := newFlagSet("conformance", stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: gofast conformance -connect <address> [flags]\n\n"+
+			"Checks the FastCGI application for compliance with the FastCGI\n"+
+			"specification, and prints the report. Exits with 1 if any check fails.\n\n"+
+			"Checks:\n")
+		for _, check := range conformance.Checks {
+			fmt.Fprintf(stderr, "  %-14s %s\n", check.Name, check.Description)
+		}
+		fmt.Fprintf(stderr, "\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	connect := fs.String("connect", "", "address of the FastCGI application (e.g. 127.0.0.1:9000, unix:/run/php/php-fpm.sock)")
+	timeout := fs.Duration("timeout", 5*time.Second, "timeout of every read and write")
+	var params, checks listFlag
+	fs.Var(&params, "param", "FastCGI param of every request as NAME=value, e.g. SCRIPT_FILENAME=/var/www/index.php (repeatable)")
+	fs.Var(&checks, "check", "name of the check to run, instead of all checks (repeatable)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *connect == "" {
+		fmt.Fprintf(stderr, "gofast conformance: -connect is required\n")
+		return errUsage
+	}
+
+	c := conformance.Config{
+		ConnFactory: gofast.SimpleConnFactory(gofast.ParseAddress(*connect)),
+		Params:      make(map[string]string, len(params)),
+		Timeout:     *timeout,
+		Checks:      checks,
+	}
+	for _, p := range params {
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("invalid param %q", p)
+		}
+		c.Params[kv[0]] = kv[1]
+	}
+	report, err := conformance.Run(c)
+	if err != nil {
+		return err
+	}
+	if _, err = report.WriteTo(stdout); err != nil {
+		return err
+	}
+	if !report.Passed() {
+		return fmt.Errorf("%d of %d checks failed", report.Count(conformance.Fail), len(report.Results))
+	}
+	return nil
+}
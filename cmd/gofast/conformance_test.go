@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yookoala/gofast/fcgitest"
+)
+
+func TestConformance(t *testing.T) {
+	s := fcgitest.NewUnixServer(fcgitest.Reply(fcgitest.Body("hello")))
+	defer s.Close()
+
+	stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+	code := run([]string{
+		"conformance",
+		"-connect", "unix:" + s.Address,
+		"-param", "SCRIPT_FILENAME=/srv/www/index.php",
+		"-check", "responder",
+		"-check", "get-values",
+	}, stdout, stderr)
+	if want, have := 0, code; want != have {
+		t.Fatalf("expected %#v, got %#v (stderr: %s)", want, have, stderr.String())
+	}
+	if want, have := "2 passed, 0 warnings, 0 failed, 0 skipped\n", stdout.String(); !strings.HasSuffix(have, want) {
+		t.Errorf("expected suffix %#v, got %#v", want, have)
+	}
+	if want, have := "/srv/www/index.php", s.LastRequest().Params["SCRIPT_FILENAME"]; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestConformance_failed(t *testing.T) {
+	// application that closes every connection
+	dir, err := ioutil.TempDir("", "gofast-cmd-")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	address := filepath.Join(dir, "app.sock")
+	l, err := net.Listen("unix", address)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+	code := run([]string{
+		"conformance",
+		"-connect", "unix:" + address,
+		"-timeout", "100ms",
+		"-check", "responder",
+	}, stdout, stderr)
+	if want, have := 1, code; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "FAIL  responder", stdout.String(); !strings.HasPrefix(have, want) {
+		t.Errorf("expected prefix %#v, got %#v", want, have)
+	}
+	if want, have := "gofast: 1 of 1 checks failed\n", stderr.String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestConformance_usage(t *testing.T) {
+	stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+	if want, have := 2, run([]string{"conformance"}, stdout, stderr); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want := "-connect is required"; !strings.Contains(stderr.String(), want) {
+		t.Errorf("expected %#v in %#v", want, stderr.String())
+	}
+	if want, have := 0, run([]string{"conformance", "-h"}, stdout, stderr); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want := "  multiplex      multiplexed requests on a connection\n"; !strings.Contains(stderr.String(), want) {
+		t.Errorf("expected %#v in %#v", want, stderr.String())
+	}
+}
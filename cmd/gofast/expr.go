@@ -0,0 +1,277 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// types of the values of expressions
+const (
+	typeString = "string"
+	typeBool   = "bool"
+)
+
+// expr is a compiled expression of the routing rules. The expressions
+// are in the syntax of Go expressions, of the variables, functions and
+// operators below, e.g.
+//
+//	header("X-Canary") == "1" && has_prefix(path, "/api/")
+//
+// They are type checked when compiled, so the errors are found
+// when the config is loaded instead of when serving.
+type expr struct {
+	typ  string
+	eval func(r *http.Request) interface{}
+}
+
+// exprVars are the variables of the request
+var exprVars = map[string]func(r *http.Request) string{
+	"method": func(r *http.Request) string { return r.Method },
+	"path":   func(r *http.Request) string { return r.URL.Path },
+	"query":  func(r *http.Request) string { return r.URL.RawQuery },
+	"host": func(r *http.Request) string {
+		if host, _, err := net.SplitHostPort(r.Host); err == nil {
+			return host
+		}
+		return r.Host
+	},
+	"remote_addr": func(r *http.Request) string {
+		if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			return host
+		}
+		return r.RemoteAddr
+	},
+	"scheme": func(r *http.Request) string {
+		if r.TLS != nil {
+			return "https"
+		}
+		return "http"
+	},
+}
+
+// exprFunc is a function of the expressions, with the
+// types of the arguments and the result
+type exprFunc struct {
+	args   []string
+	result string
+	call   func(r *http.Request, args []interface{}) interface{}
+}
+
+// strFunc returns an exprFunc of strings to string
+func strFunc(n int, f func(args []string) string) exprFunc {
+	return exprFunc{args: stringArgs(n), result: typeString, call: func(r *http.Request, args []interface{}) interface{} {
+		return f(stringValues(args))
+	}}
+}
+
+// boolFunc returns an exprFunc of strings to bool
+func boolFunc(n int, f func(args []string) bool) exprFunc {
+	return exprFunc{args: stringArgs(n), result: typeBool, call: func(r *http.Request, args []interface{}) interface{} {
+		return f(stringValues(args))
+	}}
+}
+
+func stringArgs(n int) []string {
+	args := make([]string, n)
+	for i := range args {
+		args[i] = typeString
+	}
+	return args
+}
+
+func stringValues(args []interface{}) []string {
+	s := make([]string, len(args))
+	for i, arg := range args {
+		s[i] = arg.(string)
+	}
+	return s
+}
+
+// exprFuncs are the functions of the expressions. The regular
+// expression of matches is compiled with the expression.
+var exprFuncs = map[string]exprFunc{
+	"header": {args: []string{typeString}, result: typeString, call: func(r *http.Request, args []interface{}) interface{} {
+		return r.Header.Get(args[0].(string))
+	}},
+	"cookie": {args: []string{typeString}, result: typeString, call: func(r *http.Request, args []interface{}) interface{} {
+		if c, err := r.Cookie(args[0].(string)); err == nil {
+			return c.Value
+		}
+		return ""
+	}},
+	"arg": {args: []string{typeString}, result: typeString, call: func(r *http.Request, args []interface{}) interface{} {
+		return r.URL.Query().Get(args[0].(string))
+	}},
+	"lower":       strFunc(1, func(s []string) string { return strings.ToLower(s[0]) }),
+	"upper":       strFunc(1, func(s []string) string { return strings.ToUpper(s[0]) }),
+	"trim_prefix": strFunc(2, func(s []string) string { return strings.TrimPrefix(s[0], s[1]) }),
+	"trim_suffix": strFunc(2, func(s []string) string { return strings.TrimSuffix(s[0], s[1]) }),
+	"replace":     strFunc(3, func(s []string) string { return strings.ReplaceAll(s[0], s[1], s[2]) }),
+	"has_prefix":  boolFunc(2, func(s []string) bool { return strings.HasPrefix(s[0], s[1]) }),
+	"has_suffix":  boolFunc(2, func(s []string) bool { return strings.HasSuffix(s[0], s[1]) }),
+	"contains":    boolFunc(2, func(s []string) bool { return strings.Contains(s[0], s[1]) }),
+}
+
+// compileExpr compiles the expression of the source,
+// which must be of the type
+func compileExpr(src, typ string) (*expr, error) {
+	node, err := parser.ParseExpr(src)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %s", src, err)
+	}
+	e, err := compileNode(node)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %s", src, err)
+	}
+	if e.typ != typ {
+		return nil, fmt.Errorf("expression %q is %s, not %s", src, e.typ, typ)
+	}
+	return e, nil
+}
+
+// evalString evaluates the expression of string
+func (e *expr) evalString(r *http.Request) string {
+	return e.eval(r).(string)
+}
+
+// evalBool evaluates the expression of bool
+func (e *expr) evalBool(r *http.Request) bool {
+	return e.eval(r).(bool)
+}
+
+func compileNode(node ast.Expr) (*expr, error) {
+	switch n := node.(type) {
+	case *ast.ParenExpr:
+		return compileNode(n.X)
+	case *ast.BasicLit:
+		if n.Kind != token.STRING {
+			return nil, fmt.Errorf("only string literals are supported, got %s", n.Value)
+		}
+		s, err := strconv.Unquote(n.Value)
+		if err != nil {
+			return nil, err
+		}
+		return &expr{typeString, func(r *http.Request) interface{} { return s }}, nil
+	case *ast.Ident:
+		switch n.Name {
+		case "true", "false":
+			b := n.Name == "true"
+			return &expr{typeBool, func(r *http.Request) interface{} { return b }}, nil
+		}
+		v, ok := exprVars[n.Name]
+		if !ok {
+			return nil, fmt.Errorf("unknown variable %q", n.Name)
+		}
+		return &expr{typeString, func(r *http.Request) interface{} { return v(r) }}, nil
+	case *ast.CallExpr:
+		return compileCall(n)
+	case *ast.UnaryExpr:
+		x, err := compileNode(n.X)
+		if err != nil {
+			return nil, err
+		}
+		if n.Op != token.NOT || x.typ != typeBool {
+			return nil, fmt.Errorf("operator %s not supported for %s", n.Op, x.typ)
+		}
+		return &expr{typeBool, func(r *http.Request) interface{} { return !x.evalBool(r) }}, nil
+	case *ast.BinaryExpr:
+		return compileBinary(n)
+	}
+	return nil, fmt.Errorf("unsupported expression %T", node)
+}
+
+func compileCall(n *ast.CallExpr) (*expr, error) {
+	ident, ok := n.Fun.(*ast.Ident)
+	if !ok {
+		return nil, fmt.Errorf("unsupported function call")
+	}
+	if ident.Name == "matches" {
+		return compileMatches(n)
+	}
+	f, ok := exprFuncs[ident.Name]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %q", ident.Name)
+	}
+	if len(n.Args) != len(f.args) {
+		return nil, fmt.Errorf("%s requires %d arguments, got %d", ident.Name, len(f.args), len(n.Args))
+	}
+	args := make([]*expr, len(n.Args))
+	for i, arg := range n.Args {
+		var err error
+		if args[i], err = compileNode(arg); err != nil {
+			return nil, err
+		}
+		if args[i].typ != f.args[i] {
+			return nil, fmt.Errorf("argument %d of %s is %s, not %s", i+1, ident.Name, args[i].typ, f.args[i])
+		}
+	}
+	return &expr{f.result, func(r *http.Request) interface{} {
+		values := make([]interface{}, len(args))
+		for i, arg := range args {
+			values[i] = arg.eval(r)
+		}
+		return f.call(r, values)
+	}}, nil
+}
+
+// compileMatches compiles matches(s, pattern), of which
+// the pattern is a string literal of regular expression
+func compileMatches(n *ast.CallExpr) (*expr, error) {
+	if len(n.Args) != 2 {
+		return nil, fmt.Errorf("matches requires 2 arguments, got %d", len(n.Args))
+	}
+	s, err := compileNode(n.Args[0])
+	if err != nil {
+		return nil, err
+	}
+	if s.typ != typeString {
+		return nil, fmt.Errorf("argument 1 of matches is %s, not string", s.typ)
+	}
+	lit, ok := n.Args[1].(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return nil, fmt.Errorf("pattern of matches must be a string literal")
+	}
+	pattern, err := strconv.Unquote(lit.Value)
+	if err != nil {
+		return nil, err
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return &expr{typeBool, func(r *http.Request) interface{} { return re.MatchString(s.evalString(r)) }}, nil
+}
+
+func compileBinary(n *ast.BinaryExpr) (*expr, error) {
+	x, err := compileNode(n.X)
+	if err != nil {
+		return nil, err
+	}
+	y, err := compileNode(n.Y)
+	if err != nil {
+		return nil, err
+	}
+	if x.typ != y.typ {
+		return nil, fmt.Errorf("operator %s of mismatched types %s and %s", n.Op, x.typ, y.typ)
+	}
+	switch {
+	case n.Op == token.EQL:
+		return &expr{typeBool, func(r *http.Request) interface{} { return x.eval(r) == y.eval(r) }}, nil
+	case n.Op == token.NEQ:
+		return &expr{typeBool, func(r *http.Request) interface{} { return x.eval(r) != y.eval(r) }}, nil
+	case n.Op == token.ADD && x.typ == typeString:
+		return &expr{typeString, func(r *http.Request) interface{} { return x.evalString(r) + y.evalString(r) }}, nil
+	case n.Op == token.LAND && x.typ == typeBool:
+		return &expr{typeBool, func(r *http.Request) interface{} { return x.evalBool(r) && y.evalBool(r) }}, nil
+	case n.Op == token.LOR && x.typ == typeBool:
+		return &expr{typeBool, func(r *http.Request) interface{} { return x.evalBool(r) || y.evalBool(r) }}, nil
+	}
+	return nil, fmt.Errorf("operator %s not supported for %s", n.Op, x.typ)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestExpr(t *testing.T) {
+	r := httptest.NewRequest("POST", "http://example.com:8080/api/v1/users?page=2", nil)
+	r.Header.Set("X-Canary", "1")
+	r.Header.Set("Cookie", "tenant=acme")
+	r.RemoteAddr = "192.0.2.1:1234"
+
+	tests := []struct {
+		src  string
+		want interface{}
+	}{
+		{`method`, "POST"},
+		{`path`, "/api/v1/users"},
+		{`query`, "page=2"},
+		{`host`, "example.com"},
+		{`remote_addr`, "192.0.2.1"},
+		{`scheme`, "http"},
+		{`header("X-Canary")`, "1"},
+		{`header("X-None")`, ""},
+		{`cookie("tenant")`, "acme"},
+		{`cookie("none")`, ""},
+		{`arg("page")`, "2"},
+		{`upper(method) + ":" + lower("ABC")`, "POST:abc"},
+		{`trim_prefix(path, "/api")`, "/v1/users"},
+		{`trim_suffix(path, "/users")`, "/api/v1"},
+		{`replace(path, "/", "_")`, "_api_v1_users"},
+		{`header("X-Canary") == "1"`, true},
+		{`header("X-Canary") != "1"`, false},
+		{`has_prefix(path, "/api/") && !has_suffix(path, ".php")`, true},
+		{`contains(path, "admin") || method == "GET"`, false},
+		{`matches(path, "^/api/v[0-9]+/")`, true},
+		{`(true || false) && false`, false},
+	}
+	for _, test := range tests {
+		typ := typeString
+		if _, ok := test.want.(bool); ok {
+			typ = typeBool
+		}
+		e, err := compileExpr(test.src, typ)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.src, err)
+			continue
+		}
+		if want, have := test.want, e.eval(r); want != have {
+			t.Errorf("%s: expected %#v, got %#v", test.src, want, have)
+		}
+	}
+}
+
+func TestExpr_errors(t *testing.T) {
+	tests := []struct {
+		src string
+		typ string
+		err string
+	}{
+		{`path ==`, typeBool, "invalid expression"},
+		{`1 + 2`, typeString, "only string literals are supported"},
+		{`user`, typeString, `unknown variable "user"`},
+		{`exec("rm")`, typeString, `unknown function "exec"`},
+		{`header()`, typeString, "header requires 1 arguments, got 0"},
+		{`has_prefix(path, true)`, typeBool, "argument 2 of has_prefix is bool, not string"},
+		{`path == true`, typeBool, "mismatched types string and bool"},
+		{`path && path`, typeBool, "operator && not supported for string"},
+		{`-path`, typeString, "operator - not supported for string"},
+		{`matches(path, method)`, typeBool, "pattern of matches must be a string literal"},
+		{`matches(path, "(")`, typeBool, "missing closing )"},
+		{`path.x`, typeString, "unsupported expression"},
+		{`path`, typeBool, `expression "path" is string, not bool`},
+	}
+	for _, test := range tests {
+		_, err := compileExpr(test.src, test.typ)
+		if err == nil {
+			t.Errorf("%s: expected error, got nil", test.src)
+		} else if !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expected error containing %#v, got %#v", test.src, test.err, err.Error())
+		}
+	}
+}
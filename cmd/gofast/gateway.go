@@ -0,0 +1,509 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/yookoala/gofast"
+	"github.com/yookoala/gofast/tools/systemd"
+)
+
+// defaultShutdownTimeout is the time to wait for in-flight
+// requests on shutdown, if not configured
+const defaultShutdownTimeout = 30 * time.Second
+
+// Gateway serves HTTP requests with FastCGI applications
+// as configured by a Config
+type Gateway struct {
+
+	// counters of the requests served and the reloads, first
+	// for the 64-bit alignment of atomic operations
+	requests int64
+	reloads  int64
+
+	// Handler serves all the routes
+	Handler http.Handler
+
+	// ErrorLog logs the errors of the gateway
+	ErrorLog *log.Logger
+
+	// ConfigFile is the file to load when
+	// reloaded by the admin API
+	ConfigFile string
+
+	drainer  *gofast.Drainer
+	started  time.Time
+	level    int32
+	upgraded int32
+
+	// mutex guards the fields below
+	mutex    sync.RWMutex
+	config   *Config
+	current  *generation
+	retiring map[*generation]bool
+	backends map[string]*backend
+
+	// listeners being served, to pass on Upgrade
+	listeners []listener
+	admin     net.Listener
+}
+
+// generation is the handler of a config, with the resources
+// to close when the config is replaced
+type generation struct {
+	handler   http.Handler
+	errorLog  io.Writer
+	closers   []io.Closer
+	inFlight  sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// close closes the log files and the client pools of the generation
+func (gen *generation) close() {
+	gen.closeOnce.Do(func() {
+		for _, c := range gen.closers {
+			c.Close()
+		}
+	})
+}
+
+// NewGateway builds a *Gateway from the config. The config
+// should be validated (e.g. returned by LoadConfig).
+func NewGateway(c *Config) (*Gateway, error) {
+	g := &Gateway{
+		drainer:  gofast.NewDrainer(),
+		started:  time.Now(),
+		level:    logLevels[c.Log.Level],
+		config:   c,
+		retiring: make(map[*generation]bool),
+		backends: make(map[string]*backend),
+	}
+	gen, err := g.newGeneration(c)
+	if err != nil {
+		return nil, err
+	}
+	g.current = gen
+	g.ErrorLog = log.New(gen.errorLog, "", log.LstdFlags)
+	g.Handler = g.drainer.Wrap(http.HandlerFunc(g.serveHTTP))
+	return g, nil
+}
+
+// newGeneration builds the handler of the config
+func (g *Gateway) newGeneration(c *Config) (*generation, error) {
+	gen := &generation{}
+	errorLog, err := gen.openLog(c.Log.Error, "stderr")
+	if err != nil {
+		return nil, fmt.Errorf("error log: %s", err)
+	}
+	if errorLog == nil {
+		errorLog = ioutil.Discard
+	}
+	gen.errorLog = errorLog
+	accessLog, err := gen.openLog(c.Log.Access, "off")
+	if err != nil {
+		gen.close()
+		return nil, fmt.Errorf("access log: %s", err)
+	}
+
+	// the route handlers log with the logger of the
+	// generation, so the logs of the in-flight requests
+	// go to the old error log until it is closed
+	logger := log.New(errorLog, "", log.LstdFlags)
+	clientFactories := make(map[string]gofast.ClientFactory, len(c.Backends))
+	for name, b := range c.Backends {
+		clientFactories[name] = gen.newClientFactory(b)
+	}
+
+	mux := http.NewServeMux()
+	for _, r := range c.Routes {
+		handler := func(backend string) http.Handler {
+			rc := r
+			rc.Backend = backend
+			h := g.newRouteHandler(rc, clientFactories[backend], logger)
+			if r.StripPrefix {
+				h = gofast.Mount(r.Path, h)
+			}
+			return h
+		}
+		h := handler(r.Backend)
+		if len(r.Rules) > 0 {
+			// the handlers of the backends the rules may pick
+			handlers := map[string]http.Handler{r.Backend: h}
+			for _, rule := range r.Rules {
+				if _, ok := handlers[rule.Backend]; rule.Backend != "" && !ok {
+					handlers[rule.Backend] = handler(rule.Backend)
+				}
+			}
+			h = newRulesHandler(r.Rules, r.Backend, handlers)
+		}
+		mux.Handle(r.Host+r.Path, h)
+	}
+
+	var h http.Handler = mux
+	if c.Limits.MaxBodyBytes > 0 {
+		h = maxBodyBytes(c.Limits.MaxBodyBytes, h)
+	}
+	if accessLog != nil {
+		h = newAccessLogger(accessLog, h)
+	}
+	gen.handler = h
+	return gen, nil
+}
+
+// openLog opens the log of the given config value. Returns
+// nil if the log is "off".
+func (gen *generation) openLog(name, defaultName string) (io.Writer, error) {
+	if name == "" {
+		name = defaultName
+	}
+	switch name {
+	case "off":
+		return nil, nil
+	case "stdout":
+		return os.Stdout, nil
+	case "stderr":
+		return os.Stderr, nil
+	}
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	gen.closers = append(gen.closers, f)
+	return f, nil
+}
+
+// serveHTTP serves the request with the current generation.
+// While draining, the clients are asked to close the connections.
+func (g *Gateway) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt64(&g.requests, 1)
+	if g.drainer.Draining() {
+		w.Header().Set("Connection", "close")
+	}
+	g.mutex.RLock()
+	gen := g.current
+	gen.inFlight.Add(1)
+	g.mutex.RUnlock()
+	defer gen.inFlight.Done()
+	gen.handler.ServeHTTP(w, r)
+}
+
+// Reload applies the config to the running gateway. The routes,
+// backends, logs, log level, admin token and max_body_bytes change at
+// once for new requests, while the in-flight requests finish with the
+// old config. The old log files are closed when they are done, or
+// after the shutdown timeout. If the config cannot be applied (e.g. a log file cannot be
+// opened), the gateway keeps the old config.
+//
+// Changes of the listeners (including the admin address) and of the
+// other limits are only applied by a restart, which is reported to
+// ErrorLog.
+func (g *Gateway) Reload(c *Config) error {
+	gen, err := g.newGeneration(c)
+	if err != nil {
+		return err
+	}
+
+	g.mutex.Lock()
+	old, oldConfig := g.current, g.config
+	g.current, g.config = gen, c
+	g.retiring[old] = true
+	g.mutex.Unlock()
+	g.ErrorLog.SetOutput(gen.errorLog)
+	atomic.StoreInt32(&g.level, logLevels[c.Log.Level])
+	atomic.AddInt64(&g.reloads, 1)
+
+	if !reflect.DeepEqual(oldConfig.Listeners, c.Listeners) || oldConfig.Admin.Address != c.Admin.Address {
+		g.ErrorLog.Printf("gofast: listeners changed, restart to apply")
+	}
+	oldLimits, limits := oldConfig.Limits, c.Limits
+	oldLimits.MaxBodyBytes, oldLimits.ShutdownTimeout = limits.MaxBodyBytes, limits.ShutdownTimeout
+	if oldLimits != limits {
+		g.ErrorLog.Printf("gofast: limits of the servers changed, restart to apply")
+	}
+
+	go g.retire(old, shutdownTimeout(c))
+	return nil
+}
+
+// retire closes the generation when its in-flight requests
+// are done, or after the timeout
+func (g *Gateway) retire(gen *generation, timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		gen.inFlight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		g.ErrorLog.Printf("gofast: requests of the old config still in-flight after %s", timeout)
+	}
+	g.mutex.Lock()
+	delete(g.retiring, gen)
+	g.mutex.Unlock()
+	gen.close()
+}
+
+// shutdownTimeout returns the shutdown timeout of the config
+func shutdownTimeout(c *Config) time.Duration {
+	if c.Limits.ShutdownTimeout.Duration == 0 {
+		return defaultShutdownTimeout
+	}
+	return c.Limits.ShutdownTimeout.Duration
+}
+
+// newClientFactory returns the gofast.ClientFactory of the backend.
+// The client pool, if any, is closed with the generation.
+func (gen *generation) newClientFactory(b BackendConfig) gofast.ClientFactory {
+	clientFactory := gofast.SimpleClientFactory(gofast.SimpleConnFactory(gofast.ParseAddress(b.Address)))
+	if b.Pool.Size > 0 {
+		pool := gofast.NewClientPool(clientFactory, b.Pool.Size, b.Pool.Expires.Duration)
+		gen.closers = append(gen.closers, pool)
+		return pool.CreateClient
+	}
+	return clientFactory
+}
+
+// newRouteHandler returns the http.Handler of the route
+func (g *Gateway) newRouteHandler(r RouteConfig, clientFactory gofast.ClientFactory, errorLog *log.Logger) http.Handler {
+	var middleware gofast.Middleware
+	switch r.Type {
+	case "static":
+		return http.FileServer(http.Dir(r.DocRoot))
+	case "endpoint":
+		middleware = gofast.NewFileEndpoint(r.Endpoint)
+	default:
+		middleware = gofast.NewPHPFS(r.DocRoot)
+	}
+	if r.StripPrefix {
+		middleware = gofast.Chain(middleware, gofast.MapMountPrefix)
+	}
+	if len(r.Rules) > 0 {
+		middleware = gofast.Chain(middleware, mapRuleParams)
+	}
+	h := gofast.NewHandler(middleware(gofast.BasicSession), clientFactory)
+	h.SetLogger(errorLog)
+	fastcgi := g.backendHandler(r.Backend, h)
+	if r.Type == "endpoint" {
+		return fastcgi
+	}
+	fs := gofast.NewFileServerHandler(fastcgi, http.FileServer(http.Dir(r.DocRoot)), gofast.MatchPHP)
+	fs.FallThrough = r.TryFiles
+	return fs
+}
+
+// maxBodyBytes limits the size of request body
+func maxBodyBytes(n int64, inner http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > n {
+			http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if r.Body != nil {
+			r.Body = http.MaxBytesReader(w, r.Body, n)
+		}
+		inner.ServeHTTP(w, r)
+	})
+}
+
+// listener is a net.Listener with its config
+type listener struct {
+	net.Listener
+	config ListenerConfig
+}
+
+// listen creates the listeners of the config. The sockets passed
+// by the old process on upgrade are taken from inherited, and the
+// sockets of systemd socket activation are looked up by name.
+func (g *Gateway) listen(inherited map[string][]net.Listener) (listeners []listener, err error) {
+	var activated map[string][]net.Listener
+	defer func() {
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+		}
+	}()
+	g.mutex.RLock()
+	config := g.config
+	g.mutex.RUnlock()
+	for i, lc := range config.Listeners {
+		if ls, ok := inherited[lc.Address]; ok {
+			for _, l := range ls {
+				listeners = append(listeners, listener{l, lc})
+			}
+			delete(inherited, lc.Address)
+			continue
+		}
+		if lc.Address == "systemd" || strings.HasPrefix(lc.Address, "systemd:") {
+			if activated == nil {
+				if activated, err = systemd.ListenersWithNames(); err != nil {
+					return
+				}
+			}
+			var found []net.Listener
+			if lc.Address == "systemd" {
+				for _, ls := range activated {
+					found = append(found, ls...)
+				}
+			} else {
+				found = activated[strings.TrimPrefix(lc.Address, "systemd:")]
+			}
+			if len(found) == 0 {
+				err = fmt.Errorf("listeners[%d]: no socket from systemd for %q", i, lc.Address)
+				return
+			}
+			for _, l := range found {
+				listeners = append(listeners, listener{l, lc})
+			}
+			continue
+		}
+		var l net.Listener
+		if l, err = net.Listen(gofast.ParseAddress(lc.Address)); err != nil {
+			return
+		}
+		listeners = append(listeners, listener{l, lc})
+	}
+	return
+}
+
+// Serve listens to the listeners and serves until the ctx is
+// done. The servers are then shut down gracefully, waiting for
+// in-flight requests up to the shutdown timeout.
+func (g *Gateway) Serve(ctx context.Context) error {
+	inherited, err := inheritListeners()
+	if err != nil {
+		return err
+	}
+	listeners, err := g.listen(inherited)
+	if err != nil {
+		closeListeners(inherited)
+		return err
+	}
+
+	g.mutex.RLock()
+	limits, adminAddress := g.config.Limits, g.config.Admin.Address
+	g.mutex.RUnlock()
+	var admin net.Listener
+	if ls := inherited[adminKey]; adminAddress != "" && len(ls) > 0 {
+		admin = ls[0]
+		inherited[adminKey] = ls[1:]
+	} else if adminAddress != "" {
+		if admin, err = listenAdmin(adminAddress); err != nil {
+			closeListeners(inherited)
+			for _, l := range listeners {
+				l.Close()
+			}
+			return fmt.Errorf("admin: %s", err)
+		}
+	}
+
+	// the sockets of the old process not in the config any more
+	closeListeners(inherited)
+	g.mutex.Lock()
+	g.listeners, g.admin = listeners, admin
+	g.mutex.Unlock()
+
+	servers := make([]*http.Server, len(listeners))
+	errs := make(chan error, len(listeners)+1)
+	var wg sync.WaitGroup
+	for i, l := range listeners {
+		srv := &http.Server{
+			Handler:        g.Handler,
+			ReadTimeout:    limits.ReadTimeout.Duration,
+			WriteTimeout:   limits.WriteTimeout.Duration,
+			IdleTimeout:    limits.IdleTimeout.Duration,
+			MaxHeaderBytes: limits.MaxHeaderBytes,
+			ErrorLog:       g.ErrorLog,
+		}
+		servers[i] = srv
+		wg.Add(1)
+		go func(l listener) {
+			defer wg.Done()
+			g.logf(levelInfo, "gofast: listening on %s", l.Addr())
+			var err error
+			if l.config.TLSCert != "" {
+				err = srv.ServeTLS(l, l.config.TLSCert, l.config.TLSKey)
+			} else {
+				err = srv.Serve(l)
+			}
+			if err != http.ErrServerClosed {
+				errs <- err
+			}
+		}(l)
+	}
+	if admin != nil {
+		srv := &http.Server{Handler: g.adminHandler(), ErrorLog: g.ErrorLog}
+		servers = append(servers, srv)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			g.logf(levelInfo, "gofast: admin API listening on %s", admin.Addr())
+			if err := srv.Serve(admin); err != http.ErrServerClosed {
+				errs <- err
+			}
+		}()
+	}
+	systemd.Notify(systemd.Ready)
+	if err := notifyUpgraded(); err != nil {
+		g.ErrorLog.Printf("gofast: failed to report ready to the old process: %s", err)
+	}
+
+	select {
+	case <-ctx.Done():
+	case err = <-errs:
+	}
+
+	g.mutex.Lock()
+	g.listeners, g.admin = nil, nil
+	g.mutex.Unlock()
+	if atomic.LoadInt32(&g.upgraded) == 1 {
+		// the new process serves the sockets, which
+		// must not be removed by closing them here
+		for _, l := range listeners {
+			if ul, ok := l.Listener.(*net.UnixListener); ok {
+				ul.SetUnlinkOnClose(false)
+			}
+		}
+		if ul, ok := admin.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+	} else {
+		systemd.Notify(systemd.Stopping)
+	}
+	g.mutex.RLock()
+	timeout := shutdownTimeout(g.config)
+	g.mutex.RUnlock()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	for _, srv := range servers {
+		if shutdownErr := srv.Shutdown(shutdownCtx); shutdownErr != nil && err == nil {
+			err = shutdownErr
+		}
+	}
+	wg.Wait()
+	return err
+}
+
+// Close closes the log files, including the ones
+// of the old configs not yet closed
+func (g *Gateway) Close() error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.current.close()
+	for gen := range g.retiring {
+		gen.close()
+	}
+	return nil
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yookoala/gofast"
+	"github.com/yookoala/gofast/fcgitest"
+)
+
+func newTestGateway(t *testing.T) (g *Gateway, s *fcgitest.Server, root string) {
+	s = fcgitest.NewUnixServer(fcgitest.Reply(fcgitest.Body("hello")))
+	root, err := ioutil.TempDir("", "gofast-cmd-")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	ioutil.WriteFile(filepath.Join(root, "index.php"), []byte("<?php"), 0644)
+	ioutil.WriteFile(filepath.Join(root, "style.css"), []byte("body {}"), 0644)
+
+	c := &Config{
+		Listeners: []ListenerConfig{{Address: "unix:" + filepath.Join(root, "http.sock")}},
+		Backends: map[string]BackendConfig{
+			"app": {Address: "unix:" + s.Address},
+		},
+		Routes: []RouteConfig{
+			{Path: "/api/", Type: "endpoint", Backend: "app", Endpoint: "/srv/api.php"},
+			{Path: "/blog/", Backend: "app", DocRoot: root, StripPrefix: true},
+			{Path: "/assets/", Type: "static", DocRoot: root, StripPrefix: true},
+			{Backend: "app", DocRoot: root},
+		},
+		Limits: LimitsConfig{MaxBodyBytes: 10},
+		Log:    LogConfig{Error: "off"},
+	}
+	if err := c.validate(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	g, err = NewGateway(c)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return
+}
+
+func TestGateway_routes(t *testing.T) {
+	g, s, root := newTestGateway(t)
+	defer os.RemoveAll(root)
+	defer s.Close()
+	defer g.Close()
+
+	tests := []struct {
+		path       string
+		body       string
+		scriptName string
+		filename   string
+	}{
+		{"/index.php", "hello", "/index.php", filepath.Join(root, "index.php")},
+		{"/style.css", "body {}", "", ""},
+		{"/api/users", "hello", "/api.php", "/srv/api.php"},
+		{"/blog/index.php", "hello", "/blog/index.php", filepath.Join(root, "index.php")},
+		{"/assets/style.css", "body {}", "", ""},
+	}
+	for _, test := range tests {
+		before := len(s.Requests())
+		w := httptest.NewRecorder()
+		g.Handler.ServeHTTP(w, httptest.NewRequest("GET", test.path, nil))
+		if want, have := test.body, w.Body.String(); want != have {
+			t.Errorf("%s: expected %#v, got %#v", test.path, want, have)
+		}
+		if test.scriptName == "" {
+			if want, have := before, len(s.Requests()); want != have {
+				t.Errorf("%s: expected %#v requests, got %#v", test.path, want, have)
+			}
+			continue
+		}
+		req := s.LastRequest()
+		if req == nil {
+			t.Errorf("%s: no request to backend", test.path)
+			continue
+		}
+		if want, have := test.scriptName, req.Params["SCRIPT_NAME"]; want != have {
+			t.Errorf("%s: expected %#v, got %#v", test.path, want, have)
+		}
+		if want, have := test.filename, req.Params["SCRIPT_FILENAME"]; want != have {
+			t.Errorf("%s: expected %#v, got %#v", test.path, want, have)
+		}
+	}
+}
+
+func TestGateway_maxBodyBytes(t *testing.T) {
+	g, s, root := newTestGateway(t)
+	defer os.RemoveAll(root)
+	defer s.Close()
+	defer g.Close()
+
+	w := httptest.NewRecorder()
+	g.Handler.ServeHTTP(w, httptest.NewRequest("POST", "/index.php", strings.NewReader("more than 10 bytes")))
+	if want, have := http.StatusRequestEntityTooLarge, w.Code; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestGateway_accessLog(t *testing.T) {
+	out := new(bytes.Buffer)
+	h := newAccessLogger(out, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("short and stout"))
+	}))
+	r := httptest.NewRequest("GET", "/tea?pot=1", nil)
+	r.RemoteAddr = "192.0.2.1:12345"
+	r.Header.Set("User-Agent", "test")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	line := out.String()
+	if want := "192.0.2.1 - - ["; !strings.HasPrefix(line, want) {
+		t.Errorf("expected prefix %#v, got %#v", want, line)
+	}
+	if want := `] "GET /tea?pot=1 HTTP/1.1" 418 15 "" "test"` + "\n"; !strings.HasSuffix(line, want) {
+		t.Errorf("expected suffix %#v, got %#v", want, line)
+	}
+}
+
+func TestGateway_Serve(t *testing.T) {
+	g, s, root := newTestGateway(t)
+	defer os.RemoveAll(root)
+	defer s.Close()
+	defer g.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- g.Serve(ctx)
+	}()
+
+	sock := filepath.Join(root, "http.sock")
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", sock)
+			},
+		},
+	}
+	var resp *http.Response
+	var err error
+	for i := 0; i < 50; i++ {
+		if resp, err = client.Get("http://gateway/index.php"); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if want, have := "hello", string(body); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Serve does not return after ctx is done")
+	}
+}
+
+func TestRun_usage(t *testing.T) {
+	stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+	if want, have := 2, run([]string{"nonsense"}, stdout, stderr); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want := `unknown command "nonsense"`; !strings.Contains(stderr.String(), want) {
+		t.Errorf("expected %#v in %#v", want, stderr.String())
+	}
+	if want, have := 0, run([]string{"help"}, stdout, stderr); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want := "serve"; !strings.Contains(stdout.String(), want) {
+		t.Errorf("expected %#v in %#v", want, stdout.String())
+	}
+}
+
+func TestGateway_Reload(t *testing.T) {
+	root, err := ioutil.TempDir("", "gofast-cmd-")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(root)
+
+	// the old backend holds the request until released
+	started, release := make(chan struct{}), make(chan struct{})
+	oldApp := fcgitest.NewUnixServer(func(ctx context.Context, req *gofast.Request, stdout, stderr io.Writer) int {
+		close(started)
+		<-release
+		io.WriteString(stdout, "Content-Type: text/plain\r\n\r\nold")
+		return 0
+	})
+	defer oldApp.Close()
+	newApp := fcgitest.NewUnixServer(fcgitest.Reply(fcgitest.Body("new")))
+	defer newApp.Close()
+
+	config := func(address, accessLog string) *Config {
+		c := &Config{
+			Listeners: []ListenerConfig{{Address: "unix:" + filepath.Join(root, "http.sock")}},
+			Backends:  map[string]BackendConfig{"app": {Address: "unix:" + address}},
+			Routes:    []RouteConfig{{Type: "endpoint", Backend: "app", Endpoint: "/srv/index.php"}},
+			Log:       LogConfig{Access: accessLog, Error: "off"},
+		}
+		if err := c.validate(); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return c
+	}
+	oldLog, newLog := filepath.Join(root, "old.log"), filepath.Join(root, "new.log")
+	g, err := NewGateway(config(oldApp.Address, oldLog))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer g.Close()
+
+	inFlight := make(chan *httptest.ResponseRecorder)
+	go func() {
+		w := httptest.NewRecorder()
+		g.Handler.ServeHTTP(w, httptest.NewRequest("GET", "/in-flight", nil))
+		inFlight <- w
+	}()
+	<-started
+
+	// a config that cannot be applied
+	if err := g.Reload(config(newApp.Address, filepath.Join(root, "no-such-dir", "access.log"))); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+	if err := g.Reload(config(newApp.Address, newLog)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	w := httptest.NewRecorder()
+	g.Handler.ServeHTTP(w, httptest.NewRequest("GET", "/after", nil))
+	if want, have := "new", w.Body.String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	close(release)
+	select {
+	case w = <-inFlight:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the in-flight request does not finish")
+	}
+	if want, have := "old", w.Body.String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	// each request is logged by the config it is served with
+	for filename, want := range map[string]string{oldLog: "/in-flight", newLog: "/after"} {
+		b, _ := ioutil.ReadFile(filename)
+		if have := string(b); strings.Count(have, "\n") != 1 || !strings.Contains(have, want) {
+			t.Errorf("%s: expected a line of %#v, got %#v", filename, want, have)
+		}
+	}
+}
+
+func TestGateway_Reload_pool(t *testing.T) {
+	root, err := ioutil.TempDir("", "gofast-cmd-")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(root)
+
+	// the backend reports the connections closed by the gateway
+	sock := filepath.Join(root, "app.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer l.Close()
+	accepted, closed := make(chan struct{}, 10), make(chan struct{}, 10)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- struct{}{}
+			go func() {
+				io.Copy(ioutil.Discard, conn)
+				conn.Close()
+				closed <- struct{}{}
+			}()
+		}
+	}()
+
+	c := &Config{
+		Listeners: []ListenerConfig{{Address: "unix:" + filepath.Join(root, "http.sock")}},
+		Backends:  map[string]BackendConfig{"app": {Address: "unix:" + sock, Pool: PoolConfig{Size: 1}}},
+		Routes:    []RouteConfig{{Type: "endpoint", Backend: "app", Endpoint: "/srv/index.php"}},
+		Log:       LogConfig{Error: "off"},
+	}
+	if err := c.validate(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	g, err := NewGateway(c)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer g.Close()
+	select {
+	case <-accepted:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the pool to connect")
+	}
+
+	// the pool of the old config is closed once retired
+	if err := g.Reload(c); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Errorf("expected the connections of the old pool closed")
+	}
+}
+
+func TestWatchFile(t *testing.T) {
+	root, err := ioutil.TempDir("", "gofast-cmd-")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(root)
+	filename := filepath.Join(root, "gofast.yaml")
+	ioutil.WriteFile(filename, []byte("routes: []\n"), 0644)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changed := watchFile(ctx, filename, 5*time.Millisecond)
+	select {
+	case <-changed:
+		t.Fatalf("expected no change")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	ioutil.WriteFile(filename, []byte("routes: [{}]\n"), 0644)
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the change reported")
+	}
+
+	if changed := watchFile(ctx, filename, 0); changed == nil {
+		t.Errorf("expected a channel, got nil")
+	}
+}
@@ -0,0 +1,11 @@
+module github.com/yookoala/gofast/cmd/gofast
+
+go 1.16
+
+require (
+	github.com/BurntSushi/toml v1.3.2
+	github.com/yookoala/gofast v0.0.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+replace github.com/yookoala/gofast => ../../
@@ -0,0 +1,228 @@
+// Command gofast is a standalone gateway that serves HTTP requests with
+// FastCGI applications (e.g. php-fpm), as configured by a YAML, TOML or
+// JSON config file. See README.md for the config format.
+//
+// Usage:
+//
+//	gofast serve -config /etc/gofast/gofast.yaml
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+	"time"
+)
+
+// command is a subcommand of gofast
+type command struct {
+	summary string
+	run     func(args []string, stdout, stderr io.Writer) error
+}
+
+// commands are the subcommands by name
+var commands = map[string]command{
+	"serve":       {"run the gateway", runServe},
+	"request":     {"send a single FastCGI request (like cgi-fcgi)", runRequest},
+	"conformance": {"check a FastCGI application for spec compliance", runConformance},
+	"bench":       {"load test a FastCGI application, without HTTP", runBench},
+}
+
+// errUsage reports wrong command line
+// usage, which is printed already
+var errUsage = fmt.Errorf("usage error")
+
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: gofast <command> [flags]\n\nCommands:\n")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-12s %s\n", name, commands[name].summary)
+	}
+	fmt.Fprintf(w, "\nRun \"gofast <command> -h\" for the flags of the command.\n")
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run runs the command line and returns the exit code
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		usage(stderr)
+		return 2
+	}
+	if args[0] == "-h" || args[0] == "-help" || args[0] == "help" {
+		usage(stdout)
+		return 0
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(stderr, "gofast: unknown command %q\n\n", args[0])
+		usage(stderr)
+		return 2
+	}
+	if err := cmd.run(args[1:], stdout, stderr); err == flag.ErrHelp {
+		return 0
+	} else if err == errUsage {
+		return 2
+	} else if err != nil {
+		fmt.Fprintf(stderr, "gofast: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+// newFlagSet returns a *flag.FlagSet of the subcommand
+func newFlagSet(name string, stderr io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet("gofast "+name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	return fs
+}
+
+// parseFlags parses the flags. The errors are printed by fs,
+// and returned as errUsage (or flag.ErrHelp for -h).
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err == flag.ErrHelp {
+		return err
+	} else if err != nil {
+		return errUsage
+	}
+	return nil
+}
+
+func runServe(args []string, stdout, stderr io.Writer) error {
+	fs := newFlagSet("serve", stderr)
+	configFile := fs.String("config", "gofast.yaml", "path to the config file (.yaml, .yml, .toml or .json)")
+	check := fs.Bool("check", false, "check the config file and exit")
+	watch := fs.Duration("watch", 0, "interval to check the config file for changes to reload (e.g. 2s). Off if 0. SIGHUP always reloads")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	c, err := LoadConfig(*configFile)
+	if err != nil {
+		return err
+	}
+	if *check {
+		fmt.Fprintf(stdout, "%s: ok\n", *configFile)
+		return nil
+	}
+	g, err := NewGateway(c)
+	if err != nil {
+		return err
+	}
+	defer g.Close()
+	g.ConfigFile = *configFile
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		s := <-sig
+		g.logf(levelInfo, "gofast: received %s, shutting down", s)
+		cancel()
+	}()
+
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	defer signal.Stop(reload)
+	go func() {
+		changed := watchFile(ctx, *configFile, *watch)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-reload:
+			case <-changed:
+			}
+			if err := reloadConfig(g, *configFile); err != nil {
+				g.ErrorLog.Printf("gofast: failed to reload %s, keeping the old config: %s", *configFile, err)
+				continue
+			}
+			g.logf(levelInfo, "gofast: reloaded %s", *configFile)
+		}
+	}()
+
+	if len(upgradeSignals) > 0 {
+		upgrade := make(chan os.Signal, 1)
+		signal.Notify(upgrade, upgradeSignals...)
+		defer signal.Stop(upgrade)
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-upgrade:
+				}
+				p, err := g.Upgrade()
+				if err != nil {
+					g.ErrorLog.Printf("gofast: failed to upgrade, keeping the old process: %s", err)
+					continue
+				}
+				g.logf(levelInfo, "gofast: upgraded to process %d, shutting down", p.Pid)
+				cancel()
+				return
+			}
+		}()
+	}
+	return g.Serve(ctx)
+}
+
+// reloadConfig loads the config file and applies it to the gateway
+func reloadConfig(g *Gateway, filename string) error {
+	c, err := LoadConfig(filename)
+	if err != nil {
+		return err
+	}
+	return g.Reload(c)
+}
+
+// watchFile checks the file every interval, and sends to the channel
+// when its modification time or size changes. Never sends if the
+// interval is 0.
+func watchFile(ctx context.Context, filename string, interval time.Duration) <-chan struct{} {
+	changed := make(chan struct{}, 1)
+	if interval <= 0 {
+		return changed
+	}
+	stat := func() (time.Time, int64) {
+		info, err := os.Stat(filename)
+		if err != nil {
+			// e.g. in the middle of being replaced
+			return time.Time{}, -1
+		}
+		return info.ModTime(), info.Size()
+	}
+	modTime, size := stat()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			m, s := stat()
+			if s < 0 || (m.Equal(modTime) && s == size) {
+				continue
+			}
+			modTime, size = m, s
+			select {
+			case changed <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return changed
+}
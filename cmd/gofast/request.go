@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yookoala/gofast"
+)
+
+// listFlag is a flag.Value of repeatable flag
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *listFlag) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// roles are the FastCGI roles by name
+var roles = map[string]gofast.Role{
+	"responder":  gofast.RoleResponder,
+	"authorizer": gofast.RoleAuthorizer,
+	"filter":     gofast.RoleFilter,
+}
+
+func runRequest(args []string, stdout, stderr io.Writer) error {
+	fs := newFlagSet("request", stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: gofast request -connect <address> [flags] [request URI]\n\n"+
+			"Sends a single FastCGI request and prints the response headers and body\n"+
+			"to stdout, and the FastCGI stderr stream to stderr. With -dry-run, prints\n"+
+			"the middleware chain and the params of the request instead of sending it.\n\n"+
+			"Exits with status 1 if the request fails, or with -fail, if the response\n"+
+			"status is 400 or above.\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	connect := fs.String("connect", "", "address of the FastCGI application (e.g. 127.0.0.1:9000, unix:/run/php/php-fpm.sock)")
+	method := fs.String("method", "GET", "request method")
+	role := fs.String("role", "responder", "FastCGI role: responder, authorizer or filter")
+	script := fs.String("script", "", "script file that serves all request paths (e.g. /var/www/index.php)")
+	docroot := fs.String("docroot", "", "document root to route the request path to PHP scripts, as the gateway does")
+	data := fs.String("data", "", "request body. Use @file to read from a file, or @- to read from stdin")
+	filterData := fs.String("filter-data", "", "file of the FCGI_DATA stream for the filter role")
+	host := fs.String("host", "localhost", "host of the request")
+	timeout := fs.Duration("timeout", 30*time.Second, "timeout of the request")
+	headers := fs.Bool("headers", true, "print the response status and headers")
+	fail := fs.Bool("fail", false, "exit with status 1 if the response status is 400 or above (e.g. for health checks)")
+	verbose := fs.Bool("v", false, "print the params sent to stderr")
+	dryRun := fs.Bool("dry-run", false, "print the params set by each middleware and the request, without connecting")
+	var params, reqHeaders listFlag
+	fs.Var(&params, "param", "extra FastCGI param as NAME=value, overriding the generated ones (repeatable)")
+	fs.Var(&reqHeaders, "header", "request header as \"Name: value\" (repeatable)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if *connect == "" && !*dryRun {
+		fmt.Fprintf(stderr, "gofast request: -connect is required\n")
+		return errUsage
+	}
+	if *script != "" && *docroot != "" {
+		fmt.Fprintf(stderr, "gofast request: -script and -docroot cannot be used together\n")
+		return errUsage
+	}
+	if fs.NArg() > 1 {
+		fmt.Fprintf(stderr, "gofast request: only one request URI is allowed\n")
+		return errUsage
+	}
+	reqRole, ok := roles[*role]
+	if !ok {
+		fmt.Fprintf(stderr, "gofast request: unknown role %q\n", *role)
+		return errUsage
+	}
+	uri := "/"
+	if fs.NArg() == 1 {
+		uri = fs.Arg(0)
+	}
+
+	// compose the http request to map params from
+	body, err := readData(*data)
+	if err != nil {
+		return err
+	}
+	r, err := http.NewRequest(*method, "http://"+*host+uri, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	r.RequestURI = uri
+	r.RemoteAddr = "127.0.0.1:0"
+	for _, h := range reqHeaders {
+		kv := strings.SplitN(h, ":", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("invalid header %q", h)
+		}
+		r.Header.Add(strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]))
+	}
+	if len(body) > 0 && r.Header.Get("Content-Length") == "" {
+		r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), *timeout)
+	defer cancel()
+	r = r.WithContext(ctx)
+
+	// the session chain
+	var middlewares []gofast.Middleware
+	switch {
+	case *script != "":
+		middlewares = append(middlewares, gofast.NewFileEndpoint(*script))
+	case *docroot != "":
+		middlewares = append(middlewares, gofast.NewPHPFS(*docroot))
+	default:
+		middlewares = append(middlewares, gofast.BasicParamsMap, gofast.MapHeader)
+	}
+	if reqRole == gofast.RoleAuthorizer {
+		middlewares = append(middlewares, gofast.FilterAuthReqParams)
+	}
+	middlewares = append(middlewares, gofast.Describe("request flags", "role="+*role, func(inner gofast.SessionHandler) gofast.SessionHandler {
+		return func(client gofast.Client, req *gofast.Request) (*gofast.ResponsePipe, error) {
+			req.Role = reqRole
+			if reqRole == gofast.RoleAuthorizer {
+				req.Stdin = nil
+			}
+			if reqRole == gofast.RoleFilter {
+				if err := setFilterData(req, *filterData); err != nil {
+					return nil, err
+				}
+			}
+			for _, p := range params {
+				kv := strings.SplitN(p, "=", 2)
+				if len(kv) != 2 {
+					return nil, fmt.Errorf("invalid param %q", p)
+				}
+				req.Params[kv[0]] = kv[1]
+			}
+			if *verbose {
+				printParams(stderr, req.Params)
+			}
+			return inner(client, req)
+		}
+	}))
+	sessionHandler := gofast.Chain(middlewares...)(gofast.BasicSession)
+
+	if *dryRun {
+		result, err := gofast.DryRun(sessionHandler, r)
+		if result.Request != nil && result.Request.Data != nil {
+			result.Request.Data.Close()
+		}
+		if err != nil {
+			return err
+		}
+		_, err = result.WriteTo(stdout)
+		return err
+	}
+
+	client, err := gofast.SimpleClientFactory(gofast.SimpleConnFactory(gofast.ParseAddress(*connect)))()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	req := gofast.NewRequest(r)
+	resp, err := sessionHandler(client, req)
+	if req.Data != nil {
+		defer req.Data.Close()
+	}
+	if err != nil {
+		return err
+	}
+	w := &responsePrinter{out: stdout, headers: *headers, header: make(http.Header)}
+	if err = resp.WriteTo(w, stderr); err != nil {
+		return err
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("request timeout after %s", *timeout)
+	}
+
+	// the response may be written in full before the request
+	// failed (e.g. the connection closed before FCGI_END_REQUEST)
+	if err = resp.Err(); err != nil {
+		return err
+	}
+	if *fail && w.code >= 400 {
+		return fmt.Errorf("response status %d", w.code)
+	}
+	return nil
+}
+
+// readData reads the request body of the -data flag
+func readData(data string) ([]byte, error) {
+	switch {
+	case data == "@-":
+		return ioutil.ReadAll(os.Stdin)
+	case strings.HasPrefix(data, "@"):
+		return ioutil.ReadFile(data[1:])
+	}
+	return []byte(data), nil
+}
+
+// setFilterData sets the file as the FCGI_DATA stream of the
+// request, with the required params of filter role
+func setFilterData(req *gofast.Request, filename string) error {
+	if filename == "" {
+		return fmt.Errorf("filter role requires -filter-data")
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	req.Data = f
+	req.Params["FCGI_DATA_LAST_MOD"] = strconv.FormatInt(stat.ModTime().Unix(), 10)
+	req.Params["FCGI_DATA_LENGTH"] = strconv.FormatInt(stat.Size(), 10)
+	return nil
+}
+
+// printParams prints the params sorted by name
+func printParams(w io.Writer, params map[string]string) {
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "> %s=%s\n", name, params[name])
+	}
+}
+
+// responsePrinter is an http.ResponseWriter that prints
+// the response in CGI format as it is written
+type responsePrinter struct {
+	out         io.Writer
+	header      http.Header
+	headers     bool
+	wroteHeader bool
+	code        int
+}
+
+func (w *responsePrinter) Header() http.Header {
+	return w.header
+}
+
+func (w *responsePrinter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.code = code
+	if !w.headers {
+		return
+	}
+	fmt.Fprintf(w.out, "Status: %d %s\r\n", code, http.StatusText(code))
+	w.header.Write(w.out)
+	io.WriteString(w.out, "\r\n")
+}
+
+func (w *responsePrinter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.out.Write(b)
+}
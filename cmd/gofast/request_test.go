@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yookoala/gofast"
+	"github.com/yookoala/gofast/fcgitest"
+)
+
+func TestRequest(t *testing.T) {
+	s := fcgitest.NewUnixServer(fcgitest.Reply(
+		fcgitest.Status(201),
+		fcgitest.Header("X-Test", "yes"),
+		fcgitest.Stderr("some warning"),
+		fcgitest.Body("hello"),
+	))
+	defer s.Close()
+
+	stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+	code := run([]string{
+		"request",
+		"-connect", "unix:" + s.Address,
+		"-method", "POST",
+		"-script", "/srv/www/index.php",
+		"-header", "Content-Type: text/plain",
+		"-param", "APP_ENV=test",
+		"-data", "request body",
+		"/hello/world?foo=bar",
+	}, stdout, stderr)
+	if want, have := 0, code; want != have {
+		t.Fatalf("expected %#v, got %#v (stderr: %s)", want, have, stderr.String())
+	}
+
+	if want, have := "Status: 201 Created\r\n", stdout.String(); !strings.HasPrefix(have, want) {
+		t.Errorf("expected prefix %#v, got %#v", want, have)
+	}
+	if want, have := "X-Test: yes\r\n", stdout.String(); !strings.Contains(have, want) {
+		t.Errorf("expected %#v in %#v", want, have)
+	}
+	if want, have := "\r\n\r\nhello", stdout.String(); !strings.HasSuffix(have, want) {
+		t.Errorf("expected suffix %#v, got %#v", want, have)
+	}
+	if want, have := "some warning", stderr.String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	req := s.LastRequest()
+	if req == nil {
+		t.Fatalf("no request received")
+	}
+	if want, have := gofast.RoleResponder, req.Role; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	for name, want := range map[string]string{
+		"REQUEST_METHOD":  "POST",
+		"REQUEST_URI":     "/hello/world?foo=bar",
+		"QUERY_STRING":    "foo=bar",
+		"SCRIPT_FILENAME": "/srv/www/index.php",
+		"CONTENT_TYPE":    "text/plain",
+		"CONTENT_LENGTH":  "12",
+		"HTTP_HOST":       "localhost",
+		"APP_ENV":         "test",
+	} {
+		if have := req.Params[name]; want != have {
+			t.Errorf("%s: expected %#v, got %#v", name, want, have)
+		}
+	}
+	if want, have := "request body", string(req.Stdin); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestRequest_noHeaders(t *testing.T) {
+	s := fcgitest.NewUnixServer(fcgitest.Reply(fcgitest.Body("hello")))
+	defer s.Close()
+
+	stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+	code := run([]string{"request", "-connect", "unix:" + s.Address, "-headers=false"}, stdout, stderr)
+	if want, have := 0, code; want != have {
+		t.Fatalf("expected %#v, got %#v (stderr: %s)", want, have, stderr.String())
+	}
+	if want, have := "hello", stdout.String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestRequest_exitCode(t *testing.T) {
+	s := fcgitest.NewUnixServer(fcgitest.Reply(fcgitest.Status(503), fcgitest.Body("unavailable")))
+	defer s.Close()
+	broken := fcgitest.NewUnixServer(fcgitest.Reply(fcgitest.Body("partial"), fcgitest.CloseConn()))
+	defer broken.Close()
+
+	tests := []struct {
+		args []string
+		code int
+	}{
+		{[]string{"-connect", "unix:" + s.Address}, 0},
+		{[]string{"-connect", "unix:" + s.Address, "-fail"}, 1},
+		{[]string{"-connect", "unix:" + broken.Address}, 1},
+	}
+	for _, tc := range tests {
+		stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+		code := run(append([]string{"request"}, tc.args...), stdout, stderr)
+		if want, have := tc.code, code; want != have {
+			t.Errorf("%v: expected %#v, got %#v (stderr: %s)", tc.args, want, have, stderr.String())
+		}
+	}
+}
+
+func TestRequest_roles(t *testing.T) {
+	s := fcgitest.NewUnixServer(fcgitest.Reply(fcgitest.Body("ok")))
+	defer s.Close()
+
+	stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+	code := run([]string{"request", "-connect", "unix:" + s.Address, "-role", "authorizer", "-script", "/srv/auth.php"}, stdout, stderr)
+	if want, have := 0, code; want != have {
+		t.Fatalf("expected %#v, got %#v (stderr: %s)", want, have, stderr.String())
+	}
+	req := s.LastRequest()
+	if want, have := gofast.RoleAuthorizer, req.Role; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if _, ok := req.Params["SCRIPT_NAME"]; ok {
+		t.Errorf("expected SCRIPT_NAME to be filtered for authorizer")
+	}
+
+	dir, err := ioutil.TempDir("", "gofast-cmd-")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	dataFile := filepath.Join(dir, "data.txt")
+	ioutil.WriteFile(dataFile, []byte("filter me"), 0644)
+
+	code = run([]string{"request", "-connect", "unix:" + s.Address, "-role", "filter", "-filter-data", dataFile}, stdout, stderr)
+	if want, have := 0, code; want != have {
+		t.Fatalf("expected %#v, got %#v (stderr: %s)", want, have, stderr.String())
+	}
+	req = s.LastRequest()
+	if want, have := gofast.RoleFilter, req.Role; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "9", req.Params["FCGI_DATA_LENGTH"]; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "filter me", string(req.Data); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestRequest_usage(t *testing.T) {
+	tests := []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"request"}, "-connect is required"},
+		{[]string{"request", "-connect", ":9000", "-role", "boss"}, `unknown role "boss"`},
+		{[]string{"request", "-connect", ":9000", "-script", "a.php", "-docroot", "/srv"}, "cannot be used together"},
+	}
+	for _, test := range tests {
+		stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+		if want, have := 2, run(test.args, stdout, stderr); want != have {
+			t.Errorf("%v: expected %#v, got %#v", test.args, want, have)
+		}
+		if !strings.Contains(stderr.String(), test.err) {
+			t.Errorf("%v: expected %#v in %#v", test.args, test.err, stderr.String())
+		}
+	}
+}
+
+func TestRequest_dryRun(t *testing.T) {
+	stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+	code := run([]string{
+		"request",
+		"-dry-run",
+		"-docroot", "/var/www/html",
+		"-param", "APP_ENV=test",
+		"/foo/index.php/bar",
+	}, stdout, stderr)
+	if want, have := 0, code; want != have {
+		t.Fatalf("expected %#v, got %#v (stderr: %s)", want, have, stderr.String())
+	}
+	for _, want := range []string{
+		"1. gofast.BasicParamsMap\n",
+		"3. gofast.FileSystemRouter (DocRoot=/var/www/html)\n",
+		"     + SCRIPT_FILENAME=/var/www/html/foo/index.php\n",
+		"4. request flags (role=responder)\n     + APP_ENV=test\n",
+		"  PATH_INFO=/bar\n",
+	} {
+		if have := stdout.String(); !strings.Contains(have, want) {
+			t.Errorf("expected %#v in %#v", want, have)
+		}
+	}
+}
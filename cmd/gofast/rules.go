@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/yookoala/gofast"
+)
+
+// RuleConfig is a routing rule of a route. The rules of a route apply
+// in order to each request, with expressions of the request (see expr
+// and README.md) to pick the backend, rewrite the path, or add params
+// without recompiling the gateway.
+type RuleConfig struct {
+
+	// If is the condition of the rule, an expression of bool.
+	// The rule always applies if empty.
+	If string `yaml:"if" toml:"if" json:"if"`
+
+	// Backend is the name of the backend to serve the
+	// request instead of the backend of the route
+	Backend string `yaml:"backend" toml:"backend" json:"backend"`
+
+	// Rewrite is an expression of string of the new path of the
+	// request. The rules after see the new path, and REQUEST_URI
+	// stays the original one.
+	Rewrite string `yaml:"rewrite" toml:"rewrite" json:"rewrite"`
+
+	// Params are the expressions of string of extra FastCGI
+	// params by name, which override the generated ones
+	Params map[string]string `yaml:"params" toml:"params" json:"params"`
+
+	// Last, if true, stops the rules after if the rule applies
+	Last bool `yaml:"last" toml:"last" json:"last"`
+
+	cond    *expr
+	rewrite *expr
+	params  map[string]*expr
+}
+
+// compile compiles the expressions of the rule
+func (rule *RuleConfig) compile() (err error) {
+	if rule.If != "" {
+		if rule.cond, err = compileExpr(rule.If, typeBool); err != nil {
+			return fmt.Errorf("if: %s", err)
+		}
+	}
+	if rule.Rewrite != "" {
+		if rule.rewrite, err = compileExpr(rule.Rewrite, typeString); err != nil {
+			return fmt.Errorf("rewrite: %s", err)
+		}
+	}
+	rule.params = make(map[string]*expr, len(rule.Params))
+	for name, src := range rule.Params {
+		if rule.params[name], err = compileExpr(src, typeString); err != nil {
+			return fmt.Errorf("params.%s: %s", name, err)
+		}
+	}
+	return nil
+}
+
+// ruleParamsKey is the context key of the params of the rules
+type ruleParamsKey struct{}
+
+// newRulesHandler returns an http.Handler that applies the rules
+// to the request, then serves it with the handler of the backend
+func newRulesHandler(rules []RuleConfig, backend string, handlers map[string]http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		backend := backend
+		var params map[string]string
+		rewritten := false
+		for i := range rules {
+			rule := &rules[i]
+			if rule.cond != nil && !rule.cond.evalBool(r) {
+				continue
+			}
+			if rule.Backend != "" {
+				backend = rule.Backend
+			}
+			if rule.rewrite != nil {
+				r = withPath(r, rule.rewrite.evalString(r))
+				rewritten = true
+			}
+			for name, e := range rule.params {
+				if params == nil {
+					params = make(map[string]string)
+				}
+				params[name] = e.evalString(r)
+			}
+			if rule.Last {
+				break
+			}
+		}
+		if rewritten {
+			// MapEndpoint sets REQUEST_URI of the rewritten URL
+			if params == nil {
+				params = make(map[string]string)
+			}
+			if _, ok := params["REQUEST_URI"]; !ok {
+				params["REQUEST_URI"] = r.RequestURI
+			}
+		}
+		if params != nil {
+			r = r.WithContext(context.WithValue(r.Context(), ruleParamsKey{}, params))
+		}
+		handlers[backend].ServeHTTP(w, r)
+	})
+}
+
+// withPath returns a shallow copy of the request with the path
+func withPath(r *http.Request, path string) *http.Request {
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	r2 := new(http.Request)
+	*r2 = *r
+	u := *r.URL
+	u.Path, u.RawPath = path, ""
+	r2.URL = &u
+	return r2
+}
+
+// mapRuleParams is a gofast.Middleware that sets the
+// params of the rules, if any
+func mapRuleParams(inner gofast.SessionHandler) gofast.SessionHandler {
+	return func(client gofast.Client, req *gofast.Request) (*gofast.ResponsePipe, error) {
+		if params, ok := req.Raw.Context().Value(ruleParamsKey{}).(map[string]string); ok {
+			for name, value := range params {
+				req.Params[name] = value
+			}
+		}
+		return inner(client, req)
+	}
+}
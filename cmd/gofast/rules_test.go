@@ -0,0 +1,112 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/yookoala/gofast/fcgitest"
+)
+
+func TestGateway_rules(t *testing.T) {
+	root, err := ioutil.TempDir("", "gofast-cmd-")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(root)
+	ioutil.WriteFile(filepath.Join(root, "new.css"), []byte("new {}"), 0644)
+
+	stable := fcgitest.NewUnixServer(fcgitest.Reply(fcgitest.Body("stable")))
+	defer stable.Close()
+	canary := fcgitest.NewUnixServer(fcgitest.Reply(fcgitest.Body("canary")))
+	defer canary.Close()
+
+	c := &Config{
+		Listeners: []ListenerConfig{{Address: ":8080"}},
+		Backends: map[string]BackendConfig{
+			"stable": {Address: "unix:" + stable.Address},
+			"canary": {Address: "unix:" + canary.Address},
+		},
+		Routes: []RouteConfig{
+			{Path: "/assets/", Type: "static", DocRoot: root, StripPrefix: true, Rules: []RuleConfig{
+				{If: `path == "/assets/old.css"`, Rewrite: `"/assets/new.css"`},
+			}},
+			{Type: "endpoint", Backend: "stable", Endpoint: "/srv/index.php", Rules: []RuleConfig{
+				{If: `header("X-Canary") == "1"`, Backend: "canary"},
+				{If: `has_prefix(path, "/v1/")`, Rewrite: `"/v2/" + trim_prefix(path, "/v1/")`},
+				{Params: map[string]string{"APP_TENANT": `cookie("tenant")`, "APP_PATH": `path`}},
+				{If: `arg("debug") == "1"`, Params: map[string]string{"APP_DEBUG": `"1"`}, Last: true},
+				{Backend: "canary", Params: map[string]string{"APP_DEBUG": `"0"`}},
+			}},
+		},
+		Log: LogConfig{Error: "off"},
+	}
+	if err := c.validate(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	g, err := NewGateway(c)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer g.Close()
+
+	tests := []struct {
+		desc    string
+		path    string
+		header  map[string]string
+		body    string
+		params  map[string]string
+		backend *fcgitest.Server
+	}{
+		{
+			desc: "rewrite of static route",
+			path: "/assets/old.css",
+			body: "new {}",
+		},
+		{
+			desc:    "params and last",
+			path:    "/v1/users?debug=1",
+			header:  map[string]string{"Cookie": "tenant=acme"},
+			body:    "stable",
+			params:  map[string]string{"APP_TENANT": "acme", "APP_PATH": "/v2/users", "APP_DEBUG": "1", "REQUEST_URI": "/v1/users?debug=1"},
+			backend: stable,
+		},
+		{
+			desc:    "backend by header",
+			path:    "/users?debug=1",
+			header:  map[string]string{"X-Canary": "1"},
+			body:    "canary",
+			params:  map[string]string{"APP_TENANT": "", "APP_PATH": "/users", "APP_DEBUG": "1"},
+			backend: canary,
+		},
+		{
+			desc:    "rules after",
+			path:    "/users",
+			body:    "canary",
+			params:  map[string]string{"APP_DEBUG": "0"},
+			backend: canary,
+		},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", test.path, nil)
+		for name, value := range test.header {
+			r.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		g.Handler.ServeHTTP(w, r)
+		if want, have := test.body, w.Body.String(); want != have {
+			t.Errorf("%s: expected %#v, got %#v", test.desc, want, have)
+		}
+		if test.backend == nil {
+			continue
+		}
+		req := test.backend.LastRequest()
+		for name, want := range test.params {
+			if have, ok := req.Params[name]; !ok || want != have {
+				t.Errorf("%s: %s: expected %#v, got %#v", test.desc, name, want, have)
+			}
+		}
+	}
+}
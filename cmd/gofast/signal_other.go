@@ -0,0 +1,13 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// upgradeSignals are the signals to upgrade the gateway
+// (see Gateway.Upgrade), like USR2 of nginx
+var upgradeSignals = []os.Signal{syscall.SIGUSR2}
@@ -0,0 +1,12 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"os"
+)
+
+// upgradeSignals are the signals to upgrade the gateway. None on
+// Windows, which cannot pass the sockets to the new process.
+var upgradeSignals = []os.Signal{}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/yookoala/gofast/tools/systemd"
+)
+
+// environment variables to the upgraded process
+const (
+	// envUpgradeFds lists the inherited listeners, as a query
+	// string of the addresses in the config to the fds
+	envUpgradeFds = "GOFAST_UPGRADE_FDS"
+
+	// envUpgradeReady is the fd of the pipe to
+	// report to the old process when ready
+	envUpgradeReady = "GOFAST_UPGRADE_READY"
+)
+
+// adminKey is the key of the admin API listener in envUpgradeFds
+const adminKey = "admin"
+
+// upgradeTimeout is the time to wait for the new process to be ready
+const upgradeTimeout = 30 * time.Second
+
+// upgradeCommand returns the command of the new process: the
+// executable, which may have been replaced, with the same arguments
+var upgradeCommand = func() (*exec.Cmd, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	return exec.Command(exe, os.Args[1:]...), nil
+}
+
+// filer is a net.Listener of which the socket can be passed to
+// another process (e.g. *net.TCPListener, *net.UnixListener)
+type filer interface {
+	File() (*os.File, error)
+}
+
+// Upgrade starts a new process of the executable with the listening
+// sockets of the gateway, and waits for it to be ready, like the binary
+// upgrade of nginx. The gateway should then shut down (i.e. the ctx of
+// Serve done), so the new process accepts all the new connections,
+// while the old one finishes the in-flight requests.
+//
+// If the new process fails to start or to serve, it is killed and the
+// gateway keeps serving.
+func (g *Gateway) Upgrade() (*os.Process, error) {
+	g.mutex.RLock()
+	listeners, admin := g.listeners, g.admin
+	g.mutex.RUnlock()
+	if len(listeners) == 0 {
+		return nil, fmt.Errorf("upgrade: gateway is not serving")
+	}
+
+	// the sockets to pass, as the fds 3, 4, 5... of the new process
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	fds := url.Values{}
+	add := func(key string, l net.Listener) error {
+		fl, ok := l.(filer)
+		if !ok {
+			return fmt.Errorf("upgrade: cannot pass listener of %s", l.Addr())
+		}
+		f, err := fl.File()
+		if err != nil {
+			return fmt.Errorf("upgrade: %s", err)
+		}
+		fds.Add(key, strconv.Itoa(3+len(files)))
+		files = append(files, f)
+		return nil
+	}
+	for _, l := range listeners {
+		if err := add(l.config.Address, l.Listener); err != nil {
+			return nil, err
+		}
+	}
+	if admin != nil {
+		if err := add(adminKey, admin); err != nil {
+			return nil, err
+		}
+	}
+	ready, w, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("upgrade: %s", err)
+	}
+	defer ready.Close()
+	readyFd := 3 + len(files)
+	files = append(files, w)
+
+	cmd, err := upgradeCommand()
+	if err != nil {
+		return nil, fmt.Errorf("upgrade: %s", err)
+	}
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env,
+		envUpgradeFds+"="+fds.Encode(),
+		envUpgradeReady+"="+strconv.Itoa(readyFd))
+	if cmd.Stdout == nil {
+		cmd.Stdout = os.Stdout
+	}
+	if cmd.Stderr == nil {
+		cmd.Stderr = os.Stderr
+	}
+	cmd.ExtraFiles = files
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("upgrade: %s", err)
+	}
+
+	// the write end of the pipe is only open in the new
+	// process, so the read ends if the new process exits
+	w.Close()
+	files = files[:len(files)-1]
+	done := make(chan error, 1)
+	go func() {
+		b, err := ioutil.ReadAll(io.LimitReader(ready, 1))
+		if err == nil && len(b) == 0 {
+			err = fmt.Errorf("new process exited before ready")
+		}
+		done <- err
+	}()
+	select {
+	case err = <-done:
+	case <-time.After(upgradeTimeout):
+		err = fmt.Errorf("new process not ready after %s", upgradeTimeout)
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, fmt.Errorf("upgrade: %s", err)
+	}
+	atomic.StoreInt32(&g.upgraded, 1)
+	return cmd.Process, nil
+}
+
+// inheritListeners returns the listeners passed by the old process on
+// upgrade, by the addresses in the config. Returns nil if not upgraded.
+func inheritListeners() (map[string][]net.Listener, error) {
+	v := os.Getenv(envUpgradeFds)
+	if v == "" {
+		return nil, nil
+	}
+	os.Unsetenv(envUpgradeFds)
+	fds, err := url.ParseQuery(v)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", envUpgradeFds, err)
+	}
+	inherited := make(map[string][]net.Listener, len(fds))
+	for key, values := range fds {
+		for _, value := range values {
+			fd, err := strconv.Atoi(value)
+			if err != nil {
+				closeListeners(inherited)
+				return nil, fmt.Errorf("%s: invalid fd %q", envUpgradeFds, value)
+			}
+			f := os.NewFile(uintptr(fd), key)
+			l, err := net.FileListener(f)
+			f.Close()
+			if err != nil {
+				closeListeners(inherited)
+				return nil, fmt.Errorf("%s: %s", envUpgradeFds, err)
+			}
+			inherited[key] = append(inherited[key], l)
+		}
+	}
+	return inherited, nil
+}
+
+// closeListeners closes the listeners
+func closeListeners(listeners map[string][]net.Listener) {
+	for _, ls := range listeners {
+		for _, l := range ls {
+			l.Close()
+		}
+	}
+}
+
+// notifyUpgraded reports to the old process, if upgraded, that the new
+// process is ready. systemd is told the new main PID, so the service
+// keeps running when the old process exits.
+func notifyUpgraded() error {
+	v := os.Getenv(envUpgradeReady)
+	if v == "" {
+		return nil
+	}
+	os.Unsetenv(envUpgradeReady)
+	fd, err := strconv.Atoi(v)
+	if err != nil {
+		return fmt.Errorf("%s: invalid fd %q", envUpgradeReady, v)
+	}
+	systemd.Notify(fmt.Sprintf("MAINPID=%d", os.Getpid()))
+	f := os.NewFile(uintptr(fd), "ready")
+	defer f.Close()
+	_, err = f.Write([]byte{1})
+	return err
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// envUpgradeHelper runs TestUpgrade_helper as the new process,
+// serving the static files of the folder
+const envUpgradeHelper = "GOFAST_TEST_UPGRADE_HELPER"
+
+// staticConfig returns the config of a gateway serving
+// the static files of root on the socket
+func staticConfig(sock, root string) *Config {
+	return &Config{
+		Listeners: []ListenerConfig{{Address: "unix:" + sock}},
+		Routes:    []RouteConfig{{Path: "/", Type: "static", DocRoot: root}},
+		Log:       LogConfig{Error: "off"},
+	}
+}
+
+func TestUpgrade_helper(t *testing.T) {
+	root := os.Getenv(envUpgradeHelper)
+	if root == "" {
+		t.Skip("only run as the new process of TestGateway_Upgrade")
+	}
+	g, err := NewGateway(staticConfig(filepath.Join(root, "http.sock"), filepath.Join(root, "new")))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := g.Serve(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+func TestGateway_Upgrade(t *testing.T) {
+	root, err := ioutil.TempDir("", "gofast-cmd-")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(root)
+	for _, name := range []string{"old", "new"} {
+		os.Mkdir(filepath.Join(root, name), 0755)
+		ioutil.WriteFile(filepath.Join(root, name, "who.txt"), []byte(name), 0644)
+	}
+	sock := filepath.Join(root, "http.sock")
+
+	defer func(orig func() (*exec.Cmd, error)) { upgradeCommand = orig }(upgradeCommand)
+	upgradeCommand = func() (*exec.Cmd, error) {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestUpgrade_helper$")
+		cmd.Env = append(os.Environ(), envUpgradeHelper+"="+root)
+		return cmd, nil
+	}
+
+	g, err := NewGateway(staticConfig(sock, filepath.Join(root, "old")))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer g.Close()
+	if _, err := g.Upgrade(); err == nil {
+		t.Errorf("expected error upgrading a gateway not serving")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- g.Serve(ctx)
+	}()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", sock)
+			},
+			DisableKeepAlives: true,
+		},
+	}
+	get := func() (string, error) {
+		resp, err := client.Get("http://gateway/who.txt")
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		return string(body), err
+	}
+	var have string
+	for i := 0; i < 50; i++ {
+		if have, err = get(); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if want := "old"; want != have {
+		t.Errorf("expected %#v, got %#v (error: %v)", want, have, err)
+	}
+
+	p, err := g.Upgrade()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer func() {
+		p.Kill()
+		p.Wait()
+	}()
+
+	// the old process shuts down, and the
+	// new process serves on the same socket
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Serve does not return after ctx is done")
+	}
+	if have, err = get(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want := "new"; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestInheritListeners(t *testing.T) {
+	os.Unsetenv(envUpgradeFds)
+	if inherited, err := inheritListeners(); err != nil || inherited != nil {
+		t.Errorf("expected no listeners, got %#v (error: %v)", inherited, err)
+	}
+
+	os.Setenv(envUpgradeFds, "admin=nonsense")
+	if _, err := inheritListeners(); err == nil {
+		t.Errorf("expected error for invalid fd")
+	}
+	if want, have := "", os.Getenv(envUpgradeFds); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
@@ -0,0 +1,67 @@
+package gofast
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// Dialer dials a connection to the FastCGI application, and should give
+// up once the ctx is done. It may connect in any way, e.g. over TLS with
+// tls.Dialer, through an SSH tunnel or a SOCKS proxy, or return the
+// connections established otherwise (e.g. accepted from a listener of
+// systemd socket activation):
+//
+//	d := &tls.Dialer{Config: &tls.Config{ServerName: "php.internal"}}
+//	dial := func(ctx context.Context) (net.Conn, error) {
+//		return d.DialContext(ctx, "tcp", "php.internal:9000")
+//	}
+//	clientFactory := gofast.DialerClientFactory(dial, 5*time.Second)
+type Dialer func(ctx context.Context) (net.Conn, error)
+
+// DialerConnFactory returns a ConnFactory of the Dialer. The ctx of the
+// dial is done after the timeout, if not 0. The timeout is honored even
+// if the Dialer ignores the ctx (e.g. the Dial of an SSH client): the
+// ConnFactory returns the ErrTimeout, and the connection made late, if
+// any, is closed. Through the Handler, the ErrTimeout responds 504.
+func DialerConnFactory(dial Dialer, timeout time.Duration) ConnFactory {
+	return func() (net.Conn, error) {
+		if timeout <= 0 {
+			return dial(context.Background())
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		type result struct {
+			conn net.Conn
+			err  error
+		}
+		done := make(chan result, 1)
+		go func() {
+			conn, err := dial(ctx)
+			done <- result{conn, err}
+		}()
+		select {
+		case r := <-done:
+			if r.err != nil && ctx.Err() != nil {
+				// the dialer gave up on the ctx
+				return nil, &timeoutError{ctx.Err()}
+			}
+			return r.conn, r.err
+		case <-ctx.Done():
+			go func() {
+				if r := <-done; r.conn != nil {
+					r.conn.Close()
+				}
+			}()
+			return nil, &timeoutError{ctx.Err()}
+		}
+	}
+}
+
+// DialerClientFactory returns a ClientFactory of the connections of the
+// Dialer, with the timeout of DialerConnFactory. Like the clients of
+// SimpleClientFactory, the errors of dialing are ErrDial.
+func DialerClientFactory(dial Dialer, timeout time.Duration, options ...ClientOption) ClientFactory {
+	return SimpleClientFactory(DialerConnFactory(dial, timeout), options...)
+}
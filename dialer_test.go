@@ -0,0 +1,72 @@
+package gofast_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/yookoala/gofast"
+)
+
+func TestDialerClientFactory(t *testing.T) {
+	s := gofast.NewServer(echoName)
+	connFactory := muxConnFactory(s, "", nil)
+	var dialed bool
+	dial := func(ctx context.Context) (net.Conn, error) {
+		if _, ok := ctx.Deadline(); !ok {
+			t.Errorf("expected the ctx to have a deadline")
+		}
+		dialed = true
+		return connFactory()
+	}
+	have, err := doName(gofast.DialerClientFactory(dial, time.Second), "hello")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want := "hello"; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if !dialed {
+		t.Errorf("expected the dialer to be used")
+	}
+}
+
+func TestDialerConnFactory_timeout(t *testing.T) {
+	// the dialer honoring the ctx
+	dial := func(ctx context.Context) (net.Conn, error) {
+		var d net.Dialer
+		<-ctx.Done()
+		return d.DialContext(ctx, "tcp", "127.0.0.1:0")
+	}
+	h := gofast.NewHandler(gofast.BasicSession, gofast.DialerClientFactory(dial, 10*time.Millisecond))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if want, have := http.StatusGatewayTimeout, w.Code; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	// the dialer ignoring the ctx, of which
+	// the connection made late is closed
+	release := make(chan struct{})
+	late, peer := net.Pipe()
+	defer peer.Close()
+	dial = func(ctx context.Context) (net.Conn, error) {
+		<-release
+		return late, nil
+	}
+	_, err := gofast.DialerConnFactory(dial, 10*time.Millisecond)()
+	if err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+	if e, ok := err.(interface{ Timeout() bool }); !ok || !e.Timeout() {
+		t.Errorf("expected timeout error, got %#v", err)
+	}
+	close(release)
+	peer.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := peer.Read(make([]byte, 1)); err == nil {
+		t.Errorf("expected the late connection to be closed")
+	}
+}
@@ -0,0 +1,89 @@
+package gofast
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// NewDrainer returns a *Drainer
+func NewDrainer() *Drainer {
+	return &Drainer{}
+}
+
+// Drainer tracks in-flight requests of http.Handler(s) so a gateway can
+// be shut down (or restarted) without cutting off the requests that are
+// being served.
+//
+// Wrap the handler(s) with the Drainer. Call Drain when the gateway is
+// about to stop, then Wait for the in-flight requests to finish.
+type Drainer struct {
+	mutex    sync.Mutex
+	draining bool
+	inFlight int
+	idle     chan struct{}
+}
+
+// Wrap returns an http.Handler which counts the in-flight
+// requests of the inner handler.
+//
+// New requests are still served after Drain. It is up to the load
+// balancer (e.g. Kubernetes, see Probes) to stop sending requests.
+func (d *Drainer) Wrap(inner http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d.add(1)
+		defer d.add(-1)
+		inner.ServeHTTP(w, r)
+	})
+}
+
+func (d *Drainer) add(delta int) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.inFlight == 0 && d.idle == nil {
+		d.idle = make(chan struct{})
+	}
+	d.inFlight += delta
+	if d.inFlight == 0 {
+		close(d.idle)
+		d.idle = nil
+	}
+}
+
+// InFlight returns the number of requests being served
+func (d *Drainer) InFlight() int {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.inFlight
+}
+
+// Drain marks the Drainer as draining
+func (d *Drainer) Drain() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.draining = true
+}
+
+// Draining reports if Drain has been called
+func (d *Drainer) Draining() bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.draining
+}
+
+// Wait blocks until there is no in-flight request, or the ctx is
+// done. Returns the error of the ctx in the later case.
+func (d *Drainer) Wait(ctx context.Context) error {
+	d.mutex.Lock()
+	idle := d.idle
+	d.mutex.Unlock()
+	if idle == nil {
+		return nil
+	}
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,59 @@
+package gofast_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/yookoala/gofast"
+)
+
+func TestDrainer(t *testing.T) {
+	d := gofast.NewDrainer()
+	release := make(chan struct{})
+	started := make(chan struct{})
+	h := d.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+	}))
+
+	// no in-flight request
+	if err := d.Wait(context.Background()); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		close(done)
+	}()
+	<-started
+
+	if want, have := 1, d.InFlight(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if d.Draining() {
+		t.Errorf("expected not draining")
+	}
+	d.Drain()
+	if !d.Draining() {
+		t.Errorf("expected draining")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if want, have := context.DeadlineExceeded, d.Wait(ctx); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	close(release)
+	if err := d.Wait(context.Background()); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	<-done
+	if want, have := 0, d.InFlight(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
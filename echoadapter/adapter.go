@@ -0,0 +1,58 @@
+// Package echoadapter mounts gofast handlers under echo routes.
+//
+// The route path prefix before the wildcard is stripped and remembered,
+// so gofast.MapMountPrefix may restore it to SCRIPT_NAME and
+// DOCUMENT_URI. Other path parameters are available to
+// gofast.MapRouteParams.
+//
+//  sess := gofast.Chain(
+//  	gofast.NewPHPFS("/var/www/html"),
+//  	gofast.MapMountPrefix,
+//  	gofast.MapRouteParams,
+//  )(gofast.BasicSession)
+//  h := gofast.NewHandler(sess, clientFactory)
+//
+//  e := echo.New()
+//  g := e.Group("/app")
+//  g.Any("/*", echoadapter.Handler(h))
+//
+package echoadapter
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/yookoala/gofast"
+)
+
+// Handler returns an echo.HandlerFunc that serves the given http.Handler
+// (e.g. a gofast.Handler). If the matched route has a wildcard (e.g.
+// "/app/*"), the path before it is stripped from the request path with
+// gofast.MountWildcard.
+func Handler(h http.Handler) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var wildcard string
+		var hasWildcard bool
+
+		names, values := c.ParamNames(), c.ParamValues()
+		params := make(map[string]string, len(names))
+		for i, name := range names {
+			if i >= len(values) {
+				break
+			}
+			if name == "*" {
+				wildcard, hasWildcard = values[i], true
+				continue
+			}
+			params[name] = values[i]
+		}
+		r := gofast.WithRouteParams(c.Request(), params)
+
+		if hasWildcard {
+			gofast.MountWildcard(c.Response(), r, wildcard, h)
+			return nil
+		}
+		h.ServeHTTP(c.Response(), r)
+		return nil
+	}
+}
@@ -0,0 +1,10 @@
+module github.com/yookoala/gofast/echoadapter
+
+go 1.16
+
+require (
+	github.com/labstack/echo/v4 v4.11.4
+	github.com/yookoala/gofast v0.0.0
+)
+
+replace github.com/yookoala/gofast => ../
@@ -0,0 +1,201 @@
+package gofast
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Errors of the web server side. The errors returned are the errors of
+// the details (e.g. the *net.OpError of the dial) tagged with these, so
+// the messages stay the same. They can be checked
+// with errors.Is and errors.As of Go 1.13 or later:
+//
+//	if errors.Is(err, gofast.ErrDial) {
+//		// retry with another backend
+//	}
+var (
+	// ErrDial is the error of connecting to the FastCGI application
+	// (e.g. by the ClientFactory of SimpleClientFactory)
+	ErrDial = errors.New("gofast: failed to connect to FastCGI application")
+
+	// ErrTimeout is the error of the request context being done
+	// (deadline exceeded or canceled) before the application
+	// ends the request
+	ErrTimeout = errors.New("gofast: timeout or canceled")
+
+	// ErrPoolExhausted is the error of ClientPool if no client is
+	// ready within its MaxWait
+	ErrPoolExhausted = errors.New("gofast: client pool exhausted")
+
+	// ErrPoolClosed is the error of ClientPool after it is closed
+	ErrPoolClosed = errors.New("gofast: client pool closed")
+
+	// ErrCircuitOpen is the error of CircuitBreaker if the circuit
+	// is open after consecutive failures of the application
+	ErrCircuitOpen = errors.New("gofast: circuit open")
+
+	// ErrHeaderTooLarge is the error of the CGI header of the response
+	// exceeding the limit of the client (see WithMaxHeaderBytes)
+	ErrHeaderTooLarge = errors.New("gofast: header from subprocess too large")
+)
+
+// ProtocolError is an error of the FastCGI records from the
+// application (e.g. unexpected record type, or the connection closed
+// before FCGI_END_REQUEST)
+type ProtocolError struct {
+
+	// Type of the record, 0 if the error is of no record
+	Type uint8
+
+	// ReqID is the request ID
+	ReqID uint16
+
+	// Reason of the error
+	Reason string
+}
+
+// Error implements error
+func (e *ProtocolError) Error() string {
+	if e.Type == 0 {
+		return fmt.Sprintf("gofast: protocol error: %s (request %d)", e.Reason, e.ReqID)
+	}
+	return fmt.Sprintf("gofast: protocol error: %s (%s of request %d)",
+		e.Reason, recType(e.Type), e.ReqID)
+}
+
+// BackendError is the error of the application not completing the
+// request, as reported by the protocolStatus of FCGI_END_REQUEST
+type BackendError struct {
+
+	// Status is the protocolStatus: 1 for FCGI_CANT_MPX_CONN,
+	// 2 for FCGI_OVERLOADED, 3 for FCGI_UNKNOWN_ROLE
+	Status uint8
+
+	// AppStatus is the appStatus of the application
+	AppStatus uint32
+}
+
+// Error implements error
+func (e *BackendError) Error() string {
+	var name string
+	switch e.Status {
+	case statusCantMultiplex:
+		name = "FCGI_CANT_MPX_CONN"
+	case statusOverloaded:
+		name = "FCGI_OVERLOADED"
+	case statusUnknownRole:
+		name = "FCGI_UNKNOWN_ROLE"
+	default:
+		name = fmt.Sprintf("protocol status %d", e.Status)
+	}
+	return "gofast: request rejected by application: " + name
+}
+
+// HTTPStatus is the HTTP status code for the error:
+// 503 Service Unavailable if the application is overloaded,
+// or 502 Bad Gateway otherwise
+func (e *BackendError) HTTPStatus() int {
+	if e.Status == statusOverloaded {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusBadGateway
+}
+
+// wrapError tags the error with a sentinel error, and
+// unwraps to the error for the details
+type wrapError struct {
+	sentinel error
+	err      error
+}
+
+func (e *wrapError) Error() string {
+	return e.err.Error()
+}
+
+// Is supports errors.Is
+func (e *wrapError) Is(target error) bool {
+	return target == e.sentinel
+}
+
+// Unwrap supports errors.Is and errors.As
+func (e *wrapError) Unwrap() error {
+	return e.err
+}
+
+// timeoutError is the ErrTimeout of the context error
+type timeoutError struct {
+	err error
+}
+
+func (e *timeoutError) Error() string {
+	return ErrTimeout.Error()
+}
+
+// Is supports errors.Is
+func (e *timeoutError) Is(target error) bool {
+	return target == ErrTimeout
+}
+
+// Unwrap supports errors.Is (e.g. of context.DeadlineExceeded)
+func (e *timeoutError) Unwrap() error {
+	return e.err
+}
+
+// Timeout implements the Timeout of net.Error
+func (e *timeoutError) Timeout() bool {
+	return true
+}
+
+// isTimeout reports whether err is the timeout of a
+// deadline (e.g. of net.Conn.SetReadDeadline)
+func isTimeout(err error) bool {
+	e, ok := err.(interface{ Timeout() bool })
+	return ok && e.Timeout()
+}
+
+// isError reports whether any error in the chain of err
+// is target, like errors.Is of Go 1.13 or later
+func isError(err, target error) bool {
+	for err != nil {
+		if err == target {
+			return true
+		}
+		if x, ok := err.(interface{ Is(error) bool }); ok && x.Is(target) {
+			return true
+		}
+		u, ok := err.(interface{ Unwrap() error })
+		if !ok {
+			return false
+		}
+		err = u.Unwrap()
+	}
+	return false
+}
+
+// errorStatus returns the HTTP status code of the error,
+// or the fallback if the error is of none of the types
+func errorStatus(err error, fallback int) int {
+	for e := err; e != nil; {
+		switch e := e.(type) {
+		case *BackendError:
+			return e.HTTPStatus()
+		case *ProtocolError:
+			return http.StatusBadGateway
+		}
+		u, ok := e.(interface{ Unwrap() error })
+		if !ok {
+			break
+		}
+		e = u.Unwrap()
+	}
+	switch {
+	case isError(err, ErrTimeout):
+		return http.StatusGatewayTimeout
+	case isError(err, ErrPoolExhausted), isError(err, ErrPoolClosed), isError(err, ErrCircuitOpen):
+		return http.StatusServiceUnavailable
+	case isError(err, ErrDial), isError(err, ErrHeaderTooLarge):
+		return http.StatusBadGateway
+	}
+	return fallback
+}
@@ -0,0 +1,184 @@
+//go:build go1.13
+// +build go1.13
+
+package gofast_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/yookoala/gofast"
+	"github.com/yookoala/gofast/fcgitest"
+)
+
+// rawConnFactory connects to a peer that discards the
+// request and responds with the raw records
+func rawConnFactory(records ...[]byte) gofast.ConnFactory {
+	return func() (net.Conn, error) {
+		appConn, webConn := net.Pipe()
+		go io.Copy(ioutil.Discard, appConn)
+		go func() {
+			for _, rec := range records {
+				appConn.Write(rec)
+			}
+		}()
+		return webConn, nil
+	}
+}
+
+// endRequest is a FCGI_END_REQUEST record of request 1
+func endRequest(protocolStatus byte) []byte {
+	return []byte{1, 3, 0, 1, 0, 8, 0, 0, 0, 0, 0, 0, protocolStatus, 0, 0, 0}
+}
+
+// doRaw sends a request to the peer of the records, and
+// returns the status code and the error of the response
+func doRaw(t *testing.T, records ...[]byte) (int, error) {
+	c, err := gofast.SimpleClientFactory(rawConnFactory(records...))()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer c.Close()
+	resp, err := c.Do(gofast.NewRequest(httptest.NewRequest("GET", "/", nil)))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	w := httptest.NewRecorder()
+	resp.WriteTo(w, ioutil.Discard)
+	return w.Code, resp.Err()
+}
+
+func TestErrDial(t *testing.T) {
+	_, err := gofast.SimpleClientFactory(gofast.SimpleConnFactory("unix", "/nonexistent/fcgi.sock"))()
+	if !errors.Is(err, gofast.ErrDial) {
+		t.Errorf("expected ErrDial, got %#v", err)
+	}
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) {
+		t.Errorf("expected *net.OpError, got %#v", err)
+	}
+
+	h := gofast.NewHandler(gofast.BasicSession, gofast.SimpleClientFactory(gofast.SimpleConnFactory("unix", "/nonexistent/fcgi.sock")))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if want, have := 502, w.Code; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestErrTimeout(t *testing.T) {
+	s := fcgitest.NewServer(fcgitest.Reply(fcgitest.Delay(time.Second), fcgitest.Body("hello")))
+	defer s.Close()
+	c, err := s.ClientFactory()()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	resp, err := c.Do(gofast.NewRequest(httptest.NewRequest("GET", "/", nil).WithContext(ctx)))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	w := httptest.NewRecorder()
+	resp.WriteTo(w, ioutil.Discard)
+	if want, have := 504, w.Code; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if err = resp.Err(); !errors.Is(err, gofast.ErrTimeout) {
+		t.Errorf("expected ErrTimeout, got %#v", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %#v", err)
+	}
+	if want, have := "gofast: timeout or canceled", err.Error(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestErrPoolExhausted(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+	pool := gofast.NewClientPool(func() (gofast.Client, error) {
+		<-block
+		return nil, nil
+	}, 1, time.Minute)
+	pool.MaxWait = 10 * time.Millisecond
+
+	_, err := pool.CreateClient()
+	if !errors.Is(err, gofast.ErrPoolExhausted) {
+		t.Errorf("expected ErrPoolExhausted, got %#v", err)
+	}
+
+	h := gofast.NewHandler(gofast.BasicSession, pool.CreateClient)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if want, have := 503, w.Code; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestProtocolError(t *testing.T) {
+	// FCGI_GET_VALUES_RESULT in response to a request
+	code, err := doRaw(t, []byte{1, 10, 0, 1, 0, 0, 0, 0}, endRequest(0))
+	var perr *gofast.ProtocolError
+	if !errors.As(err, &perr) {
+		t.Fatalf("expected *gofast.ProtocolError, got %#v", err)
+	}
+	if want, have := (gofast.ProtocolError{Type: 10, ReqID: 1, Reason: "unexpected record type"}), *perr; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := 502, code; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	// invalid version
+	_, err = doRaw(t, []byte{2, 6, 0, 1, 0, 0, 0, 0})
+	if !errors.As(err, &perr) {
+		t.Fatalf("expected *gofast.ProtocolError, got %#v", err)
+	}
+	if want, have := "invalid header version", perr.Reason; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestBackendError(t *testing.T) {
+	tests := []struct {
+		protocolStatus byte
+		code           int
+		message        string
+	}{
+		{1, 502, "gofast: request rejected by application: FCGI_CANT_MPX_CONN"},
+		{2, 503, "gofast: request rejected by application: FCGI_OVERLOADED"},
+		{3, 502, "gofast: request rejected by application: FCGI_UNKNOWN_ROLE"},
+	}
+	for _, test := range tests {
+		code, err := doRaw(t, endRequest(test.protocolStatus))
+		var berr *gofast.BackendError
+		if !errors.As(err, &berr) {
+			t.Errorf("expected *gofast.BackendError, got %#v", err)
+			continue
+		}
+		if want, have := test.protocolStatus, berr.Status; want != have {
+			t.Errorf("expected %#v, got %#v", want, have)
+		}
+		if want, have := test.message, berr.Error(); want != have {
+			t.Errorf("expected %#v, got %#v", want, have)
+		}
+		if want, have := test.code, code; want != have {
+			t.Errorf("expected %#v, got %#v", want, have)
+		}
+	}
+
+	// request completed
+	if _, err := doRaw(t, endRequest(0)); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
@@ -14,23 +14,9 @@ import (
 	"github.com/go-restit/lzjson"
 
 	"github.com/yookoala/gofast/example/php"
-	"github.com/yookoala/gofast/tools/phpfpm"
+	"github.com/yookoala/gofast/tools/phpfpm/phpfpmtest"
 )
 
-var username, phpfpmPath string
-
-func init() {
-	var err error
-
-	// defined in environment
-	if phpfpmPath = os.Getenv("TEST_PHPFPM_PATH"); phpfpmPath != "" {
-		// do nothing
-	} else if phpfpmPath, err = phpfpm.FindBinary(phpfpm.ReadPaths(os.Getenv("PATH"))...); err != nil {
-		panic(err)
-	}
-	username = os.Getenv("USER")
-}
-
 func checkError(t *testing.T, cb func() error) {
 	if err := cb(); err != nil {
 		t.Errorf("Unexpected error: %s", err)
@@ -99,39 +85,19 @@ func post(h http.Handler, path string, payload string) (w *httptest.ResponseReco
 	return
 }
 
-func initEnv(t *testing.T, name string, worker int) (exmpPath string, process *phpfpm.Process) {
-	if phpfpmPath == "" {
-		t.Skip("empty TEST_PHPFPM_PATH, skip test")
-	}
-	if stat, err := os.Stat(phpfpmPath); os.IsNotExist(err) {
-		t.Errorf("TEST_PHPFPM_PATH (%#v) not found", phpfpmPath)
-		return
-	} else if fmode := stat.Mode(); !fmode.IsRegular() {
-		t.Errorf("TEST_PHPFPM_PATH (%#v) is not a regular file", phpfpmPath)
-		return
-	}
-
+func initEnv(t *testing.T, worker int) (exmpPath string, fpm *phpfpmtest.Server) {
+	fpm = phpfpmtest.New(t, &phpfpmtest.Options{Worker: worker})
 	exmpPath = examplePath()
-	process = phpfpm.NewProcess(phpfpmPath)
-	process.SetName(name)
-	process.SetWorker(worker)
-	process.SetDatadir(path.Join(exmpPath, "var"))
-	process.User = username
-	process.SaveConfig(path.Join(exmpPath, "etc", "test.handler.conf"))
-	if err := process.Start(); err != nil {
-		t.Errorf("unexpected error: %s", err.Error())
-		return
-	}
-
 	return
 }
+
 func TestNewSimpleHandler(t *testing.T) {
 
-	exmpPath, process := initEnv(t, "phpfpm1", 10)
-	defer checkError(t, process.Stop)
+	exmpPath, fpm := initEnv(t, 10)
+	defer checkError(t, fpm.Close)
 
 	// start the proxy handler
-	network, address := process.Address()
+	network, address := fpm.Address()
 	h := php.NewSimpleHandler(
 		path.Join(exmpPath, "htdocs"),
 		network, address)
@@ -194,11 +160,11 @@ func TestNewSimpleHandler(t *testing.T) {
 
 func TestNewSimpleHandler__ErrorStream(t *testing.T) {
 
-	exmpPath, process := initEnv(t, "phpfpm2", 1) // 1 worker to test regression
-	defer checkError(t, process.Stop)
+	exmpPath, fpm := initEnv(t, 1) // 1 worker to test regression
+	defer checkError(t, fpm.Close)
 
 	// start the proxy handler
-	network, address := process.Address()
+	network, address := fpm.Address()
 	h := php.NewSimpleHandler(
 		path.Join(exmpPath, "htdocs"),
 		network, address)
@@ -226,14 +192,14 @@ func TestNewSimpleHandler__ErrorStream(t *testing.T) {
 
 func TestNewFileEndpointHandler(t *testing.T) {
 
-	exmpPath, process := initEnv(t, "phpfpm3", 10)
-	defer checkError(t, process.Stop)
+	exmpPath, fpm := initEnv(t, 10)
+	defer checkError(t, fpm.Close)
 
 	// start the proxy handler
 	var w *httptest.ResponseRecorder
 	var err error
 	var resp lzjson.Node
-	network, address := process.Address()
+	network, address := fpm.Address()
 	h := php.NewFileEndpointHandler(
 		path.Join(exmpPath, "htdocs", "vars.php"),
 		network, address)
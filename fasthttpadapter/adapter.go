@@ -0,0 +1,240 @@
+// Package fasthttpadapter exposes gofast session pipeline as a
+// fasthttp.RequestHandler, without converting the request through
+// net/http types.
+package fasthttpadapter
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+	"github.com/yookoala/gofast"
+)
+
+// NewRequest returns a *gofast.Request for the given fasthttp request
+// context. Basic CGI parameters and the HTTP_* header parameters are
+// mapped from the context, equivalent to gofast.BasicParamsMap and
+// gofast.MapHeader on a net/http request.
+//
+// Note: req.Raw is always nil for the returned request. Path related
+// parameters are left to the session handler (e.g. MapEndpoint).
+func NewRequest(ctx *fasthttp.RequestCtx) (req *gofast.Request) {
+	req = gofast.NewRequest(nil)
+	req.Stdin = ioutil.NopCloser(bytes.NewReader(ctx.PostBody()))
+
+	isHTTPS := ctx.IsTLS()
+	if isHTTPS {
+		req.Params["HTTPS"] = "on"
+	}
+
+	var remoteAddr, remotePort string
+	if addr := ctx.RemoteAddr(); addr != nil {
+		remoteAddr, remotePort, _ = net.SplitHostPort(addr.String())
+	}
+
+	rawHost := string(ctx.Host())
+	host, serverPort, err := net.SplitHostPort(rawHost)
+	if err != nil {
+		host = rawHost
+		if isHTTPS {
+			serverPort = "443"
+		} else {
+			serverPort = "80"
+		}
+	}
+
+	scheme := "http"
+	if isHTTPS {
+		scheme = "https"
+	}
+
+	contentLength := ""
+	if l := ctx.Request.Header.ContentLength(); l >= 0 {
+		contentLength = strconv.Itoa(l)
+	}
+
+	// the basic information here
+	req.Params["CONTENT_TYPE"] = string(ctx.Request.Header.ContentType())
+	req.Params["CONTENT_LENGTH"] = contentLength
+	req.Params["GATEWAY_INTERFACE"] = "CGI/1.1"
+	req.Params["REMOTE_ADDR"] = remoteAddr
+	req.Params["REMOTE_PORT"] = remotePort
+	req.Params["SERVER_PORT"] = serverPort
+	req.Params["SERVER_NAME"] = host
+	req.Params["SERVER_PROTOCOL"] = string(ctx.Request.Header.Protocol())
+	req.Params["SERVER_SOFTWARE"] = "gofast"
+	req.Params["REDIRECT_STATUS"] = "200"
+	req.Params["REQUEST_SCHEME"] = scheme
+	req.Params["REQUEST_METHOD"] = string(ctx.Method())
+	req.Params["REQUEST_URI"] = string(ctx.RequestURI())
+	req.Params["QUERY_STRING"] = string(ctx.QueryArgs().QueryString())
+	req.Params["DOCUMENT_URI"] = string(ctx.Path())
+
+	// http header
+	if rawHost != "" {
+		req.Params["HTTP_HOST"] = rawHost
+	}
+	ctx.Request.Header.VisitAll(func(k, v []byte) {
+		formattedKey := strings.Replace(strings.ToUpper(string(k)), "-", "_", -1)
+		if formattedKey == "CONTENT_TYPE" || formattedKey == "CONTENT_LENGTH" || formattedKey == "HOST" {
+			return
+		}
+		key := "HTTP_" + formattedKey
+		if prev, ok := req.Params[key]; ok {
+			// combine multiple header fields with the same name,
+			// as what gofast.MapHeader does.
+			req.Params[key] = prev + "," + string(v)
+			return
+		}
+		req.Params[key] = string(v)
+	})
+	return
+}
+
+// NewHandler returns a fasthttp.RequestHandler which creates request with
+// NewRequest, then hands it over to the sessionHandler with client from
+// the clientFactory.
+func NewHandler(sessionHandler gofast.SessionHandler, clientFactory gofast.ClientFactory) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		c, err := clientFactory()
+		if err != nil {
+			ctx.Error("failed to connect to FastCGI application", http.StatusBadGateway)
+			log.Printf("gofast: unable to connect to FastCGI application. %s",
+				err.Error())
+			return
+		}
+		defer func() {
+			if err := c.Close(); err != nil {
+				log.Printf("gofast: error closing client: %s",
+					err.Error())
+			}
+		}()
+
+		resp, err := sessionHandler(c, NewRequest(ctx))
+		if err != nil {
+			ctx.Error("failed to process request", http.StatusInternalServerError)
+			log.Printf("gofast: unable to process request %s",
+				err.Error())
+			return
+		}
+
+		errBuffer := new(bytes.Buffer)
+		if err = resp.WriteTo(&responseWriter{ctx: ctx}, errBuffer); err != nil {
+			log.Printf("gofast: error writing error buffer to response: %s", err)
+		}
+		if errBuffer.Len() > 0 {
+			log.Printf("gofast: error stream from application process %s",
+				errBuffer.String())
+		}
+	}
+}
+
+// responseWriter writes the parsed FastCGI response directly
+// into the fasthttp response.
+type responseWriter struct {
+	ctx         *fasthttp.RequestCtx
+	header      http.Header
+	wroteHeader bool
+}
+
+func (w *responseWriter) Header() http.Header {
+	if w.header == nil {
+		w.header = make(http.Header)
+	}
+	return w.header
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	for k, vv := range w.header {
+		for _, v := range vv {
+			w.ctx.Response.Header.Add(k, v)
+		}
+	}
+	w.ctx.SetStatusCode(code)
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ctx.Write(p)
+}
+
+// MapEndpoint returns a gofast.Middleware that prepare session for
+// application with only 1 file as endpoint. Equivalent of
+// gofast.MapEndpoint for requests created with NewRequest.
+//
+// Parameters included:
+//  SCRIPT_NAME
+//  SCRIPT_FILENAME
+//  DOCUMENT_ROOT
+//
+func MapEndpoint(endpointFile string) gofast.Middleware {
+	dir, webpath := filepath.Dir(endpointFile), "/"+filepath.Base(endpointFile)
+	return func(inner gofast.SessionHandler) gofast.SessionHandler {
+		return func(client gofast.Client, req *gofast.Request) (*gofast.ResponsePipe, error) {
+			req.Params["SCRIPT_NAME"] = webpath
+			req.Params["SCRIPT_FILENAME"] = endpointFile
+			req.Params["DOCUMENT_ROOT"] = dir
+			return inner(client, req)
+		}
+	}
+}
+
+// MapPHPFS returns a gofast.Middleware that route request to script files
+// which path matches the request path. Equivalent of the router of
+// gofast.FileSystemRouter for requests created with NewRequest.
+//
+// Parameters included:
+//  PATH_INFO
+//  PATH_TRANSLATED
+//  SCRIPT_NAME
+//  SCRIPT_FILENAME
+//  DOCUMENT_ROOT
+//
+func MapPHPFS(root string) gofast.Middleware {
+	pathinfoRe := regexp.MustCompile(`^(.+\.php)(/?.+)$`)
+	docroot := filepath.Join(root) // converts to absolute path
+	return func(inner gofast.SessionHandler) gofast.SessionHandler {
+		return func(client gofast.Client, req *gofast.Request) (*gofast.ResponsePipe, error) {
+			fastcgiScriptName := req.Params["DOCUMENT_URI"]
+
+			var fastcgiPathInfo string
+			if matches := pathinfoRe.FindStringSubmatch(fastcgiScriptName); len(matches) > 0 {
+				fastcgiScriptName, fastcgiPathInfo = matches[1], matches[2]
+			}
+
+			// If accessing a directory, try accessing document index file
+			if strings.HasSuffix(fastcgiScriptName, "/") {
+				fastcgiScriptName = path.Join(fastcgiScriptName, "index.php")
+			}
+
+			req.Params["PATH_INFO"] = fastcgiPathInfo
+			req.Params["PATH_TRANSLATED"] = filepath.Join(docroot, fastcgiPathInfo)
+			req.Params["SCRIPT_NAME"] = fastcgiScriptName
+			req.Params["SCRIPT_FILENAME"] = filepath.Join(docroot, fastcgiScriptName)
+			req.Params["DOCUMENT_ROOT"] = docroot
+
+			// check if the script filename is within docroot.
+			// triggers error if not.
+			if !strings.HasPrefix(req.Params["SCRIPT_FILENAME"], docroot) {
+				err := fmt.Errorf("error: access path outside of filesystem docroot")
+				return nil, err
+			}
+			return inner(client, req)
+		}
+	}
+}
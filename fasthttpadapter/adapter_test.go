@@ -0,0 +1,60 @@
+package fasthttpadapter_test
+
+import (
+	"testing"
+
+	"github.com/valyala/fasthttp"
+	"github.com/yookoala/gofast"
+	"github.com/yookoala/gofast/fasthttpadapter"
+)
+
+func TestNewRequest(t *testing.T) {
+	var ctx fasthttp.RequestCtx
+	ctx.Request.Header.SetMethod("POST")
+	ctx.Request.SetRequestURI("/hello/world?foo=bar")
+	ctx.Request.Header.SetHost("foobar.com:8080")
+	ctx.Request.Header.Set("X-Hello-World", "hello")
+	ctx.Request.SetBodyString("hello body")
+
+	req := fasthttpadapter.NewRequest(&ctx)
+	if req.Raw != nil {
+		t.Errorf("expected nil, got %#v", req.Raw)
+	}
+
+	for key, want := range map[string]string{
+		"REQUEST_METHOD":     "POST",
+		"REQUEST_URI":        "/hello/world?foo=bar",
+		"QUERY_STRING":       "foo=bar",
+		"DOCUMENT_URI":       "/hello/world",
+		"SERVER_NAME":        "foobar.com",
+		"SERVER_PORT":        "8080",
+		"HTTP_HOST":          "foobar.com:8080",
+		"HTTP_X_HELLO_WORLD": "hello",
+	} {
+		if have := req.Params[key]; want != have {
+			t.Errorf("%s: expected %#v, got %#v", key, want, have)
+		}
+	}
+}
+
+func TestMapPHPFS(t *testing.T) {
+	var ctx fasthttp.RequestCtx
+	ctx.Request.Header.SetMethod("GET")
+	ctx.Request.SetRequestURI("/hello/index.php/some/path")
+
+	sess := fasthttpadapter.MapPHPFS("/var/www/html")(func(client gofast.Client, req *gofast.Request) (resp *gofast.ResponsePipe, err error) {
+		for key, want := range map[string]string{
+			"SCRIPT_NAME":     "/hello/index.php",
+			"SCRIPT_FILENAME": "/var/www/html/hello/index.php",
+			"PATH_INFO":       "/some/path",
+		} {
+			if have := req.Params[key]; want != have {
+				t.Errorf("%s: expected %#v, got %#v", key, want, have)
+			}
+		}
+		return
+	})
+	if _, err := sess(nil, fasthttpadapter.NewRequest(&ctx)); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
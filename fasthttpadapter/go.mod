@@ -0,0 +1,10 @@
+module github.com/yookoala/gofast/fasthttpadapter
+
+go 1.16
+
+require (
+	github.com/valyala/fasthttp v1.51.0
+	github.com/yookoala/gofast v0.0.0
+)
+
+replace github.com/yookoala/gofast => ../
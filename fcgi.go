@@ -17,6 +17,7 @@ import (
 	"errors"
 	"io"
 	"sync"
+	"time"
 )
 
 // recType is a record type, as defined by
@@ -136,9 +137,21 @@ type conn struct {
 	mutex sync.Mutex
 	rwc   io.ReadWriteCloser
 
-	// to avoid allocations
-	buf bytes.Buffer
-	h   header
+	// writeTimeout is the most time to write a record,
+	// if rwc supports deadlines (see WithWriteTimeout)
+	writeTimeout time.Duration
+}
+
+// writeDeadliner is a connection supporting write deadlines
+type writeDeadliner interface {
+	SetWriteDeadline(t time.Time) error
+}
+
+// setWriteTimeout sets the writeTimeout of the connection
+func (c *conn) setWriteTimeout(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.writeTimeout = d
 }
 
 func newConn(rwc io.ReadWriteCloser) *conn {
@@ -156,12 +169,37 @@ type record struct {
 	buf [maxWrite + maxPad]byte
 }
 
+// recordPool pools the records read, as every
+// record is a buffer of maxWrite + maxPad bytes
+var recordPool = sync.Pool{
+	New: func() interface{} { return new(record) },
+}
+
+// newRecord returns a record of the pool
+func newRecord() *record {
+	return recordPool.Get().(*record)
+}
+
+// free puts the record back to the pool. The
+// record and its content must not be used after.
+func (rec *record) free() {
+	recordPool.Put(rec)
+}
+
 func (rec *record) read(r io.Reader) (err error) {
-	if err = binary.Read(r, binary.BigEndian, &rec.h); err != nil {
+	// decode the header in the buffer, as
+	// binary.Read would allocate to decode
+	if _, err = io.ReadFull(r, rec.buf[:headerLen]); err != nil {
 		return err
 	}
+	rec.h.Version = rec.buf[0]
+	rec.h.Type = recType(rec.buf[1])
+	rec.h.ID = binary.BigEndian.Uint16(rec.buf[2:])
+	rec.h.ContentLength = binary.BigEndian.Uint16(rec.buf[4:])
+	rec.h.PaddingLength = rec.buf[6]
+	rec.h.Reserved = rec.buf[7]
 	if rec.h.Version != 1 {
-		return errors.New("fcgi: invalid header version")
+		return &ProtocolError{Type: uint8(rec.h.Type), ReqID: rec.h.ID, Reason: "invalid header version"}
 	}
 	n := int(rec.h.ContentLength) + int(rec.h.PaddingLength)
 	if _, err = io.ReadFull(r, rec.buf[:n]); err != nil {
@@ -174,22 +212,40 @@ func (rec *record) content() []byte {
 	return rec.buf[:rec.h.ContentLength]
 }
 
+// encode encodes the header in b of at least headerLen bytes
+func (h *header) encode(b []byte) {
+	b[0] = h.Version
+	b[1] = byte(h.Type)
+	binary.BigEndian.PutUint16(b[2:], h.ID)
+	binary.BigEndian.PutUint16(b[4:], h.ContentLength)
+	b[6] = h.PaddingLength
+	b[7] = h.Reserved
+}
+
+// recordBuf is the buffer of a record to be sent at once
+type recordBuf [headerLen + maxWrite + maxPad]byte
+
+// recordBufPool pools the buffers of the records sent
+var recordBufPool = sync.Pool{
+	New: func() interface{} { return new(recordBuf) },
+}
+
 // writeRecord writes and sends a single record.
 func (c *conn) writeRecord(recType recType, reqID uint16, b []byte) error {
+	var h header
+	h.init(recType, reqID, len(b))
+	buf := recordBufPool.Get().(*recordBuf)
+	defer recordBufPool.Put(buf)
+	h.encode(buf[:])
+	n := headerLen + copy(buf[headerLen:], b)
+	n += copy(buf[n:], pad[:h.PaddingLength])
+
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
-	c.buf.Reset()
-	c.h.init(recType, reqID, len(b))
-	if err := binary.Write(&c.buf, binary.BigEndian, c.h); err != nil {
-		return err
-	}
-	if _, err := c.buf.Write(b); err != nil {
-		return err
-	}
-	if _, err := c.buf.Write(pad[:c.h.PaddingLength]); err != nil {
-		return err
+	if d, ok := c.rwc.(writeDeadliner); ok && c.writeTimeout > 0 {
+		d.SetWriteDeadline(time.Now().Add(c.writeTimeout))
 	}
-	_, err := c.rwc.Write(c.buf.Bytes())
+	_, err := c.rwc.Write(buf[:n])
 	return err
 }
 
@@ -199,34 +255,50 @@ func (c *conn) writeBeginRequest(reqID uint16, role Role, flags uint8) error {
 }
 
 func (c *conn) writeEndRequest(reqID uint16, appStatus int, protocolStatus uint8) error {
-	b := make([]byte, 8)
-	binary.BigEndian.PutUint32(b, uint32(appStatus))
+	var b [8]byte
+	binary.BigEndian.PutUint32(b[:], uint32(appStatus))
 	b[4] = protocolStatus
-	return c.writeRecord(typeEndRequest, reqID, b)
+	return c.writeRecord(typeEndRequest, reqID, b[:])
 }
 
 func (c *conn) writeAbortRequest(reqID uint16) error {
 	return c.writeRecord(typeAbortRequest, reqID, nil)
 }
 
+// copyStream writes the content of r, if any, as the stream of the
+// type in records of at most size bytes (maxWrite if 0), then the empty
+// record ending the stream. Only a record is buffered at a time, and
+// r is read no faster than the records are written.
+func (c *conn) copyStream(recType recType, reqID uint16, r io.Reader, size int) error {
+	w := newWriterSize(c, recType, reqID, size)
+	if r != nil {
+		if _, err := io.Copy(w, r); err != nil {
+			return err
+		}
+	}
+	return w.Close()
+}
+
 func (c *conn) writePairs(recType recType, reqID uint16, pairs map[string]string) error {
 	w := newWriter(c, recType, reqID)
-	b := make([]byte, 8)
+	b := w.sizes[:]
 	for k, v := range pairs {
 		n := encodeSize(b, uint32(len(k)))
 		n += encodeSize(b[n:], uint32(len(v)))
 		if _, err := w.Write(b[:n]); err != nil {
+			w.Close()
 			return err
 		}
 		if _, err := w.WriteString(k); err != nil {
+			w.Close()
 			return err
 		}
 		if _, err := w.WriteString(v); err != nil {
+			w.Close()
 			return err
 		}
 	}
-	w.Close()
-	return nil
+	return w.Close()
 }
 
 func readSize(s []byte) (uint32, int) {
@@ -252,6 +324,21 @@ func readString(s []byte, size uint32) string {
 	return string(s[:size])
 }
 
+// encodePairs encodes the pairs as the content of a
+// single record (e.g. FCGI_GET_VALUES)
+func encodePairs(pairs map[string]string) []byte {
+	buf := new(bytes.Buffer)
+	b := make([]byte, 8)
+	for k, v := range pairs {
+		n := encodeSize(b, uint32(len(k)))
+		n += encodeSize(b[n:], uint32(len(v)))
+		buf.Write(b[:n])
+		buf.WriteString(k)
+		buf.WriteString(v)
+	}
+	return buf.Bytes()
+}
+
 func encodeSize(b []byte, size uint32) int {
 	if size > 127 {
 		size |= 1 << 31
@@ -262,15 +349,70 @@ func encodeSize(b []byte, size uint32) int {
 	return 1
 }
 
+// errStreamClosed is the error writing a closed stream
+var errStreamClosed = errors.New("gofast: write on closed stream")
+
+// bufWriterPool pools the bufio.Writer of maxWrite
+// bytes, which are the most of the writers
+var bufWriterPool sync.Pool
+
 // bufWriter encapsulates bufio.Writer but also closes the underlying stream when
-// Closed.
+// Closed. The bufio.Writer of maxWrite bytes is put back to the pool on Close,
+// so the writer must not be used after.
 type bufWriter struct {
 	closer io.Closer
-	*bufio.Writer
+	w      *bufio.Writer
+	pooled bool
+
+	// the buffer to encode the sizes of
+	// the name-value pairs (see writePairs)
+	sizes [8]byte
+}
+
+// Write implements io.Writer
+func (w *bufWriter) Write(p []byte) (int, error) {
+	if w.w == nil {
+		return 0, errStreamClosed
+	}
+	return w.w.Write(p)
+}
+
+// WriteString implements io.StringWriter
+func (w *bufWriter) WriteString(s string) (int, error) {
+	if w.w == nil {
+		return 0, errStreamClosed
+	}
+	return w.w.WriteString(s)
+}
+
+// ReadFrom implements io.ReaderFrom, so io.Copy reads into
+// the buffer of the writer instead of an intermediate one
+func (w *bufWriter) ReadFrom(r io.Reader) (int64, error) {
+	if w.w == nil {
+		return 0, errStreamClosed
+	}
+	return w.w.ReadFrom(r)
+}
+
+// Flush writes the buffered data as a record
+func (w *bufWriter) Flush() error {
+	if w.w == nil {
+		return errStreamClosed
+	}
+	return w.w.Flush()
 }
 
 func (w *bufWriter) Close() error {
-	if err := w.Writer.Flush(); err != nil {
+	if w.w == nil {
+		return errStreamClosed
+	}
+	err := w.w.Flush()
+	if w.pooled {
+		w.w.Reset(nil)
+		bufWriterPool.Put(w.w)
+	}
+	w.w = nil
+	if err != nil {
 		w.closer.Close()
 		return err
 	}
@@ -278,9 +420,26 @@ func (w *bufWriter) Close() error {
 }
 
 func newWriter(c *conn, recType recType, reqID uint16) *bufWriter {
+	return newWriterSize(c, recType, reqID, maxWrite)
+}
+
+// newWriterSize returns a writer of the stream buffering size
+// bytes, which are also the most of a record (maxWrite if 0)
+func newWriterSize(c *conn, recType recType, reqID uint16, size int) *bufWriter {
+	if size <= 0 || size > maxWrite {
+		size = maxWrite
+	}
 	s := &streamWriter{c: c, recType: recType, reqID: reqID}
-	w := bufio.NewWriterSize(s, maxWrite)
-	return &bufWriter{s, w}
+	if size != maxWrite {
+		return &bufWriter{closer: s, w: bufio.NewWriterSize(s, size)}
+	}
+	w, _ := bufWriterPool.Get().(*bufio.Writer)
+	if w == nil {
+		w = bufio.NewWriterSize(s, maxWrite)
+	} else {
+		w.Reset(s)
+	}
+	return &bufWriter{closer: s, w: w, pooled: true}
 }
 
 // streamWriter abstracts out the separation of a stream into discrete records.
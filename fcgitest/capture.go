@@ -0,0 +1,187 @@
+package fcgitest
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/yookoala/gofast"
+)
+
+// DefaultMatchParams are the params recorded by a Recorder of no Params
+var DefaultMatchParams = []string{"REQUEST_METHOD", "REQUEST_URI"}
+
+// Recorder captures the FastCGI exchanges (i.e. the request params and
+// the response streams) on the connections to a real FastCGI application
+// (e.g. php-fpm), so they can be saved as a Fixture and replayed in tests
+// without the application.
+type Recorder struct {
+
+	// Params are the names of the request params to record, which the
+	// replay matches requests with. Params that vary between runs (e.g.
+	// REMOTE_PORT, or SCRIPT_FILENAME of a local path) should not be
+	// recorded. DefaultMatchParams if nil.
+	Params []string
+
+	mutex     sync.Mutex
+	exchanges []Exchange
+}
+
+// ConnFactory returns a gofast.ConnFactory that connects with the given
+// one, and records every exchange completed on the connections.
+func (r *Recorder) ConnFactory(connFactory gofast.ConnFactory) gofast.ConnFactory {
+	return func() (net.Conn, error) {
+		conn, err := connFactory()
+		if err != nil {
+			return nil, err
+		}
+		return &captureConn{
+			Conn:     conn,
+			recorder: r,
+			pending:  make(map[uint16]*capture),
+		}, nil
+	}
+}
+
+// Fixture returns a Fixture of the exchanges recorded so far,
+// in the order of completion.
+func (r *Recorder) Fixture() *Fixture {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	exchanges := make([]Exchange, len(r.exchanges))
+	copy(exchanges, r.exchanges)
+	return &Fixture{Exchanges: exchanges}
+}
+
+// add records an exchange of the params
+func (r *Recorder) add(params map[string]string, e Exchange) {
+	names := r.Params
+	if names == nil {
+		names = DefaultMatchParams
+	}
+	e.Params = make(map[string]string, len(names))
+	for _, name := range names {
+		if v, ok := params[name]; ok {
+			e.Params[name] = v
+		}
+	}
+	r.mutex.Lock()
+	r.exchanges = append(r.exchanges, e)
+	r.mutex.Unlock()
+}
+
+// capture is an exchange in progress
+type capture struct {
+	params   []byte
+	exchange Exchange
+}
+
+// captureConn parses the records written and read on a connection
+type captureConn struct {
+	net.Conn
+	recorder *Recorder
+
+	// the bytes of incomplete records
+	sent, received []byte
+
+	mutex   sync.Mutex
+	pending map[uint16]*capture
+}
+
+func (c *captureConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.sent = c.parse(append(c.sent, b[:n]...), c.requestRecord)
+	return n, err
+}
+
+func (c *captureConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.received = c.parse(append(c.received, b[:n]...), c.responseRecord)
+	return n, err
+}
+
+// parse handles all the complete records in b,
+// and returns the bytes of incomplete record
+func (c *captureConn) parse(b []byte, handle func(recType uint8, reqID uint16, content []byte)) []byte {
+	for len(b) >= 8 {
+		contentLength := int(binary.BigEndian.Uint16(b[4:]))
+		size := 8 + contentLength + int(b[6])
+		if len(b) < size {
+			break
+		}
+		c.mutex.Lock()
+		handle(b[1], binary.BigEndian.Uint16(b[2:]), b[8:8+contentLength])
+		c.mutex.Unlock()
+		b = b[size:]
+	}
+	return append([]byte(nil), b...)
+}
+
+func (c *captureConn) requestRecord(recType uint8, reqID uint16, content []byte) {
+	switch recType {
+	case TypeBeginRequest:
+		c.pending[reqID] = &capture{}
+	case TypeParams:
+		if p, ok := c.pending[reqID]; ok {
+			p.params = append(p.params, content...)
+		}
+	}
+}
+
+func (c *captureConn) responseRecord(recType uint8, reqID uint16, content []byte) {
+	p, ok := c.pending[reqID]
+	if !ok {
+		return
+	}
+	switch recType {
+	case TypeStdout:
+		p.exchange.Stdout = append(p.exchange.Stdout, content...)
+	case TypeStderr:
+		p.exchange.Stderr = append(p.exchange.Stderr, content...)
+	case TypeEndRequest:
+		delete(c.pending, reqID)
+		if len(content) >= 4 {
+			p.exchange.AppStatus = int(binary.BigEndian.Uint32(content))
+		}
+		params, err := decodePairs(p.params)
+		if err != nil {
+			return
+		}
+		c.recorder.add(params, p.exchange)
+	}
+}
+
+// decodePairs decodes the content of a name-value pair stream
+func decodePairs(b []byte) (map[string]string, error) {
+	pairs := make(map[string]string)
+	readSize := func() (int, error) {
+		if len(b) > 0 && b[0]&0x80 == 0 {
+			size := int(b[0])
+			b = b[1:]
+			return size, nil
+		}
+		if len(b) < 4 {
+			return 0, fmt.Errorf("fcgitest: invalid name-value pair length")
+		}
+		size := int(binary.BigEndian.Uint32(b) &^ (1 << 31))
+		b = b[4:]
+		return size, nil
+	}
+	for len(b) > 0 {
+		nameLen, err := readSize()
+		if err != nil {
+			return nil, err
+		}
+		valueLen, err := readSize()
+		if err != nil {
+			return nil, err
+		}
+		if nameLen+valueLen > len(b) {
+			return nil, fmt.Errorf("fcgitest: name-value pair exceeds content length")
+		}
+		pairs[string(b[:nameLen])] = string(b[nameLen : nameLen+valueLen])
+		b = b[nameLen+valueLen:]
+	}
+	return pairs, nil
+}
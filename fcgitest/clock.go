@@ -0,0 +1,127 @@
+package fcgitest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/yookoala/gofast"
+)
+
+// FakeClock is a gofast.Clock for tests. Its time only moves by Advance
+// or Set, so the timeout and expiry logics can be tested without real
+// sleeps.
+type FakeClock struct {
+	mutex  sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+// NewFakeClock returns a *FakeClock of the given time
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+// Now implements gofast.Clock
+func (c *FakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+// After implements gofast.Clock
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	return c.NewTimer(d).C()
+}
+
+// NewTimer implements gofast.Clock
+func (c *FakeClock) NewTimer(d time.Duration) gofast.Timer {
+	t := &fakeTimer{clock: c, c: make(chan time.Time, 1)}
+	t.Reset(d)
+	return t
+}
+
+// Advance moves the time forward by the duration,
+// and fires the timers due.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.Set(c.Now().Add(d))
+}
+
+// Set sets the time, and fires the timers due
+func (c *FakeClock) Set(now time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = now
+	c.fire()
+}
+
+// Timers returns the number of timers not yet fired or stopped.
+// Tests may wait for it to know the code under test is waiting.
+func (c *FakeClock) Timers() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.timers)
+}
+
+// WaitTimers waits until there are n timers not yet fired or stopped
+func (c *FakeClock) WaitTimers(n int) {
+	for c.Timers() < n {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// fire fires and removes the timers due. Must
+// be called with the mutex locked.
+func (c *FakeClock) fire() {
+	timers := c.timers[:0]
+	for _, t := range c.timers {
+		if t.when.After(c.now) {
+			timers = append(timers, t)
+			continue
+		}
+		select {
+		case t.c <- c.now:
+		default:
+		}
+	}
+	c.timers = timers
+}
+
+// remove removes the timer and returns true if it was
+// active. Must be called with the mutex locked.
+func (c *FakeClock) remove(t *fakeTimer) bool {
+	for i, active := range c.timers {
+		if active == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// fakeTimer is a gofast.Timer of FakeClock
+type fakeTimer struct {
+	clock *FakeClock
+	when  time.Time
+	c     chan time.Time
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mutex.Lock()
+	defer t.clock.mutex.Unlock()
+	return t.clock.remove(t)
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	c := t.clock
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	active := c.remove(t)
+	t.when = c.now.Add(d)
+	c.timers = append(c.timers, t)
+	c.fire()
+	return active
+}
@@ -0,0 +1,83 @@
+package fcgitest_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/yookoala/gofast/fcgitest"
+)
+
+// fired checks if the channel has received
+func fired(c <-chan time.Time) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := fcgitest.NewFakeClock(start)
+	if want, have := start, clock.Now(); !want.Equal(have) {
+		t.Errorf("expected %s, got %s", want, have)
+	}
+
+	after := clock.After(time.Minute)
+	timer := clock.NewTimer(2 * time.Minute)
+	if want, have := 2, clock.Timers(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	clock.Advance(59 * time.Second)
+	if fired(after) {
+		t.Errorf("unexpected fire before the duration")
+	}
+	clock.Advance(time.Second)
+	if !fired(after) {
+		t.Errorf("expected After to fire")
+	}
+	if want, have := 1, clock.Timers(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	// stop and reset
+	if want, have := true, timer.Stop(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := false, timer.Stop(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	clock.Advance(time.Hour)
+	if fired(timer.C()) {
+		t.Errorf("unexpected fire of stopped timer")
+	}
+	if want, have := false, timer.Reset(time.Second); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	clock.Set(clock.Now().Add(time.Second))
+	if !fired(timer.C()) {
+		t.Errorf("expected the timer to fire")
+	}
+
+	// timer of no duration fires at once
+	if !fired(clock.After(0)) {
+		t.Errorf("expected After(0) to fire")
+	}
+}
+
+func TestFakeClock_WaitTimers(t *testing.T) {
+	clock := fcgitest.NewFakeClock(time.Unix(0, 0))
+	done := make(chan struct{})
+	go func() {
+		<-clock.After(time.Hour)
+		close(done)
+	}()
+	clock.WaitTimers(1)
+	clock.Advance(time.Hour)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Errorf("expected the waiting goroutine to wake up")
+	}
+}
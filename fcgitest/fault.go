@@ -0,0 +1,162 @@
+package fcgitest
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/yookoala/gofast"
+)
+
+// Errors of the injected faults
+var (
+	ErrInjectedDial  = errors.New("fcgitest: connection refused (injected)")
+	ErrInjectedReset = errors.New("fcgitest: connection reset (injected)")
+)
+
+// FaultCounts are the numbers of faults injected, by kind
+type FaultCounts struct {
+	Latency      int
+	DialError    int
+	Reset        int
+	PartialWrite int
+	Corrupt      int
+}
+
+// FaultInjector injects faults into the connections of a ConnFactory,
+// at the given probabilities (0 to 1), so the resilience of the web
+// server side (e.g. retries, circuit breakers, timeouts) can be tested
+// against an unreliable FastCGI application.
+type FaultInjector struct {
+
+	// Seed of the random faults. The same seed injects the same faults
+	// for the same sequence of connection operations.
+	Seed int64
+
+	// LatencyRate is the probability of waiting for Latency
+	// before a read or write
+	Latency     time.Duration
+	LatencyRate float64
+
+	// DialErrorRate is the probability of ErrInjectedDial
+	// instead of connecting
+	DialErrorRate float64
+
+	// ResetRate is the probability of closing the connection
+	// on a read or write, which then returns ErrInjectedReset
+	ResetRate float64
+
+	// PartialWriteRate is the probability of writing only part of
+	// the bytes of a write, before the connection is reset
+	PartialWriteRate float64
+
+	// CorruptRate is the probability of flipping a random byte
+	// of the bytes read
+	CorruptRate float64
+
+	// Clock to wait for the Latency. gofast.SystemClock if nil.
+	Clock gofast.Clock
+
+	mutex  sync.Mutex
+	rand   *rand.Rand
+	counts FaultCounts
+}
+
+// ConnFactory returns a gofast.ConnFactory that connects with the
+// given one, and injects the faults into the connections.
+func (f *FaultInjector) ConnFactory(connFactory gofast.ConnFactory) gofast.ConnFactory {
+	return func() (net.Conn, error) {
+		if f.chance(f.DialErrorRate, &f.counts.DialError) {
+			return nil, ErrInjectedDial
+		}
+		conn, err := connFactory()
+		if err != nil {
+			return nil, err
+		}
+		return &faultConn{Conn: conn, faults: f}, nil
+	}
+}
+
+// Counts returns the numbers of faults injected so far
+func (f *FaultInjector) Counts() FaultCounts {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.counts
+}
+
+// chance returns true, and counts the fault, at the probability
+func (f *FaultInjector) chance(rate float64, count *int) bool {
+	if rate <= 0 {
+		return false
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.rand == nil {
+		f.rand = rand.New(rand.NewSource(f.Seed))
+	}
+	if f.rand.Float64() >= rate {
+		return false
+	}
+	*count++
+	return true
+}
+
+// intn returns a random int in [0, n)
+func (f *FaultInjector) intn(n int) int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.rand.Intn(n)
+}
+
+// delay waits for the Latency, at the LatencyRate
+func (f *FaultInjector) delay() {
+	if f.chance(f.LatencyRate, &f.counts.Latency) {
+		clock := f.Clock
+		if clock == nil {
+			clock = gofast.SystemClock
+		}
+		<-clock.After(f.Latency)
+	}
+}
+
+// faultConn is a net.Conn with faults injected
+type faultConn struct {
+	net.Conn
+	faults *FaultInjector
+}
+
+func (c *faultConn) reset() error {
+	c.Conn.Close()
+	return ErrInjectedReset
+}
+
+func (c *faultConn) Read(b []byte) (int, error) {
+	f := c.faults
+	f.delay()
+	if f.chance(f.ResetRate, &f.counts.Reset) {
+		return 0, c.reset()
+	}
+	n, err := c.Conn.Read(b)
+	if n > 0 && f.chance(f.CorruptRate, &f.counts.Corrupt) {
+		b[f.intn(n)] ^= byte(1 + f.intn(255))
+	}
+	return n, err
+}
+
+func (c *faultConn) Write(b []byte) (int, error) {
+	f := c.faults
+	f.delay()
+	if f.chance(f.ResetRate, &f.counts.Reset) {
+		return 0, c.reset()
+	}
+	if len(b) > 0 && f.chance(f.PartialWriteRate, &f.counts.PartialWrite) {
+		n, err := c.Conn.Write(b[:f.intn(len(b))])
+		if err != nil {
+			return n, err
+		}
+		return n, c.reset()
+	}
+	return c.Conn.Write(b)
+}
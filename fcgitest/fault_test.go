@@ -0,0 +1,229 @@
+package fcgitest_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/yookoala/gofast"
+	"github.com/yookoala/gofast/fcgitest"
+)
+
+// echoConnFactory connects to a peer that echoes everything
+func echoConnFactory() (net.Conn, error) {
+	appConn, webConn := net.Pipe()
+	go func() {
+		io.Copy(appConn, appConn)
+		appConn.Close()
+	}()
+	return webConn, nil
+}
+
+// discardConnFactory connects to a peer that discards everything
+func discardConnFactory() (net.Conn, error) {
+	appConn, webConn := net.Pipe()
+	go io.Copy(ioutil.Discard, appConn)
+	return webConn, nil
+}
+
+func TestFaultInjector_none(t *testing.T) {
+	s := fcgitest.NewServer(fcgitest.Reply(fcgitest.Body("hello")))
+	defer s.Close()
+
+	f := &fcgitest.FaultInjector{}
+	h := gofast.NewHandler(
+		gofast.NewFileEndpoint("/var/www/html/index.php")(gofast.BasicSession),
+		gofast.SimpleClientFactory(f.ConnFactory(s.ConnFactory())),
+	)
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		if want, have := "hello", w.Body.String(); want != have {
+			t.Errorf("expected %#v, got %#v", want, have)
+		}
+	}
+	if want, have := (fcgitest.FaultCounts{}), f.Counts(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestFaultInjector_DialError(t *testing.T) {
+	s := fcgitest.NewServer(fcgitest.Reply(fcgitest.Body("hello")))
+	defer s.Close()
+
+	f := &fcgitest.FaultInjector{DialErrorRate: 1}
+	h := gofast.NewHandler(
+		gofast.NewFileEndpoint("/var/www/html/index.php")(gofast.BasicSession),
+		gofast.SimpleClientFactory(f.ConnFactory(s.ConnFactory())),
+	)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if want, have := 502, w.Code; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := 1, f.Counts().DialError; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := 0, len(s.Requests()); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestFaultInjector_Reset(t *testing.T) {
+	f := &fcgitest.FaultInjector{ResetRate: 1}
+	conn, err := f.ConnFactory(echoConnFactory)()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err = conn.Write([]byte("hello")); err != fcgitest.ErrInjectedReset {
+		t.Errorf("expected %#v, got %#v", fcgitest.ErrInjectedReset, err)
+	}
+	if _, err = conn.Read(make([]byte, 5)); err != fcgitest.ErrInjectedReset {
+		t.Errorf("expected %#v, got %#v", fcgitest.ErrInjectedReset, err)
+	}
+	if want, have := 2, f.Counts().Reset; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestFaultInjector_PartialWrite(t *testing.T) {
+	appConn, webConn := net.Pipe()
+	received := make(chan []byte)
+	go func() {
+		b, _ := ioutil.ReadAll(appConn)
+		received <- b
+	}()
+	f := &fcgitest.FaultInjector{PartialWriteRate: 1}
+	conn, _ := f.ConnFactory(func() (net.Conn, error) {
+		return webConn, nil
+	})()
+
+	b := bytes.Repeat([]byte("x"), 100)
+	n, err := conn.Write(b)
+	if err != fcgitest.ErrInjectedReset {
+		t.Errorf("expected %#v, got %#v", fcgitest.ErrInjectedReset, err)
+	}
+	if n >= len(b) {
+		t.Errorf("expected partial write, got %d bytes written", n)
+	}
+	if want, have := n, len(<-received); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestFaultInjector_Corrupt(t *testing.T) {
+	f := &fcgitest.FaultInjector{CorruptRate: 1}
+	conn, _ := f.ConnFactory(echoConnFactory)()
+	defer conn.Close()
+
+	want := []byte("hello world")
+	go conn.Write(want)
+	have := make([]byte, len(want))
+	if _, err := io.ReadFull(conn, have); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	diff := 0
+	for i := range want {
+		if want[i] != have[i] {
+			diff++
+		}
+	}
+	if diff == 0 {
+		t.Errorf("expected corrupted bytes, got %#v", string(have))
+	}
+	if diff > f.Counts().Corrupt {
+		t.Errorf("expected at most 1 byte corrupted per read, got %d bytes of %d reads", diff, f.Counts().Corrupt)
+	}
+}
+
+func TestFaultInjector_Latency(t *testing.T) {
+	clock := fcgitest.NewFakeClock(time.Unix(0, 0))
+	f := &fcgitest.FaultInjector{Latency: time.Hour, LatencyRate: 1, Clock: clock}
+	conn, _ := f.ConnFactory(discardConnFactory)()
+	defer conn.Close()
+
+	done := make(chan error)
+	go func() {
+		_, err := conn.Write([]byte("hello"))
+		done <- err
+	}()
+	clock.WaitTimers(1)
+	select {
+	case <-done:
+		t.Fatalf("expected the write to wait for the latency")
+	case <-time.After(10 * time.Millisecond):
+	}
+	clock.Advance(time.Hour)
+	if err := <-done; err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if want, have := 1, f.Counts().Latency; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestFaultInjector_Seed(t *testing.T) {
+	faults := func() []bool {
+		f := &fcgitest.FaultInjector{Seed: 42, ResetRate: 0.5}
+		results := make([]bool, 0, 50)
+		for i := 0; i < 50; i++ {
+			conn, _ := f.ConnFactory(discardConnFactory)()
+			_, err := conn.Write([]byte("hello"))
+			results = append(results, err == fcgitest.ErrInjectedReset)
+			conn.Close()
+		}
+		return results
+	}
+	first, second := faults(), faults()
+	resets := 0
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("expected the same faults of the same seed")
+		}
+		if first[i] {
+			resets++
+		}
+	}
+	if resets == 0 || resets == len(first) {
+		t.Errorf("expected some of the writes reset, got %d of %d", resets, len(first))
+	}
+}
+
+func TestFaultInjector_chaos(t *testing.T) {
+	s := fcgitest.NewServer(fcgitest.Reply(fcgitest.Body("hello")))
+	defer s.Close()
+
+	// the handler always responds within the request deadline, whatever
+	// the faults. A corrupted record header (e.g. content length) may
+	// leave the client waiting for bytes that never come, until timeout.
+	f := &fcgitest.FaultInjector{
+		Seed:             1,
+		ResetRate:        0.05,
+		PartialWriteRate: 0.05,
+		CorruptRate:      0.05,
+	}
+	h := gofast.NewHandler(
+		gofast.NewFileEndpoint("/var/www/html/index.php")(gofast.BasicSession),
+		gofast.SimpleClientFactory(f.ConnFactory(s.ConnFactory())),
+	)
+	for i := 0; i < 50; i++ {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+			r := httptest.NewRequest("GET", "/", nil).WithContext(ctx)
+			h.ServeHTTP(httptest.NewRecorder(), r)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("request %d hangs with faults %#v", i, f.Counts())
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package fcgitest
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"unicode/utf8"
+
+	"github.com/yookoala/gofast"
+)
+
+// Data is the content of a stream in a Fixture. It is encoded in JSON
+// as a string if it is valid UTF-8, or as {"base64": "..."} if not.
+type Data []byte
+
+// MarshalJSON implements json.Marshaler
+func (d Data) MarshalJSON() ([]byte, error) {
+	if utf8.Valid(d) {
+		return json.Marshal(string(d))
+	}
+	return json.Marshal(map[string]string{"base64": base64.StdEncoding.EncodeToString(d)})
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (d *Data) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*d = Data(s)
+		return nil
+	}
+	var encoded struct {
+		Base64 string `json:"base64"`
+	}
+	if err := json.Unmarshal(b, &encoded); err != nil {
+		return fmt.Errorf("fcgitest: data is neither a string nor {\"base64\": ...}")
+	}
+	decoded, err := base64.StdEncoding.DecodeString(encoded.Base64)
+	if err != nil {
+		return fmt.Errorf("fcgitest: invalid base64 data: %s", err)
+	}
+	*d = Data(decoded)
+	return nil
+}
+
+// Exchange is a recorded FastCGI exchange
+type Exchange struct {
+
+	// Params to match the requests with. A request matches if
+	// it has all the params, of the same values.
+	Params map[string]string `json:"params"`
+
+	// Stdout is the FCGI_STDOUT stream of the response,
+	// i.e. the CGI header and the body.
+	Stdout Data `json:"stdout"`
+
+	// Stderr is the FCGI_STDERR stream of the response
+	Stderr Data `json:"stderr,omitempty"`
+
+	// AppStatus of the FCGI_END_REQUEST
+	AppStatus int `json:"app_status,omitempty"`
+}
+
+// matches checks if the params of the request match the exchange
+func (e *Exchange) matches(params map[string]string) bool {
+	for k, v := range e.Params {
+		if have, ok := params[k]; !ok || have != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Fixture is a set of recorded exchanges, usually recorded with a
+// Recorder and stored in a JSON file of the tests (e.g. testdata/).
+type Fixture struct {
+	Exchanges []Exchange `json:"exchanges"`
+}
+
+// LoadFixture loads a Fixture from the JSON file
+func LoadFixture(filename string) (*Fixture, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	f := &Fixture{}
+	if err = json.Unmarshal(b, f); err != nil {
+		return nil, fmt.Errorf("fcgitest: invalid fixture %s: %s", filename, err)
+	}
+	return f, nil
+}
+
+// Save saves the Fixture to the JSON file
+func (f *Fixture) Save(filename string) error {
+	b, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, append(b, '\n'), 0644)
+}
+
+// Match returns the first exchange which matches the params,
+// or nil if there is none.
+func (f *Fixture) Match(params map[string]string) *Exchange {
+	for i := range f.Exchanges {
+		if f.Exchanges[i].matches(params) {
+			return &f.Exchanges[i]
+		}
+	}
+	return nil
+}
+
+// Replay returns a gofast.ServerHandler which responds every request
+// with the first matching exchange of the fixture (see Fixture.Match).
+// A request of no matching exchange is responded with
+// "500 Internal Server Error", and the reason in FCGI_STDERR.
+func Replay(f *Fixture) gofast.ServerHandler {
+	return func(ctx context.Context, req *gofast.Request, stdout, stderr io.Writer) int {
+		e := f.Match(req.Params)
+		if e == nil {
+			fmt.Fprintf(stderr, "fcgitest: no recorded exchange matches %s %s",
+				req.Params["REQUEST_METHOD"], req.Params["REQUEST_URI"])
+			io.WriteString(stdout, "Status: 500 Internal Server Error\r\n"+
+				"Content-Type: text/plain; charset=utf-8\r\n\r\n"+
+				"no recorded exchange matches the request\n")
+			return 1
+		}
+		stdout.Write(e.Stdout)
+		stderr.Write(e.Stderr)
+		return e.AppStatus
+	}
+}
@@ -0,0 +1,172 @@
+package fcgitest_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/yookoala/gofast"
+	"github.com/yookoala/gofast/fcgitest"
+)
+
+func TestRecorder(t *testing.T) {
+	app := fcgitest.NewServer(fcgitest.Reply(
+		fcgitest.Status(201),
+		fcgitest.Header("X-App", "php"),
+		fcgitest.Stderr("some notice"),
+		fcgitest.Body("hello "),
+		fcgitest.Body(strings.Repeat("world", 20000)),
+		fcgitest.AppStatus(3),
+	))
+	defer app.Close()
+
+	recorder := &fcgitest.Recorder{}
+	h := gofast.NewHandler(
+		gofast.NewFileEndpoint("/var/www/html/index.php")(gofast.BasicSession),
+		gofast.SimpleClientFactory(recorder.ConnFactory(app.ConnFactory())),
+	)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/hello?a=b", nil))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/world", strings.NewReader("a=b")))
+
+	f := recorder.Fixture()
+	if want, have := 2, len(f.Exchanges); want != have {
+		t.Fatalf("expected %#v, got %#v", want, have)
+	}
+	if want, have := map[string]string{"REQUEST_METHOD": "GET", "REQUEST_URI": "/hello?a=b"}, f.Exchanges[0].Params; !reflect.DeepEqual(want, have) {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := map[string]string{"REQUEST_METHOD": "POST", "REQUEST_URI": "/world"}, f.Exchanges[1].Params; !reflect.DeepEqual(want, have) {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	e := f.Exchanges[0]
+	if want, have := "Status: 201 Created\r\n", string(e.Stdout); !strings.HasPrefix(have, want) {
+		t.Errorf("expected prefix %#v, got %#v", want, have)
+	}
+	if want, have := "\r\n\r\nhello "+strings.Repeat("world", 20000), string(e.Stdout); !strings.HasSuffix(have, want) {
+		t.Errorf("expected the whole body in stdout")
+	}
+	if want, have := "some notice", string(e.Stderr); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := 3, e.AppStatus; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestRecorder_Params(t *testing.T) {
+	app := fcgitest.NewServer(fcgitest.Reply(fcgitest.Body("hello")))
+	defer app.Close()
+
+	recorder := &fcgitest.Recorder{Params: []string{"DOCUMENT_URI", "NO_SUCH_PARAM"}}
+	h := gofast.NewHandler(
+		gofast.NewFileEndpoint("/var/www/html/index.php")(gofast.BasicSession),
+		gofast.SimpleClientFactory(recorder.ConnFactory(app.ConnFactory())),
+	)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/hello?a=b", nil))
+
+	f := recorder.Fixture()
+	if want, have := 1, len(f.Exchanges); want != have {
+		t.Fatalf("expected %#v, got %#v", want, have)
+	}
+	if want, have := map[string]string{"DOCUMENT_URI": "/hello"}, f.Exchanges[0].Params; !reflect.DeepEqual(want, have) {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	f := &fcgitest.Fixture{Exchanges: []fcgitest.Exchange{
+		{
+			Params: map[string]string{"REQUEST_METHOD": "POST", "REQUEST_URI": "/login"},
+			Stdout: fcgitest.Data("Status: 302 Found\r\nLocation: /home\r\n\r\n"),
+		},
+		{
+			Params: map[string]string{"REQUEST_URI": "/login"},
+			Stdout: fcgitest.Data("Content-Type: text/html\r\n\r\n<form></form>"),
+			Stderr: fcgitest.Data("PHP Notice: something"),
+		},
+	}}
+
+	// round trip of the fixture file
+	dir, err := ioutil.TempDir("", "fcgitest-")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "fixture.json")
+	if err = f.Save(filename); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	loaded, err := fcgitest.LoadFixture(filename)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(f, loaded) {
+		t.Errorf("expected %#v, got %#v", f, loaded)
+	}
+
+	s := fcgitest.NewServer(fcgitest.Replay(loaded))
+	defer s.Close()
+	h := gofast.NewHandler(
+		gofast.NewFileEndpoint("/var/www/html/index.php")(gofast.BasicSession),
+		s.ClientFactory(),
+	)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/login", strings.NewReader("user=foo")))
+	if want, have := 302, w.Code; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "/home", w.Header().Get("Location"); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/login", nil))
+	if want, have := 200, w.Code; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "<form></form>", w.Body.String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/nowhere", nil))
+	if want, have := 500, w.Code; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestData(t *testing.T) {
+	for _, d := range []fcgitest.Data{
+		fcgitest.Data("Content-Type: text/plain\r\n\r\nhello"),
+		fcgitest.Data("\x89PNG\r\n\x1a\n\x00\xff"),
+		fcgitest.Data(""),
+	} {
+		b, err := json.Marshal(d)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		var have fcgitest.Data
+		if err = json.Unmarshal(b, &have); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !bytes.Equal(d, have) {
+			t.Errorf("expected %#v, got %#v", d, have)
+		}
+	}
+
+	b, _ := json.Marshal(fcgitest.Data("\xff"))
+	if want, have := `{"base64":"/w=="}`, string(b); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	var d fcgitest.Data
+	if err := json.Unmarshal([]byte(`42`), &d); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+}
@@ -0,0 +1,113 @@
+package fcgitest
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/yookoala/gofast"
+)
+
+// CloseOnGetValues returns a gofast.ConnFactory that connects with the
+// given one, of which the connections behave like the ones of php-fpm
+// on FCGI_GET_VALUES: it is answered with FCGI_MPXS_CONNS of 0 (if
+// asked), then the connection is closed by the application. The
+// records before are passed on as is.
+//
+// The web server side code querying the limits of the application (e.g.
+// ClientPool.HealthCheck) can so be tested not to use the connection
+// of the query for requests.
+func CloseOnGetValues(connFactory gofast.ConnFactory) gofast.ConnFactory {
+	return func() (net.Conn, error) {
+		conn, err := connFactory()
+		if err != nil {
+			return nil, err
+		}
+		return &getValuesConn{Conn: conn}, nil
+	}
+}
+
+// getValuesConn is a net.Conn closed after FCGI_GET_VALUES
+type getValuesConn struct {
+	net.Conn
+
+	// the bytes of incomplete record written
+	sent []byte
+
+	mutex  sync.Mutex
+	answer []byte
+	closed bool
+}
+
+func (c *getValuesConn) Write(b []byte) (int, error) {
+	if c.isClosed() {
+		return 0, io.ErrClosedPipe
+	}
+	c.sent = append(c.sent, b...)
+	for len(c.sent) >= 8 {
+		contentLength := int(binary.BigEndian.Uint16(c.sent[4:]))
+		size := 8 + contentLength + int(c.sent[6])
+		if len(c.sent) < size {
+			break
+		}
+		rec := c.sent[:size]
+		c.sent = c.sent[size:]
+		if rec[1] != TypeGetValues {
+			if _, err := c.Conn.Write(rec); err != nil {
+				return 0, err
+			}
+			continue
+		}
+
+		// answer, then close the connection
+		var content []byte
+		if query, err := decodePairs(rec[8 : 8+contentLength]); err == nil {
+			if _, ok := query["FCGI_MPXS_CONNS"]; ok {
+				content = []byte("\x0f\x01FCGI_MPXS_CONNS0")
+			}
+		}
+		c.mutex.Lock()
+		c.answer, c.closed = Record(TypeGetValuesResult, 0, content), true
+		c.mutex.Unlock()
+		c.Conn.Close()
+		break
+	}
+	return len(b), nil
+}
+
+func (c *getValuesConn) Read(b []byte) (int, error) {
+	if !c.isClosed() {
+		n, err := c.Conn.Read(b)
+		if err == nil || !c.isClosed() {
+			return n, err
+		}
+	}
+
+	// the answer, then the end of the connection
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if len(c.answer) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(b, c.answer)
+	c.answer = c.answer[n:]
+	return n, nil
+}
+
+func (c *getValuesConn) isClosed() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.closed
+}
+
+// SetReadDeadline implements net.Conn. The deadline is of no use after
+// the connection is closed by the application, as the answer is read
+// without waiting.
+func (c *getValuesConn) SetReadDeadline(t time.Time) error {
+	if c.isClosed() {
+		return nil
+	}
+	return c.Conn.SetReadDeadline(t)
+}
@@ -0,0 +1,49 @@
+package fcgitest_test
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/yookoala/gofast"
+	"github.com/yookoala/gofast/fcgitest"
+)
+
+func TestCloseOnGetValues(t *testing.T) {
+	s := fcgitest.NewServer(fcgitest.Reply(fcgitest.Body("hello")))
+	defer s.Close()
+	clientFactory := gofast.SimpleClientFactory(fcgitest.CloseOnGetValues(s.ConnFactory()))
+
+	// the requests are passed on
+	c, err := clientFactory()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer c.Close()
+	resp, err := c.Do(gofast.NewRequest(nil))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	w := httptest.NewRecorder()
+	resp.WriteTo(w, ioutil.Discard)
+	if want, have := "hello", w.Body.String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	// FCGI_GET_VALUES is answered, then the connection closed
+	limits, err := c.(gofast.LimitsReporter).BackendLimits()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want, have := (gofast.BackendLimits{}), limits; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	resp, err = c.Do(gofast.NewRequest(nil))
+	if err == nil {
+		resp.WriteTo(httptest.NewRecorder(), ioutil.Discard)
+		err = resp.Err()
+	}
+	if err == nil {
+		t.Errorf("expected error, got nil")
+	}
+}
@@ -0,0 +1,138 @@
+package fcgitest
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"unicode"
+)
+
+// UpdateGoldenEnv is the environment variable to update the golden
+// files instead of comparing with them, e.g.
+//
+//	TEST_UPDATE_GOLDEN=1 go test ./...
+//
+// Review the changes of the golden files before commit.
+const UpdateGoldenEnv = "TEST_UPDATE_GOLDEN"
+
+// Golden compares the output with the content of the golden file, and
+// reports the lines of difference as an error of the test. If
+// $TEST_UPDATE_GOLDEN is set, it writes the output to the golden file
+// instead, creating the folders if needed.
+func Golden(t testing.TB, filename string, have []byte) {
+	t.Helper()
+	if os.Getenv(UpdateGoldenEnv) != "" {
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatalf("fcgitest: failed to update golden file: %s", err)
+		}
+		if err := ioutil.WriteFile(filename, have, 0644); err != nil {
+			t.Fatalf("fcgitest: failed to update golden file: %s", err)
+		}
+		return
+	}
+	want, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		t.Errorf("fcgitest: golden file %s not found, run the tests with %s=1 to create it",
+			filename, UpdateGoldenEnv)
+		return
+	} else if err != nil {
+		t.Fatalf("fcgitest: failed to read golden file: %s", err)
+	}
+	if !bytes.Equal(want, have) {
+		t.Errorf("fcgitest: output differs from golden file %s (run the tests with %s=1 to update it):\n%s",
+			filename, UpdateGoldenEnv, diffLines(string(want), string(have)))
+	}
+}
+
+// GoldenParams compares the params with the golden file
+// in the format of FormatParams. See Golden.
+func GoldenParams(t testing.TB, filename string, params map[string]string) {
+	t.Helper()
+	Golden(t, filename, FormatParams(params))
+}
+
+// FormatParams formats the params in a canonical form for golden
+// files: a line of "NAME=value" for each param, sorted by name. The
+// values with line breaks, control characters, or leading or trailing
+// spaces are quoted as Go strings, so one param is always one line.
+func FormatParams(params map[string]string) []byte {
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	buf := new(bytes.Buffer)
+	for _, name := range names {
+		fmt.Fprintf(buf, "%s=%s\n", name, formatValue(params[name]))
+	}
+	return buf.Bytes()
+}
+
+// formatValue quotes the value if it would not stay
+// the same on a line of its own
+func formatValue(v string) string {
+	if strings.TrimSpace(v) != v || strings.HasPrefix(v, `"`) {
+		return strconv.Quote(v)
+	}
+	for _, r := range v {
+		if !unicode.IsPrint(r) {
+			return strconv.Quote(v)
+		}
+	}
+	return v
+}
+
+// diffLines returns the lines removed from want ("-") and
+// added in have ("+"), between the common lines
+func diffLines(want, have string) string {
+	a, b := strings.SplitAfter(want, "\n"), strings.SplitAfter(have, "\n")
+
+	// lengths of the longest common subsequences of the suffixes
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	line := func(prefix, s string) {
+		if s == "" {
+			return
+		}
+		fmt.Fprintf(buf, "%s %s", prefix, s)
+		if !strings.HasSuffix(s, "\n") {
+			fmt.Fprintf(buf, "\n\\ no newline at end\n")
+		}
+	}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			line(" ", a[i])
+			i, j = i+1, j+1
+		case j >= len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			line("-", a[i])
+			i++
+		default:
+			line("+", b[j])
+			j++
+		}
+	}
+	return buf.String()
+}
@@ -0,0 +1,94 @@
+package fcgitest_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yookoala/gofast/fcgitest"
+)
+
+// recorderTB records the errors of a test
+type recorderTB struct {
+	testing.TB
+	errors []string
+}
+
+func (t *recorderTB) Helper() {}
+
+func (t *recorderTB) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func (t *recorderTB) Fatalf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func TestFormatParams(t *testing.T) {
+	have := string(fcgitest.FormatParams(map[string]string{
+		"SCRIPT_NAME":  "/index.php",
+		"HTTP_X_EMPTY": "",
+		"HTTP_X_LINES": "a\nb",
+		"HTTP_X_SPACE": " padded",
+		"HTTP_X_QUOTE": `"quoted"`,
+		"HTTP_X_UTF8":  "café",
+	}))
+	want := `HTTP_X_EMPTY=
+HTTP_X_LINES="a\nb"
+HTTP_X_QUOTE="\"quoted\""
+HTTP_X_SPACE=" padded"
+HTTP_X_UTF8=café
+SCRIPT_NAME=/index.php
+`
+	if want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestGolden(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fcgitest-golden-")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "golden", "params.txt")
+
+	// missing golden file
+	rt := &recorderTB{TB: t}
+	fcgitest.GoldenParams(rt, filename, map[string]string{"A": "1"})
+	if want, have := 1, len(rt.errors); want != have {
+		t.Fatalf("expected %#v, got %#v", want, have)
+	}
+	if !strings.Contains(rt.errors[0], "TEST_UPDATE_GOLDEN=1") {
+		t.Errorf("expected the error to tell how to create the file, got %#v", rt.errors[0])
+	}
+
+	// update
+	os.Setenv(fcgitest.UpdateGoldenEnv, "1")
+	fcgitest.GoldenParams(t, filename, map[string]string{"A": "1", "B": "2", "C": "3"})
+	os.Unsetenv(fcgitest.UpdateGoldenEnv)
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want, have := "A=1\nB=2\nC=3\n", string(b); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	// same
+	fcgitest.GoldenParams(t, filename, map[string]string{"C": "3", "B": "2", "A": "1"})
+
+	// different
+	rt = &recorderTB{TB: t}
+	fcgitest.GoldenParams(rt, filename, map[string]string{"A": "1", "B": "two", "C": "3", "D": "4"})
+	if want, have := 1, len(rt.errors); want != have {
+		t.Fatalf("expected %#v, got %#v", want, have)
+	}
+	diff := rt.errors[0][strings.Index(rt.errors[0], ":\n")+2:]
+	if want, have := "  A=1\n- B=2\n+ B=two\n  C=3\n+ D=4\n", diff; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
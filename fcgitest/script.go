@@ -0,0 +1,215 @@
+package fcgitest
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/yookoala/gofast"
+)
+
+// ResponseWriter writes the scripted response of a request
+type ResponseWriter struct {
+	Stdout io.Writer
+	Stderr io.Writer
+
+	ctx         context.Context
+	header      http.Header
+	status      int
+	wroteHeader bool
+	appStatus   int
+}
+
+// writeHeader writes the CGI header lines, if not yet written
+func (w *ResponseWriter) writeHeader() error {
+	if w.wroteHeader {
+		return nil
+	}
+	w.wroteHeader = true
+	if w.status != 0 {
+		if _, err := fmt.Fprintf(w.Stdout, "Status: %d %s\r\n", w.status, http.StatusText(w.status)); err != nil {
+			return err
+		}
+	}
+	if w.header.Get("Content-Type") == "" {
+		w.header.Set("Content-Type", "text/html; charset=utf-8")
+	}
+	if err := w.header.Write(w.Stdout); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w.Stdout, "\r\n")
+	return err
+}
+
+// flush sends the buffered content of the streams, if any
+func (w *ResponseWriter) flush() error {
+	for _, s := range []io.Writer{w.Stdout, w.Stderr} {
+		if f, ok := s.(interface {
+			Flush() error
+		}); ok {
+			if err := f.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Step is a step of scripted response
+type Step func(w *ResponseWriter) error
+
+// Reply returns a gofast.ServerHandler which responds every request with
+// the steps in order. The steps stop on the first error. The CGI header
+// is written before the first Body step, or at the end.
+func Reply(steps ...Step) gofast.ServerHandler {
+	return func(ctx context.Context, req *gofast.Request, stdout, stderr io.Writer) int {
+		w := &ResponseWriter{
+			Stdout: stdout,
+			Stderr: stderr,
+			ctx:    ctx,
+			header: make(http.Header),
+		}
+		for _, step := range steps {
+			if err := step(w); err != nil {
+				return w.appStatus
+			}
+		}
+		w.writeHeader()
+		return w.appStatus
+	}
+}
+
+// Status sets the HTTP status code of the response
+func Status(code int) Step {
+	return func(w *ResponseWriter) error {
+		w.status = code
+		return nil
+	}
+}
+
+// Header adds the header field to the response
+func Header(key, value string) Step {
+	return func(w *ResponseWriter) error {
+		w.header.Add(key, value)
+		return nil
+	}
+}
+
+// Body writes a chunk of the response body. Every Body
+// step is flushed in its own FCGI_STDOUT record(s).
+func Body(chunk string) Step {
+	return func(w *ResponseWriter) error {
+		if err := w.writeHeader(); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w.Stdout, chunk); err != nil {
+			return err
+		}
+		return w.flush()
+	}
+}
+
+// Stderr writes the message to the FCGI_STDERR stream
+func Stderr(message string) Step {
+	return func(w *ResponseWriter) error {
+		if _, err := io.WriteString(w.Stderr, message); err != nil {
+			return err
+		}
+		return w.flush()
+	}
+}
+
+// Delay pauses the response for the duration. Stops the
+// response if the request is aborted in the meantime.
+func Delay(d time.Duration) Step {
+	return func(w *ResponseWriter) error {
+		select {
+		case <-time.After(d):
+			return nil
+		case <-w.ctx.Done():
+			return w.ctx.Err()
+		}
+	}
+}
+
+// AppStatus sets the application status reported in FCGI_END_REQUEST
+func AppStatus(appStatus int) Step {
+	return func(w *ResponseWriter) error {
+		w.appStatus = appStatus
+		return nil
+	}
+}
+
+// RawHeader writes raw bytes to the FCGI_STDOUT stream as the header,
+// instead of the one composed by Status and Header steps (e.g. to send
+// malformed CGI header).
+func RawHeader(b []byte) Step {
+	return func(w *ResponseWriter) error {
+		w.wroteHeader = true
+		if _, err := w.Stdout.Write(b); err != nil {
+			return err
+		}
+		return w.flush()
+	}
+}
+
+// Raw writes the bytes to the connection as is, bypassing the FastCGI
+// record framing. Use Record to compose records, or write garbage to
+// simulate protocol errors. Only works with Server of this package.
+func Raw(b []byte) Step {
+	return func(w *ResponseWriter) error {
+		conn, ok := w.ctx.Value(ctxKeyConn).(io.Writer)
+		if !ok {
+			return fmt.Errorf("fcgitest: no connection in context")
+		}
+		if err := w.flush(); err != nil {
+			return err
+		}
+		_, err := conn.Write(b)
+		return err
+	}
+}
+
+// CloseConn closes the connection abruptly, without ending the
+// response. The steps after it are skipped. Only works with Server
+// of this package.
+func CloseConn() Step {
+	return func(w *ResponseWriter) error {
+		conn, ok := w.ctx.Value(ctxKeyConn).(io.Closer)
+		if !ok {
+			return fmt.Errorf("fcgitest: no connection in context")
+		}
+		conn.Close()
+		return fmt.Errorf("fcgitest: connection closed")
+	}
+}
+
+// Record types of FastCGI, for composing raw records with Record.
+const (
+	TypeBeginRequest    uint8 = 1
+	TypeAbortRequest    uint8 = 2
+	TypeEndRequest      uint8 = 3
+	TypeParams          uint8 = 4
+	TypeStdin           uint8 = 5
+	TypeStdout          uint8 = 6
+	TypeStderr          uint8 = 7
+	TypeData            uint8 = 8
+	TypeGetValues       uint8 = 9
+	TypeGetValuesResult uint8 = 10
+	TypeUnknownType     uint8 = 11
+)
+
+// Record encodes a FastCGI record of the given type, request id and
+// content (at most 65535 bytes), without padding.
+func Record(recType uint8, reqID uint16, content []byte) []byte {
+	b := make([]byte, 8+len(content))
+	b[0] = 1 // version
+	b[1] = recType
+	binary.BigEndian.PutUint16(b[2:], reqID)
+	binary.BigEndian.PutUint16(b[4:], uint16(len(content)))
+	copy(b[8:], content)
+	return b
+}
@@ -0,0 +1,87 @@
+package fcgitest_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/yookoala/gofast"
+	"github.com/yookoala/gofast/fcgitest"
+)
+
+func serve(s *fcgitest.Server) (w *httptest.ResponseRecorder, stderr string, err error) {
+	c, err := s.ClientFactory()()
+	if err != nil {
+		return
+	}
+	defer c.Close()
+	resp, err := c.Do(gofast.NewRequest(httptest.NewRequest("GET", "/", nil)))
+	if err != nil {
+		return
+	}
+	w = httptest.NewRecorder()
+	ew := new(bytes.Buffer)
+	err = resp.WriteTo(w, ew)
+	return w, ew.String(), err
+}
+
+func TestReply(t *testing.T) {
+	s := fcgitest.NewServer(fcgitest.Reply(
+		fcgitest.Status(http.StatusCreated),
+		fcgitest.Header("Content-Type", "text/plain"),
+		fcgitest.Header("X-Foo", "bar"),
+		fcgitest.Body("hello "),
+		fcgitest.Delay(time.Millisecond),
+		fcgitest.Stderr("some warning"),
+		fcgitest.Body("world"),
+	))
+	defer s.Close()
+
+	w, stderr, err := serve(s)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want, have := http.StatusCreated, w.Code; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "text/plain", w.Header().Get("Content-Type"); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "bar", w.Header().Get("X-Foo"); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "hello world", w.Body.String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "some warning", stderr; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestReply_Raw(t *testing.T) {
+	// a record of unexpected type in the response
+	s := fcgitest.NewServer(fcgitest.Reply(
+		fcgitest.Body("hello"),
+		fcgitest.Raw(fcgitest.Record(fcgitest.TypeGetValuesResult, 1, nil)),
+	))
+	defer s.Close()
+	w, stderr, err := serve(s)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want, have := "hello", w.Body.String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "unexpected type FCGI_GET_VALUES_RESULT in readLoop", stderr; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestRecord(t *testing.T) {
+	want := []byte{1, 6, 0, 1, 0, 2, 0, 0, 'h', 'i'}
+	if have := fcgitest.Record(fcgitest.TypeStdout, 1, []byte("hi")); !bytes.Equal(want, have) {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
@@ -0,0 +1,196 @@
+// Package fcgitest provides utilities for testing FastCGI web server
+// side code (e.g. Middleware, SessionHandler) against a mock FastCGI
+// application, in the way net/http/httptest does for HTTP.
+//
+// A Server speaks FastCGI over in-memory net.Pipe (NewServer) or a unix
+// socket in a temporary folder (NewUnixServer). Responses can be scripted
+// with Reply, and the requests received are recorded for assertions.
+//
+// Exchanges with a real FastCGI application (e.g. php-fpm) can be
+// captured with a Recorder, saved as a Fixture file, then replayed by a
+// Server with Replay. Regression tests of real-world applications can so
+// run without the application:
+//
+//	// record once, against php-fpm
+//	recorder := &fcgitest.Recorder{}
+//	connFactory := recorder.ConnFactory(gofast.SimpleConnFactory("tcp", "127.0.0.1:9000"))
+//	// ... serve the test requests with clients of connFactory
+//	recorder.Fixture().Save("testdata/app.json")
+//
+//	// replay in tests
+//	f, err := fcgitest.LoadFixture("testdata/app.json")
+//	s := fcgitest.NewServer(fcgitest.Replay(f))
+//	defer s.Close()
+//
+// A FaultInjector wraps a ConnFactory to inject latency, connection
+// resets, partial writes and byte corruption at random, for testing
+// the resilience of the web server side code. CloseOnGetValues wraps
+// a ConnFactory to close the connections after FCGI_GET_VALUES, as
+// php-fpm does. FakeClock is a gofast.Clock of which the time only
+// moves as the tests say.
+//
+// Golden compares the output of a test (e.g. the params of FormatParams)
+// with a golden file, so the changes of the output are reviewed in the
+// diff of the file. Run the tests with TEST_UPDATE_GOLDEN=1 to update
+// the golden files.
+package fcgitest
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/yookoala/gofast"
+)
+
+type ctxKey int
+
+const ctxKeyConn ctxKey = iota
+
+// Request is a request received by the Server
+type Request struct {
+	ID     int
+	Role   gofast.Role
+	Params map[string]string
+	Stdin  []byte
+	Data   []byte
+}
+
+// Server is a mock FastCGI application for tests
+type Server struct {
+
+	// Network and Address of the listener. Empty
+	// if the server runs on net.Pipe.
+	Network string
+	Address string
+
+	server   *gofast.Server
+	listener net.Listener
+	dir      string
+
+	mutex    sync.Mutex
+	requests []*Request
+}
+
+// NewServer returns a *Server which every connection is one end of
+// a net.Pipe. The handler serves all requests, and may be created with
+// Reply. Call ConnFactory or ClientFactory to connect to it.
+func NewServer(handler gofast.ServerHandler) *Server {
+	s := &Server{}
+	s.server = gofast.NewServer(s.record(handler))
+	s.server.ConnContext = func(ctx context.Context, rwc io.ReadWriteCloser) context.Context {
+		return context.WithValue(ctx, ctxKeyConn, rwc)
+	}
+	return s
+}
+
+// NewUnixServer returns a *Server listening to a unix socket
+// in a temporary folder. See NewServer.
+func NewUnixServer(handler gofast.ServerHandler) *Server {
+	s := NewServer(handler)
+	dir, err := ioutil.TempDir("", "fcgitest-")
+	if err != nil {
+		panic("fcgitest: failed to create temporary folder: " + err.Error())
+	}
+	s.dir = dir
+	s.Network, s.Address = "unix", filepath.Join(dir, "fcgi.sock")
+	if s.listener, err = net.Listen(s.Network, s.Address); err != nil {
+		os.RemoveAll(dir)
+		panic("fcgitest: failed to listen on " + s.Address + ": " + err.Error())
+	}
+	go s.server.Serve(s.listener)
+	return s
+}
+
+// record wraps the handler to record the requests
+func (s *Server) record(handler gofast.ServerHandler) gofast.ServerHandler {
+	return func(ctx context.Context, req *gofast.Request, stdout, stderr io.Writer) int {
+		r := &Request{
+			Role:   req.Role,
+			Params: make(map[string]string, len(req.Params)),
+		}
+		for k, v := range req.Params {
+			r.Params[k] = v
+		}
+		s.mutex.Lock()
+		s.requests = append(s.requests, r)
+		r.ID = len(s.requests)
+		s.mutex.Unlock()
+
+		// record the streams as the handler reads
+		stdin, data := new(bytes.Buffer), new(bytes.Buffer)
+		stdinReader := io.TeeReader(req.Stdin, stdin)
+		req.Stdin = ioutil.NopCloser(stdinReader)
+		var dataReader io.Reader
+		if req.Data != nil {
+			dataReader = io.TeeReader(req.Data, data)
+			req.Data = ioutil.NopCloser(dataReader)
+		}
+
+		appStatus := handler(ctx, req, stdout, stderr)
+
+		// record the rest of the streams
+		io.Copy(ioutil.Discard, stdinReader)
+		if dataReader != nil {
+			io.Copy(ioutil.Discard, dataReader)
+		}
+		s.mutex.Lock()
+		r.Stdin, r.Data = stdin.Bytes(), data.Bytes()
+		s.mutex.Unlock()
+		return appStatus
+	}
+}
+
+// ConnFactory returns a gofast.ConnFactory that connects to the Server
+func (s *Server) ConnFactory() gofast.ConnFactory {
+	if s.listener != nil {
+		return gofast.SimpleConnFactory(s.Network, s.Address)
+	}
+	return func() (net.Conn, error) {
+		appConn, webConn := net.Pipe()
+		go s.server.ServeConn(appConn)
+		return webConn, nil
+	}
+}
+
+// ClientFactory returns a gofast.ClientFactory that connects to the
+// Server. Every client has its own connection.
+func (s *Server) ClientFactory() gofast.ClientFactory {
+	return gofast.SimpleClientFactory(s.ConnFactory())
+}
+
+// Requests returns all the requests received, in the order of arrival.
+// Request content (i.e. Stdin and Data) is only available after the
+// response is sent.
+func (s *Server) Requests() []*Request {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	requests := make([]*Request, len(s.requests))
+	copy(requests, s.requests)
+	return requests
+}
+
+// LastRequest returns the last request received,
+// or nil if there is none.
+func (s *Server) LastRequest() *Request {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if len(s.requests) == 0 {
+		return nil
+	}
+	return s.requests[len(s.requests)-1]
+}
+
+// Close closes all connections and the listener,
+// and removes the temporary folder, if any.
+func (s *Server) Close() {
+	s.server.Close()
+	if s.dir != "" {
+		os.RemoveAll(s.dir)
+	}
+}
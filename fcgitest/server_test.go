@@ -0,0 +1,82 @@
+package fcgitest_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/yookoala/gofast"
+	"github.com/yookoala/gofast/fcgitest"
+)
+
+func TestServer(t *testing.T) {
+	servers := map[string]*fcgitest.Server{
+		"pipe": fcgitest.NewServer(fcgitest.Reply(fcgitest.Body("hello"))),
+		"unix": fcgitest.NewUnixServer(fcgitest.Reply(fcgitest.Body("hello"))),
+	}
+	for name, s := range servers {
+		defer s.Close()
+
+		h := gofast.NewHandler(
+			gofast.NewFileEndpoint("/var/www/html/index.php")(gofast.BasicSession),
+			s.ClientFactory(),
+		)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("POST", "/hello", strings.NewReader("a=b")))
+		if want, have := "hello", w.Body.String(); want != have {
+			t.Errorf("%s: expected %#v, got %#v", name, want, have)
+		}
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/world", nil))
+
+		requests := s.Requests()
+		if want, have := 2, len(requests); want != have {
+			t.Fatalf("%s: expected %#v, got %#v", name, want, have)
+		}
+		if want, have := "POST", requests[0].Params["REQUEST_METHOD"]; want != have {
+			t.Errorf("%s: expected %#v, got %#v", name, want, have)
+		}
+		if want, have := "a=b", string(requests[0].Stdin); want != have {
+			t.Errorf("%s: expected %#v, got %#v", name, want, have)
+		}
+		if want, have := gofast.RoleResponder, requests[0].Role; want != have {
+			t.Errorf("%s: expected %#v, got %#v", name, want, have)
+		}
+		if want, have := "/world", s.LastRequest().Params["DOCUMENT_URI"]; want != have {
+			t.Errorf("%s: expected %#v, got %#v", name, want, have)
+		}
+	}
+}
+
+func TestServer_Network(t *testing.T) {
+	s := fcgitest.NewUnixServer(fcgitest.Reply())
+	defer s.Close()
+	if want, have := "unix", s.Network; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if !strings.HasSuffix(s.Address, "fcgi.sock") {
+		t.Errorf("unexpected address %#v", s.Address)
+	}
+
+	s = fcgitest.NewServer(fcgitest.Reply())
+	defer s.Close()
+	if want, have := "", s.Network; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if s.LastRequest() != nil {
+		t.Errorf("expected nil")
+	}
+	c, err := s.ClientFactory()()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	resp, err := c.Do(gofast.NewRequest(httptest.NewRequest("GET", "/", nil)))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	w := httptest.NewRecorder()
+	resp.WriteTo(w, new(strings.Builder))
+	if want, have := http.StatusOK, w.Code; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
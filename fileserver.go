@@ -0,0 +1,137 @@
+package gofast
+
+import (
+	"net/http"
+	"path"
+	"strings"
+)
+
+// NewFileServerHandler returns a *FileServerHandler which serves requests
+// matching the match function with fastcgi, and serves the other with
+// fileServer (e.g. http.FileServer). If match is nil, MatchPHP is used.
+func NewFileServerHandler(fastcgi, fileServer http.Handler, match func(r *http.Request) bool) *FileServerHandler {
+	return &FileServerHandler{
+		FastCGI:    fastcgi,
+		FileServer: fileServer,
+		Match:      match,
+	}
+}
+
+// NewPHPFileServer returns an http.Handler for an ordinary PHP hosting
+// environment. PHP scripts in the root folder are served by the FastCGI
+// application with clients from the given ClientFactory (see NewPHPFS),
+// and other files are served by http.FileServer.
+func NewPHPFileServer(root string, clientFactory ClientFactory) http.Handler {
+	return NewFileServerHandler(
+		NewHandler(NewPHPFS(root)(BasicSession), clientFactory),
+		http.FileServer(http.Dir(root)),
+		MatchPHP,
+	)
+}
+
+// FileServerHandler composes a FastCGI http.Handler (e.g. the one from
+// NewHandler) with a static file http.Handler into one http.Handler.
+type FileServerHandler struct {
+
+	// FastCGI handles the requests to the FastCGI application
+	FastCGI http.Handler
+
+	// FileServer handles the requests of static files
+	FileServer http.Handler
+
+	// Match returns true if the request should be served by FastCGI.
+	// MatchPHP is used if nil.
+	Match func(r *http.Request) bool
+
+	// FallThrough, if true, passes the requests that FileServer responds
+	// with "404 Not Found" to the FastCGI handler. This works like nginx
+	// "try_files $uri /index.php" for applications with front controller.
+	FallThrough bool
+}
+
+// ServeHTTP implements http.Handler
+func (h *FileServerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	match := h.Match
+	if match == nil {
+		match = MatchPHP
+	}
+	if match(r) {
+		h.FastCGI.ServeHTTP(w, r)
+		return
+	}
+	if !h.FallThrough {
+		h.FileServer.ServeHTTP(w, r)
+		return
+	}
+
+	iw := &notFoundInterceptor{ResponseWriter: w}
+	h.FileServer.ServeHTTP(iw, r)
+	if iw.notFound {
+		h.FastCGI.ServeHTTP(w, r)
+	}
+}
+
+// MatchPHP matches requests to PHP scripts, including the ones with path
+// info (e.g. "/index.php/hello") and directories (e.g. "/blog/", which
+// FileSystemRouter routes to the "index.php" inside).
+func MatchPHP(r *http.Request) bool {
+	p := r.URL.Path
+	return strings.HasSuffix(p, "/") ||
+		strings.HasSuffix(p, ".php") ||
+		strings.Contains(p, ".php/")
+}
+
+// MatchExt returns a function that matches requests
+// of the given file extensions (e.g. ".php")
+func MatchExt(exts ...string) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		ext := path.Ext(r.URL.Path)
+		for i := range exts {
+			if ext == exts[i] {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// notFoundInterceptor discards the response if it is
+// "404 Not Found" and passes through the others
+type notFoundInterceptor struct {
+	http.ResponseWriter
+	header      http.Header
+	wroteHeader bool
+	notFound    bool
+}
+
+func (w *notFoundInterceptor) Header() http.Header {
+	if w.header == nil {
+		w.header = make(http.Header)
+	}
+	return w.header
+}
+
+func (w *notFoundInterceptor) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if code == http.StatusNotFound {
+		w.notFound = true
+		return
+	}
+	for k, v := range w.header {
+		w.ResponseWriter.Header()[k] = v
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *notFoundInterceptor) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.notFound {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
@@ -0,0 +1,85 @@
+package gofast_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/yookoala/gofast"
+)
+
+func newFileServerTest(t *testing.T) (root string, fastcgi http.Handler) {
+	root, err := ioutil.TempDir("", "gofast-fileserver-")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "style.css"), []byte("body {}"), 0644); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	fastcgi = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Handler", "fastcgi")
+		fmt.Fprintf(w, "fastcgi %s", r.URL.Path)
+	})
+	return
+}
+
+func TestFileServerHandler(t *testing.T) {
+	root, fastcgi := newFileServerTest(t)
+	defer os.RemoveAll(root)
+	h := gofast.NewFileServerHandler(fastcgi, http.FileServer(http.Dir(root)), nil)
+
+	tests := []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/style.css", http.StatusOK, "body {}"},
+		{"/index.php", http.StatusOK, "fastcgi /index.php"},
+		{"/index.php/hello", http.StatusOK, "fastcgi /index.php/hello"},
+		{"/", http.StatusOK, "fastcgi /"},
+		{"/not-found.css", http.StatusNotFound, "404 page not found\n"},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", test.path, nil))
+		if want, have := test.code, w.Code; want != have {
+			t.Errorf("%s: expected %#v, got %#v", test.path, want, have)
+		}
+		if want, have := test.body, w.Body.String(); want != have {
+			t.Errorf("%s: expected %#v, got %#v", test.path, want, have)
+		}
+	}
+}
+
+func TestFileServerHandler_FallThrough(t *testing.T) {
+	root, fastcgi := newFileServerTest(t)
+	defer os.RemoveAll(root)
+	h := gofast.NewFileServerHandler(fastcgi, http.FileServer(http.Dir(root)), gofast.MatchExt(".php"))
+	h.FallThrough = true
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/blog/hello-world", nil))
+	if want, have := http.StatusOK, w.Code; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "fastcgi /blog/hello-world", w.Body.String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	// headers of the not found response are discarded
+	if want, have := "", w.Header().Get("X-Content-Type-Options"); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/style.css", nil))
+	if want, have := "body {}", w.Body.String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "", w.Header().Get("X-Handler"); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
@@ -0,0 +1,115 @@
+//go:build go1.18
+// +build go1.18
+
+package gofast
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// nopRWC is an io.ReadWriteCloser on a buffer
+type nopRWC struct {
+	bytes.Buffer
+}
+
+func (c *nopRWC) Close() error { return nil }
+
+func FuzzRecordRead(f *testing.F) {
+	f.Add([]byte{1, byte(typeStdout), 0, 1, 0, 5, 3, 0, 'h', 'e', 'l', 'l', 'o', 0, 0, 0})
+	f.Add([]byte{1, byte(typeEndRequest), 0, 1, 0, 8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0})
+	f.Add([]byte{2, byte(typeStdout), 0, 1, 0, 0, 0, 0})
+	f.Add([]byte{1, byte(typeStdout), 0, 1, 0xff, 0xff, 0xff, 0})
+	f.Add([]byte{1})
+	f.Fuzz(func(t *testing.T, b []byte) {
+		r := bytes.NewReader(b)
+		var rec record
+		for rec.read(r) == nil {
+			if want, have := int(rec.h.ContentLength), len(rec.content()); want != have {
+				t.Fatalf("expected %#v, got %#v", want, have)
+			}
+
+			// the record survives a round trip
+			c := newConn(&nopRWC{})
+			if err := c.writeRecord(rec.h.Type, rec.h.ID, rec.content()); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			var rec2 record
+			if err := rec2.read(c.rwc.(io.Reader)); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !bytes.Equal(rec.content(), rec2.content()) {
+				t.Fatalf("expected %#v, got %#v", rec.content(), rec2.content())
+			}
+		}
+	})
+}
+
+func FuzzDecodePairs(f *testing.F) {
+	f.Add(encodePairs(map[string]string{"SCRIPT_FILENAME": "/var/www/index.php"}))
+	f.Add(encodePairs(map[string]string{"": "", "LONG": string(make([]byte, 200))}))
+	f.Add([]byte{0x80, 0, 0, 1, 0})
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	f.Add([]byte{3, 3, 'a', 'b'})
+	f.Fuzz(func(t *testing.T, b []byte) {
+		pairs, err := decodePairs(b)
+		if err != nil {
+			return
+		}
+
+		// decoded pairs survive a round trip
+		pairs2, err := decodePairs(encodePairs(pairs))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !reflect.DeepEqual(pairs, pairs2) {
+			t.Fatalf("expected %#v, got %#v", pairs, pairs2)
+		}
+
+		// the size encoding survives a round trip
+		if len(b) >= 4 {
+			size := binary.BigEndian.Uint32(b) &^ (1 << 31)
+			enc := make([]byte, 4)
+			n := encodeSize(enc, size)
+			if have, m := readSize(enc[:n]); have != size || m != n {
+				t.Fatalf("expected %#v, got %#v", size, have)
+			}
+		}
+	})
+}
+
+func FuzzWriteResponse(f *testing.F) {
+	f.Add([]byte("Content-Type: text/html\r\n\r\nhello"))
+	f.Add([]byte("Status: 404 Not Found\r\nContent-Type: text/plain\r\n\r\n"))
+	f.Add([]byte("Location: /elsewhere\n\n"))
+	f.Add([]byte("Status: 99\r\n\r\n"))
+	f.Add([]byte("no colon\r\n\r\n"))
+	f.Add([]byte(": empty name\r\n\r\n"))
+	f.Add([]byte("Content-Type: text/html"))
+	f.Fuzz(func(t *testing.T, b []byte) {
+		pipes := NewResponsePipe()
+		go func() {
+			pipes.stdOutWriter.Write(b)
+			pipes.stdOutWriter.Close()
+		}()
+
+		done := make(chan error)
+		w := httptest.NewRecorder()
+		go func() {
+			done <- pipes.writeResponse(w)
+		}()
+		select {
+		case err := <-done:
+			if err != nil && w.Code < 400 {
+				t.Fatalf("expected error status on %q, got %d", err, w.Code)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("writeResponse hangs")
+		}
+	})
+}
@@ -0,0 +1,55 @@
+// Package ginadapter mounts gofast handlers under gin routes.
+//
+// The route path prefix before the catch-all parameter is stripped and
+// remembered, so gofast.MapMountPrefix may restore it to SCRIPT_NAME and
+// DOCUMENT_URI. Other route parameters are available to
+// gofast.MapRouteParams.
+//
+//  sess := gofast.Chain(
+//  	gofast.NewPHPFS("/var/www/html"),
+//  	gofast.MapMountPrefix,
+//  	gofast.MapRouteParams,
+//  )(gofast.BasicSession)
+//  h := gofast.NewHandler(sess, clientFactory)
+//
+//  router := gin.Default()
+//  router.Any("/app/*path", ginadapter.Handler(h))
+//
+package ginadapter
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yookoala/gofast"
+)
+
+// Handler returns a gin.HandlerFunc that serves the given http.Handler
+// (e.g. a gofast.Handler). If the matched route ends with a catch-all
+// parameter (e.g. "/app/*path"), the path before it is stripped from the
+// request path with gofast.MountWildcard.
+func Handler(h http.Handler) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var wildcardName string
+		fullPath := c.FullPath()
+		if i := strings.LastIndex(fullPath, "/*"); i >= 0 {
+			wildcardName = fullPath[i+2:]
+		}
+
+		params := make(map[string]string, len(c.Params))
+		for _, p := range c.Params {
+			if wildcardName != "" && p.Key == wildcardName {
+				continue
+			}
+			params[p.Key] = p.Value
+		}
+		r := gofast.WithRouteParams(c.Request, params)
+
+		if wildcardName != "" {
+			gofast.MountWildcard(c.Writer, r, c.Param(wildcardName), h)
+			return
+		}
+		h.ServeHTTP(c.Writer, r)
+	}
+}
@@ -0,0 +1,40 @@
+package ginadapter_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yookoala/gofast"
+	"github.com/yookoala/gofast/ginadapter"
+)
+
+func TestHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var prefix, path string
+	var params map[string]string
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		prefix, path, params = gofast.MountPrefix(r), r.URL.Path, gofast.RouteParams(r)
+	})
+
+	router := gin.New()
+	router.Any("/sites/:site/*path", ginadapter.Handler(h))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/sites/hello/index.php/world", nil))
+
+	if want, have := "/sites/hello", prefix; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "/index.php/world", path; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "hello", params["site"]; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if _, ok := params["path"]; ok {
+		t.Errorf("unexpected wildcard parameter in route params")
+	}
+}
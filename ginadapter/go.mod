@@ -0,0 +1,10 @@
+module github.com/yookoala/gofast/ginadapter
+
+go 1.16
+
+require (
+	github.com/gin-gonic/gin v1.9.1
+	github.com/yookoala/gofast v0.0.0
+)
+
+replace github.com/yookoala/gofast => ../
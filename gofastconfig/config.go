@@ -0,0 +1,185 @@
+// Package gofastconfig builds a gofast handler from a declarative
+// config, so applications can embed a config (e.g. a section of their
+// YAML or JSON config file) instead of assembling the middlewares by
+// hand.
+//
+//	c := &gofastconfig.Config{
+//		Backend:   gofastconfig.BackendConfig{Address: "unix:/run/php/php-fpm.sock"},
+//		DocRoot:   "/var/www/app/public",
+//		Framework: "laravel",
+//	}
+//	h, err := c.Handler()
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	http.ListenAndServe(":8080", h)
+//
+// The fields are tagged for YAML and JSON. This package only decodes
+// JSON (see Parse), and a YAML package of choice may decode the
+// config into the same structs.
+package gofastconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/yookoala/gofast"
+)
+
+// Config is the declarative config of a gofast handler
+type Config struct {
+
+	// Backend is the FastCGI application
+	Backend BackendConfig `yaml:"backend" json:"backend"`
+
+	// DocRoot is the document root of the scripts and static files
+	// (e.g. the "public" folder of a Laravel or Symfony project)
+	DocRoot string `yaml:"docroot" json:"docroot"`
+
+	// Framework is the name of the Preset in Presets. "php" if empty.
+	Framework string `yaml:"framework" json:"framework"`
+
+	// Params are extra FastCGI params, which override
+	// the generated ones (e.g. "APP_ENV")
+	Params map[string]string `yaml:"params" json:"params"`
+
+	Limits LimitsConfig `yaml:"limits" json:"limits"`
+	Cache  CacheConfig  `yaml:"cache" json:"cache"`
+	Auth   AuthConfig   `yaml:"auth" json:"auth"`
+}
+
+// BackendConfig configures a FastCGI application
+type BackendConfig struct {
+
+	// Address of the FastCGI application, in the format
+	// of gofast.ParseAddress (e.g. "127.0.0.1:9000",
+	// "unix:/run/php/php-fpm.sock").
+	Address string `yaml:"address" json:"address"`
+
+	// Pool of clients. No pool if the size is 0.
+	Pool PoolConfig `yaml:"pool" json:"pool"`
+}
+
+// PoolConfig configures a gofast.ClientPool
+type PoolConfig struct {
+	Size    uint     `yaml:"size" json:"size"`
+	Expires Duration `yaml:"expires" json:"expires"`
+}
+
+// LimitsConfig configures the limits of the requests
+type LimitsConfig struct {
+
+	// MaxBodyBytes is the maximum size of request body.
+	// Unlimited if 0.
+	MaxBodyBytes int64 `yaml:"max_body_bytes" json:"max_body_bytes"`
+
+	// Timeout of the requests to the backend. The request is
+	// aborted if the application does not respond in time.
+	// No timeout if 0.
+	Timeout Duration `yaml:"timeout" json:"timeout"`
+}
+
+// CacheConfig configures the cache headers of the static files
+type CacheConfig struct {
+
+	// MaxAge of the "Cache-Control: public, max-age=N" header of
+	// the static files. No header if 0.
+	MaxAge Duration `yaml:"max_age" json:"max_age"`
+
+	// Exts are the file extensions (e.g. ".css") of the static
+	// files with the header. All static files if empty.
+	Exts []string `yaml:"exts" json:"exts"`
+}
+
+// AuthConfig configures a FastCGI authorizer application, which
+// authorizes the requests before they are served (see
+// gofast.Authorizer)
+type AuthConfig struct {
+
+	// Address of the authorizer application, in the format of
+	// gofast.ParseAddress. No authorizer if empty.
+	Address string `yaml:"address" json:"address"`
+
+	// Endpoint is the script file of the authorizer, if the
+	// application requires SCRIPT_FILENAME (e.g. php-fpm)
+	Endpoint string `yaml:"endpoint" json:"endpoint"`
+}
+
+// Duration is a time.Duration in the format of
+// time.ParseDuration (e.g. "30s") in config files
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (d *Duration) UnmarshalText(text []byte) (err error) {
+	d.Duration, err = time.ParseDuration(string(text))
+	return
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.Duration.String()), nil
+}
+
+// Parse decodes the config in JSON and validates it
+func Parse(r io.Reader) (*Config, error) {
+	c := &Config{}
+	if err := json.NewDecoder(r).Decode(c); err != nil {
+		return nil, err
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Validate checks the config for missing or invalid fields
+func (c *Config) Validate() error {
+	if c.Backend.Address == "" {
+		return fmt.Errorf("backend.address is required")
+	}
+	if c.DocRoot == "" {
+		return fmt.Errorf("docroot is required")
+	}
+	if _, err := c.preset(); err != nil {
+		return err
+	}
+	if c.Limits.MaxBodyBytes < 0 {
+		return fmt.Errorf("limits.max_body_bytes must not be negative")
+	}
+	if c.Limits.Timeout.Duration < 0 {
+		return fmt.Errorf("limits.timeout must not be negative")
+	}
+	if c.Cache.MaxAge.Duration < 0 {
+		return fmt.Errorf("cache.max_age must not be negative")
+	}
+	if c.Auth.Endpoint != "" && c.Auth.Address == "" {
+		return fmt.Errorf("auth.address is required for auth.endpoint")
+	}
+	return nil
+}
+
+// preset returns the Preset of the framework
+func (c *Config) preset() (Preset, error) {
+	name := c.Framework
+	if name == "" {
+		name = "php"
+	}
+	p, ok := Presets[name]
+	if !ok {
+		return p, fmt.Errorf("unknown framework %q", c.Framework)
+	}
+	return p, nil
+}
+
+// clientFactory returns the ClientFactory of the backend
+func (bc BackendConfig) clientFactory() gofast.ClientFactory {
+	clientFactory := gofast.SimpleClientFactory(gofast.SimpleConnFactory(gofast.ParseAddress(bc.Address)))
+	if bc.Pool.Size > 0 {
+		return gofast.NewClientPool(clientFactory, bc.Pool.Size, bc.Pool.Expires.Duration).CreateClient
+	}
+	return clientFactory
+}
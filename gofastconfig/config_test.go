@@ -0,0 +1,92 @@
+package gofastconfig_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yookoala/gofast/gofastconfig"
+)
+
+func TestParse(t *testing.T) {
+	c, err := gofastconfig.Parse(strings.NewReader(`{
+		"backend": {"address": "unix:/run/php/php-fpm.sock", "pool": {"size": 4, "expires": "30s"}},
+		"docroot": "/var/www/app/public",
+		"framework": "laravel",
+		"params": {"APP_ENV": "production"},
+		"limits": {"max_body_bytes": 1048576, "timeout": "1m"},
+		"cache": {"max_age": "24h", "exts": [".css", ".js"]},
+		"auth": {"address": "127.0.0.1:9001"}
+	}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want, have := uint(4), c.Backend.Pool.Size; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := 30*time.Second, c.Backend.Pool.Expires.Duration; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "laravel", c.Framework; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "production", c.Params["APP_ENV"]; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := time.Minute, c.Limits.Timeout.Duration; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := 24*time.Hour, c.Cache.MaxAge.Duration; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "127.0.0.1:9001", c.Auth.Address; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestParse_errors(t *testing.T) {
+	tests := []struct {
+		desc   string
+		config string
+		err    string
+	}{
+		{
+			desc:   "no backend",
+			config: `{"docroot": "/var/www"}`,
+			err:    "backend.address is required",
+		},
+		{
+			desc:   "no docroot",
+			config: `{"backend": {"address": ":9000"}}`,
+			err:    "docroot is required",
+		},
+		{
+			desc:   "unknown framework",
+			config: `{"backend": {"address": ":9000"}, "docroot": "/var/www", "framework": "rails"}`,
+			err:    `unknown framework "rails"`,
+		},
+		{
+			desc:   "negative max_body_bytes",
+			config: `{"backend": {"address": ":9000"}, "docroot": "/var/www", "limits": {"max_body_bytes": -1}}`,
+			err:    "limits.max_body_bytes must not be negative",
+		},
+		{
+			desc:   "auth endpoint without address",
+			config: `{"backend": {"address": ":9000"}, "docroot": "/var/www", "auth": {"endpoint": "/var/www/auth.php"}}`,
+			err:    "auth.address is required for auth.endpoint",
+		},
+		{
+			desc:   "bad duration",
+			config: `{"backend": {"address": ":9000"}, "docroot": "/var/www", "limits": {"timeout": "soon"}}`,
+			err:    `time: invalid duration`,
+		},
+	}
+	for _, test := range tests {
+		_, err := gofastconfig.Parse(strings.NewReader(test.config))
+		if err == nil {
+			t.Errorf("%s: expected error, got nil", test.desc)
+		} else if !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expected error containing %#v, got %#v", test.desc, test.err, err.Error())
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package gofastconfig
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yookoala/gofast"
+)
+
+// Preset describes how a framework serves the requests
+type Preset struct {
+
+	// FrontController is the script, relative to DocRoot, which serves
+	// the requests of missing files, like nginx "try_files $uri
+	// /index.php". No front controller if empty.
+	FrontController string
+
+	// Scripts, if true, serves the requests of the PHP scripts in
+	// DocRoot by the scripts. Otherwise, they are served by the front
+	// controller, so no other script is reachable.
+	Scripts bool
+}
+
+// Presets are the presets of Config.Framework by name. Applications
+// may add their own presets before building the handlers.
+var Presets = map[string]Preset{
+	"php":       {Scripts: true},
+	"wordpress": {Scripts: true, FrontController: "index.php"},
+	"laravel":   {FrontController: "index.php"},
+	"symfony":   {FrontController: "index.php"},
+}
+
+// Middleware returns the gofast.Middleware of the config, which maps
+// the params of the FastCGI requests to the backend
+func (c *Config) Middleware() (gofast.Middleware, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	p, _ := c.preset()
+	middlewares := []gofast.Middleware{
+		gofast.BasicParamsMap,
+		gofast.MapHeader,
+		router(p, c.DocRoot),
+	}
+	if len(c.Params) > 0 {
+		middlewares = append(middlewares, gofast.Describe(
+			"gofastconfig.Params", "", mapParams(c.Params)))
+	}
+	return gofast.Chain(middlewares...), nil
+}
+
+// SessionHandler returns the gofast.SessionHandler of the config
+func (c *Config) SessionHandler() (gofast.SessionHandler, error) {
+	m, err := c.Middleware()
+	if err != nil {
+		return nil, err
+	}
+	return m(gofast.BasicSession), nil
+}
+
+// Handler returns the http.Handler of the config. The PHP scripts are
+// served by the backend as the preset of the framework, and the other
+// files are served as static files. Then the limits, the cache headers
+// and the authorizer, if any, apply to the requests.
+func (c *Config) Handler() (http.Handler, error) {
+	sessionHandler, err := c.SessionHandler()
+	if err != nil {
+		return nil, err
+	}
+	p, _ := c.preset()
+
+	var fastcgi http.Handler = gofast.NewHandler(sessionHandler, c.Backend.clientFactory())
+	if c.Limits.Timeout.Duration > 0 {
+		fastcgi = withTimeout(c.Limits.Timeout.Duration, fastcgi)
+	}
+	var files http.Handler = http.FileServer(http.Dir(c.DocRoot))
+	if c.Cache.MaxAge.Duration > 0 {
+		files = withCache(c.Cache, files)
+	}
+
+	var h http.Handler = &gofast.FileServerHandler{
+		FastCGI:     fastcgi,
+		FileServer:  files,
+		Match:       gofast.MatchPHP,
+		FallThrough: p.FrontController != "",
+	}
+	if c.Auth.Address != "" {
+		auth := gofast.Chain(gofast.BasicParamsMap, gofast.MapHeader)
+		if c.Auth.Endpoint != "" {
+			auth = gofast.Chain(auth, gofast.MapEndpoint(c.Auth.Endpoint))
+		}
+		auth = gofast.Chain(auth, gofast.FilterAuthReqParams)
+		authorizer := gofast.NewAuthorizer(
+			BackendConfig{Address: c.Auth.Address}.clientFactory(),
+			auth(gofast.BasicSession),
+		)
+		h = authorizer.Wrap(h)
+	}
+	if c.Limits.MaxBodyBytes > 0 {
+		h = withMaxBody(c.Limits.MaxBodyBytes, h)
+	}
+	return h, nil
+}
+
+// router returns the Middleware that routes the requests
+// to the scripts or the front controller of the preset
+func router(p Preset, docroot string) gofast.Middleware {
+	scripts := (&gofast.FileSystemRouter{
+		DocRoot:  docroot,
+		Exts:     []string{"php"},
+		DirIndex: []string{"index.php"},
+	}).Router()
+	if p.FrontController == "" {
+		return scripts
+	}
+	front := gofast.MapEndpoint(filepath.Join(docroot, p.FrontController))
+	if !p.Scripts {
+		return front
+	}
+	return func(inner gofast.SessionHandler) gofast.SessionHandler {
+		toScript, toFront := scripts(inner), front(inner)
+		return func(client gofast.Client, req *gofast.Request) (*gofast.ResponsePipe, error) {
+			if scriptExists(docroot, req.Raw.URL.Path) {
+				return toScript(client, req)
+			}
+			return toFront(client, req)
+		}
+	}
+}
+
+var pathinfoRe = regexp.MustCompile(`^(.+\.php)(/?.+)$`)
+
+// scriptExists returns true if the script of the path, as
+// gofast.FileSystemRouter routes it, is a file in docroot
+func scriptExists(docroot, urlPath string) bool {
+	name := urlPath
+	if matches := pathinfoRe.FindStringSubmatch(name); len(matches) > 0 {
+		name = matches[1]
+	}
+	if strings.HasSuffix(name, "/") {
+		name = path.Join(name, "index.php")
+	}
+	info, err := os.Stat(filepath.Join(docroot, filepath.FromSlash(path.Clean("/"+name))))
+	return err == nil && info.Mode().IsRegular()
+}
+
+// mapParams returns the Middleware that sets the params
+func mapParams(params map[string]string) gofast.Middleware {
+	return func(inner gofast.SessionHandler) gofast.SessionHandler {
+		return func(client gofast.Client, req *gofast.Request) (*gofast.ResponsePipe, error) {
+			for name, value := range params {
+				req.Params[name] = value
+			}
+			return inner(client, req)
+		}
+	}
+}
+
+// withMaxBody limits the size of the request body. Requests of a
+// larger Content-Length are responded with "413 Request Entity Too
+// Large" without reading the body.
+func withMaxBody(n int64, inner http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > n {
+			http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if r.Body != nil {
+			r.Body = http.MaxBytesReader(w, r.Body, n)
+		}
+		inner.ServeHTTP(w, r)
+	})
+}
+
+// withTimeout cancels the context of the request after the timeout
+func withTimeout(timeout time.Duration, inner http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		inner.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// withCache sets the Cache-Control header of the
+// successful responses of the static files
+func withCache(cc CacheConfig, inner http.Handler) http.Handler {
+	value := "public, max-age=" + strconv.FormatInt(int64(cc.MaxAge.Seconds()), 10)
+	match := func(r *http.Request) bool { return true }
+	if len(cc.Exts) > 0 {
+		match = gofast.MatchExt(cc.Exts...)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !match(r) {
+			inner.ServeHTTP(w, r)
+			return
+		}
+		inner.ServeHTTP(&cacheWriter{ResponseWriter: w, value: value}, r)
+	})
+}
+
+// cacheWriter sets the Cache-Control header
+// if the status is not an error
+type cacheWriter struct {
+	http.ResponseWriter
+	value       string
+	wroteHeader bool
+}
+
+func (w *cacheWriter) WriteHeader(code int) {
+	if !w.wroteHeader && code < 400 {
+		w.Header().Set("Cache-Control", w.value)
+	}
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *cacheWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher, if the ResponseWriter does
+func (w *cacheWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		if !w.wroteHeader {
+			w.WriteHeader(http.StatusOK)
+		}
+		f.Flush()
+	}
+}
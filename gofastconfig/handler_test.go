@@ -0,0 +1,235 @@
+package gofastconfig_test
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yookoala/gofast"
+	"github.com/yookoala/gofast/fcgitest"
+	"github.com/yookoala/gofast/gofastconfig"
+)
+
+// newDocRoot creates a document root with the files
+func newDocRoot(t *testing.T, files ...string) string {
+	root, err := ioutil.TempDir("", "gofastconfig-")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for _, name := range files {
+		filename := filepath.Join(root, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(filename), 0755)
+		if err = ioutil.WriteFile(filename, []byte("file "+name), 0644); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	return root
+}
+
+func TestConfig_Handler_frameworks(t *testing.T) {
+	root := newDocRoot(t, "index.php", "wp-login.php", "wp-admin/index.php", "style.css")
+	defer os.RemoveAll(root)
+	s := fcgitest.NewUnixServer(fcgitest.Reply(fcgitest.Body("php")))
+	defer s.Close()
+
+	tests := []struct {
+		framework string
+		path      string
+		body      string
+		script    string
+	}{
+		{"", "/hello.php", "php", "/hello.php"},
+		{"", "/", "php", "/index.php"},
+		{"", "/style.css", "file style.css", ""},
+		{"", "/missing.css", "404 page not found\n", ""},
+		{"wordpress", "/wp-login.php", "php", "/wp-login.php"},
+		{"wordpress", "/wp-admin/", "php", "/wp-admin/index.php"},
+		{"wordpress", "/2024/hello-world/", "php", "/index.php"},
+		{"wordpress", "/missing.css", "php", "/index.php"},
+		{"wordpress", "/style.css", "file style.css", ""},
+		{"laravel", "/users/1", "php", "/index.php"},
+		{"laravel", "/wp-login.php", "php", "/index.php"},
+		{"laravel", "/", "php", "/index.php"},
+		{"symfony", "/style.css", "file style.css", ""},
+	}
+	for _, test := range tests {
+		c := &gofastconfig.Config{
+			Backend:   gofastconfig.BackendConfig{Address: "unix:" + s.Address},
+			DocRoot:   root,
+			Framework: test.framework,
+		}
+		h, err := c.Handler()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		before := len(s.Requests())
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", test.path, nil))
+		if want, have := test.body, w.Body.String(); want != have {
+			t.Errorf("%s %s: expected %#v, got %#v", test.framework, test.path, want, have)
+		}
+		if test.script == "" {
+			if want, have := before, len(s.Requests()); want != have {
+				t.Errorf("%s %s: expected no request to the backend", test.framework, test.path)
+			}
+			continue
+		}
+		req := s.LastRequest()
+		if want, have := filepath.Join(root, test.script), req.Params["SCRIPT_FILENAME"]; want != have {
+			t.Errorf("%s %s: expected %#v, got %#v", test.framework, test.path, want, have)
+		}
+	}
+}
+
+func TestConfig_Handler_params(t *testing.T) {
+	root := newDocRoot(t, "index.php")
+	defer os.RemoveAll(root)
+	s := fcgitest.NewUnixServer(fcgitest.Reply(fcgitest.Body("php")))
+	defer s.Close()
+
+	c := &gofastconfig.Config{
+		Backend: gofastconfig.BackendConfig{Address: "unix:" + s.Address},
+		DocRoot: root,
+		Params:  map[string]string{"APP_ENV": "test", "SERVER_SOFTWARE": "app"},
+	}
+	h, err := c.Handler()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	params := s.LastRequest().Params
+	if want, have := "test", params["APP_ENV"]; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "app", params["SERVER_SOFTWARE"]; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestConfig_Handler_limits(t *testing.T) {
+	root := newDocRoot(t, "index.php")
+	defer os.RemoveAll(root)
+	s := fcgitest.NewUnixServer(fcgitest.Reply(fcgitest.Delay(time.Second), fcgitest.Body("php")))
+	defer s.Close()
+
+	c := &gofastconfig.Config{
+		Backend: gofastconfig.BackendConfig{Address: "unix:" + s.Address},
+		DocRoot: root,
+		Limits: gofastconfig.LimitsConfig{
+			MaxBodyBytes: 4,
+			Timeout:      gofastconfig.Duration{Duration: 50 * time.Millisecond},
+		},
+	}
+	h, err := c.Handler()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/", strings.NewReader("hello world")))
+	if want, have := 413, w.Code; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := 0, len(s.Requests()); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	start := time.Now()
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Errorf("expected the request to time out, took %s", elapsed)
+	}
+	if w.Body.String() == "php" {
+		t.Errorf("expected the request to time out, got %#v", w.Body.String())
+	}
+}
+
+func TestConfig_Handler_cache(t *testing.T) {
+	root := newDocRoot(t, "style.css", "robots.txt")
+	defer os.RemoveAll(root)
+
+	c := &gofastconfig.Config{
+		Backend: gofastconfig.BackendConfig{Address: "127.0.0.1:9000"},
+		DocRoot: root,
+		Cache: gofastconfig.CacheConfig{
+			MaxAge: gofastconfig.Duration{Duration: time.Hour},
+			Exts:   []string{".css"},
+		},
+	}
+	h, err := c.Handler()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	tests := []struct {
+		path  string
+		cache string
+	}{
+		{"/style.css", "public, max-age=3600"},
+		{"/missing.css", ""},
+		{"/robots.txt", ""},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", test.path, nil))
+		if want, have := test.cache, w.Header().Get("Cache-Control"); want != have {
+			t.Errorf("%s: expected %#v, got %#v", test.path, want, have)
+		}
+	}
+}
+
+func TestConfig_Handler_auth(t *testing.T) {
+	root := newDocRoot(t, "index.php")
+	defer os.RemoveAll(root)
+	s := fcgitest.NewUnixServer(fcgitest.Reply(fcgitest.Body("php")))
+	defer s.Close()
+	auth := fcgitest.NewUnixServer(func(ctx context.Context, req *gofast.Request, stdout, stderr io.Writer) int {
+		if req.Params["HTTP_AUTHORIZATION"] != "Bearer secret" {
+			return fcgitest.Reply(fcgitest.Status(403), fcgitest.Body("denied"))(ctx, req, stdout, stderr)
+		}
+		return fcgitest.Reply(fcgitest.Header("Variable-User", "alice"))(ctx, req, stdout, stderr)
+	})
+	defer auth.Close()
+
+	c := &gofastconfig.Config{
+		Backend: gofastconfig.BackendConfig{Address: "unix:" + s.Address},
+		DocRoot: root,
+		Auth: gofastconfig.AuthConfig{
+			Address:  "unix:" + auth.Address,
+			Endpoint: "/srv/auth.php",
+		},
+	}
+	h, err := c.Handler()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if want, have := 403, w.Code; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := 0, len(s.Requests()); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "/srv/auth.php", auth.LastRequest().Params["SCRIPT_FILENAME"]; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if want, have := "php", w.Body.String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "alice", s.LastRequest().Params["HTTP_USER"]; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
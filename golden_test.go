@@ -0,0 +1,53 @@
+package gofast_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yookoala/gofast"
+	"github.com/yookoala/gofast/fcgitest"
+)
+
+// TestGoldenParams checks the final params of the middleware chains
+// against the golden files in testdata/params. Run the tests with
+// TEST_UPDATE_GOLDEN=1 to update them, and review the changes.
+func TestGoldenParams(t *testing.T) {
+	np, err := gofast.ParseNginxParams(strings.NewReader(nginxFastCGIParams))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	np.DocRoot = "/var/www/html"
+
+	tests := []struct {
+		name       string
+		middleware gofast.Middleware
+	}{
+		{"php_fs", gofast.NewPHPFS("/var/www/html")},
+		{"file_endpoint", gofast.NewFileEndpoint("/var/www/html/index.php")},
+		{"auth_prepare", gofast.NewAuthPrepare()},
+		{"nginx", np.Middleware()},
+	}
+	for _, test := range tests {
+		r, _ := http.NewRequest("POST", "http://foobar.com:8080/app/index.php/hello/world?foo=bar", strings.NewReader("a=b"))
+		r.RequestURI = "/app/index.php/hello/world?foo=bar"
+		r.RemoteAddr = "192.168.0.1:56324"
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.Header.Set("Content-Length", "3")
+		r.Header.Set("X-Forwarded-For", "10.0.0.2")
+		r.Header.Add("Accept", "text/html")
+		r.Header.Add("Accept", "application/json")
+		r = r.WithContext(context.WithValue(r.Context(), http.LocalAddrContextKey,
+			&net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 8080}))
+
+		result, err := gofast.DryRun(test.middleware(gofast.BasicSession), r)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+			continue
+		}
+		fcgitest.GoldenParams(t, filepath.Join("testdata", "params", test.name+".txt"), result.Request.Params)
+	}
+}
@@ -1,9 +1,9 @@
 package gofast
 
 import (
-	"bytes"
 	"log"
 	"net/http"
+	"time"
 )
 
 // Handler is implements http.Handler and provide logger changing method.
@@ -16,11 +16,20 @@ type Handler interface {
 // act as the "web server" component in fastcgi specification, which connects
 // fastcgi "application" through the network/address and passthrough I/O as
 // specified.
-func NewHandler(sessionHandler SessionHandler, clientFactory ClientFactory) Handler {
-	return &defaultHandler{
+func NewHandler(sessionHandler SessionHandler, clientFactory ClientFactory, options ...HandlerOption) Handler {
+	return newHandler(sessionHandler, clientFactory, Budget{}, options)
+}
+
+func newHandler(sessionHandler SessionHandler, clientFactory ClientFactory, budget Budget, options []HandlerOption) *defaultHandler {
+	h := &defaultHandler{
 		sessionHandler: sessionHandler,
 		newClient:      clientFactory,
+		budget:         budget,
+	}
+	for _, option := range options {
+		option(h)
 	}
+	return h
 }
 
 // defaultHandler implements Handler
@@ -28,6 +37,27 @@ type defaultHandler struct {
 	sessionHandler SessionHandler
 	newClient      ClientFactory
 	logger         *log.Logger
+	budget         Budget
+
+	stderrHandler       StderrHandler
+	stderrOnServerError bool
+	streaming           bool
+}
+
+// connectTime is the time of getting the client of a request
+type connectTime struct {
+	start, end time.Time
+}
+
+// ConnectTime returns the time the Handler started and ended getting
+// the client of the request from the ClientFactory, i.e. the wait of
+// ClientPool and the dial, for the middlewares to observe (e.g. for
+// tracing). ok is false if the request is not of the Handler.
+func (req *Request) ConnectTime() (start, end time.Time, ok bool) {
+	if req.connect == nil {
+		return
+	}
+	return req.connect.start, req.connect.end, true
 }
 
 // SetLogger implements Handler
@@ -35,14 +65,31 @@ func (h *defaultHandler) SetLogger(logger *log.Logger) {
 	h.logger = logger
 }
 
+// logf logs with the logger of SetLogger, if any,
+// or the standard logger
+func (h *defaultHandler) logf(format string, v ...interface{}) {
+	if h.logger != nil {
+		h.logger.Printf(format, v...)
+		return
+	}
+	log.Printf(format, v...)
+}
+
 // ServeHTTP implements http.Handler
 func (h *defaultHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
+	// start the time budget of the request, if any
+	r, deadline, stop := h.budget.start(r)
+	defer stop()
+
 	// TODO: separate dial logic to pool client / connection
-	c, err := h.newClient()
+	clock := clockOrSystem(h.budget.Clock)
+	start := clock.Now()
+	c, err := h.budget.getClient(r.Context(), deadline, h.newClient)
+	connected := connectTime{start, clock.Now()}
 	if err != nil {
-		http.Error(w, "failed to connect to FastCGI application", http.StatusBadGateway)
-		log.Printf("gofast: unable to connect to FastCGI application. %s",
+		http.Error(w, "failed to connect to FastCGI application", errorStatus(err, http.StatusBadGateway))
+		h.logf("gofast: unable to connect to FastCGI application. %s",
 			err.Error())
 		return
 	}
@@ -56,26 +103,20 @@ func (h *defaultHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		// signal to close the client
 		// or the pool to return the client
 		if err = c.Close(); err != nil {
-			log.Printf("gofast: error closing client: %s",
+			h.logf("gofast: error closing client: %s",
 				err.Error())
 		}
 	}()
 
 	// handle the session
-	resp, err := h.sessionHandler(c, NewRequest(r))
+	req := NewRequest(r)
+	req.connect = &connected
+	resp, err := h.sessionHandler(c, req)
 	if err != nil {
-		http.Error(w, "failed to process request", http.StatusInternalServerError)
-		log.Printf("gofast: unable to process request %s",
+		http.Error(w, "failed to process request", errorStatus(err, http.StatusInternalServerError))
+		h.logf("gofast: unable to process request %s",
 			err.Error())
 		return
 	}
-	errBuffer := new(bytes.Buffer)
-	if err = resp.WriteTo(w, errBuffer); err != nil {
-		log.Printf("gofast: error writing error buffer to response: %s", err)
-	}
-
-	if errBuffer.Len() > 0 {
-		log.Printf("gofast: error stream from application process %s",
-			errBuffer.String())
-	}
+	h.writeResponse(w, req, resp)
 }
@@ -1,11 +1,14 @@
 package gofast_test
 
 import (
+	"bytes"
 	"fmt"
+	"log"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/yookoala/gofast"
 )
@@ -82,3 +85,61 @@ func TestHandler(t *testing.T) {
 		}
 	}
 }
+
+func TestConnectTime(t *testing.T) {
+	clientFactory := func() (gofast.Client, error) {
+		time.Sleep(10 * time.Millisecond)
+		return gofast.ClientFunc(nil), nil
+	}
+	var start, end time.Time
+	var ok bool
+	h := gofast.NewHandler(func(client gofast.Client, req *gofast.Request) (*gofast.ResponsePipe, error) {
+		start, end, ok = req.ConnectTime()
+		return nil, fmt.Errorf("done")
+	}, clientFactory)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	if !ok {
+		t.Fatalf("expected the connect time of the request")
+	}
+	if d := end.Sub(start); d < 10*time.Millisecond {
+		t.Errorf("expected at least 10ms, got %s", d)
+	}
+	if _, _, ok := gofast.NewRequest(nil).ConnectTime(); ok {
+		t.Errorf("expected no connect time of the request not of the Handler")
+	}
+}
+
+func TestHandler_logger(t *testing.T) {
+	tests := []struct {
+		desc          string
+		clientFactory gofast.ClientFactory
+		want          string
+	}{
+		{
+			desc: "connect",
+			clientFactory: func() (gofast.Client, error) {
+				return nil, fmt.Errorf("refused")
+			},
+			want: "gofast: unable to connect to FastCGI application. refused\n",
+		},
+		{
+			desc: "session",
+			clientFactory: func() (gofast.Client, error) {
+				return gofast.ClientFunc(nil), nil
+			},
+			want: "gofast: unable to process request failed\n",
+		},
+	}
+	for _, tc := range tests {
+		h := gofast.NewHandler(func(client gofast.Client, req *gofast.Request) (*gofast.ResponsePipe, error) {
+			return nil, fmt.Errorf("failed")
+		}, tc.clientFactory)
+		var logs bytes.Buffer
+		h.SetLogger(log.New(&logs, "", 0))
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		if want, have := tc.want, logs.String(); want != have {
+			t.Errorf("%s: expected %#v, got %#v", tc.desc, want, have)
+		}
+	}
+}
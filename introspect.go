@@ -0,0 +1,241 @@
+package gofast
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// chainTrace records the steps of a request through a
+// middleware chain, for DryRun
+type chainTrace struct {
+	steps []traceStep
+}
+
+// traceStep is a step entered, with the params
+// before the step handles the request
+type traceStep struct {
+	name    string
+	summary string
+	params  map[string]string
+
+	// fresh is true if no step has entered since
+	fresh bool
+}
+
+func (t *chainTrace) enter(name, summary string, params map[string]string) {
+	if len(t.steps) > 0 {
+		t.steps[len(t.steps)-1].fresh = false
+	}
+	snapshot := make(map[string]string, len(params))
+	for k, v := range params {
+		snapshot[k] = v
+	}
+	t.steps = append(t.steps, traceStep{
+		name:    name,
+		summary: summary,
+		params:  snapshot,
+		fresh:   true,
+	})
+}
+
+// describe names the step just entered by Chain, or
+// enters a new step if there is none
+func (t *chainTrace) describe(name, summary string, params map[string]string) {
+	if n := len(t.steps); n > 0 && t.steps[n-1].fresh {
+		t.steps[n-1].name, t.steps[n-1].summary = name, summary
+		t.steps[n-1].fresh = false
+		return
+	}
+	t.enter(name, summary, params)
+}
+
+var funcSuffix = regexp.MustCompile(`(\.func\d+|-fm)+$`)
+
+// middlewareName returns the name of the function of the middleware,
+// e.g. "gofast.MapHeader", or "gofast.(*FileSystemRouter).Router"
+// for the closure returned by the method.
+func middlewareName(m Middleware) string {
+	f := runtime.FuncForPC(reflect.ValueOf(m).Pointer())
+	if f == nil {
+		return "unknown"
+	}
+	name := f.Name()
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	return funcSuffix.ReplaceAllString(name, "")
+}
+
+// traced wraps the SessionHandler of the middleware
+// to enter the step in the trace of DryRun, if any
+func traced(m Middleware, inner SessionHandler) SessionHandler {
+	name := middlewareName(m)
+	out := m(inner)
+	if name == "gofast.Chain" {
+		// steps of the nested chain are traced by itself
+		return out
+	}
+	return func(client Client, req *Request) (*ResponsePipe, error) {
+		if req.trace != nil {
+			req.trace.enter(name, "", req.Params)
+		}
+		return out(client, req)
+	}
+}
+
+// Describe names a Middleware and summarizes its config (e.g. the
+// document root), for the steps reported by DryRun. Middlewares chained
+// by Chain are reported by their function names if not described.
+func Describe(name, summary string, m Middleware) Middleware {
+	return func(inner SessionHandler) SessionHandler {
+		out := m(inner)
+		return func(client Client, req *Request) (*ResponsePipe, error) {
+			if req.trace != nil {
+				req.trace.describe(name, summary, req.Params)
+			}
+			return out(client, req)
+		}
+	}
+}
+
+// ChainStep is a step of a middleware chain reported by DryRun
+type ChainStep struct {
+
+	// Name and Summary of the middleware. See Describe.
+	Name    string
+	Summary string
+
+	// Set are the params set or changed by the step,
+	// and Removed are the names of the params removed.
+	Set     map[string]string
+	Removed []string
+}
+
+// DryRunResult is the result of DryRun
+type DryRunResult struct {
+
+	// Steps of the chain, in the order to handle the request
+	Steps []ChainStep
+
+	// Request is the FastCGI request that would be sent
+	// to the application. Nil if the chain returns an error,
+	// or does not send the request.
+	Request *Request
+}
+
+// dryRunClient is a Client that records the request
+// instead of sending it
+type dryRunClient struct {
+	req *Request
+}
+
+func (c *dryRunClient) Do(req *Request) (*ResponsePipe, error) {
+	c.req = req
+	resp := NewResponsePipe()
+	resp.Close()
+	return resp, nil
+}
+
+func (c *dryRunClient) Close() error {
+	return nil
+}
+
+// DryRun runs the http request through the SessionHandler (e.g. a chain
+// of middlewares applied to BasicSession) without contacting any
+// FastCGI application, and reports the steps of the chain with the
+// params each step sets, and the final request.
+//
+// This helps to debug the params of a FastCGI request, e.g. "why is
+// SCRIPT_FILENAME wrong". The middlewares should not depend on the
+// response of the application (there is none).
+func DryRun(sessionHandler SessionHandler, r *http.Request) (*DryRunResult, error) {
+	c := &dryRunClient{}
+	req := NewRequest(r)
+	req.trace = &chainTrace{}
+	_, err := sessionHandler(c, req)
+
+	result := &DryRunResult{Steps: make([]ChainStep, len(req.trace.steps))}
+	for i, step := range req.trace.steps {
+		after := req.Params
+		if i+1 < len(req.trace.steps) {
+			after = req.trace.steps[i+1].params
+		}
+		result.Steps[i] = ChainStep{
+			Name:    step.name,
+			Summary: step.summary,
+			Set:     make(map[string]string),
+		}
+		for k, v := range after {
+			if before, ok := step.params[k]; !ok || before != v {
+				result.Steps[i].Set[k] = v
+			}
+		}
+		for k := range step.params {
+			if _, ok := after[k]; !ok {
+				result.Steps[i].Removed = append(result.Steps[i].Removed, k)
+			}
+		}
+		sort.Strings(result.Steps[i].Removed)
+	}
+	if err == nil && c.req != nil {
+		c.req.trace = nil
+		result.Request = c.req
+	}
+	return result, err
+}
+
+// WriteTo writes the result as text. Implements io.WriterTo.
+func (result *DryRunResult) WriteTo(w io.Writer) (int64, error) {
+	buf := new(bytes.Buffer)
+	for i, step := range result.Steps {
+		fmt.Fprintf(buf, "%d. %s", i+1, step.Name)
+		if step.Summary != "" {
+			fmt.Fprintf(buf, " (%s)", step.Summary)
+		}
+		fmt.Fprintf(buf, "\n")
+		for _, k := range sortedKeys(step.Set) {
+			fmt.Fprintf(buf, "     + %s=%s\n", k, step.Set[k])
+		}
+		for _, k := range step.Removed {
+			fmt.Fprintf(buf, "     - %s\n", k)
+		}
+	}
+	if result.Request == nil {
+		fmt.Fprintf(buf, "\nNo request to the application.\n")
+	} else {
+		fmt.Fprintf(buf, "\nRequest of role %s with params:\n", roleName(result.Request.Role))
+		for _, k := range sortedKeys(result.Request.Params) {
+			fmt.Fprintf(buf, "  %s=%s\n", k, result.Request.Params[k])
+		}
+	}
+	n, err := w.Write(buf.Bytes())
+	return int64(n), err
+}
+
+func roleName(role Role) string {
+	switch role {
+	case RoleResponder:
+		return "responder"
+	case RoleAuthorizer:
+		return "authorizer"
+	case RoleFilter:
+		return "filter"
+	}
+	return fmt.Sprintf("%d", role)
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
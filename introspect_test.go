@@ -0,0 +1,144 @@
+package gofast_test
+
+import (
+	"bytes"
+	"fmt"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/yookoala/gofast"
+)
+
+func stepNames(result *gofast.DryRunResult) []string {
+	names := make([]string, len(result.Steps))
+	for i, step := range result.Steps {
+		names[i] = step.Name
+	}
+	return names
+}
+
+func TestDryRun(t *testing.T) {
+	r := httptest.NewRequest("GET", "http://example.com/foo/index.php/bar?x=1", nil)
+	result, err := gofast.DryRun(gofast.NewPHPFS("/var/www")(gofast.BasicSession), r)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want, have := []string{
+		"gofast.BasicParamsMap",
+		"gofast.MapHeader",
+		"gofast.FileSystemRouter",
+	}, stepNames(result); !reflect.DeepEqual(want, have) {
+		t.Fatalf("expected %#v, got %#v", want, have)
+	}
+
+	router := result.Steps[2]
+	if want, have := "DocRoot=/var/www", router.Summary; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	for name, want := range map[string]string{
+		"SCRIPT_FILENAME": "/var/www/foo/index.php",
+		"PATH_INFO":       "/bar",
+		"DOCUMENT_ROOT":   "/var/www",
+	} {
+		if have := router.Set[name]; want != have {
+			t.Errorf("%s: expected %#v, got %#v", name, want, have)
+		}
+	}
+	if _, ok := router.Set["REQUEST_METHOD"]; ok {
+		t.Errorf("expected REQUEST_METHOD set by BasicParamsMap only")
+	}
+	if want, have := "GET", result.Steps[0].Set["REQUEST_METHOD"]; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "example.com", result.Steps[1].Set["HTTP_HOST"]; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	if result.Request == nil {
+		t.Fatalf("expected the request, got nil")
+	}
+	if want, have := "/var/www/foo/index.php", result.Request.Params["SCRIPT_FILENAME"]; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := gofast.RoleResponder, result.Request.Role; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestDryRun_removed(t *testing.T) {
+	r := httptest.NewRequest("GET", "http://example.com/index.php", nil)
+	chain := gofast.Chain(gofast.NewPHPFS("/var/www"), gofast.FilterAuthReqParams)
+	result, err := gofast.DryRun(chain(gofast.BasicSession), r)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want, have := "gofast.FilterAuthReqParams", result.Steps[3].Name; want != have {
+		t.Fatalf("expected %#v, got %#v", want, have)
+	}
+	if want, have := []string{"CONTENT_LENGTH", "PATH_INFO", "PATH_TRANSLATED", "SCRIPT_NAME"}, result.Steps[3].Removed; !reflect.DeepEqual(want, have) {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestDryRun_custom(t *testing.T) {
+	setEnv := func(inner gofast.SessionHandler) gofast.SessionHandler {
+		return func(client gofast.Client, req *gofast.Request) (*gofast.ResponsePipe, error) {
+			req.Params["APP_ENV"] = "test"
+			return inner(client, req)
+		}
+	}
+	deny := func(inner gofast.SessionHandler) gofast.SessionHandler {
+		return func(client gofast.Client, req *gofast.Request) (*gofast.ResponsePipe, error) {
+			return nil, fmt.Errorf("denied")
+		}
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	chain := gofast.Chain(setEnv, gofast.Describe("deny", "everything", deny))
+	result, err := gofast.DryRun(chain(gofast.BasicSession), r)
+	if err == nil || err.Error() != "denied" {
+		t.Errorf("expected error \"denied\", got %#v", err)
+	}
+	if want, have := []string{"gofast_test.TestDryRun_custom", "deny"}, stepNames(result); !reflect.DeepEqual(want, have) {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := map[string]string{"APP_ENV": "test"}, result.Steps[0].Set; !reflect.DeepEqual(want, have) {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if result.Request != nil {
+		t.Errorf("expected no request, got %#v", result.Request)
+	}
+
+	// described middleware without Chain
+	result, _ = gofast.DryRun(gofast.MapEndpoint("/srv/app.py")(gofast.BasicSession), r)
+	if want, have := []string{"gofast.MapEndpoint"}, stepNames(result); !reflect.DeepEqual(want, have) {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "endpoint=/srv/app.py", result.Steps[0].Summary; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestDryRunResult_WriteTo(t *testing.T) {
+	r := httptest.NewRequest("GET", "http://example.com/", nil)
+	result, err := gofast.DryRun(gofast.NewFileEndpoint("/srv/app.py")(gofast.BasicSession), r)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	buf := new(bytes.Buffer)
+	result.WriteTo(buf)
+	for _, want := range []string{
+		"1. gofast.BasicParamsMap\n     + CONTENT_LENGTH=\n",
+		"2. gofast.MapHeader\n     + HTTP_HOST=example.com\n",
+		"3. gofast.MapEndpoint (endpoint=/srv/app.py)\n",
+		"     + SCRIPT_FILENAME=/srv/app.py\n",
+		"\nRequest of role responder with params:\n",
+		"  SCRIPT_NAME=/app.py\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("expected %#v in %#v", want, buf.String())
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package gofast
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// getValuesTimeout is the time to wait for FCGI_GET_VALUES_RESULT
+const getValuesTimeout = 2 * time.Second
+
+// BackendLimits are the limits of the FastCGI application, as
+// reported in FCGI_GET_VALUES_RESULT. The limits not reported by
+// the application are 0.
+type BackendLimits struct {
+
+	// MaxConns is FCGI_MAX_CONNS, the maximum number of
+	// concurrent connections the application accepts
+	MaxConns int
+
+	// MaxReqs is FCGI_MAX_REQS, the maximum number of
+	// concurrent requests the application accepts
+	MaxReqs int
+
+	// MultiplexConns is FCGI_MPXS_CONNS, true if the application
+	// multiplexes the requests on a connection
+	MultiplexConns bool
+}
+
+// LimitsReporter is a Client which can query the limits of the
+// application (e.g. the clients of SimpleClientFactory,
+// MuxClientFactory and ClientPool).
+//
+// The clients of SimpleClientFactory query on their connections, so
+// BackendLimits should not be called with a request in progress. Some
+// applications close the connection after answering (e.g. php-fpm),
+// so the client may be of no use after: query with a client of its
+// own, and close it after.
+type LimitsReporter interface {
+	BackendLimits() (BackendLimits, error)
+}
+
+// errNoLimits is the error of a Client not being a LimitsReporter
+var errNoLimits = fmt.Errorf("gofast: client does not report the backend limits")
+
+// BackendLimits implements LimitsReporter
+func (c *client) BackendLimits() (BackendLimits, error) {
+	if c.mux != nil {
+		return c.mux.limits, nil
+	}
+	if c.conn == nil {
+		return BackendLimits{}, fmt.Errorf("client connection has been closed")
+	}
+
+	// wait for the last request, if still draining after abort
+	if c.drained != nil {
+		<-c.drained
+	}
+	return getLimits(c.conn)
+}
+
+// BackendLimits implements LimitsReporter, if the inner client does
+func (pc *PoolClient) BackendLimits() (BackendLimits, error) {
+	if lr, ok := pc.Client.(LimitsReporter); ok {
+		return lr.BackendLimits()
+	}
+	return BackendLimits{}, errNoLimits
+}
+
+// getLimits queries the limits of the application on the connection
+func getLimits(c *conn) (limits BackendLimits, err error) {
+	values, err := getValues(c, []string{"FCGI_MAX_CONNS", "FCGI_MAX_REQS", "FCGI_MPXS_CONNS"})
+	if err != nil {
+		return
+	}
+	limits.MaxConns, _ = strconv.Atoi(values["FCGI_MAX_CONNS"])
+	limits.MaxReqs, _ = strconv.Atoi(values["FCGI_MAX_REQS"])
+	limits.MultiplexConns = values["FCGI_MPXS_CONNS"] == "1"
+	return
+}
+
+// getValues sends FCGI_GET_VALUES of the names on the connection and
+// returns the values of FCGI_GET_VALUES_RESULT. The application may
+// leave out the values it does not know. If the connection supports
+// deadlines (e.g. net.Conn), returns an error if there is no result
+// in getValuesTimeout.
+func getValues(c *conn, names []string) (map[string]string, error) {
+	query := make(map[string]string, len(names))
+	for _, name := range names {
+		query[name] = ""
+	}
+	if err := c.writeRecord(typeGetValues, 0, encodePairs(query)); err != nil {
+		return nil, err
+	}
+	if d, ok := c.rwc.(readDeadliner); ok {
+		if err := d.SetReadDeadline(time.Now().Add(getValuesTimeout)); err != nil {
+			return nil, err
+		}
+		defer d.SetReadDeadline(time.Time{})
+	}
+
+	var rec record
+	for {
+		if err := rec.read(c.rwc); err != nil {
+			return nil, err
+		}
+		if rec.h.ID != 0 {
+			continue
+		}
+		switch rec.h.Type {
+		case typeGetValuesResult:
+			return decodePairs(rec.content())
+		case typeUnknownType:
+			return map[string]string{}, nil
+		}
+	}
+}
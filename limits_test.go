@@ -0,0 +1,115 @@
+package gofast_test
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/yookoala/gofast"
+	"github.com/yookoala/gofast/fcgitest"
+)
+
+func TestClient_BackendLimits(t *testing.T) {
+	s := gofast.NewServer(echoName)
+	s.MaxConns, s.MaxReqs = 3, 5
+	tests := []struct {
+		desc          string
+		clientFactory gofast.ClientFactory
+	}{
+		{"simple", gofast.SimpleClientFactory(muxConnFactory(s, "", nil))},
+		{"mux", gofast.MuxClientFactory(muxConnFactory(s, "", nil))},
+		{"pool", gofast.NewClientPool(gofast.SimpleClientFactory(muxConnFactory(s, "", nil)), 1, time.Minute).CreateClient},
+	}
+	for _, tc := range tests {
+		c, err := tc.clientFactory()
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", tc.desc, err)
+		}
+		lr, ok := c.(gofast.LimitsReporter)
+		if !ok {
+			t.Fatalf("%s: expected a LimitsReporter, got %#v", tc.desc, c)
+		}
+		limits, err := lr.BackendLimits()
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tc.desc, err)
+		}
+		if want, have := (gofast.BackendLimits{MaxConns: 3, MaxReqs: 5, MultiplexConns: true}), limits; want != have {
+			t.Errorf("%s: expected %#v, got %#v", tc.desc, want, have)
+		}
+
+		// the client still serves requests after the query,
+		// as the application keeps the connection (unlike php-fpm)
+		req := gofast.NewRequest(nil)
+		req.Params["NAME"] = "hello"
+		resp, err := c.Do(req)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", tc.desc, err)
+		}
+		w := httptest.NewRecorder()
+		resp.WriteTo(w, ioutil.Discard)
+		if want, have := "hello", w.Body.String(); want != have {
+			t.Errorf("%s: expected %#v, got %#v", tc.desc, want, have)
+		}
+		c.Close()
+	}
+}
+
+func TestNewClientPoolWithLimits(t *testing.T) {
+	s := gofast.NewServer(echoName)
+	s.MaxConns = 3
+	appConns := make(chan net.Conn, 10)
+	p, err := gofast.NewClientPoolWithLimits(gofast.SimpleClientFactory(muxConnFactory(s, "", appConns)), 10, time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want, have := 3, p.Limits.MaxConns; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	// the connection of the query, then the 3
+	// connections of the clients created ahead
+	for i := 0; i < 50 && len(appConns) < 4; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if want, have := 4, len(appConns); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if have, err := doName(p.CreateClient, "hello"); err != nil || have != "hello" {
+		t.Errorf("expected %#v, got %#v (error: %v)", "hello", have, err)
+	}
+}
+
+func TestNewClientPoolWithLimits_notReporter(t *testing.T) {
+	clientFactory := func() (gofast.Client, error) {
+		return gofast.ClientFunc(nil), nil
+	}
+	if _, err := gofast.NewClientPoolWithLimits(clientFactory, 10, time.Minute); err == nil {
+		t.Errorf("expected error for a client not reporting the limits")
+	}
+}
+
+func TestClientPool_HealthCheck_closeOnGetValues(t *testing.T) {
+	s := gofast.NewServer(echoName)
+	p := gofast.NewClientPool(gofast.SimpleClientFactory(
+		fcgitest.CloseOnGetValues(muxConnFactory(s, "", nil)),
+	), 2, time.Minute)
+	p.HealthCheck = 10 * time.Millisecond
+	defer p.Close()
+
+	// start the health check, then wait for some passes, of
+	// which the clients probed are closed by the application
+	if have, err := doName(p.CreateClient, "hello"); err != nil || have != "hello" {
+		t.Errorf("expected %#v, got %#v (error: %v)", "hello", have, err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	// the clients handed out are not the ones probed
+	for i := 0; i < 4; i++ {
+		if have, err := doName(p.CreateClient, "again"); err != nil || have != "again" {
+			t.Errorf("expected %#v, got %#v (error: %v)", "again", have, err)
+		}
+	}
+}
@@ -0,0 +1,10 @@
+module github.com/yookoala/gofast/metrics
+
+go 1.16
+
+require (
+	github.com/prometheus/client_golang v1.17.0
+	github.com/yookoala/gofast v0.0.0
+)
+
+replace github.com/yookoala/gofast => ../
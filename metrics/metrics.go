@@ -0,0 +1,265 @@
+// Package metrics instruments gofast handlers and client pools with
+// Prometheus metrics, so the saturation of the FastCGI application can
+// be seen without tcpdump.
+//
+//	m := metrics.New("gofast")
+//	prometheus.MustRegister(m, metrics.NewPoolCollector("gofast", "php", pool))
+//
+//	sess := gofast.Chain(
+//		m.Middleware,
+//		gofast.NewPHPFS("/var/www/html"),
+//	)(gofast.BasicSession)
+//	h := m.Handler(gofast.NewHandler(sess, m.ClientFactory(pool.CreateClient)))
+//
+// The metrics of the namespace "gofast":
+//
+//	gofast_requests_total{code}           requests by the HTTP status
+//	gofast_fastcgi_duration_seconds       FastCGI round-trip latency
+//	gofast_fastcgi_stdin_bytes_total      request body sent (FCGI_STDIN)
+//	gofast_fastcgi_stdout_bytes_total     response received (FCGI_STDOUT)
+//	gofast_fastcgi_stderr_records_total   FCGI_STDERR records received
+//	gofast_dial_errors_total              errors of connecting (ErrDial)
+//	gofast_pool_clients{pool,state}       clients "idle" and "in_use"
+//	gofast_pool_scale{pool}               scale of the pool
+package metrics
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/yookoala/gofast"
+)
+
+// Metrics are the metrics of the requests to a FastCGI application.
+// It implements prometheus.Collector to be registered.
+type Metrics struct {
+	requests      *prometheus.CounterVec
+	duration      prometheus.Histogram
+	stdinBytes    prometheus.Counter
+	stdoutBytes   prometheus.Counter
+	stderrRecords prometheus.Counter
+	dialErrors    prometheus.Counter
+}
+
+// New returns the *Metrics of the namespace (e.g. "gofast")
+func New(namespace string) *Metrics {
+	return &Metrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "requests_total",
+			Help:      "Number of the requests served, by the HTTP status code.",
+		}, []string{"code"}),
+		duration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "fastcgi",
+			Name:      "duration_seconds",
+			Help:      "Round-trip latency of the FastCGI requests, until FCGI_END_REQUEST.",
+			Buckets:   prometheus.DefBuckets,
+		}),
+		stdinBytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "fastcgi",
+			Name:      "stdin_bytes_total",
+			Help:      "Bytes of the request bodies sent to the application.",
+		}),
+		stdoutBytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "fastcgi",
+			Name:      "stdout_bytes_total",
+			Help:      "Bytes of the FCGI_STDOUT records received from the application.",
+		}),
+		stderrRecords: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "fastcgi",
+			Name:      "stderr_records_total",
+			Help:      "Number of the FCGI_STDERR records received from the application.",
+		}),
+		dialErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "dial_errors_total",
+			Help:      "Number of the errors connecting to the application.",
+		}),
+	}
+}
+
+// collectors are the collectors of the metrics
+func (m *Metrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		m.requests,
+		m.duration,
+		m.stdinBytes,
+		m.stdoutBytes,
+		m.stderrRecords,
+		m.dialErrors,
+	}
+}
+
+// Describe implements prometheus.Collector
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range m.collectors() {
+		c.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range m.collectors() {
+		c.Collect(ch)
+	}
+}
+
+// Middleware implements gofast.Middleware. It measures the round-trip
+// of the FastCGI requests, and counts the bytes of the request bodies,
+// the bytes of FCGI_STDOUT and the FCGI_STDERR records. The hooks of
+// gofast.ClientTrace set by the inner middlewares, if any, are kept.
+func (m *Metrics) Middleware(inner gofast.SessionHandler) gofast.SessionHandler {
+	return func(client gofast.Client, req *gofast.Request) (*gofast.ResponsePipe, error) {
+		if req.Stdin != nil && req.Stdin != http.NoBody {
+			req.Stdin = &countingReader{ReadCloser: req.Stdin, counter: m.stdinBytes}
+		}
+
+		// the previous trace is restored for the request sent
+		// again (e.g. by a retry middleware before this one)
+		prev := req.Trace
+		defer func() {
+			req.Trace = prev
+		}()
+		start := time.Now()
+		req.Trace = &gofast.ClientTrace{
+			GotStdout: func(n int) {
+				m.stdoutBytes.Add(float64(n))
+				if prev != nil && prev.GotStdout != nil {
+					prev.GotStdout(n)
+				}
+			},
+			GotStderr: func(n int) {
+				// the empty record ends the stream
+				if n > 0 {
+					m.stderrRecords.Inc()
+				}
+				if prev != nil && prev.GotStderr != nil {
+					prev.GotStderr(n)
+				}
+			},
+			Done: func(err error) {
+				m.duration.Observe(time.Since(start).Seconds())
+				if prev != nil && prev.Done != nil {
+					prev.Done(err)
+				}
+			},
+		}
+		return inner(client, req)
+	}
+}
+
+// Handler returns an http.Handler which counts the
+// requests served by the handler by the HTTP status
+func (m *Metrics) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w}
+		h.ServeHTTP(sw, r)
+		if sw.code == 0 {
+			sw.code = http.StatusOK
+		}
+		m.requests.WithLabelValues(strconv.Itoa(sw.code)).Inc()
+	})
+}
+
+// ClientFactory returns a gofast.ClientFactory of the clientFactory
+// which counts the errors of connecting (see gofast.ErrDial)
+func (m *Metrics) ClientFactory(clientFactory gofast.ClientFactory) gofast.ClientFactory {
+	return func() (gofast.Client, error) {
+		c, err := clientFactory()
+		if errors.Is(err, gofast.ErrDial) {
+			m.dialErrors.Inc()
+		}
+		return c, err
+	}
+}
+
+// NewPoolCollector returns a prometheus.Collector of the numbers of
+// clients of the pool (see gofast.ClientPool.Stats), labeled with the
+// name of the pool
+func NewPoolCollector(namespace, name string, pool *gofast.ClientPool) prometheus.Collector {
+	labels := prometheus.Labels{"pool": name}
+	return &poolCollector{
+		pool: pool,
+		clients: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "pool", "clients"),
+			"Number of the clients of the pool, by the state (idle or in_use).",
+			[]string{"state"}, labels,
+		),
+		scale: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "pool", "scale"),
+			"Scale of the pool.",
+			nil, labels,
+		),
+	}
+}
+
+// poolCollector collects the stats of a gofast.ClientPool
+type poolCollector struct {
+	pool    *gofast.ClientPool
+	clients *prometheus.Desc
+	scale   *prometheus.Desc
+}
+
+// Describe implements prometheus.Collector
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.clients
+	ch <- c.scale
+}
+
+// Collect implements prometheus.Collector
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.pool.Stats()
+	ch <- prometheus.MustNewConstMetric(c.clients, prometheus.GaugeValue, float64(stats.Idle), "idle")
+	ch <- prometheus.MustNewConstMetric(c.clients, prometheus.GaugeValue, float64(stats.InUse), "in_use")
+	ch <- prometheus.MustNewConstMetric(c.scale, prometheus.GaugeValue, float64(stats.Scale))
+}
+
+// countingReader counts the bytes read
+type countingReader struct {
+	io.ReadCloser
+	counter prometheus.Counter
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.counter.Add(float64(n))
+	return n, err
+}
+
+// statusWriter records the status code written
+type statusWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher, if the ResponseWriter does
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		if w.code == 0 {
+			w.code = http.StatusOK
+		}
+		f.Flush()
+	}
+}
@@ -0,0 +1,175 @@
+package metrics_test
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/yookoala/gofast"
+	"github.com/yookoala/gofast/metrics"
+)
+
+// pipeConnFactory connects to the server with net.Pipe
+func pipeConnFactory(s *gofast.Server) gofast.ConnFactory {
+	return func() (net.Conn, error) {
+		appConn, webConn := net.Pipe()
+		go s.ServeConn(appConn)
+		return webConn, nil
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	s := gofast.NewServer(func(ctx context.Context, req *gofast.Request, stdout, stderr io.Writer) int {
+		io.Copy(ioutil.Discard, req.Stdin)
+		fmt.Fprint(stderr, "warning")
+		fmt.Fprint(stdout, "Status: 201\r\nContent-Type: text/plain\r\n\r\nhello")
+		return 0
+	})
+	m := metrics.New("gofast")
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(m)
+
+	sess := gofast.Chain(m.Middleware, gofast.BasicParamsMap)(gofast.BasicSession)
+	h := m.Handler(gofast.NewHandler(sess, m.ClientFactory(gofast.SimpleClientFactory(pipeConnFactory(s)))))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/", strings.NewReader("data")))
+	if want, have := 201, w.Code; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	// the round-trip may end after the response is written
+	for i := 0; i < 50 && testutil.CollectAndCount(m, "gofast_fastcgi_duration_seconds") == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	expected := `
+# HELP gofast_requests_total Number of the requests served, by the HTTP status code.
+# TYPE gofast_requests_total counter
+gofast_requests_total{code="201"} 1
+# HELP gofast_fastcgi_stdin_bytes_total Bytes of the request bodies sent to the application.
+# TYPE gofast_fastcgi_stdin_bytes_total counter
+gofast_fastcgi_stdin_bytes_total 4
+# HELP gofast_fastcgi_stderr_records_total Number of the FCGI_STDERR records received from the application.
+# TYPE gofast_fastcgi_stderr_records_total counter
+gofast_fastcgi_stderr_records_total 1
+# HELP gofast_dial_errors_total Number of the errors connecting to the application.
+# TYPE gofast_dial_errors_total counter
+gofast_dial_errors_total 0
+`
+	if err := testutil.CollectAndCompare(m, strings.NewReader(expected),
+		"gofast_requests_total",
+		"gofast_fastcgi_stdin_bytes_total",
+		"gofast_fastcgi_stderr_records_total",
+		"gofast_dial_errors_total",
+	); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if want, have := 1, testutil.CollectAndCount(m, "gofast_fastcgi_duration_seconds"); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestMetrics_dialErrors(t *testing.T) {
+	m := metrics.New("gofast")
+	clientFactory := m.ClientFactory(gofast.SimpleClientFactory(gofast.SimpleConnFactory("unix", "/nonexistent/fcgi.sock")))
+	h := m.Handler(gofast.NewHandler(gofast.BasicSession, clientFactory))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	expected := `
+# HELP gofast_dial_errors_total Number of the errors connecting to the application.
+# TYPE gofast_dial_errors_total counter
+gofast_dial_errors_total 1
+# HELP gofast_requests_total Number of the requests served, by the HTTP status code.
+# TYPE gofast_requests_total counter
+gofast_requests_total{code="502"} 1
+`
+	if err := testutil.CollectAndCompare(m, strings.NewReader(expected),
+		"gofast_dial_errors_total",
+		"gofast_requests_total",
+	); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestNewPoolCollector(t *testing.T) {
+	s := gofast.NewServer(func(ctx context.Context, req *gofast.Request, stdout, stderr io.Writer) int {
+		return 0
+	})
+	pool := gofast.NewClientPool(gofast.SimpleClientFactory(pipeConnFactory(s)), 3, time.Minute)
+	defer pool.Close()
+	c, err := pool.CreateClient()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer c.Close()
+	time.Sleep(10 * time.Millisecond)
+
+	expected := `
+# HELP gofast_pool_clients Number of the clients of the pool, by the state (idle or in_use).
+# TYPE gofast_pool_clients gauge
+gofast_pool_clients{pool="php",state="idle"} 3
+gofast_pool_clients{pool="php",state="in_use"} 1
+# HELP gofast_pool_scale Scale of the pool.
+# TYPE gofast_pool_scale gauge
+gofast_pool_scale{pool="php"} 3
+`
+	if err := testutil.CollectAndCompare(metrics.NewPoolCollector("gofast", "php", pool), strings.NewReader(expected)); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestMetrics_eventStream(t *testing.T) {
+	next := make(chan struct{})
+	s := gofast.NewServer(func(ctx context.Context, req *gofast.Request, stdout, stderr io.Writer) int {
+		fmt.Fprint(stdout, "Content-Type: text/event-stream\r\n\r\ndata: 1\n\n")
+		stdout.(interface{ Flush() error }).Flush()
+		select {
+		case <-next:
+		case <-ctx.Done():
+		}
+		fmt.Fprint(stdout, "data: 2\n\n")
+		return 0
+	})
+	m := metrics.New("gofast")
+	ts := httptest.NewServer(m.Handler(gofast.NewHandler(
+		gofast.BasicSession,
+		gofast.SimpleClientFactory(pipeConnFactory(s)),
+	)))
+	defer ts.Close()
+
+	// the first event is read through the wrapper
+	// before the second is written
+	line, done := make(chan string, 1), make(chan struct{})
+	go func() {
+		defer close(done)
+		resp, err := http.Get(ts.URL)
+		if err != nil {
+			line <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		r := bufio.NewReader(resp.Body)
+		l, _ := r.ReadString('\n')
+		line <- l
+		ioutil.ReadAll(r)
+	}()
+	select {
+	case l := <-line:
+		if want, have := "data: 1\n", l; want != have {
+			t.Errorf("expected %#v, got %#v", want, have)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("expected the first event streamed")
+	}
+	close(next)
+	<-done
+}
@@ -0,0 +1,151 @@
+package gofast
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type mountCtxKey int
+
+const (
+	ctxKeyMountPrefix mountCtxKey = iota
+	ctxKeyRouteParams
+)
+
+// Mount returns an http.Handler that serves h under the given web path
+// prefix. Like http.StripPrefix, the prefix is removed from r.URL.Path
+// before h is called, and request with path not starting with the prefix
+// will be replied with HTTP 404 not found.
+//
+// The prefix is remembered in the request context so that MapMountPrefix
+// may restore it to the path related fastcgi parameters.
+//
+// This is useful to mount a gofast Handler under a route group of other
+// routers (e.g. chi, gin, echo).
+func Mount(prefix string, h http.Handler) http.Handler {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if prefix == "" {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := strings.TrimPrefix(r.URL.Path, prefix)
+		if len(p) == len(r.URL.Path) || (p != "" && p[0] != '/') {
+			http.NotFound(w, r)
+			return
+		}
+		if p == "" {
+			p = "/"
+		}
+		h.ServeHTTP(w, stripRequestPath(r, prefix, p))
+	})
+}
+
+// MountWildcard mounts h the same way as Mount but derives the prefix
+// from the request: the prefix is the request path with the given
+// wildcard (catch-all) route parameter value removed from its end.
+//
+// Routers that do not strip the route group prefix themselves (e.g. gin,
+// echo or chi) provide the wildcard value of the matched route.
+func MountWildcard(w http.ResponseWriter, r *http.Request, wildcard string, h http.Handler) {
+	if wildcard != "" && wildcard[0] != '/' {
+		wildcard = "/" + wildcard
+	}
+	if !strings.HasSuffix(r.URL.Path, wildcard) {
+		// the wildcard value is not the end of the path
+		// (e.g. rewritten path). Serve without stripping.
+		h.ServeHTTP(w, r)
+		return
+	}
+	prefix := strings.TrimSuffix(r.URL.Path, wildcard)
+	Mount(prefix, h).ServeHTTP(w, r)
+}
+
+// stripRequestPath returns a shallow copy of r with URL.Path
+// replaced and the stripped prefix stored in context.
+func stripRequestPath(r *http.Request, prefix, p string) *http.Request {
+	// nested mount appends to the previous prefix
+	if prev := MountPrefix(r); prev != "" {
+		prefix = prev + prefix
+	}
+	ctx := context.WithValue(r.Context(), ctxKeyMountPrefix, prefix)
+	r2 := r.WithContext(ctx)
+	r2.URL = new(url.URL)
+	*r2.URL = *r.URL
+	r2.URL.Path = p
+	r2.URL.RawPath = ""
+	return r2
+}
+
+// MountPrefix returns the web path prefix stripped by Mount
+// or MountWildcard. Returns empty string if there is none.
+func MountPrefix(r *http.Request) string {
+	if prefix, ok := r.Context().Value(ctxKeyMountPrefix).(string); ok {
+		return prefix
+	}
+	return ""
+}
+
+// WithRouteParams returns a shallow copy of r with the given route
+// parameters (e.g. the "id" in route "/users/:id") stored in context.
+// Parameters are merged with the ones previously stored.
+//
+// Mainly for adapters of third party routers to use. The parameters can
+// then be mapped to fastcgi parameters by MapRouteParams.
+func WithRouteParams(r *http.Request, params map[string]string) *http.Request {
+	merged := make(map[string]string)
+	for k, v := range RouteParams(r) {
+		merged[k] = v
+	}
+	for k, v := range params {
+		merged[k] = v
+	}
+	return r.WithContext(context.WithValue(r.Context(), ctxKeyRouteParams, merged))
+}
+
+// RouteParams returns the route parameters stored by WithRouteParams.
+func RouteParams(r *http.Request) map[string]string {
+	if params, ok := r.Context().Value(ctxKeyRouteParams).(map[string]string); ok {
+		return params
+	}
+	return nil
+}
+
+// MapMountPrefix implements Middleware. It prepends the prefix stripped by
+// Mount to the web path parameters, so application sees the path the
+// client actually requested. Since the path mapping middlewares (e.g.
+// NewPHPFS, NewFileEndpoint) works on the stripped path, this middleware
+// should be placed after them in a Chain.
+//
+// Parameters altered:
+//  SCRIPT_NAME
+//  DOCUMENT_URI
+//
+func MapMountPrefix(inner SessionHandler) SessionHandler {
+	return func(client Client, req *Request) (*ResponsePipe, error) {
+		if prefix := MountPrefix(req.Raw); prefix != "" {
+			if v, ok := req.Params["SCRIPT_NAME"]; ok {
+				req.Params["SCRIPT_NAME"] = prefix + v
+			}
+			if v, ok := req.Params["DOCUMENT_URI"]; ok {
+				req.Params["DOCUMENT_URI"] = prefix + v
+			}
+		}
+		return inner(client, req)
+	}
+}
+
+// MapRouteParams implements Middleware. It maps the route parameters
+// stored by WithRouteParams to fastcgi parameters. Parameter names are
+// upper-cased and prefixed with "ROUTE_". For example, route parameter
+// "user-id" will be mapped to "ROUTE_USER_ID".
+func MapRouteParams(inner SessionHandler) SessionHandler {
+	return func(client Client, req *Request) (*ResponsePipe, error) {
+		for k, v := range RouteParams(req.Raw) {
+			key := "ROUTE_" + strings.Replace(strings.ToUpper(k), "-", "_", -1)
+			req.Params[key] = v
+		}
+		return inner(client, req)
+	}
+}
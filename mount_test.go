@@ -0,0 +1,86 @@
+package gofast_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/yookoala/gofast"
+)
+
+func TestMount(t *testing.T) {
+	var params map[string]string
+	sess := gofast.Chain(
+		gofast.BasicParamsMap,
+		gofast.NewPHPFS("/var/www/html"),
+		gofast.MapMountPrefix,
+		gofast.MapRouteParams,
+	)(func(client gofast.Client, req *gofast.Request) (resp *gofast.ResponsePipe, err error) {
+		params = req.Params
+		err = fmt.Errorf("stop here")
+		return
+	})
+	h := gofast.NewHandler(sess, func() (gofast.Client, error) {
+		return gofast.ClientFunc(nil), nil
+	})
+
+	r := httptest.NewRequest("GET", "/app/index.php/hello?foo=bar", nil)
+	r = gofast.WithRouteParams(r, map[string]string{"user-id": "1234"})
+	w := httptest.NewRecorder()
+	gofast.Mount("/app/", h).ServeHTTP(w, r)
+
+	for key, want := range map[string]string{
+		"SCRIPT_NAME":     "/app/index.php",
+		"SCRIPT_FILENAME": "/var/www/html/index.php",
+		"PATH_INFO":       "/hello",
+		"DOCUMENT_URI":    "/app/index.php/hello",
+		"REQUEST_URI":     "/app/index.php/hello?foo=bar",
+		"ROUTE_USER_ID":   "1234",
+	} {
+		if have := params[key]; want != have {
+			t.Errorf("%s: expected %#v, got %#v", key, want, have)
+		}
+	}
+}
+
+func TestMount_NotFound(t *testing.T) {
+	h := gofast.Mount("/app", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected call to handler with path %#v", r.URL.Path)
+	}))
+	for _, path := range []string{"/", "/apple", "/other/app"} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if want, have := http.StatusNotFound, w.Code; want != have {
+			t.Errorf("%s: expected %#v, got %#v", path, want, have)
+		}
+	}
+}
+
+func TestMountWildcard(t *testing.T) {
+	tests := []struct {
+		path     string
+		wildcard string
+		prefix   string
+		stripped string
+	}{
+		{"/app/index.php", "/index.php", "/app", "/index.php"},
+		{"/app/index.php", "index.php", "/app", "/index.php"},
+		{"/app/", "/", "/app", "/"},
+		{"/app", "", "/app", "/"},
+		{"/app/index.php", "/other.php", "", "/app/index.php"},
+	}
+	for _, test := range tests {
+		var prefix, stripped string
+		h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			prefix, stripped = gofast.MountPrefix(r), r.URL.Path
+		})
+		gofast.MountWildcard(httptest.NewRecorder(), httptest.NewRequest("GET", test.path, nil), test.wildcard, h)
+		if want, have := test.prefix, prefix; want != have {
+			t.Errorf("%s: expected prefix %#v, got %#v", test.path, want, have)
+		}
+		if want, have := test.stripped, stripped; want != have {
+			t.Errorf("%s: expected path %#v, got %#v", test.path, want, have)
+		}
+	}
+}
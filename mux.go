@@ -0,0 +1,271 @@
+package gofast
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// maxMuxQueueBytes is the maximum of the content queued to a stream of
+// MuxClientFactory and not read yet by the client
+const maxMuxQueueBytes = 4 << 20
+
+// errMuxQueueFull is the error of a stream past maxMuxQueueBytes
+var errMuxQueueFull = errors.New("gofast: response queue of multiplexed connection full")
+
+// MuxClientFactory returns a ClientFactory of which the clients share
+// one connection from the ConnFactory. The concurrent requests of the
+// clients are multiplexed on the connection with distinct request IDs,
+// and the records of the response streams are demultiplexed by the
+// request IDs.
+//
+// The connection is created on the first use, or after the connection
+// is closed. The application is asked for FCGI_MPXS_CONNS with
+// FCGI_GET_VALUES first. If the application does not multiplex (e.g.
+// php-fpm) or does not answer, every client has its own connection
+// instead, like the clients of SimpleClientFactory.
+//
+// The records of a response are queued until read, so a slow reader
+// does not block the other requests. A request of which the queued
+// response exceeds 4 MB is aborted with an error.
+func MuxClientFactory(connFactory ConnFactory, options ...ClientOption) ClientFactory {
+	f := &muxFactory{connFactory: connFactory, options: options}
+	return f.createClient
+}
+
+// muxFactory creates the clients of MuxClientFactory
+type muxFactory struct {
+	connFactory ConnFactory
+	options     []ClientOption
+
+	mutex sync.Mutex
+	mux   *muxConn
+	noMux bool
+}
+
+func (f *muxFactory) createClient() (Client, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.noMux {
+		return SimpleClientFactory(f.connFactory, f.options...)()
+	}
+	if f.mux == nil || f.mux.isClosed() {
+		conn, err := f.connFactory()
+		if err != nil {
+			return nil, &wrapError{ErrDial, err}
+		}
+		c := newConn(conn)
+		// the connection of the query is not for requests, if the
+		// answer may yet come, or the application closes it after
+		// the answer (e.g. php-fpm)
+		limits, err := getLimits(c)
+		if err != nil || !limits.MultiplexConns {
+			conn.Close()
+			f.noMux = true
+			return SimpleClientFactory(f.connFactory, f.options...)()
+		}
+		f.mux = newMuxConn(c, limits)
+	}
+	c := newClient(f.mux.conn, f.mux.ids, f.options)
+	c.mux = f.mux
+	return c, nil
+}
+
+// muxConn is a connection shared by the clients of MuxClientFactory.
+// The records from the application are read by a single goroutine,
+// and queued to the streams of the requests.
+type muxConn struct {
+	conn   *conn
+	ids    *idPool
+	limits BackendLimits
+
+	mutex   sync.Mutex
+	streams map[uint16]*muxStream
+	closed  bool
+}
+
+func newMuxConn(c *conn, limits BackendLimits) *muxConn {
+	mc := &muxConn{
+		conn:    c,
+		ids:     newIDs(),
+		limits:  limits,
+		streams: make(map[uint16]*muxStream),
+	}
+	go mc.demux()
+	return mc
+}
+
+func (mc *muxConn) isClosed() bool {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+	return mc.closed
+}
+
+// open opens the stream of the request before the request is
+// written, so no record of the response would be missed
+func (mc *muxConn) open(reqID uint16) {
+	s := &muxStream{}
+	s.cond = sync.NewCond(&s.mutex)
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+	if mc.closed {
+		s.fail(io.EOF)
+	}
+	mc.streams[reqID] = s
+}
+
+// stream returns the stream of the request
+func (mc *muxConn) stream(reqID uint16) *muxStream {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+	return mc.streams[reqID]
+}
+
+// finish ends the stream of the request when the client is done with
+// it. The request ID is only released after FCGI_END_REQUEST (e.g. the
+// request timed out), so the late records of the request would not
+// be mixed up with a new request of the same ID.
+func (mc *muxConn) finish(reqID uint16) {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+	s, ok := mc.streams[reqID]
+	if !ok {
+		return
+	}
+	if s.finish() || mc.closed {
+		delete(mc.streams, reqID)
+		mc.ids.Release(reqID)
+	}
+}
+
+// demux reads the records and queues them to the streams
+// by the request IDs, until the connection is closed
+func (mc *muxConn) demux() {
+	var rec record
+	for {
+		if err := rec.read(mc.conn.rwc); err != nil {
+			mc.fail(err)
+			return
+		}
+		if rec.h.ID == 0 {
+			// management records are not expected
+			continue
+		}
+		mc.mutex.Lock()
+		s := mc.streams[rec.h.ID]
+		if s != nil {
+			done, full := s.push(&rec)
+			if done {
+				delete(mc.streams, rec.h.ID)
+				mc.ids.Release(rec.h.ID)
+			}
+			if full {
+				// not to block the demux on writing
+				go mc.conn.writeAbortRequest(rec.h.ID)
+			}
+		}
+		mc.mutex.Unlock()
+	}
+}
+
+// fail closes the connection, and ends all the streams with the error
+func (mc *muxConn) fail(err error) {
+	mc.conn.Close()
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+	mc.closed = true
+	for reqID, s := range mc.streams {
+		if s.fail(err) {
+			delete(mc.streams, reqID)
+			mc.ids.Release(reqID)
+		}
+	}
+}
+
+// muxRecord is a record queued to a muxStream
+type muxRecord struct {
+	h       header
+	content []byte
+}
+
+// muxStream is the queue of the records of a request. The queue is
+// bounded by maxMuxQueueBytes instead of blocking the demux, so a slow
+// reader of a response does not block the other requests.
+type muxStream struct {
+	mutex    sync.Mutex
+	cond     *sync.Cond
+	queue    []muxRecord
+	queued   int
+	err      error
+	ended    bool
+	finished bool
+}
+
+// push queues a copy of the record. Returns done if the stream is
+// done with: the request is ended and the client has finished. Returns
+// full if the record exceeds maxMuxQueueBytes, and the stream is ended
+// with errMuxQueueFull after the queued records. The records after
+// are dropped.
+func (s *muxStream) push(rec *record) (done, full bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if rec.h.Type == typeEndRequest {
+		s.ended = true
+	}
+	if !s.finished && s.err == nil {
+		if s.queued+len(rec.content()) > maxMuxQueueBytes {
+			s.err, full = errMuxQueueFull, true
+		} else {
+			content := make([]byte, len(rec.content()))
+			copy(content, rec.content())
+			s.queue = append(s.queue, muxRecord{rec.h, content})
+			s.queued += len(content)
+		}
+		s.cond.Signal()
+	}
+	return s.ended && s.finished, full
+}
+
+// fail ends the stream with the error after the queued records.
+// Returns true if the client has finished.
+func (s *muxStream) fail(err error) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.err == nil {
+		s.err = err
+	}
+	s.cond.Broadcast()
+	return s.finished
+}
+
+// finish drops the records not read, and stops the reader. Returns
+// true if the request is ended.
+func (s *muxStream) finish() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.finished = true
+	s.queue, s.queued = nil, 0
+	if s.err == nil {
+		s.err = io.EOF
+	}
+	s.cond.Broadcast()
+	return s.ended
+}
+
+// read reads the next record of the stream to the rec
+func (s *muxStream) read(rec *record) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for len(s.queue) == 0 && s.err == nil {
+		s.cond.Wait()
+	}
+	if len(s.queue) == 0 {
+		return s.err
+	}
+	r := s.queue[0]
+	s.queue = s.queue[1:]
+	s.queued -= len(r.content)
+	rec.h = r.h
+	copy(rec.buf[:], r.content)
+	return nil
+}
@@ -0,0 +1,238 @@
+package gofast_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yookoala/gofast"
+	"github.com/yookoala/gofast/fcgitest"
+)
+
+// muxConnFactory connects to the server with net.Pipe, and sends
+// the application ends of the connections to the channel, if any. If
+// mpxs is not empty, FCGI_GET_VALUES is answered with it as
+// FCGI_MPXS_CONNS instead of by the server.
+func muxConnFactory(s *gofast.Server, mpxs string, appConns chan<- net.Conn) gofast.ConnFactory {
+	return func() (net.Conn, error) {
+		appConn, webConn := net.Pipe()
+		if appConns != nil {
+			appConns <- appConn
+		}
+		go func() {
+			var rwc io.ReadWriteCloser = appConn
+			if mpxs != "" {
+				// answer the FCGI_GET_VALUES, if it is the first
+				// record, else serve it with the others
+				h := make([]byte, 8)
+				if _, err := io.ReadFull(appConn, h); err != nil {
+					return
+				}
+				b := make([]byte, int(binary.BigEndian.Uint16(h[4:]))+int(h[6]))
+				if _, err := io.ReadFull(appConn, b); err != nil {
+					return
+				}
+				if h[1] == fcgitest.TypeGetValues {
+					content := "\x0f\x01FCGI_MPXS_CONNS" + mpxs
+					if _, err := appConn.Write(fcgitest.Record(fcgitest.TypeGetValuesResult, 0, []byte(content))); err != nil {
+						return
+					}
+				} else {
+					rwc = struct {
+						io.Reader
+						io.WriteCloser
+					}{io.MultiReader(bytes.NewReader(append(h, b...)), appConn), appConn}
+				}
+			}
+			s.ServeConn(rwc)
+		}()
+		return webConn, nil
+	}
+}
+
+// echoName responds with the param NAME
+func echoName(ctx context.Context, req *gofast.Request, stdout, stderr io.Writer) int {
+	fmt.Fprintf(stdout, "Content-Type: text/plain\r\n\r\n%s", req.Params["NAME"])
+	return 0
+}
+
+// doName sends a request of the param NAME with
+// the client, and returns the response body
+func doName(clientFactory gofast.ClientFactory, name string) (string, error) {
+	c, err := clientFactory()
+	if err != nil {
+		return "", err
+	}
+	defer c.Close()
+	req := gofast.NewRequest(httptest.NewRequest("GET", "/", nil))
+	req.Params["NAME"] = name
+	resp, err := c.Do(req)
+	if err != nil {
+		return "", err
+	}
+	w := httptest.NewRecorder()
+	resp.WriteTo(w, ioutil.Discard)
+	return w.Body.String(), resp.Err()
+}
+
+func TestMuxClientFactory(t *testing.T) {
+	const n = 10
+
+	// every request waits for all the others, so they
+	// must be served concurrently on the connection
+	var arrived sync.WaitGroup
+	arrived.Add(n)
+	s := gofast.NewServer(func(ctx context.Context, req *gofast.Request, stdout, stderr io.Writer) int {
+		arrived.Done()
+		arrived.Wait()
+		return echoName(ctx, req, stdout, stderr)
+	})
+	appConns := make(chan net.Conn, n)
+	clientFactory := gofast.MuxClientFactory(muxConnFactory(s, "", appConns))
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			if have, err := doName(clientFactory, name); err != nil || have != name {
+				t.Errorf("expected %#v, got %#v (error: %v)", name, have, err)
+			}
+		}(fmt.Sprintf("request %d", i))
+	}
+	wg.Wait()
+	if want, have := 1, len(appConns); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	// the connection is kept for the next clients
+	arrived.Add(n)
+	var wg2 sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg2.Add(1)
+		go func() {
+			defer wg2.Done()
+			if have, err := doName(clientFactory, "again"); err != nil || have != "again" {
+				t.Errorf("expected %#v, got %#v (error: %v)", "again", have, err)
+			}
+		}()
+	}
+	wg2.Wait()
+	if want, have := 1, len(appConns); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestMuxClientFactory_noMux(t *testing.T) {
+	s := gofast.NewServer(echoName)
+	appConns := make(chan net.Conn, 4)
+
+	// the application closes the connection of the
+	// FCGI_GET_VALUES after answering, as php-fpm does
+	clientFactory := gofast.MuxClientFactory(fcgitest.CloseOnGetValues(muxConnFactory(s, "", appConns)))
+	for _, name := range []string{"first", "second", "third"} {
+		if have, err := doName(clientFactory, name); err != nil || have != name {
+			t.Errorf("expected %#v, got %#v (error: %v)", name, have, err)
+		}
+	}
+
+	// the connection of the query, then a connection
+	// per client, as SimpleClientFactory
+	if want, have := 4, len(appConns); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestMuxClientFactory_reconnect(t *testing.T) {
+	s := gofast.NewServer(echoName)
+	appConns := make(chan net.Conn, 2)
+	clientFactory := gofast.MuxClientFactory(muxConnFactory(s, "", appConns))
+	if have, err := doName(clientFactory, "hello"); err != nil || have != "hello" {
+		t.Errorf("expected %#v, got %#v (error: %v)", "hello", have, err)
+	}
+
+	// the application closes the connection, and
+	// the next clients connect again
+	(<-appConns).Close()
+	var have string
+	var err error
+	for i := 0; i < 50; i++ {
+		if have, err = doName(clientFactory, "again"); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if want := "again"; want != have {
+		t.Errorf("expected %#v, got %#v (error: %v)", want, have, err)
+	}
+	if want, have := 1, len(appConns); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestMuxClientFactory_queueFull(t *testing.T) {
+
+	// the response of "large" is not read, and is
+	// aborted once its queue is full
+	aborted := make(chan struct{})
+	s := gofast.NewServer(func(ctx context.Context, req *gofast.Request, stdout, stderr io.Writer) int {
+		if req.Params["NAME"] != "large" {
+			return echoName(ctx, req, stdout, stderr)
+		}
+		fmt.Fprintf(stdout, "Content-Type: text/plain\r\n\r\n")
+		chunk := make([]byte, 64*1024)
+		timeout := time.After(10 * time.Second)
+		for {
+			select {
+			case <-ctx.Done():
+				close(aborted)
+				return 0
+			case <-timeout:
+				return 0
+			default:
+			}
+			stdout.Write(chunk)
+		}
+	})
+	clientFactory := gofast.MuxClientFactory(muxConnFactory(s, "", nil))
+	c, err := clientFactory()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer c.Close()
+	req := gofast.NewRequest(httptest.NewRequest("GET", "/", nil))
+	req.Params["NAME"] = "large"
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	select {
+	case <-aborted:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the request aborted")
+	}
+
+	// the other requests are not blocked
+	if have, err := doName(clientFactory, "hello"); err != nil || have != "hello" {
+		t.Errorf("expected %#v, got %#v (error: %v)", "hello", have, err)
+	}
+
+	// the queued response, and the record being written
+	// to the pipe, are read before the error
+	w := httptest.NewRecorder()
+	resp.WriteTo(w, ioutil.Discard)
+	if want, have := 4<<20+65535, w.Body.Len(); want < have || have == 0 {
+		t.Errorf("expected up to %d bytes, got %d", want, have)
+	}
+	if err := resp.Err(); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+}
@@ -0,0 +1,415 @@
+package gofast
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// NginxParam is a fastcgi_param directive of nginx.
+type NginxParam struct {
+
+	// Name of the fastcgi parameter
+	Name string
+
+	// Value of the parameter, which may contain $variables
+	Value string
+
+	// IfNotEmpty is true if the parameter should only be
+	// set when its value is not empty (i.e. "if_not_empty")
+	IfNotEmpty bool
+
+	segments []nginxSegment
+}
+
+// nginxSegment is either a literal string or a $variable in
+// the value of fastcgi_param
+type nginxSegment struct {
+	literal  string
+	variable string
+}
+
+// NginxParams holds the fastcgi related directives loaded from an nginx
+// config snippet (e.g. the fastcgi_params file shipped with nginx). See
+// ParseNginxParams for details.
+type NginxParams struct {
+
+	// DocRoot is the value of $document_root. Set by "root" directive,
+	// if presents.
+	DocRoot string
+
+	// SplitPathInfo is the regular expression set by
+	// "fastcgi_split_path_info" directive, if presents.
+	SplitPathInfo *regexp.Regexp
+
+	// Index is the file name set by "fastcgi_index" directive,
+	// if presents.
+	Index string
+
+	// Params are the fastcgi_param directives in the order of appearance
+	Params []NginxParam
+}
+
+// nginxVariables are the supported nginx variables and how they are
+// derived from the request
+var nginxVariables = map[string]func(r *http.Request, v *nginxVars) string{
+	"document_root":       func(r *http.Request, v *nginxVars) string { return v.docRoot },
+	"fastcgi_script_name": func(r *http.Request, v *nginxVars) string { return v.scriptName },
+	"fastcgi_path_info":   func(r *http.Request, v *nginxVars) string { return v.pathInfo },
+	"request_filename":    func(r *http.Request, v *nginxVars) string { return filepath.Join(v.docRoot, r.URL.Path) },
+	"uri":                 func(r *http.Request, v *nginxVars) string { return r.URL.Path },
+	"document_uri":        func(r *http.Request, v *nginxVars) string { return r.URL.Path },
+	"request_uri":         func(r *http.Request, v *nginxVars) string { return r.RequestURI },
+	"query_string":        func(r *http.Request, v *nginxVars) string { return r.URL.RawQuery },
+	"args":                func(r *http.Request, v *nginxVars) string { return r.URL.RawQuery },
+	"is_args":             func(r *http.Request, v *nginxVars) string { return v.isArgs(r) },
+	"request_method":      func(r *http.Request, v *nginxVars) string { return r.Method },
+	"content_type":        func(r *http.Request, v *nginxVars) string { return r.Header.Get("Content-Type") },
+	"content_length":      func(r *http.Request, v *nginxVars) string { return r.Header.Get("Content-Length") },
+	"server_protocol":     func(r *http.Request, v *nginxVars) string { return r.Proto },
+	"scheme":              func(r *http.Request, v *nginxVars) string { return v.scheme(r) },
+	"https":               func(r *http.Request, v *nginxVars) string { return v.https(r) },
+	"host":                func(r *http.Request, v *nginxVars) string { return v.host },
+	"server_name":         func(r *http.Request, v *nginxVars) string { return v.host },
+	"server_addr":         func(r *http.Request, v *nginxVars) string { return v.serverAddr },
+	"server_port":         func(r *http.Request, v *nginxVars) string { return v.serverPort },
+	"remote_addr":         func(r *http.Request, v *nginxVars) string { return v.remoteAddr },
+	"remote_port":         func(r *http.Request, v *nginxVars) string { return v.remotePort },
+	"nginx_version":       func(r *http.Request, v *nginxVars) string { return "gofast" },
+}
+
+// nginxVars holds the values derived from a request for
+// nginx variables interpolation
+type nginxVars struct {
+	docRoot    string
+	scriptName string
+	pathInfo   string
+	host       string
+	serverAddr string
+	serverPort string
+	remoteAddr string
+	remotePort string
+}
+
+func (v *nginxVars) isArgs(r *http.Request) string {
+	if r.URL.RawQuery != "" {
+		return "?"
+	}
+	return ""
+}
+
+func (v *nginxVars) scheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+func (v *nginxVars) https(r *http.Request) string {
+	if r.TLS != nil {
+		return "on"
+	}
+	return ""
+}
+
+// isNginxVariable checks if the variable name is supported
+func isNginxVariable(name string) bool {
+	if _, ok := nginxVariables[name]; ok {
+		return true
+	}
+	return strings.HasPrefix(name, "http_") && len(name) > len("http_")
+}
+
+// LoadNginxParams loads the nginx config snippet from the file.
+// See ParseNginxParams for details.
+func LoadNginxParams(filename string) (*NginxParams, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseNginxParams(f)
+}
+
+// ParseNginxParams parses an nginx config snippet, such as the fastcgi_params
+// file, into NginxParams. The snippet should only contain simple directives
+// (i.e. no block). The directives understood are:
+//
+//  fastcgi_param NAME value [if_not_empty];
+//  fastcgi_split_path_info regex;
+//  fastcgi_index file;
+//  root path;
+//
+// Other directives (e.g. fastcgi_pass, include) are ignored. The values of
+// fastcgi_param may contain these nginx variables: $document_root,
+// $fastcgi_script_name, $fastcgi_path_info, $request_filename, $uri,
+// $document_uri, $request_uri, $query_string, $args, $is_args,
+// $request_method, $content_type, $content_length, $server_protocol,
+// $scheme, $https, $host, $server_name, $server_addr, $server_port,
+// $remote_addr, $remote_port, $nginx_version and $http_* (request header).
+// Any other variable results in error.
+func ParseNginxParams(r io.Reader) (np *NginxParams, err error) {
+	np = &NginxParams{}
+	statements, err := readNginxStatements(r)
+	if err != nil {
+		return nil, err
+	}
+	for _, stmt := range statements {
+		args := stmt.args
+		switch args[0] {
+		case "fastcgi_param":
+			if len(args) < 3 || len(args) > 4 || (len(args) == 4 && args[3] != "if_not_empty") {
+				return nil, fmt.Errorf("gofast: invalid fastcgi_param directive on line %d", stmt.line)
+			}
+			param := NginxParam{
+				Name:       args[1],
+				Value:      args[2],
+				IfNotEmpty: len(args) == 4,
+			}
+			if param.segments, err = parseNginxValue(param.Value); err != nil {
+				return nil, fmt.Errorf("gofast: %s on line %d", err, stmt.line)
+			}
+			np.Params = append(np.Params, param)
+		case "fastcgi_split_path_info":
+			if len(args) != 2 {
+				return nil, fmt.Errorf("gofast: invalid fastcgi_split_path_info directive on line %d", stmt.line)
+			}
+			if np.SplitPathInfo, err = regexp.Compile(args[1]); err != nil {
+				return nil, fmt.Errorf("gofast: invalid fastcgi_split_path_info on line %d: %s", stmt.line, err)
+			}
+		case "fastcgi_index":
+			if len(args) != 2 {
+				return nil, fmt.Errorf("gofast: invalid fastcgi_index directive on line %d", stmt.line)
+			}
+			np.Index = args[1]
+		case "root":
+			if len(args) != 2 {
+				return nil, fmt.Errorf("gofast: invalid root directive on line %d", stmt.line)
+			}
+			np.DocRoot = args[1]
+		}
+	}
+	return np, nil
+}
+
+// nginxStatement is a simple directive in nginx config
+type nginxStatement struct {
+	line int
+	args []string
+}
+
+// readNginxStatements tokenizes the nginx config into statements
+func readNginxStatements(r io.Reader) (statements []nginxStatement, err error) {
+	br := bufio.NewReader(r)
+	line := 1
+	var args []string
+	var token []byte
+	var inToken bool
+	var quote byte
+	var stmtLine int
+
+	endToken := func() {
+		if inToken {
+			if len(args) == 0 {
+				stmtLine = line
+			}
+			args = append(args, string(token))
+		}
+		token, inToken = token[:0], false
+	}
+
+	for {
+		c, rerr := br.ReadByte()
+		if rerr == io.EOF {
+			break
+		} else if rerr != nil {
+			return nil, rerr
+		}
+
+		if quote != 0 {
+			switch c {
+			case quote:
+				quote = 0
+			case '\\':
+				next, rerr := br.ReadByte()
+				if rerr != nil {
+					return nil, fmt.Errorf("gofast: unexpected end of quoted string on line %d", line)
+				}
+				if next != quote && next != '\\' {
+					token = append(token, c)
+				}
+				token = append(token, next)
+			default:
+				if c == '\n' {
+					line++
+				}
+				token = append(token, c)
+			}
+			continue
+		}
+
+		switch c {
+		case '#':
+			endToken()
+			if _, rerr := br.ReadString('\n'); rerr != nil && rerr != io.EOF {
+				return nil, rerr
+			}
+			line++
+		case ' ', '\t', '\r', '\n':
+			endToken()
+			if c == '\n' {
+				line++
+			}
+		case '"', '\'':
+			quote, inToken = c, true
+		case ';':
+			endToken()
+			if len(args) == 0 {
+				return nil, fmt.Errorf("gofast: unexpected \";\" on line %d", line)
+			}
+			statements = append(statements, nginxStatement{line: stmtLine, args: args})
+			args = nil
+		case '{', '}':
+			return nil, fmt.Errorf("gofast: unexpected %q on line %d, blocks are not supported", c, line)
+		default:
+			token, inToken = append(token, c), true
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("gofast: unexpected end of quoted string on line %d", line)
+	}
+	endToken()
+	if len(args) > 0 {
+		return nil, fmt.Errorf("gofast: unexpected end of file, expecting \";\" on line %d", line)
+	}
+	return
+}
+
+// parseNginxValue parses a value with nginx variables into segments
+func parseNginxValue(value string) (segments []nginxSegment, err error) {
+	for len(value) > 0 {
+		i := strings.IndexByte(value, '$')
+		if i < 0 {
+			segments = append(segments, nginxSegment{literal: value})
+			break
+		}
+		if i > 0 {
+			segments = append(segments, nginxSegment{literal: value[:i]})
+		}
+		value = value[i+1:]
+
+		var name string
+		if strings.HasPrefix(value, "{") {
+			end := strings.IndexByte(value, '}')
+			if end < 0 {
+				return nil, fmt.Errorf("missing closing bracket of variable")
+			}
+			name, value = value[1:end], value[end+1:]
+		} else {
+			end := 0
+			for end < len(value) && isNginxVariableChar(value[end]) {
+				end++
+			}
+			name, value = value[:end], value[end:]
+		}
+		if name == "" {
+			return nil, fmt.Errorf("invalid variable name")
+		}
+		if !isNginxVariable(name) {
+			return nil, fmt.Errorf("unsupported variable \"$%s\"", name)
+		}
+		segments = append(segments, nginxSegment{variable: name})
+	}
+	return
+}
+
+func isNginxVariableChar(c byte) bool {
+	return c == '_' ||
+		(c >= 'a' && c <= 'z') ||
+		(c >= 'A' && c <= 'Z') ||
+		(c >= '0' && c <= '9')
+}
+
+// vars derives the nginx variables values from the request
+func (np *NginxParams) vars(r *http.Request) *nginxVars {
+	v := &nginxVars{
+		docRoot:    np.DocRoot,
+		scriptName: r.URL.Path,
+	}
+	if np.SplitPathInfo != nil {
+		if matches := np.SplitPathInfo.FindStringSubmatch(r.URL.Path); len(matches) > 2 {
+			v.scriptName, v.pathInfo = matches[1], matches[2]
+		}
+	}
+	if np.Index != "" && strings.HasSuffix(v.scriptName, "/") {
+		v.scriptName = path.Join(v.scriptName, np.Index)
+	}
+
+	v.remoteAddr, v.remotePort, _ = net.SplitHostPort(r.RemoteAddr)
+	host, port, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+		if r.TLS != nil {
+			port = "443"
+		} else {
+			port = "80"
+		}
+	}
+	v.host, v.serverPort = host, port
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		if serverAddr, serverPort, err := net.SplitHostPort(addr.String()); err == nil {
+			v.serverAddr, v.serverPort = serverAddr, serverPort
+		}
+	}
+	return v
+}
+
+// expand interpolates the variables of the param value
+func (p *NginxParam) expand(r *http.Request, v *nginxVars) string {
+	segments := p.segments
+	if segments == nil {
+		// param not created by ParseNginxParams
+		segments, _ = parseNginxValue(p.Value)
+	}
+	var buf bytes.Buffer
+	for _, seg := range segments {
+		if seg.variable == "" {
+			buf.WriteString(seg.literal)
+		} else if fn, ok := nginxVariables[seg.variable]; ok {
+			buf.WriteString(fn(r, v))
+		} else if strings.HasPrefix(seg.variable, "http_") {
+			name := strings.Replace(seg.variable[len("http_"):], "_", "-", -1)
+			buf.WriteString(strings.Join(r.Header[http.CanonicalHeaderKey(name)], ","))
+		}
+	}
+	return buf.String()
+}
+
+// Middleware returns a Middleware that maps the fastcgi parameters
+// as the loaded fastcgi_param directives do in nginx. Parameters are
+// set in the order of the directives, so later ones take precedence.
+func (np *NginxParams) Middleware() Middleware {
+	return func(inner SessionHandler) SessionHandler {
+		return func(client Client, req *Request) (*ResponsePipe, error) {
+			r := req.Raw
+			v := np.vars(r)
+			for i := range np.Params {
+				value := np.Params[i].expand(r, v)
+				if value == "" && np.Params[i].IfNotEmpty {
+					continue
+				}
+				req.Params[np.Params[i].Name] = value
+			}
+			return inner(client, req)
+		}
+	}
+}
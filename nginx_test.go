@@ -0,0 +1,230 @@
+package gofast_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yookoala/gofast"
+)
+
+const nginxFastCGIParams = `
+# fastcgi_params shipped with nginx (trimmed)
+fastcgi_param  QUERY_STRING       $query_string;
+fastcgi_param  REQUEST_METHOD     $request_method;
+fastcgi_param  CONTENT_TYPE       $content_type;
+fastcgi_param  CONTENT_LENGTH     $content_length;
+
+fastcgi_param  SCRIPT_NAME        $fastcgi_script_name;
+fastcgi_param  REQUEST_URI        $request_uri;
+fastcgi_param  DOCUMENT_URI       $document_uri;
+fastcgi_param  DOCUMENT_ROOT      $document_root;
+fastcgi_param  SERVER_PROTOCOL    $server_protocol;
+fastcgi_param  REQUEST_SCHEME     $scheme;
+fastcgi_param  HTTPS              $https if_not_empty;
+
+fastcgi_param  GATEWAY_INTERFACE  CGI/1.1;
+fastcgi_param  SERVER_SOFTWARE    nginx/$nginx_version;
+
+fastcgi_param  REMOTE_ADDR        $remote_addr;
+fastcgi_param  REMOTE_PORT        $remote_port;
+fastcgi_param  SERVER_ADDR        $server_addr;
+fastcgi_param  SERVER_PORT        $server_port;
+fastcgi_param  SERVER_NAME        $server_name;
+
+# PHP only, required if PHP was built with --enable-force-cgi-redirect
+fastcgi_param  REDIRECT_STATUS    200;
+
+# from the location block
+root /var/www/html;
+fastcgi_pass unix:/run/php/php-fpm.sock;
+fastcgi_index index.php;
+fastcgi_split_path_info ^(.+\.php)(/.+)$;
+fastcgi_param  SCRIPT_FILENAME    $document_root$fastcgi_script_name;
+fastcgi_param  PATH_INFO          $fastcgi_path_info;
+fastcgi_param  HTTP_PROXY         "";
+fastcgi_param  X_FORWARDED        "${http_x_forwarded_for} (via gofast)";
+`
+
+func TestParseNginxParams(t *testing.T) {
+	np, err := gofast.ParseNginxParams(strings.NewReader(nginxFastCGIParams))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want, have := "/var/www/html", np.DocRoot; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "index.php", np.Index; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if np.SplitPathInfo == nil {
+		t.Errorf("expected SplitPathInfo, got nil")
+	}
+	if want, have := 23, len(np.Params); want != have {
+		t.Fatalf("expected %#v, got %#v", want, have)
+	}
+	if want, have := (gofast.NginxParam{Name: "HTTPS", Value: "$https", IfNotEmpty: true}), np.Params[10]; want.Name != have.Name ||
+		want.Value != have.Value || want.IfNotEmpty != have.IfNotEmpty {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestParseNginxParams_errors(t *testing.T) {
+	tests := []struct {
+		desc   string
+		config string
+		want   string
+	}{
+		{
+			desc:   "unsupported variable",
+			config: "fastcgi_param FOO $foo;",
+			want:   "gofast: unsupported variable \"$foo\" on line 1",
+		},
+		{
+			desc:   "missing semicolon",
+			config: "fastcgi_param FOO bar;\nfastcgi_param BAR foo",
+			want:   "gofast: unexpected end of file, expecting \";\" on line 2",
+		},
+		{
+			desc:   "block",
+			config: "location / {\n}",
+			want:   "gofast: unexpected '{' on line 1, blocks are not supported",
+		},
+		{
+			desc:   "invalid flag",
+			config: "\n\nfastcgi_param FOO bar if_empty;",
+			want:   "gofast: invalid fastcgi_param directive on line 3",
+		},
+		{
+			desc:   "unclosed quote",
+			config: "fastcgi_param FOO \"bar;",
+			want:   "gofast: unexpected end of quoted string on line 1",
+		},
+	}
+	for _, test := range tests {
+		_, err := gofast.ParseNginxParams(strings.NewReader(test.config))
+		if err == nil {
+			t.Errorf("%s: expected error, got nil", test.desc)
+			continue
+		}
+		if want, have := test.want, err.Error(); want != have {
+			t.Errorf("%s: expected %#v, got %#v", test.desc, want, have)
+		}
+	}
+}
+
+func TestLoadNginxParams(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gofast-nginx-")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "fastcgi_params")
+	if err := ioutil.WriteFile(filename, []byte(nginxFastCGIParams), 0644); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	np, err := gofast.LoadNginxParams(filename)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want, have := 23, len(np.Params); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	if _, err := gofast.LoadNginxParams(filepath.Join(dir, "not-exists")); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+}
+
+func TestNginxParams_Middleware(t *testing.T) {
+	np, err := gofast.ParseNginxParams(strings.NewReader(nginxFastCGIParams))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	r, _ := http.NewRequest("POST", "http://foobar.com:8080/app/index.php/hello/world?foo=bar", strings.NewReader("a=b"))
+	r.RequestURI = "/app/index.php/hello/world?foo=bar"
+	r.RemoteAddr = "192.168.0.1:56324"
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("Content-Length", "3")
+	r.Header.Set("X-Forwarded-For", "10.0.0.2")
+	r = r.WithContext(context.WithValue(r.Context(), http.LocalAddrContextKey,
+		&net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 8080}))
+
+	var params map[string]string
+	inner := func(client gofast.Client, req *gofast.Request) (resp *gofast.ResponsePipe, err error) {
+		params = req.Params
+		return
+	}
+	if _, err := np.Middleware()(inner)(nil, gofast.NewRequest(r)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	wants := map[string]string{
+		"QUERY_STRING":      "foo=bar",
+		"REQUEST_METHOD":    "POST",
+		"CONTENT_TYPE":      "application/x-www-form-urlencoded",
+		"CONTENT_LENGTH":    "3",
+		"SCRIPT_NAME":       "/app/index.php",
+		"REQUEST_URI":       "/app/index.php/hello/world?foo=bar",
+		"DOCUMENT_URI":      "/app/index.php/hello/world",
+		"DOCUMENT_ROOT":     "/var/www/html",
+		"SERVER_PROTOCOL":   "HTTP/1.1",
+		"REQUEST_SCHEME":    "http",
+		"GATEWAY_INTERFACE": "CGI/1.1",
+		"SERVER_SOFTWARE":   "nginx/gofast",
+		"REMOTE_ADDR":       "192.168.0.1",
+		"REMOTE_PORT":       "56324",
+		"SERVER_ADDR":       "10.0.0.1",
+		"SERVER_PORT":       "8080",
+		"SERVER_NAME":       "foobar.com",
+		"REDIRECT_STATUS":   "200",
+		"SCRIPT_FILENAME":   "/var/www/html/app/index.php",
+		"PATH_INFO":         "/hello/world",
+		"HTTP_PROXY":        "",
+		"X_FORWARDED":       "10.0.0.2 (via gofast)",
+	}
+	for name, want := range wants {
+		if have, ok := params[name]; !ok {
+			t.Errorf("expected param %s, not found", name)
+		} else if want != have {
+			t.Errorf("%s: expected %#v, got %#v", name, want, have)
+		}
+	}
+	if have, ok := params["HTTPS"]; ok {
+		t.Errorf("expected HTTPS not set, got %#v", have)
+	}
+}
+
+func TestNginxParams_Middleware_index(t *testing.T) {
+	np, err := gofast.ParseNginxParams(strings.NewReader(nginxFastCGIParams))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	np.DocRoot = "/srv/www"
+
+	r, _ := http.NewRequest("GET", "http://foobar.com/blog/", nil)
+	var params map[string]string
+	inner := func(client gofast.Client, req *gofast.Request) (resp *gofast.ResponsePipe, err error) {
+		params = req.Params
+		return
+	}
+	if _, err := np.Middleware()(inner)(nil, gofast.NewRequest(r)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want, have := "/blog/index.php", params["SCRIPT_NAME"]; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "/srv/www/blog/index.php", params["SCRIPT_FILENAME"]; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "80", params["SERVER_PORT"]; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
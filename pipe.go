@@ -0,0 +1,22 @@
+package gofast
+
+import (
+	"errors"
+)
+
+// ErrNamedPipeUnsupported is returned by NamedPipeConnFactory and
+// ListenNamedPipe on platforms other than Windows.
+var ErrNamedPipeUnsupported = errors.New("gofast: named pipe is only supported on Windows")
+
+// pipeAddr is the net.Addr of a named pipe
+type pipeAddr string
+
+// Network implements net.Addr
+func (a pipeAddr) Network() string {
+	return "pipe"
+}
+
+// String implements net.Addr
+func (a pipeAddr) String() string {
+	return string(a)
+}
@@ -0,0 +1,28 @@
+//go:build !windows
+// +build !windows
+
+package gofast
+
+import (
+	"net"
+)
+
+// NamedPipeConnFactory returns a ConnFactory that connects to the
+// Windows named pipe (e.g. `\\.\pipe\php-fcgi`).
+//
+// Not supported on this platform. The connections always
+// fail with ErrNamedPipeUnsupported.
+func NamedPipeConnFactory(name string) ConnFactory {
+	return func() (net.Conn, error) {
+		return nil, ErrNamedPipeUnsupported
+	}
+}
+
+// ListenNamedPipe creates a net.Listener on the Windows named pipe
+// (e.g. `\\.\pipe\gofast`) for Server.Serve.
+//
+// Not supported on this platform. Always returns
+// ErrNamedPipeUnsupported.
+func ListenNamedPipe(name string) (net.Listener, error) {
+	return nil, ErrNamedPipeUnsupported
+}
@@ -0,0 +1,19 @@
+//go:build !windows
+// +build !windows
+
+package gofast_test
+
+import (
+	"testing"
+
+	"github.com/yookoala/gofast"
+)
+
+func TestNamedPipe_unsupported(t *testing.T) {
+	if _, err := gofast.ListenNamedPipe(`\\.\pipe\gofast-test`); err != gofast.ErrNamedPipeUnsupported {
+		t.Errorf("expected %#v, got %#v", gofast.ErrNamedPipeUnsupported, err)
+	}
+	if _, err := gofast.NamedPipeConnFactory(`\\.\pipe\gofast-test`)(); err != gofast.ErrNamedPipeUnsupported {
+		t.Errorf("expected %#v, got %#v", gofast.ErrNamedPipeUnsupported, err)
+	}
+}
@@ -0,0 +1,539 @@
+//go:build windows
+// +build windows
+
+package gofast
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+var (
+	modkernel32 = syscall.NewLazyDLL("kernel32.dll")
+
+	procCreateNamedPipeW    = modkernel32.NewProc("CreateNamedPipeW")
+	procConnectNamedPipe    = modkernel32.NewProc("ConnectNamedPipe")
+	procDisconnectNamedPipe = modkernel32.NewProc("DisconnectNamedPipe")
+	procWaitNamedPipeW      = modkernel32.NewProc("WaitNamedPipeW")
+)
+
+// constants of the named pipe API, see
+// https://docs.microsoft.com/en-us/windows/win32/api/winbase/nf-winbase-createnamedpipea
+const (
+	pipeAccessDuplex          = 0x00000003
+	pipeTypeByte              = 0x00000000
+	pipeReadmodeByte          = 0x00000000
+	pipeWait                  = 0x00000000
+	pipeUnlimitedInstances    = 255
+	pipeBufferSize            = 65536
+	fileFlagFirstPipeInstance = 0x00080000
+	fileFlagOverlapped        = 0x40000000
+	nmpwaitUseDefaultWait     = 0x00000000
+	errorPipeBusy             = syscall.Errno(231)
+	errorPipeConnected        = syscall.Errno(535)
+	errorNoData               = syscall.Errno(232)
+	errorBrokenPipe           = syscall.Errno(109)
+	errorPipeNotConnected     = syscall.Errno(233)
+	errorOperationAborted     = syscall.Errno(995)
+	errorIOPending            = syscall.Errno(997)
+)
+
+var (
+	errPipeListenerClosed       = errors.New("gofast: use of closed named pipe listener")
+	errPipeClosed               = errors.New("gofast: use of closed named pipe")
+	errPipeTimeout        error = pipeTimeoutError{}
+)
+
+// pipeTimeoutError is the net.Error of I/O past the deadline
+type pipeTimeoutError struct{}
+
+func (pipeTimeoutError) Error() string   { return "gofast: named pipe i/o timeout" }
+func (pipeTimeoutError) Timeout() bool   { return true }
+func (pipeTimeoutError) Temporary() bool { return true }
+
+// ioCompletionPort is the I/O completion port of all the named
+// pipe handles, created on first use
+var ioCompletionPort struct {
+	once sync.Once
+	h    syscall.Handle
+	err  error
+}
+
+// getIOCompletionPort returns the I/O completion port, and starts
+// the goroutine to dispatch the completed operations
+func getIOCompletionPort() (syscall.Handle, error) {
+	ioCompletionPort.once.Do(func() {
+		h, err := syscall.CreateIoCompletionPort(syscall.InvalidHandle, 0, 0, 0xffffffff)
+		ioCompletionPort.h, ioCompletionPort.err = h, err
+		if err == nil {
+			go ioCompletionLoop(h)
+		}
+	})
+	return ioCompletionPort.h, ioCompletionPort.err
+}
+
+// ioCompletionLoop passes the result of every completed
+// operation to the goroutine waiting for it
+func ioCompletionLoop(port syscall.Handle) {
+	for {
+		var n, key uint32
+		var o *syscall.Overlapped
+		err := syscall.GetQueuedCompletionStatus(port, &n, &key, &o, syscall.INFINITE)
+		if o == nil {
+			// the port itself fails, which should not happen
+			return
+		}
+		op := (*ioOperation)(unsafe.Pointer(o))
+		op.result <- ioResult{n: n, err: err}
+	}
+}
+
+// ioOperation is an overlapped I/O operation. The Overlapped must be
+// the first field, so the operation is found by it on completion.
+type ioOperation struct {
+	o      syscall.Overlapped
+	result chan ioResult
+}
+
+// ioResult is the result of a completed ioOperation
+type ioResult struct {
+	n   uint32
+	err error
+}
+
+// deadline is the deadline of either reads or writes of a pipeFile.
+// The channel is closed once the deadline is passed.
+type deadline struct {
+	mutex   sync.Mutex
+	ch      chan struct{}
+	expired bool
+	timer   *time.Timer
+	gen     int
+}
+
+// set sets the deadline, or no deadline if t is zero
+func (d *deadline) set(t time.Time) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.gen++
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+	if d.ch == nil || d.expired {
+		d.ch, d.expired = make(chan struct{}), false
+	}
+	if t.IsZero() {
+		return
+	}
+	dur := t.Sub(time.Now())
+	if dur <= 0 {
+		d.expire()
+		return
+	}
+	gen := d.gen
+	d.timer = time.AfterFunc(dur, func() {
+		d.mutex.Lock()
+		defer d.mutex.Unlock()
+		if d.gen == gen {
+			d.expire()
+		}
+	})
+}
+
+func (d *deadline) expire() {
+	if !d.expired {
+		d.expired = true
+		close(d.ch)
+	}
+}
+
+// passed reports whether the deadline is passed
+func (d *deadline) passed() bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.expired
+}
+
+// done returns the channel closed on the deadline
+func (d *deadline) done() <-chan struct{} {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.ch == nil {
+		d.ch = make(chan struct{})
+	}
+	return d.ch
+}
+
+// pipeFile does overlapped I/O on a named pipe handle, so the reads and
+// writes on it run concurrently (the synchronous I/O on a handle is
+// serialized by Windows), and are canceled on the deadlines or Close.
+type pipeFile struct {
+	h syscall.Handle
+
+	// mutex is held for read from preparing to starting an
+	// operation, so Close would cancel every started one
+	mutex   sync.RWMutex
+	wg      sync.WaitGroup
+	closing bool
+
+	readDeadline, writeDeadline deadline
+}
+
+// newPipeFile associates the handle with the I/O completion port
+func newPipeFile(h syscall.Handle) (*pipeFile, error) {
+	port, err := getIOCompletionPort()
+	if err == nil {
+		_, err = syscall.CreateIoCompletionPort(h, port, 0, 0xffffffff)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &pipeFile{h: h}, nil
+}
+
+// prepare returns a new operation, with the mutex held
+// for read. The caller starts the operation and unlocks.
+func (f *pipeFile) prepare() (*ioOperation, error) {
+	f.mutex.RLock()
+	if f.closing {
+		f.mutex.RUnlock()
+		return nil, errPipeClosed
+	}
+	f.wg.Add(1)
+	return &ioOperation{result: make(chan ioResult, 1)}, nil
+}
+
+// wait waits for the operation started with the given error. The
+// operation is canceled once the deadline, if any, is passed.
+func (f *pipeFile) wait(op *ioOperation, err error, d *deadline) (int, error) {
+	defer f.wg.Done()
+	if err != nil && err != errorIOPending {
+		// failed to start, nothing will complete
+		return 0, err
+	}
+
+	var done <-chan struct{}
+	if d != nil {
+		done = d.done()
+	}
+	var r ioResult
+	select {
+	case r = <-op.result:
+	case <-done:
+		syscall.CancelIoEx(f.h, &op.o)
+		if r = <-op.result; r.err == errorOperationAborted {
+			r.err = errPipeTimeout
+		}
+	}
+	if r.err == errorOperationAborted {
+		r.err = errPipeClosed
+	}
+	return int(r.n), r.err
+}
+
+// Read implements io.Reader
+func (f *pipeFile) Read(p []byte) (int, error) {
+	if f.readDeadline.passed() {
+		return 0, errPipeTimeout
+	}
+	op, err := f.prepare()
+	if err != nil {
+		return 0, err
+	}
+	var n uint32
+	err = syscall.ReadFile(f.h, p, &n, &op.o)
+	f.mutex.RUnlock()
+	return f.wait(op, err, &f.readDeadline)
+}
+
+// Write implements io.Writer
+func (f *pipeFile) Write(p []byte) (int, error) {
+	if f.writeDeadline.passed() {
+		return 0, errPipeTimeout
+	}
+	op, err := f.prepare()
+	if err != nil {
+		return 0, err
+	}
+	var n uint32
+	err = syscall.WriteFile(f.h, p, &n, &op.o)
+	f.mutex.RUnlock()
+	written, err := f.wait(op, err, &f.writeDeadline)
+	if err == nil && written < len(p) {
+		err = io.ErrShortWrite
+	}
+	return written, err
+}
+
+// connect waits for a client to connect to the
+// server instance, until the pipeFile is closed
+func (f *pipeFile) connect() error {
+	op, err := f.prepare()
+	if err != nil {
+		return err
+	}
+	r, _, err := procConnectNamedPipe.Call(uintptr(f.h), uintptr(unsafe.Pointer(&op.o)))
+	f.mutex.RUnlock()
+	if r != 0 {
+		err = nil
+	}
+	_, err = f.wait(op, err, nil)
+	if err == errorPipeConnected || err == errorNoData {
+		// ERROR_NO_DATA: client connected and closed before this call.
+		// The error will be found on first read / write.
+		return nil
+	}
+	return err
+}
+
+// Close cancels the pending operations and closes the handle
+func (f *pipeFile) Close() error {
+	f.mutex.Lock()
+	if f.closing {
+		f.mutex.Unlock()
+		return errPipeClosed
+	}
+	f.closing = true
+	syscall.CancelIoEx(f.h, nil)
+	f.mutex.Unlock()
+
+	f.wg.Wait()
+	return syscall.CloseHandle(f.h)
+}
+
+// SetDeadline implements net.Conn
+func (f *pipeFile) SetDeadline(t time.Time) error {
+	f.readDeadline.set(t)
+	f.writeDeadline.set(t)
+	return nil
+}
+
+// SetReadDeadline implements net.Conn
+func (f *pipeFile) SetReadDeadline(t time.Time) error {
+	f.readDeadline.set(t)
+	return nil
+}
+
+// SetWriteDeadline implements net.Conn
+func (f *pipeFile) SetWriteDeadline(t time.Time) error {
+	f.writeDeadline.set(t)
+	return nil
+}
+
+func createNamedPipe(name string, first bool) (*pipeFile, error) {
+	p, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return nil, err
+	}
+	mode := uint32(pipeAccessDuplex | fileFlagOverlapped)
+	if first {
+		mode |= fileFlagFirstPipeInstance
+	}
+	r, _, err := procCreateNamedPipeW.Call(
+		uintptr(unsafe.Pointer(p)),
+		uintptr(mode),
+		uintptr(pipeTypeByte|pipeReadmodeByte|pipeWait),
+		uintptr(pipeUnlimitedInstances),
+		uintptr(pipeBufferSize),
+		uintptr(pipeBufferSize),
+		0,
+		0,
+	)
+	h := syscall.Handle(r)
+	if h == syscall.InvalidHandle {
+		return nil, err
+	}
+	f, err := newPipeFile(h)
+	if err != nil {
+		syscall.CloseHandle(h)
+	}
+	return f, err
+}
+
+func waitNamedPipe(name string) error {
+	p, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return err
+	}
+	if r, _, err := procWaitNamedPipeW.Call(uintptr(unsafe.Pointer(p)), nmpwaitUseDefaultWait); r == 0 {
+		return err
+	}
+	return nil
+}
+
+func openNamedPipe(name string) (*pipeFile, error) {
+	p, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		h, err := syscall.CreateFile(
+			p,
+			syscall.GENERIC_READ|syscall.GENERIC_WRITE,
+			0,
+			nil,
+			syscall.OPEN_EXISTING,
+			fileFlagOverlapped,
+			0,
+		)
+		if err == nil {
+			f, err := newPipeFile(h)
+			if err != nil {
+				syscall.CloseHandle(h)
+			}
+			return f, err
+		}
+		if err != errorPipeBusy {
+			return nil, err
+		}
+		// all instances are busy, wait for one
+		if err = waitNamedPipe(name); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// pipeConn implements net.Conn with a named pipe opened for
+// overlapped I/O, which supports deadlines.
+type pipeConn struct {
+	*pipeFile
+	addr   pipeAddr
+	server bool
+}
+
+// LocalAddr implements net.Conn
+func (c *pipeConn) LocalAddr() net.Addr {
+	return c.addr
+}
+
+// RemoteAddr implements net.Conn
+func (c *pipeConn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+// Read implements net.Conn. Broken pipe is reported as io.EOF.
+func (c *pipeConn) Read(p []byte) (int, error) {
+	n, err := c.pipeFile.Read(p)
+	if err == errorBrokenPipe || err == errorPipeNotConnected {
+		return n, io.EOF
+	}
+	return n, err
+}
+
+// Close implements net.Conn. The server side flushes the
+// written data and disconnect the client before closing.
+func (c *pipeConn) Close() error {
+	if c.server {
+		syscall.FlushFileBuffers(c.h)
+		procDisconnectNamedPipe.Call(uintptr(c.h))
+	}
+	return c.pipeFile.Close()
+}
+
+// NamedPipeConnFactory returns a ConnFactory that connects to the
+// Windows named pipe (e.g. `\\.\pipe\php-fcgi`). If all instances of
+// the pipe are busy, it waits for the default timeout of the pipe.
+func NamedPipeConnFactory(name string) ConnFactory {
+	return func() (net.Conn, error) {
+		f, err := openNamedPipe(name)
+		if err != nil {
+			return nil, &net.OpError{Op: "dial", Net: "pipe", Addr: pipeAddr(name), Err: err}
+		}
+		return &pipeConn{
+			pipeFile: f,
+			addr:     pipeAddr(name),
+		}, nil
+	}
+}
+
+// ListenNamedPipe creates a net.Listener on the Windows named pipe
+// (e.g. `\\.\pipe\gofast`) for Server.Serve. Returns error if the pipe
+// of the name already exists.
+func ListenNamedPipe(name string) (net.Listener, error) {
+	f, err := createNamedPipe(name, true)
+	if err != nil {
+		return nil, &net.OpError{Op: "listen", Net: "pipe", Addr: pipeAddr(name), Err: err}
+	}
+	return &pipeListener{
+		name: name,
+		next: f,
+	}, nil
+}
+
+// pipeListener implements net.Listener for named pipe
+type pipeListener struct {
+	name string
+
+	mutex     sync.Mutex
+	next      *pipeFile
+	accepting bool
+	closed    bool
+}
+
+// Accept implements net.Listener
+func (l *pipeListener) Accept() (net.Conn, error) {
+	l.mutex.Lock()
+	if l.closed {
+		l.mutex.Unlock()
+		return nil, errPipeListenerClosed
+	}
+	if l.accepting {
+		l.mutex.Unlock()
+		return nil, errors.New("gofast: concurrent Accept on named pipe listener")
+	}
+	f := l.next
+	l.accepting = true
+	l.mutex.Unlock()
+
+	// blocks until a client connects, or the
+	// instance is closed by the listener Close
+	err := f.connect()
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.accepting = false
+	if l.closed {
+		return nil, errPipeListenerClosed
+	}
+	if err != nil {
+		return nil, &net.OpError{Op: "accept", Net: "pipe", Addr: pipeAddr(l.name), Err: err}
+	}
+
+	// prepare the instance for the next client before
+	// returning the connected one
+	if l.next, err = createNamedPipe(l.name, false); err != nil {
+		l.closed = true
+		f.Close()
+		return nil, &net.OpError{Op: "accept", Net: "pipe", Addr: pipeAddr(l.name), Err: err}
+	}
+	return &pipeConn{
+		pipeFile: f,
+		addr:     pipeAddr(l.name),
+		server:   true,
+	}, nil
+}
+
+// Close implements net.Listener. The pending Accept, if any,
+// is canceled and returns error.
+func (l *pipeListener) Close() error {
+	l.mutex.Lock()
+	if l.closed {
+		l.mutex.Unlock()
+		return errPipeListenerClosed
+	}
+	l.closed = true
+	f := l.next
+	l.mutex.Unlock()
+	return f.Close()
+}
+
+// Addr implements net.Listener
+func (l *pipeListener) Addr() net.Addr {
+	return pipeAddr(l.name)
+}
@@ -0,0 +1,166 @@
+//go:build windows
+// +build windows
+
+package gofast_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/yookoala/gofast"
+)
+
+func TestNamedPipe(t *testing.T) {
+	name := `\\.\pipe\gofast-test`
+	l, err := gofast.ListenNamedPipe(name)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	s := gofast.NewServer(func(ctx context.Context, req *gofast.Request, stdout, stderr io.Writer) int {
+		fmt.Fprintf(stdout, "Content-Type: text/plain\r\n\r\n")
+		fmt.Fprintf(stdout, "hello %s", req.Params["SCRIPT_NAME"])
+		return 0
+	})
+	done := make(chan error)
+	go func() { done <- s.Serve(l) }()
+
+	// the name is taken
+	if _, err := gofast.ListenNamedPipe(name); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+
+	h := gofast.NewHandler(
+		gofast.NewFileEndpoint("/var/www/html/index.php")(gofast.BasicSession),
+		gofast.SimpleClientFactory(gofast.NamedPipeConnFactory(name)),
+	)
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/hello", nil))
+		if want, have := "hello /index.php", w.Body.String(); want != have {
+			t.Errorf("expected %#v, got %#v", want, have)
+		}
+	}
+
+	s.Close()
+	if want, have := gofast.ErrServerClosed, <-done; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestNamedPipe_concurrentReadWrite(t *testing.T) {
+	name := `\\.\pipe\gofast-test-rw`
+	l, err := gofast.ListenNamedPipe(name)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer l.Close()
+
+	// the server answers "ping" with "pong"
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+			return
+		}
+		defer conn.Close()
+		b := make([]byte, 4)
+		if _, err := io.ReadFull(conn, b); err != nil {
+			t.Errorf("unexpected error: %s", err)
+			return
+		}
+		conn.Write([]byte("pong"))
+	}()
+
+	conn, err := gofast.NamedPipeConnFactory(name)()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer conn.Close()
+
+	// the read is pending before the write on the same pipe,
+	// which would block each other with synchronous I/O
+	read := make(chan string)
+	go func() {
+		b := make([]byte, 4)
+		io.ReadFull(conn, b)
+		read <- string(b)
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	written := make(chan error)
+	go func() {
+		_, err := conn.Write([]byte("ping"))
+		written <- err
+	}()
+	select {
+	case err := <-written:
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("write is blocked by the pending read")
+	}
+	select {
+	case have := <-read:
+		if want := "pong"; want != have {
+			t.Errorf("expected %#v, got %#v", want, have)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("expected the response read")
+	}
+}
+
+func TestNamedPipe_deadline(t *testing.T) {
+	name := `\\.\pipe\gofast-test-deadline`
+	l, err := gofast.ListenNamedPipe(name)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer l.Close()
+	go func() {
+		if conn, err := l.Accept(); err == nil {
+			defer conn.Close()
+			io.Copy(ioutil.Discard, conn)
+		}
+	}()
+
+	conn, err := gofast.NamedPipeConnFactory(name)()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	_, err = conn.Read(make([]byte, 1))
+	if e, ok := err.(net.Error); !ok || !e.Timeout() {
+		t.Errorf("expected timeout error, got %#v", err)
+	}
+}
+
+func TestNamedPipe_closeAccept(t *testing.T) {
+	l, err := gofast.ListenNamedPipe(`\\.\pipe\gofast-test-close`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	accepted := make(chan error)
+	go func() {
+		_, err := l.Accept()
+		accepted <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	l.Close()
+	select {
+	case err := <-accepted:
+		if err == nil {
+			t.Errorf("expected error, got nil")
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("expected Accept to return on Close")
+	}
+}
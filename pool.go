@@ -1,6 +1,8 @@
 package gofast
 
 import (
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -11,28 +13,59 @@ type PoolClient struct {
 	Err          error
 	returnClient chan<- *PoolClient
 	expires      time.Time
+	clock        Clock
+
+	// pool of the client, if any
+	pool *ClientPool
+
+	// created and idle are the times the client is
+	// created, and last returned to the pool
+	created time.Time
+	idle    time.Time
 }
 
 // Expired check if the client expired
 func (pc *PoolClient) Expired() bool {
-	return time.Now().After(pc.expires)
+	return clockOrSystem(pc.clock).Now().After(pc.expires)
 }
 
 // Close close the inner client only
 // if it is expired. Otherwise it will
 // return itself to the pool.
 func (pc *PoolClient) Close() error {
+	if pc.pool != nil {
+		atomic.AddInt32(&pc.pool.inUse, -1)
+	}
 	if pc.Expired() {
 		return pc.Client.Close()
 	}
-	go func() {
-		// block wait until the client
-		// is returned.
-		pc.returnClient <- pc
-	}()
+	pc.idle = clockOrSystem(pc.clock).Now()
+	go pc.putBack()
 	return nil
 }
 
+// putBack blocks wait until the client is returned
+// to the pool, or closes it if the pool is closed
+func (pc *PoolClient) putBack() {
+	var done <-chan struct{}
+	if pc.pool != nil {
+		done = pc.pool.done
+	}
+	select {
+	case pc.returnClient <- pc:
+	case <-done:
+		if pc.Client != nil {
+			pc.Client.Close()
+		}
+		return
+	}
+
+	// the pool may be closed while returning
+	if pc.pool != nil && pc.pool.isClosed() {
+		pc.pool.drain()
+	}
+}
+
 // NewClientPool creates a *ClientPool
 // from the given ClientFactory and pool
 // it to scale with expiration.
@@ -41,35 +74,275 @@ func NewClientPool(
 	scale uint,
 	expires time.Duration,
 ) *ClientPool {
-	pool := make(chan *PoolClient, scale)
+	return NewClientPoolWithClock(clientFactory, scale, expires, SystemClock)
+}
+
+// NewClientPoolWithClock creates a *ClientPool as NewClientPool
+// does, with the expiration of clients by the given Clock.
+func NewClientPoolWithClock(
+	clientFactory ClientFactory,
+	scale uint,
+	expires time.Duration,
+	clock Clock,
+) *ClientPool {
+	clock = clockOrSystem(clock)
+	p := &ClientPool{
+		clients: make(chan *PoolClient, scale),
+		clock:   clock,
+		done:    make(chan struct{}),
+	}
 	go func() {
-		for {
+		for !p.isClosed() {
 			c, err := clientFactory()
+			now := clock.Now()
 			pc := &PoolClient{
 				Client:       c,
 				Err:          err,
-				returnClient: pool,
-				expires:      time.Now().Add(expires),
+				returnClient: p.clients,
+				expires:      now.Add(expires),
+				clock:        clock,
+				pool:         p,
+				created:      now,
+				idle:         now,
 			}
-			pool <- pc
+			pc.putBack()
 		}
 	}()
-	return &ClientPool{
-		createClient: pool,
+	return p
+}
+
+// NewClientPoolWithLimits creates a *ClientPool as NewClientPool does,
+// with the scale capped to the FCGI_MAX_CONNS of the application, so
+// the clients created ahead do not exceed the connections it accepts
+// (e.g. the pm.max_children of php-fpm). The limits are queried on
+// start with a client of the ClientFactory, which must be a
+// LimitsReporter (e.g. of SimpleClientFactory).
+func NewClientPoolWithLimits(
+	clientFactory ClientFactory,
+	scale uint,
+	expires time.Duration,
+) (*ClientPool, error) {
+	c, err := clientFactory()
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	lr, ok := c.(LimitsReporter)
+	if !ok {
+		return nil, errNoLimits
+	}
+	limits, err := lr.BackendLimits()
+	if err != nil {
+		return nil, err
+	}
+
+	// the pool creates one client more than the scale,
+	// waiting to be sent to the channel
+	if limits.MaxConns > 0 && uint(limits.MaxConns) <= scale {
+		scale = uint(limits.MaxConns - 1)
 	}
+	p := NewClientPool(clientFactory, scale, expires)
+	p.Limits = limits
+	return p, nil
 }
 
 // ClientPool pools client created from
 // a given ClientFactory.
+//
+// The fields should be set before the first CreateClient.
 type ClientPool struct {
-	createClient <-chan *PoolClient
+
+	// Limits are the limits of the application,
+	// if queried by NewClientPoolWithLimits
+	Limits BackendLimits
+
+	// MaxWait is the maximum duration CreateClient waits for a
+	// client. CreateClient returns ErrPoolExhausted if no client
+	// is ready in time. Waits until a client is ready if 0.
+	MaxWait time.Duration
+
+	// MaxIdleTime is the maximum duration a client stays in the
+	// pool unused. The clients idle longer (e.g. of which the
+	// connections may have been dropped by a firewall) are closed
+	// instead of handed out. No limit if 0.
+	MaxIdleTime time.Duration
+
+	// MaxLifetime is the maximum age of a client handed out. The
+	// clients older are closed instead, even if they are returned
+	// before the expires of NewClientPool. No limit if 0.
+	MaxLifetime time.Duration
+
+	// HealthCheck is the interval of probing an idle client with a
+	// FCGI_GET_VALUES round-trip, so the clients of connections broken
+	// (e.g. by a php-fpm restart) are closed and replaced before handed
+	// out. The client probed is closed after, as the application may
+	// close the connection after answering (e.g. php-fpm). If the probe
+	// fails, all the idle clients are closed, as the connections are
+	// likely broken alike. The clients of dial errors are also
+	// replaced. No check if 0.
+	HealthCheck time.Duration
+
+	clients chan *PoolClient
+	clock   Clock
+	inUse   int32
+
+	healthCheck sync.Once
+	closeOnce   sync.Once
+	done        chan struct{}
 }
 
 // CreateClient implements ClientFactory
 func (p *ClientPool) CreateClient() (c Client, err error) {
-	pc := <-p.createClient
-	if c, err = pc, pc.Err; err != nil {
-		return nil, err
+	if p.HealthCheck > 0 {
+		p.healthCheck.Do(func() {
+			go p.checkHealth()
+		})
+	}
+
+	var timeout <-chan time.Time
+	if p.MaxWait > 0 {
+		timer := clockOrSystem(p.clock).NewTimer(p.MaxWait)
+		defer timer.Stop()
+		timeout = timer.C()
+	}
+	for {
+		var pc *PoolClient
+		select {
+		case pc = <-p.clients:
+		case <-timeout:
+			return nil, ErrPoolExhausted
+		case <-p.done:
+			return nil, ErrPoolClosed
+		}
+		if pc.Err != nil {
+			return nil, pc.Err
+		}
+		if p.isStale(pc) {
+			pc.Client.Close()
+			continue
+		}
+		atomic.AddInt32(&p.inUse, 1)
+		return pc, nil
+	}
+}
+
+// PoolStats are the numbers of clients of a ClientPool
+type PoolStats struct {
+
+	// Idle is the number of the clients ready in the
+	// pool (including the ones of dial errors, if any)
+	Idle int
+
+	// InUse is the number of the clients handed out
+	// and not closed yet
+	InUse int
+
+	// Scale is the scale of the pool
+	Scale int
+}
+
+// Stats returns the numbers of clients of the pool, e.g. to
+// see whether the pool (or the application) is saturated
+func (p *ClientPool) Stats() PoolStats {
+	return PoolStats{
+		Idle:  len(p.clients),
+		InUse: int(atomic.LoadInt32(&p.inUse)),
+		Scale: cap(p.clients),
+	}
+}
+
+// Close closes the pool and the idle clients in it. The clients in
+// use are closed when returned. CreateClient returns ErrPoolClosed
+// after.
+func (p *ClientPool) Close() error {
+	p.closeOnce.Do(func() {
+		close(p.done)
+	})
+	p.drain()
+	return nil
+}
+
+func (p *ClientPool) isClosed() bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// drain closes the clients in the pool
+func (p *ClientPool) drain() {
+	for {
+		select {
+		case pc := <-p.clients:
+			if pc.Client != nil {
+				pc.Client.Close()
+			}
+		default:
+			return
+		}
+	}
+}
+
+// isStale returns true if the client is idle or
+// alive for too long to be handed out
+func (p *ClientPool) isStale(pc *PoolClient) bool {
+	now := clockOrSystem(p.clock).Now()
+	if p.MaxIdleTime > 0 && now.Sub(pc.idle) > p.MaxIdleTime {
+		return true
+	}
+	return p.MaxLifetime > 0 && now.Sub(pc.created) > p.MaxLifetime
+}
+
+// checkHealth probes an idle client every HealthCheck
+// interval, until the pool is closed
+func (p *ClientPool) checkHealth() {
+	clock := clockOrSystem(p.clock)
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-clock.After(p.HealthCheck):
+		}
+
+		// check each of the clients in the pool once, with
+		// the first of them probed and closed
+		probed, healthy := false, true
+		for n := len(p.clients); n > 0; n-- {
+			var pc *PoolClient
+			select {
+			case pc = <-p.clients:
+			default:
+			}
+			if pc == nil {
+				break
+			}
+			if pc.Err != nil {
+				continue
+			}
+			if !probed && !p.isStale(pc) {
+				probed, healthy = true, isHealthy(pc.Client)
+				pc.Client.Close()
+				continue
+			}
+			if p.isStale(pc) || !healthy {
+				pc.Client.Close()
+				continue
+			}
+			go pc.putBack()
+		}
+	}
+}
+
+// isHealthy returns false if the client fails to query the
+// limits of the application. Clients of no LimitsReporter are
+// assumed healthy.
+func isHealthy(c Client) bool {
+	lr, ok := c.(LimitsReporter)
+	if !ok {
+		return true
 	}
-	return
+	_, err := lr.BackendLimits()
+	return err == nil
 }
@@ -1,8 +1,12 @@
 package gofast
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net"
+	"net/http/httptest"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -236,3 +240,196 @@ func TestClientPool_CreateClient_Return_40(t *testing.T) {
 		t.Errorf("client is not reused")
 	}
 }
+
+// closeConn is a mockConn indicating if it is closed,
+// safe for the Close in other goroutines
+type closeConn struct {
+	mockConn
+	closed int32
+}
+
+func (c *closeConn) Close() error {
+	atomic.StoreInt32(&c.closed, 1)
+	return nil
+}
+
+func (c *closeConn) isClosed() bool {
+	return atomic.LoadInt32(&c.closed) == 1
+}
+
+// closeConnFactory returns a ConnFactory of closeConn,
+// and sends the connections created to the channel
+func closeConnFactory(conns chan<- *closeConn) ConnFactory {
+	return func() (net.Conn, error) {
+		conn := &closeConn{}
+		conns <- conn
+		return conn, nil
+	}
+}
+
+// connOf returns the closeConn of the pooled client
+func connOf(c Client) *closeConn {
+	return c.(*PoolClient).Client.(*client).conn.rwc.(*closeConn)
+}
+
+// waitClosed waits for the connection to be closed by the pool
+// handing out the clients, and returns false if it does not
+func waitClosed(t *testing.T, cp *ClientPool, conn *closeConn, used Client) bool {
+	for i := 0; i < 50; i++ {
+		c, err := cp.CreateClient()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if c == used {
+			t.Errorf("expected the client not to be reused")
+		}
+		c.Close()
+		if conn.isClosed() {
+			return true
+		}
+	}
+	return false
+}
+
+func TestClientPool_MaxIdleTime(t *testing.T) {
+	conns := make(chan *closeConn, 100)
+	cp := NewClientPool(SimpleClientFactory(closeConnFactory(conns)), 0, time.Minute)
+	cp.MaxIdleTime = 20 * time.Millisecond
+	defer cp.Close()
+
+	c1, err := cp.CreateClient()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	conn1 := connOf(c1)
+	c1.Close()
+	time.Sleep(40 * time.Millisecond)
+	if !waitClosed(t, cp, conn1, c1) {
+		t.Errorf("expected the idle client to be closed")
+	}
+}
+
+func TestClientPool_MaxLifetime(t *testing.T) {
+	conns := make(chan *closeConn, 100)
+	cp := NewClientPool(SimpleClientFactory(closeConnFactory(conns)), 0, time.Minute)
+	cp.MaxLifetime = 20 * time.Millisecond
+	defer cp.Close()
+
+	c1, err := cp.CreateClient()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	time.Sleep(40 * time.Millisecond)
+	conn1 := connOf(c1)
+	c1.Close()
+	time.Sleep(time.Millisecond)
+	if !waitClosed(t, cp, conn1, c1) {
+		t.Errorf("expected the old client to be closed")
+	}
+}
+
+func TestClientPool_HealthCheck(t *testing.T) {
+	s := NewServer(func(ctx context.Context, req *Request, stdout, stderr io.Writer) int {
+		fmt.Fprint(stdout, "Content-Type: text/plain\r\n\r\nhello")
+		return 0
+	})
+	appConns := make(chan net.Conn, 100)
+	cp := NewClientPool(SimpleClientFactory(func() (net.Conn, error) {
+		appConn, webConn := net.Pipe()
+		appConns <- appConn
+		go s.ServeConn(appConn)
+		return webConn, nil
+	}), 2, time.Minute)
+	cp.HealthCheck = 10 * time.Millisecond
+	defer cp.Close()
+
+	// start the health check, with
+	// the pool filled with clients
+	c, err := cp.CreateClient()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	c.Close()
+	time.Sleep(20 * time.Millisecond)
+
+	// the application restarts
+	for n := len(appConns); n > 0; n-- {
+		(<-appConns).Close()
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	// the broken clients are replaced before handed out
+	for i := 0; i < 4; i++ {
+		c, err := cp.CreateClient()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		resp, err := c.Do(NewRequest(nil))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		w := httptest.NewRecorder()
+		resp.WriteTo(w, ioutil.Discard)
+		if want, have := "hello", w.Body.String(); want != have {
+			t.Errorf("expected %#v, got %#v (error: %v)", want, have, resp.Err())
+		}
+		c.Close()
+	}
+}
+
+func TestClientPool_Close(t *testing.T) {
+	conns := make(chan *closeConn, 100)
+	cp := NewClientPool(SimpleClientFactory(closeConnFactory(conns)), 5, time.Minute)
+	c1, err := cp.CreateClient()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	conn1 := connOf(c1)
+	time.Sleep(10 * time.Millisecond)
+	cp.Close()
+
+	// the idle clients are closed, and the client in
+	// use is closed when returned
+	time.Sleep(10 * time.Millisecond)
+	for n := len(conns); n > 0; n-- {
+		if conn := <-conns; conn != conn1 && !conn.isClosed() {
+			t.Errorf("expected the idle client to be closed")
+		}
+	}
+	c1.Close()
+	time.Sleep(10 * time.Millisecond)
+	if !conn1.isClosed() {
+		t.Errorf("expected the returned client to be closed")
+	}
+	if c, err := cp.CreateClient(); err != ErrPoolClosed {
+		t.Errorf("expected %#v, got %#v (client: %#v)", ErrPoolClosed, err, c)
+	}
+}
+
+func TestClientPool_Stats(t *testing.T) {
+	conns := make(chan *closeConn, 100)
+	cp := NewClientPool(SimpleClientFactory(closeConnFactory(conns)), 5, time.Minute)
+	defer cp.Close()
+	c1, err := cp.CreateClient()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	c2, err := cp.CreateClient()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if want, have := (PoolStats{Idle: 5, InUse: 2, Scale: 5}), cp.Stats(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	// the client closed is not in use
+	c1.Close()
+	if want, have := 1, cp.Stats().InUse; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	c2.Close()
+	if want, have := 0, cp.Stats().InUse; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
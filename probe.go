@@ -0,0 +1,210 @@
+package gofast
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// HealthCheck checks the health of a dependency (e.g. the FastCGI
+// application). Returns nil if healthy.
+type HealthCheck func(ctx context.Context) error
+
+// ConnHealthCheck returns a HealthCheck that is healthy if a new
+// connection can be made with the ConnFactory.
+func ConnHealthCheck(connFactory ConnFactory) HealthCheck {
+	return func(ctx context.Context) error {
+		conn, err := connFactory()
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+}
+
+// ClientHealthCheck returns a HealthCheck that is healthy if a Client can
+// be created with the ClientFactory. With ClientPool, it reflects if the
+// pool is able to create clients. The client is closed (i.e. returned to
+// the pool) right away.
+func ClientHealthCheck(clientFactory ClientFactory) HealthCheck {
+	return func(ctx context.Context) error {
+		c, err := clientFactory()
+		if err != nil {
+			return err
+		}
+		return c.Close()
+	}
+}
+
+// Probes provides the http.Handler(s) for Kubernetes liveness, readiness
+// probes and preStop hook of a gateway.
+//
+// A typical deployment looks like this:
+//
+//  drainer := gofast.NewDrainer()
+//  probes := &gofast.Probes{
+//      Drainer: drainer,
+//      Checks: map[string]gofast.HealthCheck{
+//          "backend": gofast.ConnHealthCheck(connFactory),
+//      },
+//  }
+//  http.Handle("/", drainer.Wrap(handler))
+//  http.Handle("/healthz", probes.Healthz())
+//  http.Handle("/readyz", probes.Readyz())
+//  http.Handle("/prestop", probes.PreStop(5*time.Second))
+//
+// With the pod spec:
+//
+//  livenessProbe:
+//    httpGet: {path: /healthz, port: 8080}
+//  readinessProbe:
+//    httpGet: {path: /readyz, port: 8080}
+//  lifecycle:
+//    preStop:
+//      httpGet: {path: /prestop, port: 8080}
+type Probes struct {
+
+	// Drainer tracks the in-flight requests of the gateway. May be nil.
+	Drainer *Drainer
+
+	// Checks are the health checks by name
+	Checks map[string]HealthCheck
+
+	// Timeout for each check. Default 5 seconds if zero.
+	Timeout time.Duration
+
+	// Clock of the PreStop delay. SystemClock if nil.
+	Clock Clock
+}
+
+func (p *Probes) timeout() time.Duration {
+	if p.Timeout > 0 {
+		return p.Timeout
+	}
+	return 5 * time.Second
+}
+
+// runChecks runs all checks concurrently and
+// returns the errors by check name
+func (p *Probes) runChecks(ctx context.Context) map[string]error {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout())
+	defer cancel()
+
+	type result struct {
+		name string
+		err  error
+	}
+	results := make(chan result, len(p.Checks))
+	for name, check := range p.Checks {
+		go func(name string, check HealthCheck) {
+			done := make(chan error, 1)
+			go func() { done <- check(ctx) }()
+			select {
+			case err := <-done:
+				results <- result{name, err}
+			case <-ctx.Done():
+				results <- result{name, ctx.Err()}
+			}
+		}(name, check)
+	}
+	errs := make(map[string]error, len(p.Checks))
+	for range p.Checks {
+		r := <-results
+		errs[r.name] = r.err
+	}
+	return errs
+}
+
+// writeProbeResult writes the probe result in a format like the health
+// endpoints of kube-apiserver, with error status if any check fails.
+func writeProbeResult(w http.ResponseWriter, errs map[string]error) {
+	names := make([]string, 0, len(errs))
+	failed := false
+	for name, err := range errs {
+		names = append(names, name)
+		failed = failed || err != nil
+	}
+	sort.Strings(names)
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if failed {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+	for _, name := range names {
+		if err := errs[name]; err != nil {
+			fmt.Fprintf(w, "[-]%s failed: %s\n", name, err)
+		} else {
+			fmt.Fprintf(w, "[+]%s ok\n", name)
+		}
+	}
+	if failed {
+		fmt.Fprintf(w, "unhealthy\n")
+	} else {
+		fmt.Fprintf(w, "ok\n")
+	}
+}
+
+// Healthz returns an http.Handler for liveness probe. It responds
+// with "503 Service Unavailable" if any of the Checks fails.
+//
+// Note: Kubernetes restarts the container if liveness probe fails. If
+// the backend is not in the same pod, you may want to leave its checks
+// out of Probes for liveness.
+func (p *Probes) Healthz() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeProbeResult(w, p.runChecks(r.Context()))
+	})
+}
+
+// Readyz returns an http.Handler for readiness probe. It responds
+// with "503 Service Unavailable" if any of the Checks fails, or
+// if the Drainer is draining.
+func (p *Probes) Readyz() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		errs := p.runChecks(r.Context())
+		if p.Drainer != nil {
+			errs["drain"] = nil
+			if p.Drainer.Draining() {
+				errs["drain"] = fmt.Errorf("draining, %d request(s) in-flight",
+					p.Drainer.InFlight())
+			}
+		}
+		writeProbeResult(w, errs)
+	})
+}
+
+// PreStop returns an http.Handler for the preStop hook. It marks the
+// Drainer as draining, so readiness probe fails and the pod is removed
+// from the service endpoints. It then waits for the given delay (for the
+// endpoints removal to propagate), then waits for the in-flight requests
+// to finish before responding.
+//
+// Kubernetes sends SIGTERM to the container after the hook returns, or
+// after terminationGracePeriodSeconds. The delay should be shorter than
+// that. The handler should not be wrapped by the Drainer, or it would
+// wait for itself.
+func (p *Probes) PreStop(delay time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if p.Drainer == nil {
+			http.Error(w, "no drainer to drain", http.StatusInternalServerError)
+			return
+		}
+		p.Drainer.Drain()
+
+		select {
+		case <-clockOrSystem(p.Clock).After(delay):
+		case <-r.Context().Done():
+			return
+		}
+		if err := p.Drainer.Wait(r.Context()); err != nil {
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintf(w, "drained\n")
+	})
+}
@@ -0,0 +1,162 @@
+package gofast_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/yookoala/gofast"
+	"github.com/yookoala/gofast/fcgitest"
+)
+
+func TestProbes_Healthz(t *testing.T) {
+	healthy := true
+	p := &gofast.Probes{
+		Checks: map[string]gofast.HealthCheck{
+			"backend": func(ctx context.Context) error {
+				if !healthy {
+					return fmt.Errorf("connection refused")
+				}
+				return nil
+			},
+			"cache": func(ctx context.Context) error { return nil },
+		},
+	}
+
+	w := httptest.NewRecorder()
+	p.Healthz().ServeHTTP(w, httptest.NewRequest("GET", "/healthz", nil))
+	if want, have := http.StatusOK, w.Code; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "[+]backend ok\n[+]cache ok\nok\n", w.Body.String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	healthy = false
+	w = httptest.NewRecorder()
+	p.Healthz().ServeHTTP(w, httptest.NewRequest("GET", "/healthz", nil))
+	if want, have := http.StatusServiceUnavailable, w.Code; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "[-]backend failed: connection refused\n[+]cache ok\nunhealthy\n", w.Body.String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestProbes_timeout(t *testing.T) {
+	p := &gofast.Probes{
+		Checks: map[string]gofast.HealthCheck{
+			"slow": func(ctx context.Context) error {
+				time.Sleep(time.Second)
+				return nil
+			},
+		},
+		Timeout: 10 * time.Millisecond,
+	}
+	w := httptest.NewRecorder()
+	p.Healthz().ServeHTTP(w, httptest.NewRequest("GET", "/healthz", nil))
+	if want, have := http.StatusServiceUnavailable, w.Code; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestProbes_ReadyzPreStop(t *testing.T) {
+	d := gofast.NewDrainer()
+	p := &gofast.Probes{Drainer: d}
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	h := d.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+	go h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	<-started
+
+	w := httptest.NewRecorder()
+	p.Readyz().ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+	if want, have := http.StatusOK, w.Code; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	stopped := make(chan *httptest.ResponseRecorder)
+	go func() {
+		w := httptest.NewRecorder()
+		p.PreStop(0).ServeHTTP(w, httptest.NewRequest("GET", "/prestop", nil))
+		stopped <- w
+	}()
+
+	// wait for the preStop hook to drain
+	for !d.Draining() {
+		time.Sleep(time.Millisecond)
+	}
+	w = httptest.NewRecorder()
+	p.Readyz().ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+	if want, have := http.StatusServiceUnavailable, w.Code; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "[-]drain failed: draining, 1 request(s) in-flight\nunhealthy\n", w.Body.String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	select {
+	case <-stopped:
+		t.Errorf("expected preStop hook to wait for in-flight request")
+	case <-time.After(10 * time.Millisecond):
+	}
+	close(release)
+	if want, have := "drained\n", (<-stopped).Body.String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestProbes_PreStop_delay(t *testing.T) {
+	clock := fcgitest.NewFakeClock(time.Unix(0, 0))
+	p := &gofast.Probes{Drainer: gofast.NewDrainer(), Clock: clock}
+
+	stopped := make(chan *httptest.ResponseRecorder)
+	go func() {
+		w := httptest.NewRecorder()
+		p.PreStop(time.Hour).ServeHTTP(w, httptest.NewRequest("GET", "/prestop", nil))
+		stopped <- w
+	}()
+
+	// wait for the preStop hook to wait for the delay
+	clock.WaitTimers(1)
+	clock.Advance(time.Hour - time.Second)
+	select {
+	case <-stopped:
+		t.Fatalf("expected preStop hook to wait for the delay")
+	case <-time.After(10 * time.Millisecond):
+	}
+	clock.Advance(time.Second)
+	if want, have := "drained\n", (<-stopped).Body.String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestConnHealthCheck(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	address := l.Addr().String()
+
+	check := gofast.ConnHealthCheck(gofast.SimpleConnFactory("tcp", address))
+	if err := check(context.Background()); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	l.Close()
+	if err := check(context.Background()); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+
+	check = gofast.ClientHealthCheck(gofast.SimpleClientFactory(gofast.SimpleConnFactory("tcp", address)))
+	if err := check(context.Background()); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+}
@@ -0,0 +1,99 @@
+package gofast
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+)
+
+// NewProxy returns a *Proxy which sends every request to the FastCGI
+// application with clients from the given ClientFactory. The director
+// is called to prepare each outbound *Request before it is sent.
+func NewProxy(clientFactory ClientFactory, director func(*Request)) *Proxy {
+	return &Proxy{
+		Director:      director,
+		ClientFactory: clientFactory,
+	}
+}
+
+// Proxy is an http.Handler that takes an incoming request and sends it to
+// a FastCGI application, proxying the response back to the client.
+//
+// It provides ergonomics similar to httputil.ReverseProxy. Instead of
+// composing SessionHandler with Middleware, you may mutate the outbound
+// *Request (e.g. its Params) in the Director function.
+type Proxy struct {
+
+	// Director must be a function which modifies the outbound *Request
+	// (e.g. Params, Role, Stdin) before it is sent to the application.
+	// The *http.Request is available as req.Raw. Director may be nil.
+	Director func(req *Request)
+
+	// ClientFactory creates the Client for each outbound *Request.
+	ClientFactory ClientFactory
+
+	// ErrorLog specifies an optional logger for errors that occur when
+	// attempting to proxy the request, and for the stderr stream of the
+	// application. If nil, logging is done via the log package's
+	// standard logger.
+	ErrorLog *log.Logger
+
+	// ErrorHandler is an optional function that handles errors reaching
+	// the FastCGI application. If nil, the default is to log the error
+	// and respond with http.StatusBadGateway.
+	ErrorHandler func(w http.ResponseWriter, r *http.Request, err error)
+}
+
+func (p *Proxy) logf(format string, args ...interface{}) {
+	if p.ErrorLog != nil {
+		p.ErrorLog.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
+
+func (p *Proxy) defaultErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	p.logf("gofast: proxy error: %s", err)
+	w.WriteHeader(errorStatus(err, http.StatusBadGateway))
+}
+
+func (p *Proxy) getErrorHandler() func(http.ResponseWriter, *http.Request, error) {
+	if p.ErrorHandler != nil {
+		return p.ErrorHandler
+	}
+	return p.defaultErrorHandler
+}
+
+// ServeHTTP implements http.Handler
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c, err := p.ClientFactory()
+	if err != nil {
+		p.getErrorHandler()(w, r, err)
+		return
+	}
+	defer func() {
+		if err := c.Close(); err != nil {
+			p.logf("gofast: error closing client: %s", err)
+		}
+	}()
+
+	req := NewRequest(r)
+	if p.Director != nil {
+		p.Director(req)
+	}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		p.getErrorHandler()(w, r, err)
+		return
+	}
+
+	errBuffer := new(bytes.Buffer)
+	if err = resp.WriteTo(w, errBuffer); err != nil {
+		p.logf("gofast: error writing response: %s", err)
+	}
+	if errBuffer.Len() > 0 {
+		p.logf("gofast: error stream from application process %s",
+			errBuffer.String())
+	}
+}
@@ -0,0 +1,99 @@
+package gofast_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/yookoala/gofast"
+)
+
+func TestProxy_Director(t *testing.T) {
+
+	// create temporary socket in the testing folder
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Errorf("unexpected error: %#v", err.Error())
+	}
+	sock := dir + "/test.proxy.sock"
+
+	// create temporary fcgi application server
+	// that listens to the socket
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(201)
+		fmt.Fprintf(w, "hello %s", r.URL.Path)
+	}
+	l, err := newApp("unix", sock, fn)
+	if err != nil {
+		t.Errorf("unexpected error: %#v", err.Error())
+	}
+	defer os.Remove(sock)
+	defer l.Close()
+
+	p := gofast.NewProxy(
+		gofast.SimpleClientFactory(
+			gofast.SimpleConnFactory(
+				l.Addr().Network(),
+				l.Addr().String(),
+			),
+		),
+		func(req *gofast.Request) {
+			req.Params["REQUEST_METHOD"] = req.Raw.Method
+			req.Params["SERVER_PROTOCOL"] = req.Raw.Proto
+			req.Params["REQUEST_URI"] = "/rewritten" + req.Raw.URL.Path
+		},
+	)
+	w := httptest.NewRecorder()
+
+	r, err := http.NewRequest("GET", "/world", nil)
+	if err != nil {
+		t.Errorf("unexpected error: %#v", err.Error())
+	}
+	p.ServeHTTP(w, r)
+
+	if want, have := "hello /rewritten/world", w.Body.String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := 201, w.Code; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestProxy_ErrorHandler(t *testing.T) {
+	var handledErr error
+	p := &gofast.Proxy{
+		ClientFactory: func() (gofast.Client, error) {
+			return nil, fmt.Errorf("dummy error")
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			handledErr = err
+			w.WriteHeader(http.StatusServiceUnavailable)
+		},
+	}
+
+	w := httptest.NewRecorder()
+	r, err := http.NewRequest("GET", "/world", nil)
+	if err != nil {
+		t.Errorf("unexpected error: %#v", err.Error())
+	}
+	p.ServeHTTP(w, r)
+
+	if handledErr == nil {
+		t.Errorf("expected error, got nil")
+	} else if want, have := "dummy error", handledErr.Error(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := http.StatusServiceUnavailable, w.Code; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	// default error handler
+	p.ErrorHandler = nil
+	w = httptest.NewRecorder()
+	p.ServeHTTP(w, r)
+	if want, have := http.StatusBadGateway, w.Code; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
@@ -0,0 +1,175 @@
+package gofast
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// ProxyProtocolVersion is the version of the HAProxy PROXY protocol
+// as specified in https://www.haproxy.org/download/1.8/doc/proxy-protocol.txt
+type ProxyProtocolVersion int
+
+// PROXY protocol versions
+const (
+	ProxyProtocolV1 ProxyProtocolVersion = 1 // human-readable header
+	ProxyProtocolV2 ProxyProtocolVersion = 2 // binary header
+)
+
+// signature of PROXY protocol v2 header
+var proxyProtocolV2Sig = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// ProxyProtocolConnFactory decorates a ConnFactory of TCP connections so
+// every new connection begins with a PROXY protocol header. This allows
+// the real client address to survive intermediate TCP load balancers
+// between gofast and the FastCGI application.
+//
+// The header is sent right before the first write to the connection. The
+// addresses are taken from the first *Request sent through it: source
+// from req.Raw.RemoteAddr, destination from the local address of the
+// http.Server (http.LocalAddrContextKey). If either is unavailable (or is
+// not an IP address), the header will describe an unknown / local
+// connection instead.
+//
+// Since the header is only sent once per connection, you should not use
+// this with clients which send requests of different visitors through
+// the same connection (e.g. ClientPool).
+//
+// Returns error if the version is not supported.
+func ProxyProtocolConnFactory(connFactory ConnFactory, version ProxyProtocolVersion) (ConnFactory, error) {
+	if version != ProxyProtocolV1 && version != ProxyProtocolV2 {
+		return nil, fmt.Errorf("gofast: unsupported PROXY protocol version %d", version)
+	}
+	return func() (net.Conn, error) {
+		conn, err := connFactory()
+		if err != nil {
+			return nil, err
+		}
+		return &proxyProtocolConn{
+			Conn:    conn,
+			version: version,
+		}, nil
+	}, nil
+}
+
+// proxySourceSetter is implemented by connections that
+// should know the original request before writing.
+type proxySourceSetter interface {
+	setProxySource(r *http.Request)
+}
+
+// proxyProtocolConn sends PROXY protocol header before
+// the first write of the inner net.Conn
+type proxyProtocolConn struct {
+	net.Conn
+	version ProxyProtocolVersion
+
+	mutex    sync.Mutex
+	src, dst *net.TCPAddr
+	hasSrc   bool
+	sent     bool
+}
+
+// setProxySource implements proxySourceSetter
+func (c *proxyProtocolConn) setProxySource(r *http.Request) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.sent || c.hasSrc {
+		return
+	}
+	c.hasSrc = true
+	c.src = parseTCPAddr(r.RemoteAddr)
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		c.dst = parseTCPAddr(addr.String())
+	}
+}
+
+// Write implements net.Conn
+func (c *proxyProtocolConn) Write(p []byte) (int, error) {
+	c.mutex.Lock()
+	if !c.sent {
+		header, err := proxyProtocolHeader(c.version, c.src, c.dst)
+		if err == nil {
+			_, err = c.Conn.Write(header)
+		}
+		if err != nil {
+			c.mutex.Unlock()
+			return 0, err
+		}
+		c.sent = true
+	}
+	c.mutex.Unlock()
+	return c.Conn.Write(p)
+}
+
+func parseTCPAddr(addr string) *net.TCPAddr {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil
+	}
+	portNum, err := strconv.Atoi(port)
+	if err != nil {
+		return nil
+	}
+	return &net.TCPAddr{IP: ip, Port: portNum}
+}
+
+// proxyProtocolHeader generates PROXY protocol header of the given version
+// for the source and destination address. If either address is nil or
+// their IP families mismatch, the UNKNOWN (v1) or LOCAL (v2) header is
+// generated.
+func proxyProtocolHeader(version ProxyProtocolVersion, src, dst *net.TCPAddr) ([]byte, error) {
+	known := src != nil && dst != nil &&
+		(src.IP.To4() == nil) == (dst.IP.To4() == nil)
+
+	switch version {
+	case ProxyProtocolV1:
+		if !known {
+			return []byte("PROXY UNKNOWN\r\n"), nil
+		}
+		proto := "TCP6"
+		if src.IP.To4() != nil {
+			proto = "TCP4"
+		}
+		return []byte(fmt.Sprintf("PROXY %s %s %s %d %d\r\n",
+			proto, src.IP, dst.IP, src.Port, dst.Port)), nil
+	case ProxyProtocolV2:
+		buf := new(bytes.Buffer)
+		buf.Write(proxyProtocolV2Sig)
+		if !known {
+			// version 2, command LOCAL, family UNSPEC, no address
+			buf.Write([]byte{0x20, 0x00, 0x00, 0x00})
+			return buf.Bytes(), nil
+		}
+
+		var famProto byte
+		var srcIP, dstIP net.IP
+		if ip4 := src.IP.To4(); ip4 != nil {
+			famProto, srcIP, dstIP = 0x11, ip4, dst.IP.To4() // TCP over IPv4
+		} else {
+			famProto, srcIP, dstIP = 0x21, src.IP.To16(), dst.IP.To16() // TCP over IPv6
+		}
+		length := make([]byte, 2)
+		binary.BigEndian.PutUint16(length, uint16(len(srcIP)*2+4))
+		ports := make([]byte, 4)
+		binary.BigEndian.PutUint16(ports, uint16(src.Port))
+		binary.BigEndian.PutUint16(ports[2:], uint16(dst.Port))
+
+		// version 2, command PROXY
+		buf.Write([]byte{0x21, famProto})
+		buf.Write(length)
+		buf.Write(srcIP)
+		buf.Write(dstIP)
+		buf.Write(ports)
+		return buf.Bytes(), nil
+	}
+	return nil, fmt.Errorf("gofast: unsupported PROXY protocol version %d", version)
+}
@@ -0,0 +1,111 @@
+package gofast_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/yookoala/gofast"
+)
+
+// doWithProxyProtocol sends a request through client of the ProxyProtocolConnFactory
+// and returns the first n bytes received by the application side.
+func doWithProxyProtocol(t *testing.T, version gofast.ProxyProtocolVersion, r *http.Request, n int) []byte {
+	appConn, webConn := net.Pipe()
+
+	cf, err := gofast.ProxyProtocolConnFactory(func() (net.Conn, error) {
+		return webConn, nil
+	}, version)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	c, err := gofast.SimpleClientFactory(cf)()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// closing the application side ends the
+	// pending reads and writes of the client
+	defer appConn.Close()
+
+	req := gofast.NewRequest(r)
+	if _, err := c.Do(req); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	b := make([]byte, n)
+	if _, err := io.ReadFull(bufio.NewReader(appConn), b); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return b
+}
+
+func newProxyProtocolRequest(t *testing.T, remoteAddr, localAddr string) *http.Request {
+	r, err := http.NewRequest("GET", "http://foobar.com/hello", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	r.RemoteAddr = remoteAddr
+	if localAddr != "" {
+		addr, err := net.ResolveTCPAddr("tcp", localAddr)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		r = r.WithContext(context.WithValue(r.Context(), http.LocalAddrContextKey, addr))
+	}
+	return r
+}
+
+func TestProxyProtocolConnFactory_v1(t *testing.T) {
+	want := "PROXY TCP4 192.168.0.1 10.0.0.1 56324 443\r\n"
+	r := newProxyProtocolRequest(t, "192.168.0.1:56324", "10.0.0.1:443")
+	if have := string(doWithProxyProtocol(t, gofast.ProxyProtocolV1, r, len(want))); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	want = "PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n"
+	r = newProxyProtocolRequest(t, "[2001:db8::1]:56324", "[2001:db8::2]:443")
+	if have := string(doWithProxyProtocol(t, gofast.ProxyProtocolV1, r, len(want))); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	// without local address
+	want = "PROXY UNKNOWN\r\n"
+	r = newProxyProtocolRequest(t, "192.168.0.1:56324", "")
+	if have := string(doWithProxyProtocol(t, gofast.ProxyProtocolV1, r, len(want))); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestProxyProtocolConnFactory_v2(t *testing.T) {
+	r := newProxyProtocolRequest(t, "192.168.0.1:56324", "10.0.0.1:443")
+	want := []byte("\r\n\r\n\x00\r\nQUIT\n\x21\x11\x00\x0c" +
+		"\xc0\xa8\x00\x01" + "\x0a\x00\x00\x01" + "\xdc\x04" + "\x01\xbb")
+	if have := doWithProxyProtocol(t, gofast.ProxyProtocolV2, r, len(want)); !bytes.Equal(want, have) {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	// without local address
+	r = newProxyProtocolRequest(t, "192.168.0.1:56324", "")
+	want = []byte("\r\n\r\n\x00\r\nQUIT\n\x20\x00\x00\x00")
+	if have := doWithProxyProtocol(t, gofast.ProxyProtocolV2, r, len(want)); !bytes.Equal(want, have) {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestProxyProtocolConnFactory_unsupported(t *testing.T) {
+	cf, err := gofast.ProxyProtocolConnFactory(func() (net.Conn, error) {
+		t.Errorf("expected no connection")
+		return nil, nil
+	}, 3)
+	if cf != nil {
+		t.Errorf("expected nil, got ConnFactory")
+	}
+	if want, have := "gofast: unsupported PROXY protocol version 3", fmt.Sprint(err); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
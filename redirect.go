@@ -0,0 +1,161 @@
+package gofast
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// InterceptInternalRedirect returns an http.Handler which serves the
+// requests with the inner handler (e.g. the one from NewHandler). If the
+// response has an "X-Accel-Redirect" or "X-Sendfile" header, the body of
+// the response is discarded, and the file referenced is served from the
+// root folder instead, like nginx and Apache mod_xsendfile do. This lets
+// the application check the access of protected downloads without
+// streaming the files itself.
+//
+// The "X-Accel-Redirect" header is a path in the root folder (e.g.
+// "/protected/report.pdf"). The "X-Sendfile" header is the file path
+// of the file system, which must be inside the root folder.
+//
+// The file is served with http.ServeContent, which handles the
+// Range and conditional requests. The other headers of the response
+// (e.g. Content-Type, Content-Disposition, ETag) are kept. If the
+// response has no ETag, one of the modification time and size is set.
+// The Content-Type is only detected from the file if the response has
+// none, so the application should set it (PHP defaults to text/html).
+//
+// The invalid redirects are logged with the logger of the inner handler
+// (see Handler.SetLogger), if any, or the standard logger.
+func InterceptInternalRedirect(root string, inner http.Handler) http.Handler {
+	logf := log.Printf
+	if l, ok := inner.(interface {
+		logf(format string, v ...interface{})
+	}); ok {
+		logf = l.logf
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		iw := &redirectInterceptor{ResponseWriter: w}
+		inner.ServeHTTP(iw, r)
+		if !iw.wroteHeader {
+			// the headers of a response without body
+			iw.WriteHeader(http.StatusOK)
+		}
+		if !iw.redirect {
+			return
+		}
+		serveInternalRedirect(w, r, root, iw.header, logf)
+	})
+}
+
+// serveInternalRedirect serves the file of the
+// redirect header with the other headers
+func serveInternalRedirect(w http.ResponseWriter, r *http.Request, root string, header http.Header, logf func(format string, v ...interface{})) {
+	name, err := internalRedirectPath(root, header)
+	header.Del("X-Accel-Redirect")
+	header.Del("X-Sendfile")
+	header.Del("Content-Length")
+	if err != nil {
+		logf("gofast: invalid internal redirect: %s", err)
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	f, err := http.Dir(root).Open(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	s, err := f.Stat()
+	if err != nil || s.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+
+	for k, v := range header {
+		w.Header()[k] = v
+	}
+	if w.Header().Get("Etag") == "" {
+		w.Header().Set("Etag", fmt.Sprintf(`"%x-%x"`, s.ModTime().Unix(), s.Size()))
+	}
+	http.ServeContent(w, r, s.Name(), s.ModTime(), f)
+}
+
+// internalRedirectPath returns the path in the root
+// folder of the redirect header
+func internalRedirectPath(root string, header http.Header) (string, error) {
+	if name := header.Get("X-Accel-Redirect"); name != "" {
+		return path.Clean("/" + name), nil
+	}
+
+	name := filepath.Clean(header.Get("X-Sendfile"))
+	prefix := filepath.Clean(root)
+	if !strings.HasSuffix(prefix, string(os.PathSeparator)) {
+		prefix += string(os.PathSeparator)
+	}
+	if !strings.HasPrefix(name, prefix) {
+		return "", fmt.Errorf("X-Sendfile %q is not in %q", name, root)
+	}
+	return filepath.ToSlash("/" + strings.TrimPrefix(name, prefix)), nil
+}
+
+// redirectInterceptor discards the response if it has an internal
+// redirect header and passes through the others
+type redirectInterceptor struct {
+	http.ResponseWriter
+	header      http.Header
+	wroteHeader bool
+	redirect    bool
+}
+
+func (w *redirectInterceptor) Header() http.Header {
+	if w.header == nil {
+		w.header = make(http.Header)
+	}
+	return w.header
+}
+
+func (w *redirectInterceptor) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if w.Header().Get("X-Accel-Redirect") != "" || w.Header().Get("X-Sendfile") != "" {
+		w.redirect = true
+		return
+	}
+	for k, v := range w.header {
+		w.ResponseWriter.Header()[k] = v
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *redirectInterceptor) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.redirect {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher, if the ResponseWriter does.
+// The response of an internal redirect is not flushed.
+func (w *redirectInterceptor) Flush() {
+	f, ok := w.ResponseWriter.(http.Flusher)
+	if !ok {
+		return
+	}
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.redirect {
+		f.Flush()
+	}
+}
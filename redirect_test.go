@@ -0,0 +1,130 @@
+package gofast_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yookoala/gofast"
+	"github.com/yookoala/gofast/fcgitest"
+)
+
+func TestInterceptInternalRedirect(t *testing.T) {
+	root, err := ioutil.TempDir("", "gofast-redirect-")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(root)
+	os.Mkdir(filepath.Join(root, "protected"), 0755)
+	ioutil.WriteFile(filepath.Join(root, "protected", "report.txt"), []byte("hello world"), 0644)
+
+	// the application responds with the header of the request
+	app := fcgitest.NewServer(func(ctx context.Context, req *gofast.Request, stdout, stderr io.Writer) int {
+		var steps []fcgitest.Step
+		if v := req.Params["HTTP_X_ACCEL_REDIRECT"]; v != "" {
+			steps = append(steps, fcgitest.Header("X-Accel-Redirect", v))
+		}
+		if v := req.Params["HTTP_X_SENDFILE"]; v != "" {
+			steps = append(steps, fcgitest.Header("X-Sendfile", v))
+		}
+		steps = append(steps,
+			fcgitest.Header("Content-Type", "application/octet-stream"),
+			fcgitest.Header("Content-Disposition", "attachment"),
+			fcgitest.Body("body of php"))
+		return fcgitest.Reply(steps...)(ctx, req, stdout, stderr)
+	})
+	defer app.Close()
+	h := gofast.InterceptInternalRedirect(root, gofast.NewHandler(
+		gofast.NewFileEndpoint("/var/www/html/download.php")(gofast.BasicSession),
+		app.ClientFactory(),
+	))
+
+	tests := []struct {
+		desc   string
+		header map[string]string
+		code   int
+		body   string
+	}{
+		{"no redirect", nil, http.StatusOK, "body of php"},
+		{"X-Accel-Redirect", map[string]string{"X-Accel-Redirect": "/protected/report.txt"}, http.StatusOK, "hello world"},
+		{"X-Sendfile", map[string]string{"X-Sendfile": filepath.Join(root, "protected", "report.txt")}, http.StatusOK, "hello world"},
+		{"range", map[string]string{"X-Accel-Redirect": "/protected/report.txt", "Range": "bytes=6-"}, http.StatusPartialContent, "world"},
+		{"not found", map[string]string{"X-Accel-Redirect": "/protected/nothing.txt"}, http.StatusNotFound, "404 page not found\n"},
+		{"traversal", map[string]string{"X-Accel-Redirect": "/../../etc/passwd"}, http.StatusNotFound, "404 page not found\n"},
+		{"X-Sendfile outside", map[string]string{"X-Sendfile": "/etc/passwd"}, http.StatusForbidden, "Forbidden\n"},
+	}
+	for _, tc := range tests {
+		r := httptest.NewRequest("GET", "/download.php", nil)
+		for k, v := range tc.header {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if want, have := tc.code, w.Code; want != have {
+			t.Errorf("%s: expected %#v, got %#v", tc.desc, want, have)
+		}
+		if want, have := tc.body, w.Body.String(); want != have {
+			t.Errorf("%s: expected %#v, got %#v", tc.desc, want, have)
+		}
+		if tc.code != http.StatusOK || tc.header == nil {
+			continue
+		}
+		if want, have := "attachment", w.Header().Get("Content-Disposition"); want != have {
+			t.Errorf("%s: expected %#v, got %#v", tc.desc, want, have)
+		}
+		if want, have := "application/octet-stream", w.Header().Get("Content-Type"); want != have {
+			t.Errorf("%s: expected %#v, got %#v", tc.desc, want, have)
+		}
+		if w.Header().Get("Etag") == "" {
+			t.Errorf("%s: expected ETag", tc.desc)
+		}
+		if want, have := "", w.Header().Get("X-Accel-Redirect")+w.Header().Get("X-Sendfile"); want != have {
+			t.Errorf("%s: expected %#v, got %#v", tc.desc, want, have)
+		}
+	}
+}
+
+func TestInterceptInternalRedirect_logger(t *testing.T) {
+	app := fcgitest.NewServer(fcgitest.Reply(fcgitest.Header("X-Sendfile", "/etc/passwd")))
+	defer app.Close()
+	inner := gofast.NewHandler(
+		gofast.NewFileEndpoint("/var/www/html/download.php")(gofast.BasicSession),
+		app.ClientFactory(),
+	)
+	buf := new(bytes.Buffer)
+	inner.SetLogger(log.New(buf, "", 0))
+	h := gofast.InterceptInternalRedirect(os.TempDir(), inner)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/download.php", nil))
+	if want, have := http.StatusForbidden, w.Code; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "gofast: invalid internal redirect: ", buf.String(); !strings.HasPrefix(have, want) {
+		t.Errorf("expected prefix %#v, got %#v", want, have)
+	}
+}
+
+func TestInterceptInternalRedirect_noBody(t *testing.T) {
+
+	// the inner handler only sets the headers
+	h := gofast.InterceptInternalRedirect(os.TempDir(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", "/login")
+	}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/download.php", nil))
+	if want, have := http.StatusOK, w.Code; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "/login", w.Header().Get("Location"); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
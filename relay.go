@@ -0,0 +1,86 @@
+package gofast
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// NewRelayHandler returns a ServerHandler that relays every FastCGI
+// request received by a Server to FastCGI application(s) behind, with
+// clients from the given ClientFactory.
+//
+// This allows gofast to sit between a web server speaking FastCGI (e.g.
+// nginx) and a farm of FastCGI backends, adding client pooling (see
+// ClientPool), balancing or other logics in between. The sessionHandler
+// may alter the request (e.g. Params) before it is relayed. Since the
+// requests are not from net/http, req.Raw is always nil. Middlewares
+// that depend on it (e.g. BasicParamsMap) cannot be used here.
+//
+// The request to the backend is aborted (FCGI_ABORT_REQUEST) when the
+// request to the relay is aborted by the web server.
+//
+// The response streams of the backend are relayed as is, then the
+// appStatus of the backend. If the backend cannot be reached, or fails
+// the request before any output, the relay responds with "502 Bad
+// Gateway" (or "503 Service Unavailable" if overloaded). The errors are
+// logged with the ErrorLog of the Server.
+func NewRelayHandler(sessionHandler SessionHandler, clientFactory ClientFactory) ServerHandler {
+	return func(ctx context.Context, req *Request, stdout, stderr io.Writer) (appStatus int) {
+		c, err := clientFactory()
+		if err != nil {
+			serverLogf(ctx, "gofast: relay unable to connect to FastCGI application. %s", err)
+			writeRelayError(stdout, http.StatusBadGateway)
+			return 1
+		}
+		defer func() {
+			if err := c.Close(); err != nil {
+				serverLogf(ctx, "gofast: relay error closing client: %s", err)
+			}
+		}()
+
+		// the request is aborted on the backend
+		// when the request to the relay is aborted
+		resp, err := sessionHandler(c, req.WithContext(ctx))
+		if err != nil {
+			serverLogf(ctx, "gofast: relay unable to process request %s", err)
+			writeRelayError(stdout, errorStatus(err, http.StatusBadGateway))
+			return 1
+		}
+
+		// the response pipes must be read concurrently
+		var wg sync.WaitGroup
+		var written int64
+		wg.Add(2)
+		go func() {
+			written, _ = io.Copy(stdout, resp.stdOutReader)
+			wg.Done()
+		}()
+		go func() {
+			io.Copy(stderr, resp.stdErrReader)
+			wg.Done()
+		}()
+		wg.Wait()
+
+		appStatus = resp.AppStatus()
+		if err := resp.Err(); err != nil {
+			serverLogf(ctx, "gofast: relay error of FastCGI application: %s", err)
+			if written == 0 {
+				writeRelayError(stdout, errorStatus(err, http.StatusBadGateway))
+			}
+			if appStatus == 0 {
+				appStatus = 1
+			}
+		}
+		return
+	}
+}
+
+// writeRelayError writes an error response in CGI format
+func writeRelayError(w io.Writer, code int) {
+	status := fmt.Sprintf("%d %s", code, http.StatusText(code))
+	fmt.Fprintf(w, "Status: %s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s\n",
+		status, status)
+}
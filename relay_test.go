@@ -0,0 +1,169 @@
+package gofast_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/yookoala/gofast"
+	"github.com/yookoala/gofast/fcgitest"
+)
+
+func TestRelayHandler(t *testing.T) {
+
+	// create temporary socket in the testing folder
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("unexpected error: %#v", err.Error())
+	}
+	sock := dir + "/test.relay.sock"
+
+	// the backend FastCGI application
+	l, err := newApp("unix", sock, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Relayed", r.Header.Get("X-Relayed"))
+		w.WriteHeader(202)
+		fmt.Fprintf(w, "hello %s", r.URL.Path)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %#v", err.Error())
+	}
+	defer os.Remove(sock)
+	defer l.Close()
+
+	// the relay in between, which adds a param
+	relay := gofast.NewServer(gofast.NewRelayHandler(
+		func(client gofast.Client, req *gofast.Request) (*gofast.ResponsePipe, error) {
+			req.Params["HTTP_X_RELAYED"] = "yes"
+			return client.Do(req)
+		},
+		gofast.SimpleClientFactory(gofast.SimpleConnFactory("unix", sock)),
+	))
+
+	h := gofast.NewHandler(
+		gofast.NewPHPFS("/var/www/html")(gofast.BasicSession),
+		pipeClientFactory(relay),
+	)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/world", nil))
+
+	if want, have := 202, w.Code; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "hello /world", w.Body.String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "yes", w.Header().Get("X-Relayed"); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestRelayHandler_badGateway(t *testing.T) {
+	relay := gofast.NewServer(gofast.NewRelayHandler(
+		gofast.BasicSession,
+		func() (gofast.Client, error) {
+			return nil, fmt.Errorf("dummy error")
+		},
+	))
+	var logs bytes.Buffer
+	relay.ErrorLog = log.New(&logs, "", 0)
+	h := gofast.NewHandler(
+		gofast.NewPHPFS("/var/www/html")(gofast.BasicSession),
+		pipeClientFactory(relay),
+	)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/world", nil))
+	if want, have := http.StatusBadGateway, w.Code; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "gofast: relay unable to connect to FastCGI application. dummy error\n", logs.String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestRelayHandler_appStatus(t *testing.T) {
+	tests := []struct {
+		desc      string
+		backend   gofast.ServerHandler
+		code      int
+		appStatus int
+		log       string
+	}{
+		{
+			desc:      "exit status",
+			backend:   fcgitest.Reply(fcgitest.Body("hello"), fcgitest.AppStatus(3)),
+			code:      http.StatusOK,
+			appStatus: 3,
+		},
+		{
+			desc:      "connection closed",
+			backend:   fcgitest.Reply(fcgitest.CloseConn()),
+			code:      http.StatusBadGateway,
+			appStatus: 1,
+			log:       "gofast: relay error of FastCGI application: gofast: protocol error: connection closed before FCGI_END_REQUEST (request 1)\n",
+		},
+	}
+	for _, tc := range tests {
+		backend := fcgitest.NewServer(tc.backend)
+		relay := gofast.NewServer(gofast.NewRelayHandler(gofast.BasicSession, backend.ClientFactory()))
+		var logs bytes.Buffer
+		relay.ErrorLog = log.New(&logs, "", 0)
+
+		c, err := pipeClientFactory(relay)()
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", tc.desc, err)
+		}
+		resp, err := c.Do(gofast.NewRequest(nil))
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", tc.desc, err)
+		}
+		w := httptest.NewRecorder()
+		resp.WriteTo(w, ioutil.Discard)
+		c.Close()
+		if want, have := tc.code, w.Code; want != have {
+			t.Errorf("%s: expected %#v, got %#v", tc.desc, want, have)
+		}
+		if want, have := tc.appStatus, resp.AppStatus(); want != have {
+			t.Errorf("%s: expected %#v, got %#v", tc.desc, want, have)
+		}
+		if want, have := tc.log, logs.String(); want != have {
+			t.Errorf("%s: expected %#v, got %#v", tc.desc, want, have)
+		}
+	}
+}
+
+func TestRelayHandler_abort(t *testing.T) {
+
+	// the backend waits until the request is aborted
+	aborted := make(chan struct{})
+	backend := gofast.NewServer(func(ctx context.Context, req *gofast.Request, stdout, stderr io.Writer) int {
+		<-ctx.Done()
+		close(aborted)
+		return 0
+	})
+	relay := gofast.NewServer(gofast.NewRelayHandler(gofast.BasicSession, pipeClientFactory(backend)))
+
+	h := gofast.NewHandler(
+		gofast.NewPHPFS("/var/www/html")(gofast.BasicSession),
+		pipeClientFactory(relay),
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/world", nil).WithContext(ctx))
+
+	select {
+	case <-aborted:
+	case <-time.After(time.Second):
+		t.Errorf("expected the abort relayed to the backend")
+	}
+}
@@ -0,0 +1,89 @@
+package gofast
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cgi"
+)
+
+// NewResponder returns a ServerHandler which serves the FastCGI
+// requests with the http.Handler, so a Go application can be served
+// by a Server behind a web server (e.g. nginx) as a FastCGI responder:
+//
+//	s := gofast.NewServer(gofast.NewResponder(mux))
+//	l, _ := net.Listen("tcp", "127.0.0.1:9000")
+//	s.Serve(l)
+//
+// The http.Request is built from the CGI params (see cgi.RequestFromMap),
+// with the FCGI_STDIN as the body and the ctx of the request as the
+// context. The response is written as CGI header lines (with the Status)
+// and the body to the FCGI_STDOUT.
+func NewResponder(handler http.Handler) ServerHandler {
+	return func(ctx context.Context, req *Request, stdout, stderr io.Writer) int {
+		r, err := cgi.RequestFromMap(req.Params)
+		if err != nil {
+			fmt.Fprintf(stderr, "gofast: error parsing request: %s", err)
+			fmt.Fprint(stdout, "Status: 400 Bad Request\r\nContent-Type: text/plain; charset=utf-8\r\n\r\nBad Request\n")
+			return 1
+		}
+		r.Body = req.Stdin
+		w := &responseWriter{stdout: stdout, header: make(http.Header)}
+		handler.ServeHTTP(w, r.WithContext(ctx))
+		if !w.wroteHeader {
+			w.WriteHeader(http.StatusOK)
+		}
+		return 0
+	}
+}
+
+// responseWriter implements http.ResponseWriter
+// of a request to the responder
+type responseWriter struct {
+	stdout      io.Writer
+	header      http.Header
+	wroteHeader bool
+}
+
+// Header implements http.ResponseWriter
+func (w *responseWriter) Header() http.Header {
+	return w.header
+}
+
+// WriteHeader implements http.ResponseWriter. The status is written
+// as the header line Status, with the other header lines.
+func (w *responseWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	fmt.Fprintf(w.stdout, "Status: %d %s\r\n", code, http.StatusText(code))
+	w.header.Write(w.stdout)
+	io.WriteString(w.stdout, "\r\n")
+}
+
+// Write implements http.ResponseWriter. The Content-Type, if
+// not set, is detected from the first write.
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		if w.header.Get("Content-Type") == "" {
+			w.header.Set("Content-Type", http.DetectContentType(p))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.stdout.Write(p)
+}
+
+// Flush implements http.Flusher. The output written
+// is sent to the web server as a FCGI_STDOUT record.
+func (w *responseWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if f, ok := w.stdout.(interface {
+		Flush() error
+	}); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,67 @@
+package gofast_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/yookoala/gofast"
+)
+
+func TestNewResponder(t *testing.T) {
+	s := gofast.NewServer(gofast.NewResponder(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+		w.Header().Set("X-Host", r.Host)
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, "%s %s %s %s", r.Method, r.URL.RequestURI(), r.Header.Get("X-Name"), body)
+	})))
+	h := gofast.NewHandler(
+		gofast.NewFileEndpoint("/var/www/html/index.php")(gofast.BasicSession),
+		pipeClientFactory(s),
+	)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "http://example.com/hello?a=1", strings.NewReader("hello body"))
+	r.Header.Set("X-Name", "world")
+	h.ServeHTTP(w, r)
+	if want, have := http.StatusCreated, w.Code; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "example.com", w.Header().Get("X-Host"); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "POST /hello?a=1 world hello body", w.Body.String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestNewResponder_contentType(t *testing.T) {
+	tests := []struct {
+		handler     http.HandlerFunc
+		code        int
+		contentType string
+	}{
+		{func(w http.ResponseWriter, r *http.Request) { fmt.Fprint(w, "<html>") }, 200, "text/html; charset=utf-8"},
+		{func(w http.ResponseWriter, r *http.Request) { fmt.Fprint(w, "hello") }, 200, "text/plain; charset=utf-8"},
+		{func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) }, 204, ""},
+		{func(w http.ResponseWriter, r *http.Request) {}, 200, ""},
+	}
+	for i, tc := range tests {
+		s := gofast.NewServer(gofast.NewResponder(tc.handler))
+		h := gofast.NewHandler(gofast.BasicParamsMap(gofast.BasicSession), pipeClientFactory(s))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		if want, have := tc.code, w.Code; want != have {
+			t.Errorf("%d: expected %#v, got %#v", i, want, have)
+		}
+		if want, have := tc.contentType, w.Header().Get("Content-Type"); want != have {
+			t.Errorf("%d: expected %#v, got %#v", i, want, have)
+		}
+	}
+}
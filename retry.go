@@ -0,0 +1,222 @@
+package gofast
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RetryPolicy is the policy of NewRetrySessionHandler
+type RetryPolicy struct {
+
+	// Attempts is the most attempts of a request, including
+	// the first one. No retry if 1 or less.
+	Attempts int
+
+	// Backoff is the wait before the second attempt, doubled
+	// for every attempt after. No wait if 0.
+	Backoff time.Duration
+
+	// Retryable reports whether the request may be sent again.
+	// IdempotentRequest if nil. Only the requests without a
+	// body are retried in any case.
+	Retryable func(r *http.Request) bool
+
+	// Clock of the backoff. SystemClock if nil.
+	Clock Clock
+}
+
+// IdempotentRequest reports whether the request is a GET or HEAD
+// request, which the application is safe to handle again
+func IdempotentRequest(r *http.Request) bool {
+	return r.Method == "GET" || r.Method == "HEAD"
+}
+
+// canRetry reports whether the request may be sent again
+func (p RetryPolicy) canRetry(req *Request) bool {
+	if p.Attempts <= 1 || req.Raw == nil || req.Data != nil {
+		return false
+	}
+	if req.Stdin != nil && req.Stdin != http.NoBody {
+		// the body is consumed by the first attempt
+		return false
+	}
+	if p.Retryable == nil {
+		return IdempotentRequest(req.Raw)
+	}
+	return p.Retryable(req.Raw)
+}
+
+// NewRetrySessionHandler returns a Middleware which retries the request
+// on a connection-level failure of the application (e.g. php-fpm
+// restarted and the pooled connection is broken), before anything of the
+// response is written to the client. The requests retried are of the
+// policy, and the retries are sent with new clients of the
+// ClientFactory (e.g. the CreateClient of a ClientPool).
+//
+// A connection-level failure is an ErrDial, or the connection failing
+// (e.g. closed before FCGI_END_REQUEST) before any output. The errors
+// of the application (e.g. FCGI_OVERLOADED) and timeouts are not
+// retried.
+//
+// The middleware waits for the first output of the application before
+// passing on the response, and should be the first of a Chain.
+func NewRetrySessionHandler(clientFactory ClientFactory, policy RetryPolicy) Middleware {
+	return func(inner SessionHandler) SessionHandler {
+		return func(client Client, req *Request) (resp *ResponsePipe, err error) {
+			if !policy.canRetry(req) {
+				return inner(client, req)
+			}
+			clock := clockOrSystem(policy.Clock)
+			wait := policy.Backoff
+			resp, err = inner(client, req)
+			for attempt := 2; attempt <= policy.Attempts && retryable(resp, err); attempt++ {
+
+				// wait for the backoff, unless the request is done
+				if wait > 0 {
+					select {
+					case <-clock.After(wait):
+					case <-req.Context().Done():
+						return
+					}
+					wait *= 2
+				}
+
+				// the client of the first attempt is left to the
+				// caller (e.g. the Handler closes it)
+				var c Client
+				if c, err = clientFactory(); err != nil {
+					resp = nil
+					continue
+				}
+				rc := &retryClient{Client: c}
+				if resp, err = inner(rc, req); err != nil {
+					rc.Close()
+				}
+			}
+			return
+		}
+	}
+}
+
+// retryable reports whether the result of the request is a
+// connection-level failure. The response is peeked for the
+// first output.
+func retryable(resp *ResponsePipe, err error) bool {
+	if err != nil {
+		return isError(err, ErrDial)
+	}
+	if resp == nil || resp.peek() {
+		return false
+	}
+	return connFailure(resp.Err())
+}
+
+// connFailure reports whether the error of a response is of the
+// connection, and not of the application or the request context
+func connFailure(err error) bool {
+	if err == nil || isError(err, ErrTimeout) {
+		return false
+	}
+	switch e := err.(type) {
+	case *BackendError:
+		return false
+	case *ProtocolError:
+		// only the connection closed
+		return e.Type == 0
+	}
+	return true
+}
+
+// peek waits for the first output of the stdout, or the end of it.
+// Returns true if there is output. The stderr is queued meanwhile,
+// so the application writing the stderr first is not blocked.
+func (pipes *ResponsePipe) peek() bool {
+	pipes.stdErrReader = newQueueReader(pipes.stdErrReader)
+	stdout := bufio.NewReader(pipes.stdOutReader)
+	pipes.stdOutReader = stdout
+	_, err := stdout.Peek(1)
+	return err == nil
+}
+
+// retryClient is a client of the retries by NewRetrySessionHandler,
+// which closes itself once done with the request
+type retryClient struct {
+	Client
+	once sync.Once
+}
+
+// Do implements Client.Do. The client is closed once
+// the stdout of the response is read to the end.
+func (c *retryClient) Do(req *Request) (*ResponsePipe, error) {
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		c.Close()
+		return resp, err
+	}
+	r := resp.stdOutReader
+	resp.stdOutReader = readerFunc(func(p []byte) (int, error) {
+		n, err := r.Read(p)
+		if err != nil {
+			c.Close()
+		}
+		return n, err
+	})
+	return resp, nil
+}
+
+// Close implements Client.Close. The inner client is closed once.
+func (c *retryClient) Close() (err error) {
+	c.once.Do(func() {
+		err = c.Client.Close()
+	})
+	return
+}
+
+// queueReader reads from a reader through a queue not bounded,
+// so the writer of the reader is not blocked
+type queueReader struct {
+	mutex sync.Mutex
+	cond  *sync.Cond
+	queue []byte
+	err   error
+}
+
+func newQueueReader(r io.Reader) *queueReader {
+	q := &queueReader{}
+	q.cond = sync.NewCond(&q.mutex)
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, err := r.Read(buf)
+			q.mutex.Lock()
+			q.queue = append(q.queue, buf[:n]...)
+			if err != nil {
+				q.err = err
+			}
+			q.cond.Broadcast()
+			q.mutex.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return q
+}
+
+// Read implements io.Reader
+func (q *queueReader) Read(p []byte) (int, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for len(q.queue) == 0 && q.err == nil {
+		q.cond.Wait()
+	}
+	if len(q.queue) == 0 {
+		return 0, q.err
+	}
+	n := copy(p, q.queue)
+	q.queue = q.queue[n:]
+	return n, nil
+}
@@ -0,0 +1,115 @@
+package gofast_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/yookoala/gofast"
+)
+
+// brokenConnFactory connects to a peer which closes
+// the connection at once, like a restarted php-fpm
+func brokenConnFactory(dials *int32) gofast.ConnFactory {
+	return func() (net.Conn, error) {
+		atomic.AddInt32(dials, 1)
+		appConn, webConn := net.Pipe()
+		appConn.Close()
+		return webConn, nil
+	}
+}
+
+func TestNewRetrySessionHandler(t *testing.T) {
+	s := gofast.NewServer(echoName)
+	var broken, retries int32
+	retryConnFactory := muxConnFactory(s, "", nil)
+	clientFactory := gofast.SimpleClientFactory(func() (net.Conn, error) {
+		atomic.AddInt32(&retries, 1)
+		return retryConnFactory()
+	})
+	retry := gofast.NewRetrySessionHandler(clientFactory, gofast.RetryPolicy{Attempts: 3})
+	sessionHandler := retry(func(client gofast.Client, req *gofast.Request) (*gofast.ResponsePipe, error) {
+		req.Params["NAME"] = "hello"
+		return client.Do(req)
+	})
+	h := gofast.NewHandler(sessionHandler, gofast.SimpleClientFactory(brokenConnFactory(&broken)))
+
+	// the failure of the first client is retried with a new client
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if want, have := 200, w.Code; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "hello", w.Body.String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := int32(1), atomic.LoadInt32(&retries); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	// requests not idempotent are not retried
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/", strings.NewReader("data")))
+	if want, have := 502, w.Code; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := int32(1), atomic.LoadInt32(&retries); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestNewRetrySessionHandler_attempts(t *testing.T) {
+	var broken, dials int32
+	retry := gofast.NewRetrySessionHandler(
+		gofast.SimpleClientFactory(brokenConnFactory(&dials)),
+		gofast.RetryPolicy{Attempts: 3},
+	)
+	h := gofast.NewHandler(retry(gofast.BasicSession), gofast.SimpleClientFactory(brokenConnFactory(&broken)))
+
+	// the response of the last attempt
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if want, have := 502, w.Code; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := int32(2), atomic.LoadInt32(&dials); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestNewRetrySessionHandler_output(t *testing.T) {
+	var retries int32
+	clientFactory := func() (gofast.Client, error) {
+		atomic.AddInt32(&retries, 1)
+		return nil, gofast.ErrPoolExhausted
+	}
+	retry := gofast.NewRetrySessionHandler(clientFactory, gofast.RetryPolicy{
+		Attempts:  3,
+		Retryable: func(r *http.Request) bool { return true },
+	})
+	s := gofast.NewServer(func(ctx context.Context, req *gofast.Request, stdout, stderr io.Writer) int {
+		fmt.Fprint(stderr, "warning")
+		fmt.Fprint(stdout, "Status: 500\r\nContent-Type: text/plain\r\n\r\nfailed")
+		return 1
+	})
+	h := gofast.NewHandler(retry(gofast.BasicSession), gofast.SimpleClientFactory(muxConnFactory(s, "", nil)))
+
+	// the response of the application is not retried
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("DELETE", "/", nil))
+	if want, have := 500, w.Code; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "failed", w.Body.String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := int32(0), atomic.LoadInt32(&retries); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
@@ -0,0 +1,95 @@
+//go:build go1.22
+// +build go1.22
+
+package gofast
+
+import (
+	"net/http"
+	"strings"
+)
+
+// HandlePattern registers h to the mux for the given Go 1.22 ServeMux
+// pattern (i.e. "[METHOD ][HOST]/[PATH]"). See PatternHandler for how h
+// sees the request.
+//
+// Note: Go 1.22 patterns are only enabled if the main module declares
+// go 1.22 or later in its go.mod (or with GODEBUG=httpmuxgo121=0).
+func HandlePattern(mux *http.ServeMux, pattern string, h http.Handler) {
+	mux.Handle(pattern, PatternHandler(pattern, h))
+}
+
+// PatternHandler returns an http.Handler that serves h for requests
+// matched by the given Go 1.22 ServeMux pattern.
+//
+// If the pattern matches a path prefix (i.e. ends with "/" or a "{name...}"
+// wildcard), the matched prefix is stripped as Mount does. For example,
+// with the pattern "/sites/{site}/{path...}", the request to
+// "/sites/foo/index.php/hello" is passed to h as "/index.php/hello" with
+// prefix "/sites/foo". Chain MapMountPrefix after the path mapping
+// middlewares so SCRIPT_NAME and DOCUMENT_URI are derived correctly
+// (e.g. "/sites/foo/index.php", with PATH_INFO "/hello").
+//
+// The values of other wildcards are stored as route parameters (see
+// WithRouteParams), which can be mapped by MapRouteParams.
+func PatternHandler(pattern string, h http.Handler) http.Handler {
+	names, strip := parsePattern(pattern)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(names) > 0 {
+			params := make(map[string]string, len(names))
+			for _, name := range names {
+				params[name] = r.PathValue(name)
+			}
+			r = WithRouteParams(r, params)
+		}
+		if strip < 0 {
+			h.ServeHTTP(w, r)
+			return
+		}
+		Mount(pathPrefix(r.URL.Path, strip), h).ServeHTTP(w, r)
+	})
+}
+
+// parsePattern parses the path of a ServeMux pattern. Returns the names
+// of wildcards (except the trailing "{name...}"), and the number of path
+// segments to strip as prefix (-1 if the pattern matches exact path).
+func parsePattern(pattern string) (names []string, strip int) {
+	// strip method and host
+	if i := strings.IndexAny(pattern, " \t"); i >= 0 && i < strings.Index(pattern, "/") {
+		pattern = strings.TrimLeft(pattern[i:], " \t")
+	}
+	if i := strings.Index(pattern, "/"); i >= 0 {
+		pattern = pattern[i:]
+	}
+
+	segments := strings.Split(strings.TrimPrefix(pattern, "/"), "/")
+	last := segments[len(segments)-1]
+	switch {
+	case last == "{$}":
+		strip, segments = -1, segments[:len(segments)-1]
+	case last == "":
+		strip, segments = len(segments)-1, segments[:len(segments)-1]
+	case strings.HasPrefix(last, "{") && strings.HasSuffix(last, "...}"):
+		strip, segments = len(segments)-1, segments[:len(segments)-1]
+	default:
+		strip = -1
+	}
+	for _, seg := range segments {
+		if strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") {
+			names = append(names, seg[1:len(seg)-1])
+		}
+	}
+	if strip == 0 {
+		// root pattern, nothing to strip
+		strip = -1
+	}
+	return
+}
+
+// pathPrefix returns the first n segments of the path
+func pathPrefix(p string, n int) string {
+	segments := strings.SplitN(strings.TrimPrefix(p, "/"), "/", n+1)
+	if len(segments) > n {
+		segments = segments[:n]
+	}
+	return "/" + strings.Join(segments, "/")
+}
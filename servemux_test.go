@@ -0,0 +1,97 @@
+//go:build go1.22
+// +build go1.22
+
+//go:debug httpmuxgo121=0
+
+package gofast_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/yookoala/gofast"
+)
+
+// paramsRecorder returns a Handler which records the params
+// of the last request as the FastCGI application sees
+func paramsRecorder(middleware gofast.Middleware, params *map[string]string) gofast.Handler {
+	return gofast.NewHandler(
+		middleware(func(client gofast.Client, req *gofast.Request) (*gofast.ResponsePipe, error) {
+			*params = req.Params
+			return nil, fmt.Errorf("recorded")
+		}),
+		func() (gofast.Client, error) {
+			return gofast.ClientFunc(nil), nil
+		},
+	)
+}
+
+func TestHandlePattern(t *testing.T) {
+	chain := gofast.Chain(
+		gofast.NewPHPFS("/var/www/html"),
+		gofast.MapMountPrefix,
+		gofast.MapRouteParams,
+	)
+	var sites, api, root map[string]string
+	mux := http.NewServeMux()
+	gofast.HandlePattern(mux, "GET example.com/sites/{site}/{path...}", paramsRecorder(chain, &sites))
+	gofast.HandlePattern(mux, "POST /api/", paramsRecorder(chain, &api))
+	gofast.HandlePattern(mux, "/{$}", paramsRecorder(chain, &root))
+
+	tests := []struct {
+		desc   string
+		method string
+		url    string
+		params *map[string]string
+		wants  map[string]string
+	}{
+		{
+			desc:   "wildcard prefix",
+			method: "GET",
+			url:    "http://example.com/sites/foo/index.php/hello",
+			params: &sites,
+			wants: map[string]string{
+				"SCRIPT_NAME":     "/sites/foo/index.php",
+				"SCRIPT_FILENAME": "/var/www/html/index.php",
+				"PATH_INFO":       "/hello",
+				"DOCUMENT_URI":    "/sites/foo/index.php/hello",
+				"ROUTE_SITE":      "foo",
+			},
+		},
+		{
+			desc:   "trailing slash prefix",
+			method: "POST",
+			url:    "http://other.com/api/v1/users.php",
+			params: &api,
+			wants: map[string]string{
+				"SCRIPT_NAME":     "/api/v1/users.php",
+				"SCRIPT_FILENAME": "/var/www/html/v1/users.php",
+				"PATH_INFO":       "",
+			},
+		},
+		{
+			desc:   "exact root",
+			method: "GET",
+			url:    "http://other.com/",
+			params: &root,
+			wants: map[string]string{
+				"SCRIPT_NAME":     "/index.php",
+				"SCRIPT_FILENAME": "/var/www/html/index.php",
+			},
+		},
+	}
+	for _, test := range tests {
+		mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(test.method, test.url, nil))
+		if *test.params == nil {
+			t.Errorf("%s: expected to be handled", test.desc)
+			continue
+		}
+		for name, want := range test.wants {
+			if have := (*test.params)[name]; want != have {
+				t.Errorf("%s: %s expected %#v, got %#v", test.desc, name, want, have)
+			}
+		}
+	}
+}
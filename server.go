@@ -0,0 +1,536 @@
+package gofast
+
+// This file implements the application side of FastCGI
+// as specified in http://www.mit.edu/~yandros/doc/specs/fcgi-spec.html
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// ErrServerClosed is returned by Server.Serve after a call to Close.
+var ErrServerClosed = errors.New("gofast: Server closed")
+
+// defaultMaxParamsBytes is the maximum size of the FCGI_PARAMS
+// of a request if Server.MaxParamsBytes is zero
+const defaultMaxParamsBytes = 1 << 20
+
+// maxServerQueueBytes is the maximum of the stdin / data content
+// of a request queued by Server and not read yet by the handler
+const maxServerQueueBytes = 4 << 20
+
+type serverCtxKey int
+
+const ctxKeyServer serverCtxKey = iota
+
+// ServerHandler handles a FastCGI request accepted by a Server.
+//
+// The handler should write the CGI response (header lines, blank line,
+// then body) to stdout, and error messages to stderr. They will be sent
+// to the web server as FCGI_STDOUT and FCGI_STDERR streams. The returned
+// appStatus is reported to the web server in FCGI_END_REQUEST.
+//
+// The req.Stdin (and req.Data for filter role) streams the request
+// content as received. The ctx is canceled if the web server aborts the
+// request (FCGI_ABORT_REQUEST) or the connection is closed.
+type ServerHandler func(ctx context.Context, req *Request, stdout, stderr io.Writer) (appStatus int)
+
+// NewServer returns a *Server that serves FastCGI requests
+// with the given handler.
+func NewServer(handler ServerHandler) *Server {
+	return &Server{
+		Handler: handler,
+	}
+}
+
+// Server implements the application side of FastCGI. It accepts
+// connections from web servers (e.g. nginx, or gofast itself), then
+// dispatches every request to the Handler.
+//
+// Requests are multiplexed on a connection if the web server does so.
+type Server struct {
+
+	// Handler handles all the requests accepted.
+	Handler ServerHandler
+
+	// MaxConns and MaxReqs are reported to the web server as
+	// FCGI_MAX_CONNS and FCGI_MAX_REQS in FCGI_GET_VALUES_RESULT.
+	// Not reported if zero.
+	MaxConns int
+	MaxReqs  int
+
+	// ErrorLog specifies an optional logger for errors. If nil,
+	// logging is done via the log package's standard logger.
+	ErrorLog *log.Logger
+
+	// MaxParamsBytes is the maximum size of the FCGI_PARAMS of a
+	// request. The connection is closed if a request exceeds it.
+	// 1 MB if zero.
+	MaxParamsBytes int
+
+	// ConnContext optionally specifies a function that modifies the
+	// context used for the requests of a new connection. The ctx is
+	// derived from context.Background.
+	ConnContext func(ctx context.Context, rwc io.ReadWriteCloser) context.Context
+
+	mutex     sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[*serverConn]struct{}
+	closed    bool
+}
+
+func (s *Server) logf(format string, args ...interface{}) {
+	if s.ErrorLog != nil {
+		s.ErrorLog.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
+
+// serverLogf logs with the Server serving the request of ctx,
+// or the log package's standard logger if none
+func serverLogf(ctx context.Context, format string, args ...interface{}) {
+	if s, ok := ctx.Value(ctxKeyServer).(*Server); ok {
+		s.logf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
+
+func (s *Server) maxParamsBytes() int {
+	if s.MaxParamsBytes > 0 {
+		return s.MaxParamsBytes
+	}
+	return defaultMaxParamsBytes
+}
+
+// Serve accepts incoming connections on the listener and serves them in
+// separated goroutines. Serve always returns a non-nil error. After Close,
+// the returned error is ErrServerClosed.
+func (s *Server) Serve(l net.Listener) error {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return ErrServerClosed
+	}
+	if s.listeners == nil {
+		s.listeners = make(map[net.Listener]struct{})
+	}
+	s.listeners[l] = struct{}{}
+	s.mutex.Unlock()
+
+	defer func() {
+		s.mutex.Lock()
+		delete(s.listeners, l)
+		s.mutex.Unlock()
+		l.Close()
+	}()
+
+	for {
+		rwc, err := l.Accept()
+		if err != nil {
+			s.mutex.Lock()
+			closed := s.closed
+			s.mutex.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		go s.ServeConn(rwc)
+	}
+}
+
+// ServeConn serves FastCGI requests on a single connection (e.g. one end
+// of net.Pipe) and blocks until the connection is closed, by either side.
+func (s *Server) ServeConn(rwc io.ReadWriteCloser) {
+	ctx := context.WithValue(context.Background(), ctxKeyServer, s)
+	if s.ConnContext != nil {
+		ctx = s.ConnContext(ctx, rwc)
+	}
+	sc := &serverConn{
+		server:   s,
+		ctx:      ctx,
+		rwc:      rwc,
+		conn:     newConn(rwc),
+		requests: make(map[uint16]*serverRequest),
+	}
+
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		rwc.Close()
+		return
+	}
+	if s.conns == nil {
+		s.conns = make(map[*serverConn]struct{})
+	}
+	s.conns[sc] = struct{}{}
+	s.mutex.Unlock()
+
+	sc.serve()
+
+	s.mutex.Lock()
+	delete(s.conns, sc)
+	s.mutex.Unlock()
+}
+
+// Close immediately closes all listeners and connections of the Server.
+func (s *Server) Close() (err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.closed = true
+	for l := range s.listeners {
+		if cerr := l.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	for sc := range s.conns {
+		sc.rwc.Close()
+	}
+	return
+}
+
+// serverRequest holds the state of a request
+// being received by serverConn
+type serverRequest struct {
+	id       uint16
+	req      *Request
+	params   bytes.Buffer
+	stdin    *serverStream
+	data     *serverStream
+	ctx      context.Context
+	cancel   context.CancelFunc
+	keepConn bool
+}
+
+// abort stops the request content streams and cancel the context
+func (sr *serverRequest) abort(err error) {
+	sr.cancel()
+	if sr.stdin != nil {
+		sr.stdin.CloseWithError(err)
+	}
+	if sr.data != nil {
+		sr.data.CloseWithError(err)
+	}
+}
+
+// serverConn serves a single connection for Server
+type serverConn struct {
+	server *Server
+	ctx    context.Context
+	rwc    io.ReadWriteCloser
+	conn   *conn
+
+	mutex    sync.Mutex
+	requests map[uint16]*serverRequest
+}
+
+func (sc *serverConn) serve() {
+	defer sc.close()
+	rec := newRecord()
+	defer rec.free()
+	for {
+		if err := rec.read(sc.rwc); err != nil {
+			return
+		}
+		if err := sc.handleRecord(rec); err != nil {
+			sc.server.logf("gofast: closing connection: %s", err)
+			return
+		}
+	}
+}
+
+func (sc *serverConn) close() {
+	sc.rwc.Close()
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	for id, sr := range sc.requests {
+		sr.abort(io.ErrUnexpectedEOF)
+		delete(sc.requests, id)
+	}
+}
+
+func (sc *serverConn) getRequest(id uint16) *serverRequest {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	return sc.requests[id]
+}
+
+func (sc *serverConn) handleRecord(rec *record) error {
+	if rec.h.ID == 0 {
+		return sc.handleManagementRecord(rec)
+	}
+
+	switch rec.h.Type {
+	case typeBeginRequest:
+		var br beginRequest
+		if err := br.read(rec.content()); err != nil {
+			return err
+		}
+		if role := Role(br.role); role != RoleResponder && role != RoleAuthorizer && role != RoleFilter {
+			if err := sc.conn.writeEndRequest(rec.h.ID, 0, statusUnknownRole); err != nil {
+				return err
+			}
+			if br.flags&flagKeepConn == 0 {
+				sc.rwc.Close()
+			}
+			return nil
+		}
+		ctx, cancel := context.WithCancel(sc.ctx)
+		req := NewRequest(nil)
+		req.Role = Role(br.role)
+		req.KeepConn = br.flags&flagKeepConn != 0
+		sc.mutex.Lock()
+		if _, ok := sc.requests[rec.h.ID]; ok {
+			sc.mutex.Unlock()
+			cancel()
+			return fmt.Errorf("duplicated request id %d", rec.h.ID)
+		}
+		sc.requests[rec.h.ID] = &serverRequest{
+			id:       rec.h.ID,
+			req:      req,
+			ctx:      ctx,
+			cancel:   cancel,
+			keepConn: req.KeepConn,
+		}
+		sc.mutex.Unlock()
+		return nil
+	case typeParams:
+		sr := sc.getRequest(rec.h.ID)
+		if sr == nil || sr.stdin != nil {
+			// ignore records of unknown request
+			return nil
+		}
+		if len(rec.content()) > 0 {
+			if max := sc.server.maxParamsBytes(); sr.params.Len()+len(rec.content()) > max {
+				return fmt.Errorf("params of request %d exceed %d bytes", rec.h.ID, max)
+			}
+			sr.params.Write(rec.content())
+			return nil
+		}
+
+		// the end of the params stream
+		params, err := decodePairs(sr.params.Bytes())
+		if err != nil {
+			return err
+		}
+		for k, v := range params {
+			sr.req.Params[k] = v
+		}
+		sr.stdin = newServerStream()
+		sr.req.Stdin = sr.stdin
+		if sr.req.Role == RoleFilter {
+			sr.data = newServerStream()
+			sr.req.Data = sr.data
+		}
+		go sc.serveRequest(sr)
+		return nil
+	case typeStdin:
+		if sr := sc.getRequest(rec.h.ID); sr != nil && sr.stdin != nil {
+			writeStream(sr.stdin, rec.content())
+		}
+		return nil
+	case typeData:
+		if sr := sc.getRequest(rec.h.ID); sr != nil && sr.data != nil {
+			writeStream(sr.data, rec.content())
+		}
+		return nil
+	case typeAbortRequest:
+		if sr := sc.getRequest(rec.h.ID); sr != nil {
+			sr.abort(fmt.Errorf("gofast: request aborted"))
+		}
+		return nil
+	}
+
+	// ignore other record types
+	return nil
+}
+
+// writeStream writes the content of a stream record to the stream.
+// Empty content marks the end of stream.
+func writeStream(s *serverStream, content []byte) {
+	if len(content) == 0 {
+		s.closeWrite()
+		return
+	}
+	s.write(content)
+}
+
+// serverStream is the stdin / data stream of a request received by
+// serverConn. The content is queued up to maxServerQueueBytes, so the
+// record read loop of the connection is not held by a handler that
+// reads the stream slowly, or not at all. Past that, write waits for
+// the handler to read or close the stream.
+type serverStream struct {
+	mutex sync.Mutex
+	cond  *sync.Cond
+	buf   bytes.Buffer
+	eof   bool
+	err   error
+}
+
+func newServerStream() *serverStream {
+	s := &serverStream{}
+	s.cond = sync.NewCond(&s.mutex)
+	return s
+}
+
+// write queues a copy of the content. The content is dropped
+// if the stream is closed, as the handler may stop reading the
+// stream at any time.
+func (s *serverStream) write(p []byte) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for s.buf.Len() >= maxServerQueueBytes && s.err == nil {
+		s.cond.Wait()
+	}
+	if s.err != nil || s.eof {
+		return
+	}
+	s.buf.Write(p)
+	s.cond.Broadcast()
+}
+
+// closeWrite ends the stream after the queued content
+func (s *serverStream) closeWrite() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.eof = true
+	s.cond.Broadcast()
+}
+
+// CloseWithError drops the queued content, then the
+// reads return the error
+func (s *serverStream) CloseWithError(err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.err == nil {
+		s.err = err
+	}
+	s.buf.Reset()
+	s.cond.Broadcast()
+}
+
+// Read implements io.Reader
+func (s *serverStream) Read(p []byte) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for s.buf.Len() == 0 && !s.eof && s.err == nil {
+		s.cond.Wait()
+	}
+	if s.err != nil {
+		return 0, s.err
+	}
+	if s.buf.Len() == 0 {
+		return 0, io.EOF
+	}
+	n, _ := s.buf.Read(p)
+	s.cond.Broadcast()
+	return n, nil
+}
+
+// Close implements io.Closer
+func (s *serverStream) Close() error {
+	s.CloseWithError(io.ErrClosedPipe)
+	return nil
+}
+
+func (sc *serverConn) handleManagementRecord(rec *record) error {
+	switch rec.h.Type {
+	case typeGetValues:
+		query, err := decodePairs(rec.content())
+		if err != nil {
+			return err
+		}
+		values := make(map[string]string)
+		for k := range query {
+			switch k {
+			case "FCGI_MAX_CONNS":
+				if sc.server.MaxConns > 0 {
+					values[k] = strconv.Itoa(sc.server.MaxConns)
+				}
+			case "FCGI_MAX_REQS":
+				if sc.server.MaxReqs > 0 {
+					values[k] = strconv.Itoa(sc.server.MaxReqs)
+				}
+			case "FCGI_MPXS_CONNS":
+				values[k] = "1"
+			}
+		}
+
+		// FCGI_GET_VALUES_RESULT is a single record, not a stream
+		return sc.conn.writeRecord(typeGetValuesResult, 0, encodePairs(values))
+	}
+
+	// reply to unknown management record
+	b := [8]byte{byte(rec.h.Type)}
+	return sc.conn.writeRecord(typeUnknownType, 0, b[:])
+}
+
+func (sc *serverConn) serveRequest(sr *serverRequest) {
+	stdout := newWriter(sc.conn, typeStdout, sr.id)
+	stderr := newWriter(sc.conn, typeStderr, sr.id)
+
+	appStatus := func() (appStatus int) {
+		defer func() {
+			if r := recover(); r != nil {
+				sc.server.logf("gofast: panic serving request %d: %v", sr.id, r)
+				appStatus = 1
+			}
+		}()
+		return sc.server.Handler(sr.ctx, sr.req, stdout, stderr)
+	}()
+
+	// stop receiving the remaining stdin / data
+	sr.req.Stdin.Close()
+	if sr.req.Data != nil {
+		sr.req.Data.Close()
+	}
+
+	stdout.Close()
+	stderr.Close()
+	sc.conn.writeEndRequest(sr.id, appStatus, statusRequestComplete)
+
+	sc.mutex.Lock()
+	delete(sc.requests, sr.id)
+	sc.mutex.Unlock()
+	sr.cancel()
+
+	if !sr.keepConn {
+		sc.rwc.Close()
+	}
+}
+
+// decodePairs decodes the content of name-value pair stream
+// (e.g. FCGI_PARAMS, FCGI_GET_VALUES)
+func decodePairs(b []byte) (pairs map[string]string, err error) {
+	pairs = make(map[string]string)
+	for len(b) > 0 {
+		keyLen, n := readSize(b)
+		if n == 0 {
+			return nil, errors.New("fcgi: invalid name-value pair length")
+		}
+		b = b[n:]
+		valLen, n := readSize(b)
+		if n == 0 {
+			return nil, errors.New("fcgi: invalid name-value pair length")
+		}
+		b = b[n:]
+		if uint64(keyLen)+uint64(valLen) > uint64(len(b)) {
+			return nil, errors.New("fcgi: name-value pair exceeds content length")
+		}
+		key := string(b[:keyLen])
+		b = b[keyLen:]
+		pairs[key] = string(b[:valLen])
+		b = b[valLen:]
+	}
+	return
+}
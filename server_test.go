@@ -0,0 +1,282 @@
+package gofast_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yookoala/gofast"
+)
+
+// pipeClientFactory returns a ClientFactory that connects to
+// the given server through net.Pipe
+func pipeClientFactory(s *gofast.Server) gofast.ClientFactory {
+	return gofast.SimpleClientFactory(func() (net.Conn, error) {
+		appConn, webConn := net.Pipe()
+		go s.ServeConn(appConn)
+		return webConn, nil
+	})
+}
+
+func TestServer_ServeConn(t *testing.T) {
+	s := gofast.NewServer(func(ctx context.Context, req *gofast.Request, stdout, stderr io.Writer) int {
+		body, err := ioutil.ReadAll(req.Stdin)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+		fmt.Fprintf(stdout, "Status: 201 Created\r\n")
+		fmt.Fprintf(stdout, "Content-Type: text/plain\r\n\r\n")
+		fmt.Fprintf(stdout, "%s %s %s", req.Params["REQUEST_METHOD"], req.Params["SCRIPT_NAME"], body)
+		fmt.Fprintf(stderr, "hello stderr")
+		return 0
+	})
+
+	h := gofast.NewHandler(
+		gofast.NewFileEndpoint("/var/www/html/index.php")(gofast.BasicSession),
+		pipeClientFactory(s),
+	)
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/hello", strings.NewReader("hello body"))
+	r.Header.Set("Content-Length", "10")
+	h.ServeHTTP(w, r)
+
+	if want, have := 201, w.Code; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "POST /index.php hello body", w.Body.String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestServer_GetValues(t *testing.T) {
+	s := &gofast.Server{MaxConns: 10}
+	appConn, webConn := net.Pipe()
+	defer webConn.Close()
+	go s.ServeConn(appConn)
+
+	// FCGI_GET_VALUES record with 2 names of empty values
+	content := "\x0e\x00FCGI_MAX_CONNS" + "\x0f\x00FCGI_MPXS_CONNS"
+	padding := -len(content) & 7
+	go webConn.Write([]byte("\x01\x09\x00\x00\x00" + string(rune(len(content))) +
+		string(rune(padding)) + "\x00" + content + strings.Repeat("\x00", padding)))
+
+	// read the FCGI_GET_VALUES_RESULT header
+	webConn.SetReadDeadline(time.Now().Add(time.Second))
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(webConn, header); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want, have := byte(10), header[1]; want != have {
+		t.Errorf("expected record type %#v, got %#v", want, have)
+	}
+	body := make([]byte, int(header[4])<<8+int(header[5])+int(header[6]))
+	if _, err := io.ReadFull(webConn, body); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for _, pair := range []string{
+		"\x0e\x02FCGI_MAX_CONNS10",
+		"\x0f\x01FCGI_MPXS_CONNS1",
+	} {
+		if !bytes.Contains(body, []byte(pair)) {
+			t.Errorf("expected %#v in result, got %#v", pair, body)
+		}
+	}
+
+	// the result is a single record, without an empty record after it
+	webConn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if n, err := webConn.Read(header); err == nil {
+		t.Errorf("unexpected record after FCGI_GET_VALUES_RESULT: %#v", header[:n])
+	}
+}
+
+func TestServer_unknownRole(t *testing.T) {
+	s := gofast.NewServer(func(ctx context.Context, req *gofast.Request, stdout, stderr io.Writer) int {
+		t.Errorf("unexpected request of role %d", req.Role)
+		return 0
+	})
+	appConn, webConn := net.Pipe()
+	defer webConn.Close()
+	go s.ServeConn(appConn)
+
+	// FCGI_BEGIN_REQUEST of role 42, without FCGI_KEEP_CONN
+	go webConn.Write([]byte("\x01\x01\x00\x01\x00\x08\x00\x00" + "\x00\x2a\x00\x00\x00\x00\x00\x00"))
+
+	// read the FCGI_END_REQUEST
+	webConn.SetReadDeadline(time.Now().Add(time.Second))
+	rec := make([]byte, 16)
+	if _, err := io.ReadFull(webConn, rec); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want, have := byte(3), rec[1]; want != have {
+		t.Errorf("expected record type %#v, got %#v", want, have)
+	}
+	if want, have := byte(3), rec[12]; want != have {
+		t.Errorf("expected protocol status FCGI_UNKNOWN_ROLE, got %#v", have)
+	}
+
+	// the connection is closed without FCGI_KEEP_CONN
+	if _, err := webConn.Read(rec); err != io.EOF {
+		t.Errorf("expected io.EOF, got %#v", err)
+	}
+}
+
+func TestServer_Close(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	s := gofast.NewServer(func(ctx context.Context, req *gofast.Request, stdout, stderr io.Writer) int {
+		fmt.Fprintf(stdout, "Content-Type: text/plain\r\n\r\nhello")
+		return 0
+	})
+	served := make(chan error)
+	go func() {
+		served <- s.Serve(l)
+	}()
+
+	// make a request through the listener
+	h := gofast.NewHandler(
+		gofast.NewFileEndpoint("/var/www/html/index.php")(gofast.BasicSession),
+		gofast.SimpleClientFactory(gofast.SimpleConnFactory("tcp", l.Addr().String())),
+	)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if want, have := "hello", w.Body.String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	s.Close()
+	select {
+	case err := <-served:
+		if want, have := gofast.ErrServerClosed, err; want != have {
+			t.Errorf("expected %#v, got %#v", want, have)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Serve does not return after Close")
+	}
+}
+
+func TestServer_abort(t *testing.T) {
+	aborted := make(chan struct{})
+	s := gofast.NewServer(func(ctx context.Context, req *gofast.Request, stdout, stderr io.Writer) int {
+		<-ctx.Done()
+		close(aborted)
+		return 0
+	})
+	appConn, webConn := net.Pipe()
+	go s.ServeConn(appConn)
+
+	// begin request and params, then close the connection
+	webConn.Write([]byte("\x01\x01\x00\x01\x00\x08\x00\x00\x00\x01\x01\x00\x00\x00\x00\x00"))
+	webConn.Write([]byte("\x01\x04\x00\x01\x00\x00\x00\x00"))
+	webConn.Close()
+
+	select {
+	case <-aborted:
+	case <-time.After(time.Second):
+		t.Errorf("handler context is not canceled after connection close")
+	}
+}
+
+func TestServer_ConnContext(t *testing.T) {
+	type ctxKey struct{}
+	s := gofast.NewServer(func(ctx context.Context, req *gofast.Request, stdout, stderr io.Writer) int {
+		fmt.Fprintf(stdout, "Content-Type: text/plain\r\n\r\n%v", ctx.Value(ctxKey{}))
+		return 0
+	})
+	s.ConnContext = func(ctx context.Context, rwc io.ReadWriteCloser) context.Context {
+		return context.WithValue(ctx, ctxKey{}, "hello")
+	}
+
+	h := gofast.NewHandler(gofast.BasicSession, pipeClientFactory(s))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if want, have := "hello", w.Body.String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestServer_httpRequest(t *testing.T) {
+	// make sure the Server also works with web server
+	// that responses with http.Handler
+	s := gofast.NewServer(func(ctx context.Context, req *gofast.Request, stdout, stderr io.Writer) int {
+		fmt.Fprintf(stdout, "Location: /somewhere\r\n\r\n")
+		return 0
+	})
+	h := gofast.NewHandler(
+		gofast.NewFileEndpoint("/var/www/html/index.php")(gofast.BasicSession),
+		pipeClientFactory(s),
+	)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if want, have := http.StatusFound, w.Code; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestServer_stdinNotRead(t *testing.T) {
+	aborted := make(chan struct{})
+	s := gofast.NewServer(func(ctx context.Context, req *gofast.Request, stdout, stderr io.Writer) int {
+		<-ctx.Done()
+		close(aborted)
+		return 0
+	})
+	appConn, webConn := net.Pipe()
+	defer webConn.Close()
+	go s.ServeConn(appConn)
+
+	// begin request, params and stdin not read by the handler,
+	// then abort the request on the same connection
+	go func() {
+		webConn.Write([]byte("\x01\x01\x00\x01\x00\x08\x00\x00\x00\x01\x01\x00\x00\x00\x00\x00"))
+		webConn.Write([]byte("\x01\x04\x00\x01\x00\x00\x00\x00"))
+		webConn.Write([]byte("\x01\x05\x00\x01\x00\x05\x03\x00hello\x00\x00\x00"))
+		webConn.Write([]byte("\x01\x02\x00\x01\x00\x00\x00\x00"))
+	}()
+
+	select {
+	case <-aborted:
+	case <-time.After(time.Second):
+		t.Errorf("abort is not read after the stdin not read by the handler")
+	}
+}
+
+func TestServer_MaxParamsBytes(t *testing.T) {
+	var logs bytes.Buffer
+	s := &gofast.Server{
+		Handler: func(ctx context.Context, req *gofast.Request, stdout, stderr io.Writer) int {
+			t.Errorf("unexpected request of params exceeding the limit")
+			return 0
+		},
+		MaxParamsBytes: 16,
+		ErrorLog:       log.New(&logs, "", 0),
+	}
+	appConn, webConn := net.Pipe()
+	defer webConn.Close()
+	go s.ServeConn(appConn)
+
+	// begin request, then 17 bytes of params
+	go func() {
+		webConn.Write([]byte("\x01\x01\x00\x01\x00\x08\x00\x00\x00\x01\x01\x00\x00\x00\x00\x00"))
+		webConn.Write([]byte("\x01\x04\x00\x01\x00\x11\x07\x00" + "\x0f\x00SCRIPT_FILENAME" + strings.Repeat("\x00", 7)))
+		webConn.Write([]byte("\x01\x04\x00\x01\x00\x00\x00\x00"))
+	}()
+
+	// the connection is closed
+	webConn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := webConn.Read(make([]byte, 8)); err != io.EOF {
+		t.Errorf("expected io.EOF, got %#v", err)
+	}
+	if want, have := "gofast: closing connection: params of request 1 exceed 16 bytes\n", logs.String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
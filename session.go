@@ -66,7 +66,7 @@ func Chain(middlewares ...Middleware) Middleware {
 	return func(inner SessionHandler) (out SessionHandler) {
 		out = inner
 		for i := len(middlewares) - 1; i >= 0; i-- {
-			out = middlewares[i](out)
+			out = traced(middlewares[i], out)
 		}
 		return
 	}
@@ -200,7 +200,7 @@ type FileSystemRouter struct {
 func (fs *FileSystemRouter) Router() Middleware {
 	pathinfoRe := regexp.MustCompile(`^(.+\.php)(/?.+)$`)
 	docroot := filepath.Join(fs.DocRoot) // converts to absolute path
-	return func(inner SessionHandler) SessionHandler {
+	return Describe("gofast.FileSystemRouter", "DocRoot="+docroot, func(inner SessionHandler) SessionHandler {
 		return func(client Client, req *Request) (*ResponsePipe, error) {
 
 			// define some required cgi parameters
@@ -236,7 +236,7 @@ func (fs *FileSystemRouter) Router() Middleware {
 
 			return inner(client, req)
 		}
-	}
+	})
 }
 
 // MapHeader implement Middleware to map header field HTTP_*
@@ -301,7 +301,7 @@ func MapHeader(inner SessionHandler) SessionHandler {
 //
 func MapEndpoint(endpointFile string) Middleware {
 	dir, webpath := filepath.Dir(endpointFile), "/"+filepath.Base(endpointFile)
-	return func(inner SessionHandler) SessionHandler {
+	return Describe("gofast.MapEndpoint", "endpoint="+endpointFile, func(inner SessionHandler) SessionHandler {
 		return func(client Client, req *Request) (*ResponsePipe, error) {
 			r := req.Raw
 			req.Params["REQUEST_URI"] = r.URL.RequestURI()
@@ -311,7 +311,7 @@ func MapEndpoint(endpointFile string) Middleware {
 			req.Params["DOCUMENT_ROOT"] = dir
 			return inner(client, req)
 		}
-	}
+	})
 }
 
 // MapFilterRequest changes the request role to RoleFilter and add the
@@ -391,6 +391,19 @@ func NewFilterFS(fs http.FileSystem) Middleware {
 	)
 }
 
+// NewFilterEndpoint chains BasicParamsMap, MapHeader, MapFilterRequest
+// and MapEndpoint to implement Middleware that prepares a fastcgi Filter
+// session of the given filter application file (e.g. a markdown
+// renderer), with the requested file of fs as the data stream.
+func NewFilterEndpoint(endpointFile string, fs http.FileSystem) Middleware {
+	return Chain(
+		BasicParamsMap,
+		MapHeader,
+		MapFilterRequest(fs),
+		MapEndpoint(endpointFile),
+	)
+}
+
 // NewPHPFS chains BasicParamsMap, MapHeader and FileSystemRouter to implement
 // Middleware that prepares an ordinary PHP hosting session environment.
 func NewPHPFS(root string) Middleware {
@@ -1,9 +1,12 @@
 package gofast_test
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"strconv"
 	"strings"
@@ -180,6 +183,56 @@ func TestMapFilterRequest(t *testing.T) {
 	}
 }
 
+func TestNewFilterEndpoint(t *testing.T) {
+
+	// more than a record of data
+	content := strings.Repeat("hello world\n", 10000)
+	vfs := VFS{
+		"doc.txt": FileEntry{
+			FileInfo: FileInfo{
+				name:    "doc.txt",
+				size:    int64(len(content)),
+				mode:    0644,
+				modTime: time.Now(),
+			},
+			content: content,
+		},
+	}
+
+	// dummy filter application to upper case the data
+	s := gofast.NewServer(func(ctx context.Context, req *gofast.Request, stdout, stderr io.Writer) int {
+		if want, have := gofast.RoleFilter, req.Role; want != have {
+			t.Errorf("expected: %#v, got: %#v", want, have)
+		}
+		if want, have := "/usr/lib/cgi-bin/upper.py", req.Params["SCRIPT_FILENAME"]; want != have {
+			t.Errorf("expected: %#v, got: %#v", want, have)
+		}
+		if want, have := strconv.Itoa(len(content)), req.Params["FCGI_DATA_LENGTH"]; want != have {
+			t.Errorf("expected: %#v, got: %#v", want, have)
+		}
+		ioutil.ReadAll(req.Stdin)
+		data, err := ioutil.ReadAll(req.Data)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+		fmt.Fprintf(stdout, "Content-Type: text/plain\r\n\r\n%s", strings.ToUpper(string(data)))
+		return 0
+	})
+	h := gofast.NewHandler(
+		gofast.NewFilterEndpoint("/usr/lib/cgi-bin/upper.py", vfs)(gofast.BasicSession),
+		gofast.SimpleClientFactory(muxConnFactory(s, "", nil)),
+	)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/doc.txt", nil))
+	if want, have := http.StatusOK, w.Code; want != have {
+		t.Errorf("expected: %#v, got: %#v", want, have)
+	}
+	if want, have := strings.ToUpper(content), w.Body.String(); want != have {
+		t.Errorf("expected %d bytes of upper case data, got %d bytes", len(want), len(have))
+	}
+}
+
 func TestFileSystemRouter_PathTraversal(t *testing.T) {
 	fs := &gofast.FileSystemRouter{
 		DocRoot:  "/non-exists/folder/structure",
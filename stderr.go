@@ -0,0 +1,105 @@
+package gofast
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+)
+
+// HandlerOption is an option of the Handler of NewHandler
+type HandlerOption func(h *defaultHandler)
+
+// StderrHandler handles the FCGI_STDERR output of a request (e.g. the
+// warnings of PHP). It is called with the output as it is read, so a
+// message may come in pieces. p must not be retained after the call.
+type StderrHandler func(req *Request, p []byte)
+
+// WithStderrHandler sets the StderrHandler of the Handler, e.g. to log
+// the output with the correlation ID of the request, to aggregate it
+// per request, or to suppress it with a function doing nothing.
+//
+// By default, the output of a request is collected and logged when
+// the request ends, with the logger of SetLogger, if any.
+func WithStderrHandler(stderrHandler StderrHandler) HandlerOption {
+	return func(h *defaultHandler) {
+		h.stderrHandler = stderrHandler
+	}
+}
+
+// WithStderrOnServerError appends the FCGI_STDERR output of a request to
+// the body of the response if the application responds a status of 5xx,
+// so the errors are shown to the developer. The response must not have a
+// Content-Length. This is for development only, as the output may expose
+// the details of the application.
+func WithStderrOnServerError() HandlerOption {
+	return func(h *defaultHandler) {
+		h.stderrOnServerError = true
+	}
+}
+
+// writeResponse writes the response to w, and passes the stderr
+// to the StderrHandler, or the logger at the end
+func (h *defaultHandler) writeResponse(w http.ResponseWriter, req *Request, resp *ResponsePipe) {
+	errBuffer := new(bytes.Buffer)
+	var ew io.Writer = errBuffer
+	if h.stderrHandler != nil {
+		ew = writerFunc(func(p []byte) (int, error) {
+			h.stderrHandler(req, p)
+			return len(p), nil
+		})
+		if h.stderrOnServerError {
+			ew = io.MultiWriter(ew, errBuffer)
+		}
+	}
+	sw := &statusWriter{ResponseWriter: w}
+	if h.stderrOnServerError {
+		w = sw
+	}
+	resp.streaming = h.streaming
+	if err := resp.WriteTo(w, ew); err != nil {
+		h.logf("gofast: error writing error buffer to response: %s", err)
+	}
+
+	if h.stderrOnServerError && sw.code >= 500 && errBuffer.Len() > 0 {
+		sw.ResponseWriter.Write(errBuffer.Bytes())
+	}
+	if h.stderrHandler == nil && errBuffer.Len() > 0 {
+		h.logf("gofast: error stream from application process %s",
+			errBuffer.String())
+	}
+}
+
+// writerFunc implements io.Writer with a function
+type writerFunc func(p []byte) (int, error)
+
+// Write implements io.Writer
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+// statusWriter records the status code written
+type statusWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher, if the ResponseWriter does
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
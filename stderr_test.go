@@ -0,0 +1,99 @@
+package gofast_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/yookoala/gofast"
+)
+
+// stderrApp responds with the status of the param STATUS,
+// and writes a warning to the stderr
+func stderrApp(ctx context.Context, req *gofast.Request, stdout, stderr io.Writer) int {
+	fmt.Fprint(stderr, "PHP Warning: "+req.Params["HTTP_X_REQUEST_ID"])
+	fmt.Fprintf(stdout, "Status: %s\r\nContent-Type: text/plain\r\n\r\nbody", req.Params["STATUS"])
+	return 0
+}
+
+// withStatus sets the param STATUS of the requests
+func withStatus(status string) gofast.SessionHandler {
+	return gofast.MapHeader(func(client gofast.Client, req *gofast.Request) (*gofast.ResponsePipe, error) {
+		req.Params["STATUS"] = status
+		return client.Do(req)
+	})
+}
+
+func TestWithStderrHandler(t *testing.T) {
+	s := gofast.NewServer(stderrApp)
+	var mutex sync.Mutex
+	stderr := make(map[string]string)
+	h := gofast.NewHandler(
+		withStatus("200"),
+		gofast.SimpleClientFactory(muxConnFactory(s, "", nil)),
+		gofast.WithStderrHandler(func(req *gofast.Request, p []byte) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			stderr[req.Raw.Header.Get("X-Request-Id")] += string(p)
+		}),
+	)
+	var logs bytes.Buffer
+	h.SetLogger(log.New(&logs, "", 0))
+
+	for _, id := range []string{"a", "b"} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("X-Request-Id", id)
+		h.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	if want, have := (map[string]string{"a": "PHP Warning: a", "b": "PHP Warning: b"}), stderr; fmt.Sprint(want) != fmt.Sprint(have) {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	// the output is handled, and not logged
+	if want, have := "", logs.String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestHandler_stderrLogger(t *testing.T) {
+	s := gofast.NewServer(stderrApp)
+	h := gofast.NewHandler(withStatus("200"), gofast.SimpleClientFactory(muxConnFactory(s, "", nil)))
+	var logs bytes.Buffer
+	h.SetLogger(log.New(&logs, "", 0))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if want, have := "gofast: error stream from application process PHP Warning: \n", logs.String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestWithStderrOnServerError(t *testing.T) {
+	s := gofast.NewServer(stderrApp)
+	tests := []struct {
+		status string
+		body   string
+	}{
+		{"200", "body"},
+		{"500", "bodyPHP Warning: a"},
+	}
+	for _, tc := range tests {
+		h := gofast.NewHandler(
+			withStatus(tc.status),
+			gofast.SimpleClientFactory(muxConnFactory(s, "", nil)),
+			gofast.WithStderrOnServerError(),
+		)
+		h.SetLogger(log.New(ioutil.Discard, "", 0))
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("X-Request-Id", "a")
+		h.ServeHTTP(w, r)
+		if want, have := tc.body, w.Body.String(); want != have {
+			t.Errorf("%s: expected %#v, got %#v", tc.status, want, have)
+		}
+	}
+}
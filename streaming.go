@@ -0,0 +1,39 @@
+package gofast
+
+import (
+	"net/http"
+	"strings"
+)
+
+// WithResponseStreaming sets whether the Handler flushes the body of
+// every response to the http client a FCGI_STDOUT record at a time, as
+// the application outputs it (e.g. a progress stream of a script calling
+// flush), instead of buffering it in the http.ResponseWriter. The
+// responses of Content-Type text/event-stream (Server-Sent Events) are
+// always streamed. The http.ResponseWriter must be an http.Flusher.
+func WithResponseStreaming(streaming bool) HandlerOption {
+	return func(h *defaultHandler) {
+		h.streaming = streaming
+	}
+}
+
+// isEventStream reports whether the header is of
+// a Server-Sent Events stream (text/event-stream)
+func isEventStream(header http.Header) bool {
+	mediaType := strings.SplitN(header.Get("Content-Type"), ";", 2)[0]
+	return strings.EqualFold(strings.TrimSpace(mediaType), "text/event-stream")
+}
+
+// flushWriter flushes the ResponseWriter after every write
+type flushWriter struct {
+	w http.ResponseWriter
+	f http.Flusher
+}
+
+// Write implements io.Writer
+func (w *flushWriter) Write(p []byte) (n int, err error) {
+	if n, err = w.w.Write(p); err == nil {
+		w.f.Flush()
+	}
+	return
+}
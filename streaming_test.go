@@ -0,0 +1,104 @@
+package gofast_test
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/yookoala/gofast"
+)
+
+// streamApp responds with the Content-Type of the param CONTENT_TYPE,
+// and an event flushed, then another once next is closed
+func streamApp(next <-chan struct{}) gofast.ServerHandler {
+	return func(ctx context.Context, req *gofast.Request, stdout, stderr io.Writer) int {
+		fmt.Fprintf(stdout, "Content-Type: %s\r\n\r\ndata: 1\n\n", req.Params["CONTENT_TYPE"])
+		stdout.(interface{ Flush() error }).Flush()
+		select {
+		case <-next:
+		case <-ctx.Done():
+		}
+		fmt.Fprint(stdout, "data: 2\n\n")
+		return 0
+	}
+}
+
+// withContentType sets the param CONTENT_TYPE of the requests
+func withContentType(contentType string) gofast.SessionHandler {
+	return gofast.MapHeader(func(client gofast.Client, req *gofast.Request) (*gofast.ResponsePipe, error) {
+		req.Params["CONTENT_TYPE"] = contentType
+		return client.Do(req)
+	})
+}
+
+func TestWithResponseStreaming(t *testing.T) {
+	tests := []struct {
+		contentType string
+		options     []gofast.HandlerOption
+	}{
+		{"text/event-stream", nil},
+		{"Text/Event-Stream; charset=utf-8", []gofast.HandlerOption{gofast.WithStderrOnServerError()}},
+		{"text/plain", []gofast.HandlerOption{gofast.WithResponseStreaming(true)}},
+	}
+	for _, test := range tests {
+		next := make(chan struct{})
+		s := gofast.NewServer(streamApp(next))
+		ts := httptest.NewServer(gofast.NewHandler(
+			withContentType(test.contentType),
+			gofast.SimpleClientFactory(muxConnFactory(s, "", nil)),
+			test.options...,
+		))
+
+		resp, err := http.Get(ts.URL)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		// the first event is read before the second is written
+		lines := make(chan string)
+		go func() {
+			r := bufio.NewReader(resp.Body)
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					close(lines)
+					return
+				}
+				lines <- line
+			}
+		}()
+		select {
+		case line := <-lines:
+			if want, have := "data: 1\n", line; want != have {
+				t.Errorf("%s: expected %#v, got %#v", test.contentType, want, have)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("%s: expected the first event streamed", test.contentType)
+		}
+		close(next)
+		for range lines {
+		}
+		resp.Body.Close()
+		ts.Close()
+	}
+}
+
+func TestWithResponseStreaming_buffered(t *testing.T) {
+	next := make(chan struct{})
+	close(next)
+	s := gofast.NewServer(streamApp(next))
+	h := gofast.NewHandler(withContentType("text/plain"), gofast.SimpleClientFactory(muxConnFactory(s, "", nil)))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if want, have := "data: 1\n\ndata: 2\n\n", w.Body.String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if w.Flushed {
+		t.Errorf("expected the response not flushed")
+	}
+}
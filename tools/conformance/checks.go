@@ -0,0 +1,403 @@
+package conformance
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// keepConnWait is the time to wait for the application which
+// wrongly closes the connection with FCGI_KEEP_CONN
+const keepConnWait = 500 * time.Millisecond
+
+// Check is a conformance check
+type Check struct {
+	Name        string
+	Description string
+	run         func(t *T) error
+}
+
+// Checks are all the conformance checks, in the order to run
+var Checks = []Check{
+	{"responder", "a simple responder request", checkResponder},
+	{"padding", "records with padding", checkPadding},
+	{"get-values", "FCGI_GET_VALUES management record", checkGetValues},
+	{"unknown-type", "unknown management record type", checkUnknownType},
+	{"keep-conn", "sequential requests with FCGI_KEEP_CONN", checkKeepConn},
+	{"close-conn", "closing connection without FCGI_KEEP_CONN", checkCloseConn},
+	{"abort", "FCGI_ABORT_REQUEST during the request", checkAbort},
+	{"large-params", "params larger than a record", checkLargeParams},
+	{"large-stdin", "stdin larger than a record", checkLargeStdin},
+	{"multiplex", "multiplexed requests on a connection", checkMultiplex},
+	{"unknown-role", "request of unknown role", checkUnknownRole},
+}
+
+func findCheck(name string) (Check, bool) {
+	for _, check := range Checks {
+		if check.Name == name {
+			return check, true
+		}
+	}
+	return Check{}, false
+}
+
+// completed checks if the response completes the request
+func completed(resp *response) error {
+	if resp.protocolStatus != statusRequestComplete {
+		return fmt.Errorf("protocol status %s", statusName(resp.protocolStatus))
+	}
+	return nil
+}
+
+func checkResponder(t *T) error {
+	c, err := t.dial()
+	if err != nil {
+		return err
+	}
+	werr := c.async(func() error {
+		return c.request(1, t.params(0), nil, false)
+	})
+	resp, err := c.readResponse(1)
+	if err != nil {
+		return err
+	}
+	if err = <-werr; err != nil {
+		return err
+	}
+	if err = completed(resp); err != nil {
+		return err
+	}
+	if len(resp.stdout) == 0 {
+		return warnf("no FCGI_STDOUT content")
+	}
+	if !bytes.Contains(resp.stdout, []byte("\r\n\r\n")) && !bytes.Contains(resp.stdout, []byte("\n\n")) {
+		return warnf("no CGI header in FCGI_STDOUT")
+	}
+	t.logf("app status %d, %d bytes of stdout", resp.appStatus, len(resp.stdout))
+	return nil
+}
+
+func checkPadding(t *T) error {
+	c, err := t.dial()
+	if err != nil {
+		return err
+	}
+
+	// every record is padded with the maximum 255 bytes,
+	// and params are split into unaligned records of 7 bytes
+	werr := c.async(func() error {
+		if err := c.write(typeBeginRequest, 1, []byte{0, byte(roleResponder), 0, 0, 0, 0, 0, 0}, 255); err != nil {
+			return err
+		}
+		params := encodePairs(t.params(5))
+		for len(params) > 0 {
+			n := 7
+			if n > len(params) {
+				n = len(params)
+			}
+			if err := c.write(typeParams, 1, params[:n], 255); err != nil {
+				return err
+			}
+			params = params[n:]
+		}
+		if err := c.write(typeParams, 1, nil, 255); err != nil {
+			return err
+		}
+		if err := c.write(typeStdin, 1, []byte("hello"), 3); err != nil {
+			return err
+		}
+		return c.write(typeStdin, 1, nil, 255)
+	})
+
+	resp, err := c.readResponse(1)
+	if err != nil {
+		return err
+	}
+	if err = <-werr; err != nil {
+		return err
+	}
+	if err = completed(resp); err != nil {
+		return err
+	}
+	if resp.unaligned > 0 {
+		t.logf("%d records of the application are not padded to 8 bytes", resp.unaligned)
+	}
+	return nil
+}
+
+func checkGetValues(t *T) error {
+	c, err := t.dial()
+	if err != nil {
+		return err
+	}
+	values, err := getValues(c)
+	if err != nil {
+		return err
+	}
+	names := []string{"FCGI_MAX_CONNS", "FCGI_MAX_REQS", "FCGI_MPXS_CONNS"}
+	reported := make([]string, 0, len(names))
+	for _, name := range names {
+		if v, ok := values[name]; ok {
+			reported = append(reported, name+"="+v)
+		}
+	}
+	if len(reported) == 0 {
+		return warnf("no value reported")
+	}
+	t.logf("%s", strings.Join(reported, ", "))
+	return nil
+}
+
+// getValues queries the application with FCGI_GET_VALUES
+func getValues(c *conn) (map[string]string, error) {
+	query := encodePairs(map[string]string{
+		"FCGI_MAX_CONNS":  "",
+		"FCGI_MAX_REQS":   "",
+		"FCGI_MPXS_CONNS": "",
+	})
+	if err := c.write(typeGetValues, 0, query, -len(query)&7); err != nil {
+		return nil, err
+	}
+	rec, err := c.read()
+	if err != nil {
+		return nil, fmt.Errorf("no FCGI_GET_VALUES_RESULT: %s", err)
+	}
+	if rec.typ != typeGetValuesResult || rec.id != 0 {
+		return nil, fmt.Errorf("expected FCGI_GET_VALUES_RESULT, got %s of request id %d", typeName(rec.typ), rec.id)
+	}
+	values, err := decodePairs(rec.content)
+	if err != nil {
+		return nil, fmt.Errorf("invalid FCGI_GET_VALUES_RESULT: %s", err)
+	}
+	return values, nil
+}
+
+func checkUnknownType(t *T) error {
+	c, err := t.dial()
+	if err != nil {
+		return err
+	}
+	const unknown = 200
+	if err = c.write(unknown, 0, nil, 0); err != nil {
+		return err
+	}
+	rec, err := c.read()
+	if err != nil {
+		return fmt.Errorf("no FCGI_UNKNOWN_TYPE: %s", err)
+	}
+	if rec.typ != typeUnknownType || rec.id != 0 {
+		return fmt.Errorf("expected FCGI_UNKNOWN_TYPE, got %s of request id %d", typeName(rec.typ), rec.id)
+	}
+	if len(rec.content) != 8 || rec.content[0] != unknown {
+		return fmt.Errorf("invalid FCGI_UNKNOWN_TYPE content %v", rec.content)
+	}
+	return nil
+}
+
+func checkKeepConn(t *T) error {
+	c, err := t.dial()
+	if err != nil {
+		return err
+	}
+	for i := 1; i <= 3; i++ {
+		werr := c.async(func() error {
+			return c.request(1, t.params(0), nil, true)
+		})
+		resp, err := c.readResponse(1)
+		if err != nil {
+			return fmt.Errorf("request %d: %s", i, err)
+		}
+		if err = <-werr; err != nil {
+			return fmt.Errorf("request %d: %s", i, err)
+		}
+		if err = completed(resp); err != nil {
+			return fmt.Errorf("request %d: %s", i, err)
+		}
+	}
+	closed, err := c.isClosed(keepConnWait)
+	if err != nil {
+		return err
+	}
+	if closed {
+		return fmt.Errorf("connection closed by the application with FCGI_KEEP_CONN")
+	}
+	return nil
+}
+
+func checkCloseConn(t *T) error {
+	c, err := t.dial()
+	if err != nil {
+		return err
+	}
+	werr := c.async(func() error {
+		return c.request(1, t.params(0), nil, false)
+	})
+	resp, err := c.readResponse(1)
+	if err != nil {
+		return err
+	}
+	if err = <-werr; err != nil {
+		return err
+	}
+	if err = completed(resp); err != nil {
+		return err
+	}
+	closed, err := c.isClosed(t.config.Timeout)
+	if err != nil {
+		return err
+	}
+	if !closed {
+		return fmt.Errorf("connection not closed by the application after FCGI_END_REQUEST")
+	}
+	return nil
+}
+
+func checkAbort(t *T) error {
+	c, err := t.dial()
+	if err != nil {
+		return err
+	}
+
+	// abort when the stdin is not yet complete
+	werr := c.async(func() error {
+		if err := c.beginRequest(1, roleResponder, true); err != nil {
+			return err
+		}
+		if err := c.writeStream(typeParams, 1, encodePairs(t.params(1024)), maxContent); err != nil {
+			return err
+		}
+		if err := c.write(typeStdin, 1, make([]byte, 512), 0); err != nil {
+			return err
+		}
+		return c.write(typeAbortRequest, 1, nil, 0)
+	})
+	resp, err := c.readResponse(1)
+	if err != nil {
+		return fmt.Errorf("no FCGI_END_REQUEST after FCGI_ABORT_REQUEST: %s", err)
+	}
+	if err = <-werr; err != nil {
+		return err
+	}
+	t.logf("app status %d, protocol status %s", resp.appStatus, statusName(resp.protocolStatus))
+	return nil
+}
+
+func checkLargeParams(t *T) error {
+	c, err := t.dial()
+	if err != nil {
+		return err
+	}
+	params := t.params(0)
+	params["HTTP_X_CONFORMANCE_LONG"] = strings.Repeat("x", 70000)
+	for i := 0; i < 500; i++ {
+		params[fmt.Sprintf("HTTP_X_CONFORMANCE_%d", i)] = strings.Repeat("y", 130)
+	}
+	werr := c.async(func() error {
+		return c.request(1, params, nil, false)
+	})
+	resp, err := c.readResponse(1)
+	if err != nil {
+		return err
+	}
+	if err = <-werr; err != nil {
+		return err
+	}
+	return completed(resp)
+}
+
+func checkLargeStdin(t *T) error {
+	c, err := t.dial()
+	if err != nil {
+		return err
+	}
+	stdin := bytes.Repeat([]byte("0123456789abcdef"), 1<<16) // 1 MiB
+	werr := c.async(func() error {
+		return c.request(1, t.params(len(stdin)), stdin, false)
+	})
+	resp, err := c.readResponse(1)
+	if err != nil {
+		return err
+	}
+	if err = <-werr; err != nil {
+		return err
+	}
+	return completed(resp)
+}
+
+func checkMultiplex(t *T) error {
+	c, err := t.dial()
+	if err != nil {
+		return err
+	}
+	values, err := getValues(c)
+	if err != nil {
+		return skipf("unable to query FCGI_MPXS_CONNS: %s", err)
+	}
+	if values["FCGI_MPXS_CONNS"] != "1" {
+		return skipf("the application does not multiplex connections")
+	}
+
+	// interleave the records of the requests
+	ids := []uint16{1, 2, 3}
+	werr := c.async(func() error {
+		for _, id := range ids {
+			if err := c.beginRequest(id, roleResponder, true); err != nil {
+				return err
+			}
+		}
+		for _, id := range ids {
+			if err := c.writeStream(typeParams, id, encodePairs(t.params(0)), maxContent); err != nil {
+				return err
+			}
+		}
+		for i := len(ids) - 1; i >= 0; i-- {
+			if err := c.writeStream(typeStdin, ids[i], nil, maxContent); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	responses, err := c.readResponses(ids...)
+	if err != nil {
+		return err
+	}
+	if err = <-werr; err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if resp := responses[id]; resp.protocolStatus == statusCantMultiplex {
+			return fmt.Errorf("request %d: FCGI_CANT_MPX_CONN while FCGI_MPXS_CONNS=1", id)
+		} else if err = completed(resp); err != nil {
+			return fmt.Errorf("request %d: %s", id, err)
+		}
+	}
+	return nil
+}
+
+func checkUnknownRole(t *T) error {
+	c, err := t.dial()
+	if err != nil {
+		return err
+	}
+
+	// the application may close the connection without
+	// reading the request, so the write errors are ignored
+	c.async(func() error {
+		if err := c.beginRequest(1, 42, false); err != nil {
+			return err
+		}
+		if err := c.writeStream(typeParams, 1, encodePairs(t.params(0)), maxContent); err != nil {
+			return err
+		}
+		return c.writeStream(typeStdin, 1, nil, maxContent)
+	})
+	resp, err := c.readResponse(1)
+	if err != nil {
+		return warnf("no FCGI_END_REQUEST: %s", err)
+	}
+	if resp.protocolStatus != statusUnknownRole {
+		return warnf("expected protocol status FCGI_UNKNOWN_ROLE, got %s", statusName(resp.protocolStatus))
+	}
+	return nil
+}
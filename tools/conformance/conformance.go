@@ -0,0 +1,247 @@
+// Package conformance exercises a FastCGI application (e.g. php-fpm, or
+// an application built with gofast.Server) for compliance with the
+// FastCGI specification, and reports the results.
+//
+// The checks speak raw FastCGI records, so the behaviours of the
+// application that gofast.Client never triggers (e.g. padding, abort,
+// multiplexing, management records) are also covered.
+//
+// See http://www.mit.edu/~yandros/doc/specs/fcgi-spec.html for the
+// specification.
+package conformance
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/yookoala/gofast"
+)
+
+// Status is the status of a check result
+type Status int
+
+// Statuses of check result
+const (
+	Pass Status = iota
+	Warn
+	Fail
+	Skip
+)
+
+// String implements fmt.Stringer
+func (s Status) String() string {
+	switch s {
+	case Pass:
+		return "PASS"
+	case Warn:
+		return "WARN"
+	case Fail:
+		return "FAIL"
+	}
+	return "SKIP"
+}
+
+// Config configures a conformance test run
+type Config struct {
+
+	// ConnFactory connects to the application under test
+	ConnFactory gofast.ConnFactory
+
+	// Params are added to the default params of every request (e.g.
+	// SCRIPT_FILENAME of a script the application can run). The checks
+	// only judge the protocol, not the response of the application.
+	Params map[string]string
+
+	// Timeout of every read and write. Default 5 seconds.
+	Timeout time.Duration
+
+	// Checks are the names of the checks to run. All checks
+	// are run if empty.
+	Checks []string
+}
+
+// Result is the result of a check
+type Result struct {
+	Check    Check
+	Status   Status
+	Message  string
+	Duration time.Duration
+}
+
+// Report is the results of a conformance test run
+type Report struct {
+	Results []Result
+}
+
+// Count returns the number of results of the status
+func (r *Report) Count(status Status) (n int) {
+	for _, result := range r.Results {
+		if result.Status == status {
+			n++
+		}
+	}
+	return
+}
+
+// Passed returns true if no check fails. Warnings
+// and skipped checks are not failures.
+func (r *Report) Passed() bool {
+	return r.Count(Fail) == 0
+}
+
+// WriteTo writes the report as text. Implements io.WriterTo.
+func (r *Report) WriteTo(w io.Writer) (int64, error) {
+	cw := &countWriter{w: w}
+	for _, result := range r.Results {
+		fmt.Fprintf(cw, "%s  %-14s %s", result.Status, result.Check.Name, result.Check.Description)
+		if result.Message != "" {
+			fmt.Fprintf(cw, ": %s", result.Message)
+		}
+		fmt.Fprintf(cw, "\n")
+	}
+	fmt.Fprintf(cw, "\n%d passed, %d warnings, %d failed, %d skipped\n",
+		r.Count(Pass), r.Count(Warn), r.Count(Fail), r.Count(Skip))
+	return cw.n, cw.err
+}
+
+// countWriter counts the bytes written and
+// keeps the first error
+type countWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (cw *countWriter) Write(b []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	n, err := cw.w.Write(b)
+	cw.n += int64(n)
+	cw.err = err
+	return n, err
+}
+
+// Run runs the checks against the application and returns the report.
+// The checks run one after another, each with new connection(s).
+func Run(c Config) (*Report, error) {
+	if c.ConnFactory == nil {
+		return nil, fmt.Errorf("conformance: no ConnFactory")
+	}
+	if c.Timeout == 0 {
+		c.Timeout = 5 * time.Second
+	}
+	checks := Checks
+	if len(c.Checks) > 0 {
+		checks = make([]Check, 0, len(c.Checks))
+		for _, name := range c.Checks {
+			check, ok := findCheck(name)
+			if !ok {
+				return nil, fmt.Errorf("conformance: unknown check %q", name)
+			}
+			checks = append(checks, check)
+		}
+	}
+
+	report := &Report{Results: make([]Result, 0, len(checks))}
+	for _, check := range checks {
+		t := &T{config: c}
+		start := time.Now()
+		err := check.run(t)
+		result := Result{
+			Check:    check,
+			Status:   Pass,
+			Message:  t.message,
+			Duration: time.Since(start),
+		}
+		switch e := err.(type) {
+		case nil:
+		case skipError:
+			result.Status, result.Message = Skip, string(e)
+		case warnError:
+			result.Status, result.Message = Warn, string(e)
+		default:
+			result.Status, result.Message = Fail, err.Error()
+		}
+		t.closeAll()
+		report.Results = append(report.Results, result)
+	}
+	return report, nil
+}
+
+// skipError skips a check with the reason
+type skipError string
+
+func (e skipError) Error() string { return string(e) }
+
+// warnError reports a check that the application does not behave as
+// the specification recommends, but works with gofast
+type warnError string
+
+func (e warnError) Error() string { return string(e) }
+
+// T is the state of a running check
+type T struct {
+	config  Config
+	conns   []*conn
+	message string
+}
+
+// dial connects to the application
+func (t *T) dial() (*conn, error) {
+	rwc, err := t.config.ConnFactory()
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect: %s", err)
+	}
+	c := newConn(rwc, t.config.Timeout)
+	t.conns = append(t.conns, c)
+	return c, nil
+}
+
+// closeAll closes all connections of the check
+func (t *T) closeAll() {
+	for _, c := range t.conns {
+		c.Close()
+	}
+}
+
+// params returns the params of a request
+// with stdin of the given length
+func (t *T) params(contentLength int) map[string]string {
+	method := "GET"
+	if contentLength > 0 {
+		method = "POST"
+	}
+	params := map[string]string{
+		"GATEWAY_INTERFACE": "CGI/1.1",
+		"REQUEST_METHOD":    method,
+		"REQUEST_URI":       "/conformance",
+		"SCRIPT_NAME":       "/conformance",
+		"QUERY_STRING":      "",
+		"CONTENT_LENGTH":    fmt.Sprintf("%d", contentLength),
+		"SERVER_SOFTWARE":   "gofast",
+		"SERVER_PROTOCOL":   "HTTP/1.1",
+		"SERVER_NAME":       "localhost",
+		"SERVER_PORT":       "80",
+		"REMOTE_ADDR":       "127.0.0.1",
+		"REMOTE_PORT":       "0",
+	}
+	for k, v := range t.config.Params {
+		params[k] = v
+	}
+	return params
+}
+
+// logf sets the message of a passed check
+func (t *T) logf(format string, args ...interface{}) {
+	t.message = fmt.Sprintf(format, args...)
+}
+
+func skipf(format string, args ...interface{}) error {
+	return skipError(fmt.Sprintf(format, args...))
+}
+
+func warnf(format string, args ...interface{}) error {
+	return warnError(fmt.Sprintf(format, args...))
+}
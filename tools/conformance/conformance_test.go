@@ -0,0 +1,104 @@
+package conformance_test
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yookoala/gofast/fcgitest"
+	"github.com/yookoala/gofast/tools/conformance"
+)
+
+func TestRun_gofastServer(t *testing.T) {
+	s := fcgitest.NewUnixServer(fcgitest.Reply(fcgitest.Body("hello")))
+	defer s.Close()
+
+	report, err := conformance.Run(conformance.Config{
+		ConnFactory: s.ConnFactory(),
+		Timeout:     time.Second,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want, have := len(conformance.Checks), len(report.Results); want != have {
+		t.Fatalf("expected %#v, got %#v", want, have)
+	}
+	for _, result := range report.Results {
+		if want, have := conformance.Pass, result.Status; want != have {
+			t.Errorf("%s: expected %s, got %s: %s", result.Check.Name, want, have, result.Message)
+		}
+	}
+	if !report.Passed() {
+		t.Errorf("expected report to pass")
+	}
+
+	buf := new(bytes.Buffer)
+	report.WriteTo(buf)
+	if want, have := "PASS  responder", buf.String(); !strings.HasPrefix(have, want) {
+		t.Errorf("expected prefix %#v, got %#v", want, have)
+	}
+	if want, have := "\n11 passed, 0 warnings, 0 failed, 0 skipped\n", buf.String(); !strings.HasSuffix(have, want) {
+		t.Errorf("expected suffix %#v, got %#v", want, have)
+	}
+
+	// all requests carry the configured params
+	for _, req := range s.Requests() {
+		if want, have := "CGI/1.1", req.Params["GATEWAY_INTERFACE"]; want != have {
+			t.Errorf("expected %#v, got %#v", want, have)
+		}
+	}
+}
+
+func TestRun_params(t *testing.T) {
+	s := fcgitest.NewServer(fcgitest.Reply(fcgitest.Body("hello")))
+	defer s.Close()
+
+	report, err := conformance.Run(conformance.Config{
+		ConnFactory: s.ConnFactory(),
+		Params:      map[string]string{"SCRIPT_FILENAME": "/srv/index.php"},
+		Checks:      []string{"responder"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want, have := 1, len(report.Results); want != have {
+		t.Fatalf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "/srv/index.php", s.LastRequest().Params["SCRIPT_FILENAME"]; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	if _, err = conformance.Run(conformance.Config{
+		ConnFactory: s.ConnFactory(),
+		Checks:      []string{"nonsense"},
+	}); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+}
+
+func TestRun_brokenBackend(t *testing.T) {
+	// backend that closes every connection without reading
+	connFactory := func() (net.Conn, error) {
+		appConn, webConn := net.Pipe()
+		appConn.Close()
+		return webConn, nil
+	}
+	report, err := conformance.Run(conformance.Config{
+		ConnFactory: connFactory,
+		Timeout:     100 * time.Millisecond,
+		Checks:      []string{"responder", "get-values", "multiplex"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for i, want := range []conformance.Status{conformance.Fail, conformance.Fail, conformance.Skip} {
+		if have := report.Results[i].Status; want != have {
+			t.Errorf("%s: expected %s, got %s", report.Results[i].Check.Name, want, have)
+		}
+	}
+	if report.Passed() {
+		t.Errorf("expected report not to pass")
+	}
+}
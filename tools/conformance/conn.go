@@ -0,0 +1,332 @@
+package conformance
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"time"
+)
+
+// record types, flags and statuses of the FastCGI specification
+const (
+	typeBeginRequest    uint8 = 1
+	typeAbortRequest    uint8 = 2
+	typeEndRequest      uint8 = 3
+	typeParams          uint8 = 4
+	typeStdin           uint8 = 5
+	typeStdout          uint8 = 6
+	typeStderr          uint8 = 7
+	typeData            uint8 = 8
+	typeGetValues       uint8 = 9
+	typeGetValuesResult uint8 = 10
+	typeUnknownType     uint8 = 11
+
+	flagKeepConn uint8 = 1
+
+	roleResponder uint16 = 1
+
+	statusRequestComplete uint8 = 0
+	statusCantMultiplex   uint8 = 1
+	statusOverloaded      uint8 = 2
+	statusUnknownRole     uint8 = 3
+
+	maxContent = 65535
+)
+
+var typeNames = map[uint8]string{
+	typeBeginRequest:    "FCGI_BEGIN_REQUEST",
+	typeAbortRequest:    "FCGI_ABORT_REQUEST",
+	typeEndRequest:      "FCGI_END_REQUEST",
+	typeParams:          "FCGI_PARAMS",
+	typeStdin:           "FCGI_STDIN",
+	typeStdout:          "FCGI_STDOUT",
+	typeStderr:          "FCGI_STDERR",
+	typeData:            "FCGI_DATA",
+	typeGetValues:       "FCGI_GET_VALUES",
+	typeGetValuesResult: "FCGI_GET_VALUES_RESULT",
+	typeUnknownType:     "FCGI_UNKNOWN_TYPE",
+}
+
+var statusNames = map[uint8]string{
+	statusRequestComplete: "FCGI_REQUEST_COMPLETE",
+	statusCantMultiplex:   "FCGI_CANT_MPX_CONN",
+	statusOverloaded:      "FCGI_OVERLOADED",
+	statusUnknownRole:     "FCGI_UNKNOWN_ROLE",
+}
+
+func typeName(t uint8) string {
+	if name, ok := typeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("type %d", t)
+}
+
+func statusName(s uint8) string {
+	if name, ok := statusNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("status %d", s)
+}
+
+// record is a raw FastCGI record
+type record struct {
+	typ     uint8
+	id      uint16
+	content []byte
+	padding int
+}
+
+// response is the response of a request, collected from the records
+type response struct {
+	stdout         []byte
+	stderr         []byte
+	appStatus      uint32
+	protocolStatus uint8
+
+	// unaligned is the number of the records
+	// not padded to multiple of 8 bytes
+	unaligned int
+}
+
+// errConnClosed is returned by conn.read when
+// the application closed the connection
+var errConnClosed = errors.New("connection closed by the application")
+
+// conn writes and reads raw records with deadlines
+type conn struct {
+	rwc     net.Conn
+	r       *bufio.Reader
+	timeout time.Duration
+}
+
+func newConn(rwc net.Conn, timeout time.Duration) *conn {
+	return &conn{
+		rwc:     rwc,
+		r:       bufio.NewReader(rwc),
+		timeout: timeout,
+	}
+}
+
+func (c *conn) Close() error {
+	return c.rwc.Close()
+}
+
+// write writes a record with the given padding length
+func (c *conn) write(typ uint8, id uint16, content []byte, padding int) error {
+	b := make([]byte, 8+len(content)+padding)
+	b[0] = 1
+	b[1] = typ
+	binary.BigEndian.PutUint16(b[2:], id)
+	binary.BigEndian.PutUint16(b[4:], uint16(len(content)))
+	b[6] = uint8(padding)
+	copy(b[8:], content)
+	c.rwc.SetWriteDeadline(time.Now().Add(c.timeout))
+	_, err := c.rwc.Write(b)
+	return err
+}
+
+// writeStream writes the content as a stream of records of at most
+// chunk bytes, each padded to multiple of 8 bytes, then the empty
+// record which ends the stream.
+func (c *conn) writeStream(typ uint8, id uint16, content []byte, chunk int) error {
+	for len(content) > 0 {
+		n := len(content)
+		if n > chunk {
+			n = chunk
+		}
+		if err := c.write(typ, id, content[:n], -n&7); err != nil {
+			return err
+		}
+		content = content[n:]
+	}
+	return c.write(typ, id, nil, 0)
+}
+
+// beginRequest writes FCGI_BEGIN_REQUEST of the role
+func (c *conn) beginRequest(id uint16, role uint16, keepConn bool) error {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint16(b, role)
+	if keepConn {
+		b[2] = flagKeepConn
+	}
+	return c.write(typeBeginRequest, id, b, 0)
+}
+
+// request writes a complete responder request
+func (c *conn) request(id uint16, params map[string]string, stdin []byte, keepConn bool) error {
+	if err := c.beginRequest(id, roleResponder, keepConn); err != nil {
+		return err
+	}
+	if err := c.writeStream(typeParams, id, encodePairs(params), maxContent); err != nil {
+		return err
+	}
+	return c.writeStream(typeStdin, id, stdin, maxContent)
+}
+
+// async runs the writes in a goroutine, so the application may respond
+// before reading the whole request (e.g. on error) without deadlock.
+// The returned channel receives the error of the writes.
+func (c *conn) async(write func() error) <-chan error {
+	errc := make(chan error, 1)
+	go func() {
+		errc <- write()
+	}()
+	return errc
+}
+
+// read reads a record
+func (c *conn) read() (*record, error) {
+	c.rwc.SetReadDeadline(time.Now().Add(c.timeout))
+	h := make([]byte, 8)
+	if _, err := io.ReadFull(c.r, h); err == io.EOF {
+		return nil, errConnClosed
+	} else if err != nil {
+		return nil, err
+	}
+	if h[0] != 1 {
+		return nil, fmt.Errorf("invalid record version %d", h[0])
+	}
+	rec := &record{
+		typ:     h[1],
+		id:      binary.BigEndian.Uint16(h[2:]),
+		padding: int(h[6]),
+	}
+	b := make([]byte, int(binary.BigEndian.Uint16(h[4:]))+rec.padding)
+	if _, err := io.ReadFull(c.r, b); err != nil {
+		return nil, fmt.Errorf("incomplete %s record: %s", typeName(rec.typ), err)
+	}
+	rec.content = b[:len(b)-rec.padding]
+	return rec, nil
+}
+
+// readResponses reads the records of the requests until all of them
+// end with FCGI_END_REQUEST
+func (c *conn) readResponses(ids ...uint16) (map[uint16]*response, error) {
+	responses := make(map[uint16]*response, len(ids))
+	for _, id := range ids {
+		responses[id] = &response{}
+	}
+	for pending := len(ids); pending > 0; {
+		rec, err := c.read()
+		if err != nil {
+			return responses, err
+		}
+		resp, ok := responses[rec.id]
+		if !ok {
+			return responses, fmt.Errorf("unexpected %s record of request id %d", typeName(rec.typ), rec.id)
+		}
+		if (len(rec.content)+rec.padding)%8 != 0 {
+			resp.unaligned++
+		}
+		switch rec.typ {
+		case typeStdout:
+			resp.stdout = append(resp.stdout, rec.content...)
+		case typeStderr:
+			resp.stderr = append(resp.stderr, rec.content...)
+		case typeEndRequest:
+			if len(rec.content) != 8 {
+				return responses, fmt.Errorf("invalid FCGI_END_REQUEST content length %d", len(rec.content))
+			}
+			resp.appStatus = binary.BigEndian.Uint32(rec.content)
+			resp.protocolStatus = rec.content[4]
+			pending--
+		default:
+			return responses, fmt.Errorf("unexpected %s record of request id %d", typeName(rec.typ), rec.id)
+		}
+	}
+	return responses, nil
+}
+
+// readResponse reads the response of a single request
+func (c *conn) readResponse(id uint16) (*response, error) {
+	responses, err := c.readResponses(id)
+	return responses[id], err
+}
+
+// isClosed checks if the application closes the connection
+// within the wait duration
+func (c *conn) isClosed(wait time.Duration) (bool, error) {
+	timeout := c.timeout
+	c.timeout = wait
+	_, err := c.read()
+	c.timeout = timeout
+	if err == errConnClosed {
+		return true, nil
+	}
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return false, nil
+	}
+	if err == nil {
+		return false, fmt.Errorf("unexpected record after FCGI_END_REQUEST")
+	}
+	return false, err
+}
+
+// encodePairs encodes the name-value pairs, sorted by name
+func encodePairs(pairs map[string]string) []byte {
+	names := make([]string, 0, len(pairs))
+	for name := range pairs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	buf := new(bytes.Buffer)
+	for _, name := range names {
+		writeSize(buf, len(name))
+		writeSize(buf, len(pairs[name]))
+		buf.WriteString(name)
+		buf.WriteString(pairs[name])
+	}
+	return buf.Bytes()
+}
+
+func writeSize(buf *bytes.Buffer, size int) {
+	if size > 127 {
+		b := make([]byte, 4)
+		binary.BigEndian.PutUint32(b, uint32(size)|1<<31)
+		buf.Write(b)
+		return
+	}
+	buf.WriteByte(byte(size))
+}
+
+// decodePairs decodes the name-value pairs
+func decodePairs(b []byte) (map[string]string, error) {
+	pairs := make(map[string]string)
+	readSize := func() (int, error) {
+		if len(b) == 0 {
+			return 0, fmt.Errorf("invalid name-value pair length")
+		}
+		if b[0]&0x80 == 0 {
+			size := int(b[0])
+			b = b[1:]
+			return size, nil
+		}
+		if len(b) < 4 {
+			return 0, fmt.Errorf("invalid name-value pair length")
+		}
+		size := int(binary.BigEndian.Uint32(b) &^ (1 << 31))
+		b = b[4:]
+		return size, nil
+	}
+	for len(b) > 0 {
+		nameLen, err := readSize()
+		if err != nil {
+			return nil, err
+		}
+		valueLen, err := readSize()
+		if err != nil {
+			return nil, err
+		}
+		if nameLen+valueLen > len(b) {
+			return nil, fmt.Errorf("name-value pair exceeds content length")
+		}
+		pairs[string(b[:nameLen])] = string(b[nameLen : nameLen+valueLen])
+		b = b[nameLen+valueLen:]
+	}
+	return pairs, nil
+}
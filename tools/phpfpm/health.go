@@ -0,0 +1,247 @@
+package phpfpm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/yookoala/gofast"
+)
+
+// Status is the status of a pool, as reported by
+// the status page of php-fpm (see Pool.StatusPath)
+type Status struct {
+	Pool               string `json:"pool"`
+	ProcessManager     string `json:"process manager"`
+	StartTime          int64  `json:"start time"`
+	StartSince         int64  `json:"start since"`
+	AcceptedConn       int64  `json:"accepted conn"`
+	ListenQueue        int    `json:"listen queue"`
+	MaxListenQueue     int    `json:"max listen queue"`
+	ListenQueueLen     int    `json:"listen queue len"`
+	IdleProcesses      int    `json:"idle processes"`
+	ActiveProcesses    int    `json:"active processes"`
+	TotalProcesses     int    `json:"total processes"`
+	MaxActiveProcesses int    `json:"max active processes"`
+	MaxChildrenReached int    `json:"max children reached"`
+	SlowRequests       int    `json:"slow requests"`
+}
+
+// pool returns the pool of the Address, which is
+// the first of the pools (see Process.Pools)
+func (proc *Process) pool() Pool {
+	return proc.pools()[0]
+}
+
+// Ping sends a FastCGI request to the ping page of the
+// process (see Process.PingPath). Returns nil if the
+// workers respond with the ping response.
+//
+// Ping is a gofast.HealthCheck, e.g. for gofast.Probes.
+func (proc *Process) Ping(ctx context.Context) error {
+	pool := proc.pool()
+	if pool.PingPath == "" {
+		return fmt.Errorf("no ping path")
+	}
+	code, body, err := proc.get(ctx, pool.PingPath, "")
+	if err != nil {
+		return err
+	}
+	want := pool.PingResponse
+	if want == "" {
+		want = "pong"
+	}
+	if code != http.StatusOK || string(bytes.TrimSpace(body)) != want {
+		return fmt.Errorf("unexpected ping response: %d %q", code, body)
+	}
+	return nil
+}
+
+// Status gets the status of the pool of the process from
+// the status page (see Process.StatusPath) with a
+// FastCGI request
+func (proc *Process) Status(ctx context.Context) (*Status, error) {
+	pool := proc.pool()
+	if pool.StatusPath == "" {
+		return nil, fmt.Errorf("no status path")
+	}
+	code, body, err := proc.get(ctx, pool.StatusPath, "json")
+	if err != nil {
+		return nil, err
+	}
+	if code != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status response: %d %q", code, body)
+	}
+	status := &Status{}
+	if err = json.Unmarshal(body, status); err != nil {
+		return nil, fmt.Errorf("error parsing status: %s", err)
+	}
+	return status, nil
+}
+
+// get sends a GET request of the path and the
+// query to the process, and returns the response
+func (proc *Process) get(ctx context.Context, path, query string) (code int, body []byte, err error) {
+	r, err := http.NewRequest("GET", path, nil)
+	if err != nil {
+		return
+	}
+	client, err := gofast.SimpleClientFactory(gofast.SimpleConnFactory(proc.Address()))()
+	if err != nil {
+		return
+	}
+	defer client.Close()
+
+	req := gofast.NewRequest(r.WithContext(ctx))
+	req.Params["REQUEST_METHOD"] = "GET"
+	req.Params["SCRIPT_NAME"] = path
+	req.Params["SCRIPT_FILENAME"] = path
+	req.Params["REQUEST_URI"] = path
+	req.Params["QUERY_STRING"] = query
+	req.Params["SERVER_PROTOCOL"] = "HTTP/1.1"
+	resp, err := client.Do(req)
+	if err != nil {
+		return
+	}
+	w := &responseRecorder{header: make(http.Header)}
+	if err = resp.WriteTo(w, new(bytes.Buffer)); err != nil {
+		return
+	}
+	if err = resp.Err(); err != nil {
+		return
+	}
+	return w.code, w.body.Bytes(), nil
+}
+
+// responseRecorder records the response written
+type responseRecorder struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+}
+
+func (w *responseRecorder) Header() http.Header {
+	return w.header
+}
+
+func (w *responseRecorder) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(b)
+}
+
+// Healthcheck supervises a process by polling the status
+// page (or the ping page, if no status page) of it, and
+// restarts it after consecutive failures
+type Healthcheck struct {
+
+	// number of the consecutive failures to
+	// restart the process. Never if 0.
+	RestartAfter int
+
+	// Restart restarts the process
+	// (Process.Restart if nil)
+	Restart func() error
+
+	proc     *Process
+	interval time.Duration
+
+	mutex    sync.Mutex
+	status   *Status
+	err      error
+	failures int
+}
+
+// Healthcheck returns a *Healthcheck of the process,
+// which checks the process every interval when run
+//
+//	h := proc.Healthcheck(5 * time.Second)
+//	h.RestartAfter = 3
+//	go h.Run(ctx)
+func (proc *Process) Healthcheck(interval time.Duration) *Healthcheck {
+	return &Healthcheck{proc: proc, interval: interval}
+}
+
+// Run checks the process at once, then every interval,
+// until the ctx is done. Returns the error of the ctx.
+func (h *Healthcheck) Run(ctx context.Context) error {
+	for {
+		h.check(ctx)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-h.proc.clock().After(h.interval):
+		}
+	}
+}
+
+// check checks the process once, with
+// the interval as the timeout
+func (h *Healthcheck) check(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, h.interval)
+	defer cancel()
+
+	var status *Status
+	var err error
+	if h.proc.pool().StatusPath != "" {
+		status, err = h.proc.Status(ctx)
+	} else {
+		err = h.proc.Ping(ctx)
+	}
+	if ctx.Err() == context.Canceled {
+		// stopped, not a failure
+		return
+	}
+
+	h.mutex.Lock()
+	h.status, h.err = status, err
+	if err == nil {
+		h.failures = 0
+		h.mutex.Unlock()
+		return
+	}
+	h.failures++
+	restart := h.RestartAfter > 0 && h.failures >= h.RestartAfter
+	h.mutex.Unlock()
+	if !restart {
+		return
+	}
+
+	fn := h.Restart
+	if fn == nil {
+		fn = h.proc.Restart
+	}
+	err = fn()
+	h.mutex.Lock()
+	h.failures = 0
+	if err != nil {
+		h.err = fmt.Errorf("error restarting: %s", err)
+	}
+	h.mutex.Unlock()
+}
+
+// Status returns the last status of the process and the
+// error of the last check, if any. The status is nil if
+// the check failed, or the process has no status page.
+func (h *Healthcheck) Status() (*Status, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.status, h.err
+}
+
+// Failures returns the number of the
+// consecutive failures of the checks
+func (h *Healthcheck) Failures() int {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.failures
+}
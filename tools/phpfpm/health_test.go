@@ -0,0 +1,150 @@
+package phpfpm_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/yookoala/gofast"
+	"github.com/yookoala/gofast/fcgitest"
+	"github.com/yookoala/gofast/tools/phpfpm"
+)
+
+// fakeFpm serves the ping and status pages like php-fpm
+// on a unix socket. The status fails if *failing is 1.
+func fakeFpm(t *testing.T, failing *int32) (listen string, closer func()) {
+	dir, err := ioutil.TempDir("", "phpfpm-")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	listen = filepath.Join(dir, "fpm.sock")
+	l, err := net.Listen("unix", listen)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("unexpected error: %s", err)
+	}
+	s := gofast.NewServer(func(ctx context.Context, req *gofast.Request, stdout, stderr io.Writer) int {
+		switch {
+		case req.Params["SCRIPT_NAME"] == "/ping":
+			fmt.Fprint(stdout, "Content-Type: text/plain\r\n\r\npong")
+		case req.Params["SCRIPT_NAME"] == "/status" && req.Params["QUERY_STRING"] == "json" && atomic.LoadInt32(failing) == 0:
+			fmt.Fprint(stdout, "Content-Type: application/json\r\n\r\n"+
+				`{"pool":"www","process manager":"dynamic","start time":1700000000,"start since":60,`+
+				`"accepted conn":12,"listen queue":1,"max listen queue":3,"listen queue len":128,`+
+				`"idle processes":4,"active processes":2,"total processes":6,`+
+				`"max active processes":5,"max children reached":0,"slow requests":7}`)
+		default:
+			fmt.Fprint(stdout, "Status: 404 Not Found\r\nContent-Type: text/plain\r\n\r\nFile not found.")
+		}
+		return 0
+	})
+	go s.Serve(l)
+	return listen, func() {
+		s.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestProcess_Ping(t *testing.T) {
+	var failing int32
+	listen, closer := fakeFpm(t, &failing)
+	defer closer()
+
+	process := &phpfpm.Process{Listen: listen, PingPath: "/ping"}
+	if err := process.Ping(context.Background()); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	process.PingPath = "/nonexistent"
+	if err := process.Ping(context.Background()); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+	process.Pools = []phpfpm.Pool{{Name: "www", Listen: listen, PingPath: "/ping", PingResponse: "ok"}}
+	if err := process.Ping(context.Background()); err == nil {
+		t.Errorf("expected error of the ping response, got nil")
+	}
+}
+
+func TestProcess_Status(t *testing.T) {
+	var failing int32
+	listen, closer := fakeFpm(t, &failing)
+	defer closer()
+
+	process := &phpfpm.Process{Listen: listen, StatusPath: "/status"}
+	status, err := process.Status(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := phpfpm.Status{
+		Pool:               "www",
+		ProcessManager:     "dynamic",
+		StartTime:          1700000000,
+		StartSince:         60,
+		AcceptedConn:       12,
+		ListenQueue:        1,
+		MaxListenQueue:     3,
+		ListenQueueLen:     128,
+		IdleProcesses:      4,
+		ActiveProcesses:    2,
+		TotalProcesses:     6,
+		MaxActiveProcesses: 5,
+		SlowRequests:       7,
+	}
+	if have := *status; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestHealthcheck(t *testing.T) {
+	var failing int32
+	listen, closer := fakeFpm(t, &failing)
+	defer closer()
+
+	clock := fcgitest.NewFakeClock(time.Now())
+	process := &phpfpm.Process{Listen: listen, StatusPath: "/status", Clock: clock}
+	var restarts int32
+	h := process.Healthcheck(time.Second)
+	h.RestartAfter = 2
+	h.Restart = func() error {
+		atomic.AddInt32(&restarts, 1)
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- h.Run(ctx)
+	}()
+
+	// healthy at once
+	clock.WaitTimers(1)
+	if status, err := h.Status(); err != nil || status == nil || status.IdleProcesses != 4 {
+		t.Errorf("unexpected status %#v, error %v", status, err)
+	}
+
+	// restarted after 2 failures
+	atomic.StoreInt32(&failing, 1)
+	for i, want := range []int{1, 0} {
+		clock.Advance(time.Second)
+		clock.WaitTimers(1)
+		if have := h.Failures(); want != have {
+			t.Errorf("%d: expected %#v, got %#v", i, want, have)
+		}
+	}
+	if want, have := int32(1), atomic.LoadInt32(&restarts); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if status, err := h.Status(); err == nil || status != nil {
+		t.Errorf("expected the error of the last check, got %#v, %v", status, err)
+	}
+
+	cancel()
+	if want, have := context.Canceled, <-done; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
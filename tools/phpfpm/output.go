@@ -0,0 +1,105 @@
+package phpfpm
+
+import (
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/yookoala/gofast"
+)
+
+// SetOutput sets the Output of the process, to stream the
+// output of the php-fpm command and the error log to w as
+// they are written (e.g. to the log of a test harness or a
+// supervisor). With w, the workers of the default pool also
+// write their output to the error log (catch_workers_output).
+//
+// The error log is followed while the process is running,
+// including the restarts. It is not followed if ErrorLog is
+// "syslog" or empty.
+func (proc *Process) SetOutput(w io.Writer) {
+	proc.Output = w
+}
+
+// logOffset returns the size of the error log, which
+// is the offset to follow the log written from now on
+func (proc *Process) logOffset() int64 {
+	if stat, err := os.Stat(proc.ErrorLog); err == nil {
+		return stat.Size()
+	}
+	return 0
+}
+
+// follow follows the error log of the running process to
+// the Output, if any. A follower of the process restarted
+// keeps following from where it was, for the new process.
+func (proc *Process) follow(process *os.Process, offset int64) {
+	if proc.Output == nil || proc.ErrorLog == "" || proc.ErrorLog == "syslog" {
+		return
+	}
+	if proc.tail == nil {
+		proc.tail = &logTail{}
+	}
+	t := proc.tail
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.process = process
+	if t.running {
+		return
+	}
+	t.running, t.offset = true, offset
+	go t.run(proc.ErrorLog, proc.Output, proc.clock())
+}
+
+// logTail follows an error log
+type logTail struct {
+	mutex   sync.Mutex
+	process *os.Process
+	running bool
+	offset  int64
+}
+
+// run copies the log written to w every 100ms, until
+// the process exits and the log is copied to the end
+func (t *logTail) run(filename string, w io.Writer, clock gofast.Clock) {
+	for {
+		t.mutex.Lock()
+		process := t.process
+		t.mutex.Unlock()
+		alive := process.Signal(syscall.Signal(0)) == nil
+
+		t.copy(filename, w)
+		if !alive {
+			t.mutex.Lock()
+			if t.process == process {
+				// not restarted
+				t.running = false
+				t.mutex.Unlock()
+				return
+			}
+			t.mutex.Unlock()
+			continue
+		}
+		<-clock.After(100 * time.Millisecond)
+	}
+}
+
+// copy copies the log from the offset to w
+func (t *logTail) copy(filename string, w io.Writer) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	if stat, err := f.Stat(); err == nil && stat.Size() < t.offset {
+		// truncated or rotated
+		t.offset = 0
+	}
+	if _, err = f.Seek(t.offset, io.SeekStart); err != nil {
+		return
+	}
+	n, _ := io.Copy(w, f)
+	t.offset += n
+}
@@ -0,0 +1,94 @@
+package phpfpm_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yookoala/gofast/tools/phpfpm"
+)
+
+// fakePhpFpm is a php-fpm of a shell script, which writes the pid file
+// of a background process that writes the error log then sleeps
+const fakePhpFpm = `#!/bin/sh
+conf="$2"
+pid=$(sed -n 's/^pid *= *//p' "$conf")
+log=$(sed -n 's/^error_log *= *//p' "$conf")
+echo "NOTICE: fpm is running"
+sh -c 'sleep 0.2; echo "PHP Fatal error: boom" >> "$0"; exec sleep 5' "$log" </dev/null >/dev/null 2>&1 &
+printf %s $! > "$pid"
+`
+
+// syncBuffer is a bytes.Buffer safe for concurrent use
+type syncBuffer struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.String()
+}
+
+func TestProcess_SetOutput(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no shell script on windows")
+	}
+	dir, err := ioutil.TempDir("", "phpfpm-")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	exec := filepath.Join(dir, "php-fpm")
+	if err := ioutil.WriteFile(exec, []byte(fakePhpFpm), 0755); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	process := phpfpm.NewProcess(exec)
+	process.SetDatadir(dir)
+	l, err := net.Listen("unix", process.Listen)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer l.Close()
+
+	// the log before the start is not followed
+	ioutil.WriteFile(process.ErrorLog, []byte("previous log\n"), 0644)
+	output := &syncBuffer{}
+	process.SetOutput(output)
+	if err := process.SaveConfig(filepath.Join(dir, "php-fpm.conf")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	f, err := process.Config()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want, have := "yes", f.Section("www").Key("catch_workers_output").String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	if err := process.Start(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer process.Stop()
+	for i := 0; i < 50 && !strings.Contains(output.String(), "boom"); i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if want, have := "NOTICE: fpm is running\nPHP Fatal error: boom\n", output.String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
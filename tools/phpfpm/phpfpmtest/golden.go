@@ -0,0 +1,49 @@
+package phpfpmtest
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/yookoala/gofast/fcgitest"
+	"github.com/yookoala/gofast/tools/phpfpm"
+	"gopkg.in/ini.v1"
+)
+
+// FormatConfig renders the config of the process in a canonical form
+// for golden files: the sections in the order of the config, and the
+// keys of each section sorted by name.
+func FormatConfig(proc *phpfpm.Process) ([]byte, error) {
+	f, err := proc.Config()
+	if err != nil {
+		return nil, err
+	}
+	buf := new(bytes.Buffer)
+	for _, s := range f.Sections() {
+		keys := s.Keys()
+		if s.Name() == ini.DEFAULT_SECTION && len(keys) == 0 {
+			continue
+		}
+		if buf.Len() > 0 {
+			fmt.Fprintf(buf, "\n")
+		}
+		fmt.Fprintf(buf, "[%s]\n", s.Name())
+		sort.Slice(keys, func(i, j int) bool { return keys[i].Name() < keys[j].Name() })
+		for _, k := range keys {
+			fmt.Fprintf(buf, "%s = %s\n", k.Name(), k.Value())
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// GoldenConfig compares the config of the process with the golden
+// file in the format of FormatConfig. See fcgitest.Golden.
+func GoldenConfig(t testing.TB, filename string, proc *phpfpm.Process) {
+	t.Helper()
+	b, err := FormatConfig(proc)
+	if err != nil {
+		t.Fatalf("phpfpmtest: %s", err)
+	}
+	fcgitest.Golden(t, filename, b)
+}
@@ -0,0 +1,40 @@
+package phpfpmtest_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/yookoala/gofast/tools/phpfpm"
+	"github.com/yookoala/gofast/tools/phpfpm/phpfpmtest"
+)
+
+func TestGoldenConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		proc func() *phpfpm.Process
+	}{
+		{"datadir", func() *phpfpm.Process {
+			proc := phpfpm.NewProcess("/usr/sbin/php-fpm")
+			proc.SetDatadir("/var/run/gofast")
+			return proc
+		}},
+		{"tcp", func() *phpfpm.Process {
+			proc := phpfpm.NewProcess("/usr/sbin/php-fpm")
+			proc.SetDatadir("/var/run/gofast")
+			proc.Listen = "127.0.0.1:9000"
+			proc.User = "www-data"
+			proc.SetWorker(4)
+			return proc
+		}},
+		{"abstract", func() *phpfpm.Process {
+			proc := phpfpm.NewProcess("/usr/sbin/php-fpm")
+			proc.SetName("test")
+			proc.SetDatadir("/tmp")
+			proc.Listen = "\x00php-fpm"
+			return proc
+		}},
+	}
+	for _, test := range tests {
+		phpfpmtest.GoldenConfig(t, filepath.Join("testdata", "config", test.name+".conf"), test.proc())
+	}
+}
@@ -0,0 +1,55 @@
+package phpfpmtest
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+// shared is the Server started by Main
+var shared *Server
+
+// Main starts a php-fpm Server shared by the tests, runs the tests,
+// and closes the Server. It returns the exit code for os.Exit, so it
+// fits TestMain. If no php-fpm is found, the tests still run, and the
+// ones that call Shared are skipped.
+func Main(m *testing.M, opts *Options) int {
+	var err error
+	shared, err = Start(opts)
+	if err == ErrNotFound {
+		fmt.Fprintf(os.Stderr, "phpfpmtest: php-fpm not found, set TEST_PHPFPM_PATH to run the php-fpm tests\n")
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "phpfpmtest: failed to start php-fpm: %s\n", err)
+		return 1
+	}
+	code := m.Run()
+	if shared != nil {
+		if err = shared.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "phpfpmtest: failed to stop php-fpm: %s\n", err)
+		}
+		shared = nil
+	}
+	return code
+}
+
+// Shared returns the Server started by Main. It skips
+// the test if no php-fpm is found.
+func Shared(t testing.TB) *Server {
+	if shared == nil {
+		t.Skip("php-fpm not found, skip test")
+	}
+	return shared
+}
+
+// New starts a Server for the test. It skips the test if no php-fpm
+// is found, and fails the test if php-fpm fails to start. The test
+// should defer Close of the Server.
+func New(t testing.TB, opts *Options) *Server {
+	s, err := Start(opts)
+	if err == ErrNotFound {
+		t.Skip("php-fpm not found, skip test")
+	} else if err != nil {
+		t.Fatalf("failed to start php-fpm: %s", err)
+	}
+	return s
+}
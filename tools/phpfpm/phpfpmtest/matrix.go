@@ -0,0 +1,268 @@
+package phpfpmtest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DefaultVersions are the PHP versions of a Matrix by default
+var DefaultVersions = []string{"7.4", "8.1", "8.3"}
+
+// Matrix runs the go tests against php-fpm of different PHP versions.
+// For each version, it starts a docker container of the php-fpm image,
+// and runs "go test" with $TEST_PHPFPM_ADDRESS of the container, so the
+// tests that use Main, Shared or New connect to it. The module folder
+// and a temporary folder ($TEST_PHPFPM_DIR) are mounted into the
+// container at the same paths, so the scripts of the tests are found by
+// php-fpm with the same SCRIPT_FILENAME. $TEST_PHP_VERSION tells the
+// tests the PHP version, if they expect anything version specific.
+type Matrix struct {
+
+	// Versions of PHP. DefaultVersions if empty.
+	Versions []string
+
+	// Image is the format of the php-fpm image name
+	// of a version. "php:%s-fpm" if empty.
+	Image string
+
+	// Dir is the folder to run "go test" in, which is
+	// mounted into the containers. The working directory
+	// if empty.
+	Dir string
+
+	// Args of "go test" (e.g. "-run", "TestPHP", "./..."),
+	// "./..." if empty.
+	Args []string
+
+	// Docker and Go are the commands to run docker and
+	// go. "docker" and "go" if empty.
+	Docker string
+	Go     string
+
+	// Timeout of php-fpm to accept connections after the
+	// container started. 30 seconds if 0.
+	Timeout time.Duration
+
+	// Log receives the progress and the output of the
+	// tests, if not nil.
+	Log io.Writer
+}
+
+// Result is the result of the tests of a PHP version
+type Result struct {
+	Version string
+	Image   string
+
+	// Passed is true if "go test" succeeded
+	Passed bool
+
+	// Err is the error of running the tests (e.g. failed
+	// to start the container, or the tests failed)
+	Err error
+
+	// Output of "go test"
+	Output string
+
+	// Duration of "go test"
+	Duration time.Duration
+}
+
+// Results of a Matrix run
+type Results []Result
+
+// Passed is true if the tests of all versions passed
+func (results Results) Passed() bool {
+	for _, result := range results {
+		if !result.Passed {
+			return false
+		}
+	}
+	return len(results) > 0
+}
+
+// WriteTo writes a summary of the results, with the output
+// of the failed ones. Implements io.WriterTo.
+func (results Results) WriteTo(w io.Writer) (int64, error) {
+	buf := new(bytes.Buffer)
+	for _, result := range results {
+		if result.Passed || result.Output == "" {
+			continue
+		}
+		fmt.Fprintf(buf, "--- PHP %s (%s)\n%s\n", result.Version, result.Image, strings.TrimRight(result.Output, "\n"))
+	}
+	for _, result := range results {
+		status := "ok"
+		if !result.Passed {
+			status = "FAIL"
+		}
+		fmt.Fprintf(buf, "%-4s  PHP %-6s %-16s %s", status, result.Version, result.Image, result.Duration.Round(time.Millisecond))
+		if result.Err != nil {
+			fmt.Fprintf(buf, "  %s", result.Err)
+		}
+		fmt.Fprintf(buf, "\n")
+	}
+	n, err := w.Write(buf.Bytes())
+	return int64(n), err
+}
+
+// Run runs the tests of the versions one after another,
+// and returns the results of all versions.
+func (m *Matrix) Run(ctx context.Context) (results Results) {
+	versions := m.Versions
+	if len(versions) == 0 {
+		versions = DefaultVersions
+	}
+	for _, version := range versions {
+		result := m.run(ctx, version)
+		if m.Log != nil {
+			Results{result}.WriteTo(m.Log)
+		}
+		results = append(results, result)
+	}
+	return
+}
+
+func (m *Matrix) command(ctx context.Context, name, defaultName string, args ...string) *exec.Cmd {
+	if name == "" {
+		name = defaultName
+	}
+	return exec.CommandContext(ctx, name, args...)
+}
+
+// run runs the tests of a version
+func (m *Matrix) run(ctx context.Context, version string) (result Result) {
+	format := m.Image
+	if format == "" {
+		format = "php:%s-fpm"
+	}
+	result = Result{Version: version, Image: fmt.Sprintf(format, version)}
+	if m.Log != nil {
+		fmt.Fprintf(m.Log, "=== PHP %s (%s)\n", version, result.Image)
+	}
+
+	dir, err := filepath.Abs(m.Dir)
+	if err != nil {
+		result.Err = err
+		return
+	}
+	shared, err := ioutil.TempDir("", "phpfpmtest-matrix")
+	if err != nil {
+		result.Err = err
+		return
+	}
+	defer os.RemoveAll(shared)
+	if err = os.Chmod(shared, 0755); err != nil {
+		result.Err = err
+		return
+	}
+
+	// start the container with the port of php-fpm
+	// published to a random port of localhost
+	out, err := m.command(ctx, m.Docker, "docker", "run", "--detach", "--rm",
+		"--publish", "127.0.0.1::9000",
+		"--volume", dir+":"+dir+":ro",
+		"--volume", shared+":"+shared,
+		result.Image).Output()
+	if err != nil {
+		result.Err = fmt.Errorf("failed to start container: %s", commandError(err))
+		return
+	}
+	container := strings.TrimSpace(string(out))
+	defer m.command(context.Background(), m.Docker, "docker", "rm", "--force", container).Run()
+
+	out, err = m.command(ctx, m.Docker, "docker", "port", container, "9000/tcp").Output()
+	if err != nil {
+		result.Err = fmt.Errorf("failed to find the port of container: %s", commandError(err))
+		return
+	}
+	// may list the addresses of both IPv4 and IPv6
+	address := strings.TrimSpace(strings.SplitN(string(out), "\n", 2)[0])
+	if err = m.waitConn(ctx, address); err != nil {
+		result.Err = err
+		return
+	}
+
+	args := m.Args
+	if len(args) == 0 {
+		args = []string{"./..."}
+	}
+	cmd := m.command(ctx, m.Go, "go", append([]string{"test"}, args...)...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"TEST_PHPFPM_ADDRESS=tcp:"+address,
+		"TEST_PHPFPM_DIR="+shared,
+		"TEST_PHP_VERSION="+version,
+	)
+	output := new(bytes.Buffer)
+	cmd.Stdout, cmd.Stderr = output, output
+	start := time.Now()
+	err = cmd.Run()
+	result.Duration = time.Since(start)
+	result.Output = output.String()
+	if err != nil {
+		result.Err = fmt.Errorf("go test: %s", err)
+		return
+	}
+	result.Passed = true
+	return
+}
+
+// getValues is a FCGI_GET_VALUES record of FCGI_MPXS_CONNS
+var getValues = []byte{1, 9, 0, 0, 0, 17, 0, 0, 15, 0, 'F', 'C', 'G', 'I', '_', 'M', 'P', 'X', 'S', '_', 'C', 'O', 'N', 'N', 'S'}
+
+// waitConn waits until the address responds to FCGI_GET_VALUES, or the
+// timeout. The port published by docker accepts connections before
+// php-fpm is up, so a connection alone tells nothing.
+func (m *Matrix) waitConn(ctx context.Context, address string) error {
+	timeout := m.Timeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	for {
+		err := ping(address)
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("php-fpm not ready at %s: %s", address, err)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// ping sends FCGI_GET_VALUES to the address
+// and reads the first byte of the result
+func ping(address string) error {
+	conn, err := net.DialTimeout("tcp", address, time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+	if _, err = conn.Write(getValues); err != nil {
+		return err
+	}
+	_, err = conn.Read(make([]byte, 1))
+	return err
+}
+
+// commandError returns the error of a command,
+// with its stderr if any
+func commandError(err error) string {
+	if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+		return fmt.Sprintf("%s: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	return err.Error()
+}
@@ -0,0 +1,135 @@
+package phpfpmtest_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yookoala/gofast/tools/phpfpm/phpfpmtest"
+)
+
+// fakeDocker is a docker of containers that publish the address ($1 of
+// sh -c). Images of PHP 8.3 are not found. Containers removed are
+// logged to the file ($2).
+const fakeDocker = `#!/bin/sh
+case "$1" in
+run)
+	for image; do :; done
+	case "$image" in *8.3*) echo "image not found" >&2; exit 1;; esac
+	echo "c-$image";;
+port) echo "%ADDRESS%";;
+rm) echo "$3" >> "%LOG%";;
+esac
+`
+
+// fakeGo is a go that fails the tests of PHP 8.1
+const fakeGo = `#!/bin/sh
+[ -d "$TEST_PHPFPM_DIR" ] || exit 2
+echo "$@ tested with $TEST_PHPFPM_ADDRESS"
+[ "$TEST_PHP_VERSION" != "8.1" ]
+`
+
+func writeScript(t *testing.T, filename, content string) {
+	if err := ioutil.WriteFile(filename, []byte(content), 0755); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+func TestMatrix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "phpfpmtest")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// a php-fpm that responds to anything
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte{1})
+			conn.Close()
+		}
+	}()
+
+	log := filepath.Join(dir, "rm.log")
+	writeScript(t, filepath.Join(dir, "docker"), strings.NewReplacer(
+		"%ADDRESS%", l.Addr().String(),
+		"%LOG%", log,
+	).Replace(fakeDocker))
+	writeScript(t, filepath.Join(dir, "go"), fakeGo)
+
+	m := &phpfpmtest.Matrix{
+		Args:    []string{"./example/php/..."},
+		Docker:  filepath.Join(dir, "docker"),
+		Go:      filepath.Join(dir, "go"),
+		Timeout: time.Second,
+	}
+	results := m.Run(context.Background())
+	if want, have := len(phpfpmtest.DefaultVersions), len(results); want != have {
+		t.Fatalf("expected %#v, got %#v", want, have)
+	}
+	if results.Passed() {
+		t.Errorf("expected the results failed")
+	}
+
+	if !results[0].Passed || results[0].Err != nil {
+		t.Errorf("expected PHP 7.4 passed, got %#v", results[0])
+	}
+	if want, have := "test ./example/php/... tested with tcp:"+l.Addr().String()+"\n", results[0].Output; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if results[1].Passed || results[1].Err == nil {
+		t.Errorf("expected PHP 8.1 failed, got %#v", results[1])
+	}
+	if results[2].Passed || results[2].Err == nil || !strings.Contains(results[2].Err.Error(), "image not found") {
+		t.Errorf("expected PHP 8.3 failed to start, got %#v", results[2])
+	}
+
+	removed, _ := ioutil.ReadFile(log)
+	if want, have := "c-php:7.4-fpm\nc-php:8.1-fpm\n", string(removed); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	buf := new(bytes.Buffer)
+	results.WriteTo(buf)
+	for _, want := range []string{
+		"--- PHP 8.1 (php:8.1-fpm)\ntest ./example/php/... tested with",
+		"\nok    PHP 7.4    php:7.4-fpm",
+		"\nFAIL  PHP 8.1    php:8.1-fpm",
+		"\nFAIL  PHP 8.3    php:8.3-fpm      0s  failed to start container:",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("expected %#v in %#v", want, buf.String())
+		}
+	}
+}
+
+func TestMatrix_docker(t *testing.T) {
+	if os.Getenv("TEST_PHP_MATRIX") == "" || os.Getenv("TEST_PHPFPM_ADDRESS") != "" {
+		t.Skip("set TEST_PHP_MATRIX to run the tests against php-fpm of docker images")
+	}
+	m := &phpfpmtest.Matrix{
+		Dir:  "../../..",
+		Args: []string{"./example/php/...", "./tools/phpfpm/phpfpmtest/..."},
+		Log:  os.Stderr,
+	}
+	if results := m.Run(context.Background()); !results.Passed() {
+		buf := new(bytes.Buffer)
+		results.WriteTo(buf)
+		t.Errorf("tests failed:\n%s", buf)
+	}
+}
@@ -0,0 +1,39 @@
+package phpfpmtest
+
+import (
+	"io"
+	"os/exec"
+)
+
+// reaperScript waits for its stdin to be closed, which happens when
+// the test process closes the Server or exits in any way, then stops
+// php-fpm of the pid file ($1) and removes the folder ($2). It ignores
+// the signals of the terminal (e.g. Ctrl-C) to outlive the tests.
+const reaperScript = `trap '' INT TERM HUP
+cat >/dev/null
+if [ -f "$1" ]; then kill -INT "$(cat "$1")" 2>/dev/null; fi
+rm -rf "$2"`
+
+// reaper is a process to clean up after
+// the test process
+type reaper struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+}
+
+func startReaper(pidFile, dir string) (r *reaper, err error) {
+	r = &reaper{cmd: exec.Command("/bin/sh", "-c", reaperScript, "phpfpmtest-reaper", pidFile, dir)}
+	if r.stdin, err = r.cmd.StdinPipe(); err != nil {
+		return nil, err
+	}
+	if err = r.cmd.Start(); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// release lets the reaper clean up, and waits for it
+func (r *reaper) release() {
+	r.stdin.Close()
+	r.cmd.Wait()
+}
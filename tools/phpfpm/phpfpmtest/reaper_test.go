@@ -0,0 +1,50 @@
+package phpfpmtest
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestReaper(t *testing.T) {
+	dir, err := ioutil.TempDir("", "phpfpmtest")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// a process in place of php-fpm
+	proc := exec.Command("sleep", "60")
+	if err := proc.Start(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	exited := make(chan error)
+	go func() { exited <- proc.Wait() }()
+	pidFile := filepath.Join(dir, "phpfpm.pid")
+	ioutil.WriteFile(pidFile, []byte(strconv.Itoa(proc.Process.Pid)), 0644)
+
+	r, err := startReaper(pidFile, dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	select {
+	case <-exited:
+		t.Fatalf("expected the process to run until released")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	r.release()
+	select {
+	case <-exited:
+	case <-time.After(5 * time.Second):
+		proc.Process.Kill()
+		t.Fatalf("expected the process stopped by the reaper")
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("expected %s removed, got %#v", dir, err)
+	}
+}
@@ -0,0 +1,210 @@
+// Package phpfpmtest provides ephemeral php-fpm processes for the
+// integration tests of FastCGI web server side code.
+//
+// A Server is a php-fpm process with its config, pid file, error log
+// and unix socket in a temporary folder. It is ready to serve requests
+// once started, and the folder is removed on Close. Even if the tests
+// never reach Close (e.g. panic, timeout, interrupt), a reaper process
+// stops php-fpm and removes the folder when the test process exits.
+//
+// For a php-fpm shared by all tests of a package:
+//
+//	func TestMain(m *testing.M) {
+//		os.Exit(phpfpmtest.Main(m, nil))
+//	}
+//
+//	func TestSomething(t *testing.T) {
+//		fpm := phpfpmtest.Shared(t) // skips the test if there is no php-fpm
+//		h := gofast.NewHandler(
+//			gofast.NewPHPFS("/path/to/htdocs")(gofast.BasicSession),
+//			fpm.ClientFactory(),
+//		)
+//		// ...
+//	}
+//
+// Or use New for a php-fpm of a single test.
+//
+// If $TEST_PHPFPM_ADDRESS is set (e.g. "tcp:127.0.0.1:9000"), the Servers
+// connect to the php-fpm there instead of starting one, with their folders
+// in $TEST_PHPFPM_DIR, which the php-fpm must see at the same path. This
+// is how Matrix runs the tests against php-fpm of different PHP versions.
+package phpfpmtest
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/yookoala/gofast"
+	"github.com/yookoala/gofast/tools/phpfpm"
+)
+
+// ErrNotFound is returned if no php-fpm binary is found
+var ErrNotFound = errors.New("phpfpmtest: php-fpm not found")
+
+// Options of a Server
+type Options struct {
+
+	// Exec is the path of php-fpm. Found by Binary if empty.
+	Exec string
+
+	// Worker is the number of workers. 2 if 0. Ignored
+	// with the php-fpm of $TEST_PHPFPM_ADDRESS.
+	Worker int
+
+	// User of the workers. $USER if empty.
+	User string
+}
+
+// Binary locates php-fpm at the path of $TEST_PHPFPM_PATH if set,
+// or in $PATH, /usr/sbin and /usr/local/sbin.
+func Binary() (string, error) {
+	if fpmPath := os.Getenv("TEST_PHPFPM_PATH"); fpmPath != "" {
+		if stat, err := os.Stat(fpmPath); err != nil {
+			return "", err
+		} else if !stat.Mode().IsRegular() {
+			return "", errors.New("phpfpmtest: TEST_PHPFPM_PATH " + fpmPath + " is not a regular file")
+		}
+		return fpmPath, nil
+	}
+	paths := append(phpfpm.ReadPaths(os.Getenv("PATH")), "/usr/sbin", "/usr/local/sbin")
+	fpmPath, err := phpfpm.FindBinary(paths...)
+	if os.IsNotExist(err) {
+		return "", ErrNotFound
+	}
+	return fpmPath, err
+}
+
+// Server is an ephemeral php-fpm process
+type Server struct {
+
+	// Process of php-fpm. Nil with the php-fpm
+	// of $TEST_PHPFPM_ADDRESS.
+	Process *phpfpm.Process
+
+	// Dir is the temporary folder of the config,
+	// pid file, error log and socket
+	Dir string
+
+	network, address string
+
+	reaper    *reaper
+	started   bool
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// Start starts a php-fpm Server, and returns when it accepts
+// connections. Returns ErrNotFound if opts.Exec is empty and
+// Binary finds no php-fpm.
+func Start(opts *Options) (s *Server, err error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	if address := os.Getenv("TEST_PHPFPM_ADDRESS"); address != "" {
+		return startExternal(address)
+	}
+	fpmPath := opts.Exec
+	if fpmPath == "" {
+		if fpmPath, err = Binary(); err != nil {
+			return
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "phpfpmtest")
+	if err != nil {
+		return
+	}
+	s = &Server{Process: phpfpm.NewProcess(fpmPath), Dir: dir}
+	s.Process.SetDatadir(dir)
+	s.Process.Worker = 2
+	if opts.Worker > 0 {
+		s.Process.Worker = opts.Worker
+	}
+	s.Process.User = os.Getenv("USER")
+	if opts.User != "" {
+		s.Process.User = opts.User
+	}
+
+	// the reaper starts first, so even a php-fpm
+	// started by a failed Start is cleaned up
+	if s.reaper, err = startReaper(s.Process.PidFile, dir); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	if err = s.Process.SaveConfig(filepath.Join(dir, "php-fpm.conf")); err == nil {
+		err = s.Process.Start()
+	}
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	s.started = true
+	return
+}
+
+// startExternal returns a Server of the php-fpm at the address
+func startExternal(address string) (s *Server, err error) {
+	dir, err := ioutil.TempDir(os.Getenv("TEST_PHPFPM_DIR"), "phpfpmtest")
+	if err != nil {
+		return
+	}
+	// for the workers of other users to read the scripts
+	if err = os.Chmod(dir, 0755); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	s = &Server{Dir: dir}
+	s.network, s.address = gofast.ParseAddress(address)
+	return
+}
+
+// Address returns the network and address of the Server
+func (s *Server) Address() (network, address string) {
+	if s.Process != nil {
+		return s.Process.Address()
+	}
+	return s.network, s.address
+}
+
+// ConnFactory returns a gofast.ConnFactory that connects to the Server
+func (s *Server) ConnFactory() gofast.ConnFactory {
+	return gofast.SimpleConnFactory(s.Address())
+}
+
+// ClientFactory returns a gofast.ClientFactory of the Server
+func (s *Server) ClientFactory() gofast.ClientFactory {
+	return gofast.SimpleClientFactory(s.ConnFactory())
+}
+
+// ErrorLog returns the content of the php-fpm error log so far,
+// for the test failure messages. Empty with the php-fpm of
+// $TEST_PHPFPM_ADDRESS.
+func (s *Server) ErrorLog() string {
+	if s.Process == nil {
+		return ""
+	}
+	b, _ := ioutil.ReadFile(s.Process.ErrorLog)
+	return string(b)
+}
+
+// Close stops php-fpm and removes the temporary folder.
+// It is safe to call Close more than once.
+func (s *Server) Close() error {
+	s.closeOnce.Do(func() {
+		if s.started {
+			if s.closeErr = s.Process.Stop(); s.closeErr == nil {
+				s.closeErr = s.Process.Wait()
+			}
+		}
+		if s.reaper != nil {
+			s.reaper.release()
+		}
+		if err := os.RemoveAll(s.Dir); s.closeErr == nil {
+			s.closeErr = err
+		}
+	})
+	return s.closeErr
+}
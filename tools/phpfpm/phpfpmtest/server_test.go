@@ -0,0 +1,127 @@
+package phpfpmtest_test
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/yookoala/gofast"
+	"github.com/yookoala/gofast/fcgitest"
+	"github.com/yookoala/gofast/tools/phpfpm/phpfpmtest"
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(phpfpmtest.Main(m, nil))
+}
+
+func TestBinary_env(t *testing.T) {
+	defer os.Setenv("TEST_PHPFPM_PATH", os.Getenv("TEST_PHPFPM_PATH"))
+
+	dir, err := ioutil.TempDir("", "phpfpmtest")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Setenv("TEST_PHPFPM_PATH", filepath.Join(dir, "php-fpm"))
+	if _, err := phpfpmtest.Binary(); !os.IsNotExist(err) {
+		t.Errorf("expected not exist error, got %#v", err)
+	}
+	os.Setenv("TEST_PHPFPM_PATH", dir)
+	if _, err := phpfpmtest.Binary(); err == nil {
+		t.Errorf("expected error for a folder, got nil")
+	}
+	ioutil.WriteFile(filepath.Join(dir, "php-fpm"), []byte("#!/bin/sh\n"), 0755)
+	os.Setenv("TEST_PHPFPM_PATH", filepath.Join(dir, "php-fpm"))
+	if want, have := filepath.Join(dir, "php-fpm"), mustBinary(t); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func mustBinary(t *testing.T) string {
+	fpmPath, err := phpfpmtest.Binary()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return fpmPath
+}
+
+func testServer(t *testing.T, fpm *phpfpmtest.Server) {
+	script := filepath.Join(fpm.Dir, "hello.php")
+	if err := ioutil.WriteFile(script, []byte(`<?php echo "hello ", $_GET["name"];`), 0644); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	h := gofast.NewHandler(
+		gofast.NewFileEndpoint(script)(gofast.BasicSession),
+		fpm.ClientFactory(),
+	)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/?name=world", nil))
+	if want, have := "hello world", w.Body.String(); want != have {
+		t.Errorf("expected %#v, got %#v (error log: %s)", want, have, fpm.ErrorLog())
+	}
+}
+
+func TestShared(t *testing.T) {
+	testServer(t, phpfpmtest.Shared(t))
+}
+
+func TestNew(t *testing.T) {
+	fpm := phpfpmtest.New(t, &phpfpmtest.Options{Worker: 1})
+	dir := fpm.Dir
+	testServer(t, fpm)
+
+	if err := fpm.Close(); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("expected %s removed, got %#v", dir, err)
+	}
+	if err := fpm.Close(); err != nil {
+		t.Errorf("unexpected error on second close: %s", err)
+	}
+}
+
+func TestNew_external(t *testing.T) {
+	dir, err := ioutil.TempDir("", "phpfpmtest")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := fcgitest.NewUnixServer(fcgitest.Reply(fcgitest.Body("hello")))
+	defer s.Close()
+	defer os.Setenv("TEST_PHPFPM_ADDRESS", os.Getenv("TEST_PHPFPM_ADDRESS"))
+	defer os.Setenv("TEST_PHPFPM_DIR", os.Getenv("TEST_PHPFPM_DIR"))
+	os.Setenv("TEST_PHPFPM_ADDRESS", "unix:"+s.Address)
+	os.Setenv("TEST_PHPFPM_DIR", dir)
+
+	fpm := phpfpmtest.New(t, nil)
+	if fpm.Process != nil {
+		t.Errorf("expected no process, got %#v", fpm.Process)
+	}
+	if want, have := dir, filepath.Dir(fpm.Dir); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	h := gofast.NewHandler(
+		gofast.NewFileEndpoint(filepath.Join(fpm.Dir, "index.php"))(gofast.BasicSession),
+		fpm.ClientFactory(),
+	)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if want, have := "hello", w.Body.String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := filepath.Join(fpm.Dir, "index.php"), s.LastRequest().Params["SCRIPT_FILENAME"]; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	if err := fpm.Close(); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if _, err := os.Stat(fpm.Dir); !os.IsNotExist(err) {
+		t.Errorf("expected %s removed, got %#v", fpm.Dir, err)
+	}
+}
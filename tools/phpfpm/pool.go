@@ -0,0 +1,201 @@
+package phpfpm
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"gopkg.in/ini.v1"
+)
+
+// PM is the process manager of a pool, which
+// controls the number of the child processes
+type PM string
+
+// Process managers of php-fpm
+const (
+	PMStatic   PM = "static"   // fixed number (MaxChildren)
+	PMDynamic  PM = "dynamic"  // between MinSpareServers and MaxSpareServers idle
+	PMOndemand PM = "ondemand" // spawned on requests, killed after ProcessIdleTimeout
+)
+
+// Pool describes a pool of php-fpm (i.e. a section of
+// the config file). Zero values are not written, so the
+// defaults of php-fpm apply.
+type Pool struct {
+
+	// name of the pool (e.g. "www")
+	Name string
+
+	// The address on which to accept FastCGI requests
+	// (see Process.Listen). Mandatory.
+	Listen string
+
+	// username of the child processes
+	User string
+
+	// process manager. PMStatic if empty.
+	PM PM
+
+	// maximum number of the child processes
+	MaxChildren int
+
+	// number of the child processes created on startup,
+	// and the range of the idle ones (PMDynamic only)
+	StartServers    int
+	MinSpareServers int
+	MaxSpareServers int
+
+	// idle time of a child process to be killed
+	// (PMOndemand only). Written in seconds.
+	ProcessIdleTimeout time.Duration
+
+	// number of the requests served by a child
+	// process before respawning. Unlimited if 0.
+	MaxRequests int
+
+	// URI of the status page of the pool (e.g. "/status")
+	StatusPath string
+
+	// URI of the ping page of the pool (e.g. "/ping"), and
+	// the response of it ("pong" of php-fpm if empty)
+	PingPath     string
+	PingResponse string
+
+	// time to kill the child process serving a request.
+	// Written in seconds. Never if 0.
+	RequestTerminateTimeout time.Duration
+
+	// to write the stdout and stderr of the child
+	// processes to the error log (catch_workers_output)
+	CatchWorkersOutput bool
+
+	// environment variables of the child processes
+	Env map[string]string
+
+	// php.ini values of the pool. PHPAdminValues cannot
+	// be overridden by ini_set of the scripts.
+	PHPValues      map[string]string
+	PHPAdminValues map[string]string
+}
+
+// pools returns the pools of the process, or the
+// default pool "www" of the process attributes
+func (proc *Process) pools() []Pool {
+	if len(proc.Pools) > 0 {
+		return proc.Pools
+	}
+	return []Pool{{
+		Name:        "www",
+		Listen:      proc.Listen,
+		User:        proc.User,
+		MaxChildren: proc.Worker,
+		StatusPath:  proc.StatusPath,
+		PingPath:    proc.PingPath,
+
+		// the output of the workers is of
+		// interest if the log is followed
+		CatchWorkersOutput: proc.Output != nil,
+	}}
+}
+
+// validatePools checks if the pools
+// of the process can be written
+func (proc *Process) validatePools() error {
+	names := make(map[string]bool)
+	for i, pool := range proc.Pools {
+		if pool.Name == "" {
+			return fmt.Errorf("pool %d has no name", i)
+		}
+		if pool.Name == "global" || names[pool.Name] {
+			return fmt.Errorf("pool %q: name is reserved or duplicated", pool.Name)
+		}
+		names[pool.Name] = true
+		if pool.Listen == "" {
+			return fmt.Errorf("pool %q: listen is required", pool.Name)
+		}
+		switch pool.PM {
+		case "", PMStatic, PMDynamic, PMOndemand:
+		default:
+			return fmt.Errorf("pool %q: unknown pm %q", pool.Name, pool.PM)
+		}
+	}
+	return nil
+}
+
+// writePool writes the pool as a section of f
+func writePool(f *ini.File, pool Pool) error {
+	s, err := f.NewSection(pool.Name)
+	if err != nil {
+		return err
+	}
+	add := func(name, value string) {
+		if err == nil {
+			_, err = s.NewKey(name, value)
+		}
+	}
+	addInt := func(name string, value int) {
+		if value != 0 {
+			add(name, fmt.Sprintf("%d", value))
+		}
+	}
+
+	pm := pool.PM
+	if pm == "" {
+		pm = PMStatic
+	}
+	add("listen", listenAddress(pool.Listen))
+	add("pm", string(pm))
+	add("pm.max_children", fmt.Sprintf("%d", pool.MaxChildren))
+	if pool.User != "" {
+		add("user", pool.User)
+	}
+	addInt("pm.start_servers", pool.StartServers)
+	addInt("pm.min_spare_servers", pool.MinSpareServers)
+	addInt("pm.max_spare_servers", pool.MaxSpareServers)
+	addInt("pm.max_requests", pool.MaxRequests)
+	if pool.ProcessIdleTimeout != 0 {
+		add("pm.process_idle_timeout", seconds(pool.ProcessIdleTimeout))
+	}
+	if pool.StatusPath != "" {
+		add("pm.status_path", pool.StatusPath)
+	}
+	if pool.PingPath != "" {
+		add("ping.path", pool.PingPath)
+	}
+	if pool.PingResponse != "" {
+		add("ping.response", pool.PingResponse)
+	}
+	if pool.CatchWorkersOutput {
+		add("catch_workers_output", "yes")
+	}
+	if pool.RequestTerminateTimeout != 0 {
+		add("request_terminate_timeout", seconds(pool.RequestTerminateTimeout))
+	}
+	for _, name := range sortedKeys(pool.Env) {
+		add("env["+name+"]", pool.Env[name])
+	}
+	for _, name := range sortedKeys(pool.PHPValues) {
+		add("php_value["+name+"]", pool.PHPValues[name])
+	}
+	for _, name := range sortedKeys(pool.PHPAdminValues) {
+		add("php_admin_value["+name+"]", pool.PHPAdminValues[name])
+	}
+	return err
+}
+
+// seconds formats the duration in seconds
+// with the unit for php-fpm (e.g. "30s")
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%ds", int64(d/time.Second))
+}
+
+// sortedKeys returns the keys of the map sorted
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,122 @@
+package phpfpm_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/yookoala/gofast/tools/phpfpm"
+)
+
+func TestProcess_Config_default(t *testing.T) {
+	process := &phpfpm.Process{Listen: "/tmp/phpfpm.sock", Worker: 5, User: "www-data"}
+	f, err := process.Config()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	s := f.Section("www")
+	for name, want := range map[string]string{
+		"listen":          "/tmp/phpfpm.sock",
+		"pm":              "static",
+		"pm.max_children": "5",
+		"user":            "www-data",
+	} {
+		if have := s.Key(name).String(); want != have {
+			t.Errorf("%s: expected %#v; got %#v", name, want, have)
+		}
+	}
+}
+
+func TestProcess_Config_pools(t *testing.T) {
+	process := &phpfpm.Process{
+		Pools: []phpfpm.Pool{
+			{
+				Name:                    "app",
+				Listen:                  "127.0.0.1:9000",
+				PM:                      phpfpm.PMDynamic,
+				MaxChildren:             20,
+				StartServers:            4,
+				MinSpareServers:         2,
+				MaxSpareServers:         6,
+				MaxRequests:             500,
+				StatusPath:              "/status",
+				RequestTerminateTimeout: 30 * time.Second,
+				Env:                     map[string]string{"APP_ENV": "test"},
+				PHPValues:               map[string]string{"memory_limit": "256M"},
+				PHPAdminValues:          map[string]string{"display_errors": "off"},
+			},
+			{
+				Name:               "admin",
+				Listen:             "@admin",
+				PM:                 phpfpm.PMOndemand,
+				MaxChildren:        2,
+				ProcessIdleTimeout: time.Minute,
+			},
+		},
+	}
+	f, err := process.Config()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want, have := "[DEFAULT global app admin]", fmt.Sprint(f.SectionStrings()); want != have {
+		t.Errorf("expected %#v; got %#v", want, have)
+	}
+	for section, keys := range map[string]map[string]string{
+		"app": {
+			"listen":                          "127.0.0.1:9000",
+			"pm":                              "dynamic",
+			"pm.max_children":                 "20",
+			"pm.start_servers":                "4",
+			"pm.min_spare_servers":            "2",
+			"pm.max_spare_servers":            "6",
+			"pm.max_requests":                 "500",
+			"pm.status_path":                  "/status",
+			"request_terminate_timeout":       "30s",
+			"env[APP_ENV]":                    "test",
+			"php_value[memory_limit]":         "256M",
+			"php_admin_value[display_errors]": "off",
+		},
+		"admin": {
+			"listen":                  "@admin",
+			"pm":                      "ondemand",
+			"pm.max_children":         "2",
+			"pm.process_idle_timeout": "60s",
+		},
+	} {
+		for name, want := range keys {
+			if have := f.Section(section).Key(name).String(); want != have {
+				t.Errorf("%s %s: expected %#v; got %#v", section, name, want, have)
+			}
+		}
+	}
+	if f.Section("admin").HasKey("pm.start_servers") {
+		t.Errorf("expected zero values not to be written")
+	}
+
+	// Start waits for the first pool without Listen
+	if _, address := process.Address(); "127.0.0.1:9000" != address {
+		t.Errorf("expected %#v; got %#v", "127.0.0.1:9000", address)
+	}
+}
+
+func TestProcess_Config_invalidPools(t *testing.T) {
+	tests := []phpfpm.Pool{
+		{Listen: "/tmp/a.sock"},
+		{Name: "global", Listen: "/tmp/a.sock"},
+		{Name: "a"},
+		{Name: "a", Listen: "/tmp/a.sock", PM: "adaptive"},
+	}
+	for _, pool := range tests {
+		process := &phpfpm.Process{Pools: []phpfpm.Pool{pool}}
+		if _, err := process.Config(); err == nil {
+			t.Errorf("%#v: expected error, got nil", pool)
+		}
+	}
+	process := &phpfpm.Process{Pools: []phpfpm.Pool{
+		{Name: "a", Listen: "/tmp/a.sock"},
+		{Name: "a", Listen: "/tmp/b.sock"},
+	}}
+	if _, err := process.Config(); err == nil {
+		t.Errorf("expected error of duplicated pool, got nil")
+	}
+}
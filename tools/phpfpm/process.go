@@ -1,22 +1,27 @@
 package phpfpm
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"os"
 	"os/exec"
 	"path"
 	"regexp"
+	"runtime"
 	"strconv"
 	"syscall"
 	"time"
 
+	"github.com/yookoala/gofast"
 	"gopkg.in/ini.v1"
 )
 
 // Process describes a minimalistic php-fpm config
-// that runs only 1 pool
+// that runs 1 pool, or the Pools described
 type Process struct {
 
 	// basename for pid / sock / log filename
@@ -36,7 +41,9 @@ type Process struct {
 
 	// The address on which to accept FastCGI requests.
 	// Valid syntaxes are: 'ip.add.re.ss:port', 'port',
-	// '/path/to/unix/socket'. This option is mandatory for each pool.
+	// '/path/to/unix/socket', '@abstract-socket-name' (Linux
+	// only, also accepts NUL byte instead of '@'). This option
+	// is mandatory for each pool.
 	Listen string
 
 	// path of the PID file
@@ -45,8 +52,39 @@ type Process struct {
 	// path of the error log
 	ErrorLog string
 
+	// URI of the status page and the ping page of the
+	// default pool (see Pool), for Status, Ping and Start
+	// to wait for the workers to be ready. With Pools,
+	// those of the first pool are used instead.
+	StatusPath string
+	PingPath   string
+
+	// Pools of the process. If empty, the process runs a static
+	// pool "www" of the Listen, Worker, User and paths above.
+	// Otherwise, Listen is the address that Start waits for,
+	// or the address of the first pool if empty.
+	Pools []Pool
+
+	// Clock of the timeout and the polling intervals
+	// when waiting for the process. SystemClock if nil.
+	Clock gofast.Clock
+
+	// Output receives the output of the php-fpm command on
+	// start, and the error log as written while the process
+	// is running (see SetOutput). Not written if nil.
+	Output io.Writer
+
+	// signal of Stop. os.Interrupt if nil, for php-fpm to
+	// stop at once (as with syscall.SIGTERM). With
+	// syscall.SIGQUIT, php-fpm stops gracefully, after
+	// the requests in-flight are served.
+	StopSignal os.Signal
+
 	// cmd stores the command of the running process
 	cmd *exec.Cmd
+
+	// tail follows the error log to the Output
+	tail *logTail
 }
 
 // NewProcess creates a new process descriptor
@@ -88,21 +126,12 @@ func (proc *Process) Config() (f *ini.File, err error) {
 		return
 	}
 
-	// www
-	if s, err = f.NewSection("www"); err != nil {
-		return
-	}
-	if _, err = s.NewKey("listen", proc.Listen); err != nil {
-		return
-	}
-	if _, err = s.NewKey("pm", "static"); err != nil {
+	// pools
+	if err = proc.validatePools(); err != nil {
 		return
 	}
-	if _, err = s.NewKey("pm.max_children", fmt.Sprintf("%d", proc.Worker)); err != nil {
-		return
-	}
-	if proc.User != "" {
-		if _, err = s.NewKey("user", proc.User); err != nil {
+	for _, pool := range proc.pools() {
+		if err = writePool(f, pool); err != nil {
 			return
 		}
 	}
@@ -144,7 +173,13 @@ func (proc *Process) Start() (err error) {
 			"-e"), // extended information
 	}
 
-	if cmbOut, err := proc.cmd.CombinedOutput(); err != nil {
+	// the error log written from now on
+	offset := proc.logOffset()
+	cmbOut, err := proc.cmd.CombinedOutput()
+	if proc.Output != nil {
+		proc.Output.Write(cmbOut)
+	}
+	if err != nil {
 		var ok bool
 		var exitErr *exec.ExitError
 		if exitErr, ok = err.(*exec.ExitError); !ok {
@@ -164,13 +199,14 @@ func (proc *Process) Start() (err error) {
 		return
 	}
 	proc.cmd.Process = spawned
+	proc.follow(spawned, offset)
 
-	// wait until the service is connectable
-	// or time out
+	// wait until the service is connectable (and
+	// responds the ping, if any) or time out
 	select {
-	case <-proc.waitConn():
+	case <-proc.waitReady():
 		// do nothing
-	case <-time.After(time.Second * 10):
+	case <-proc.clock().After(time.Second * 10):
 		// wait 10 seconds or timeout
 		err = fmt.Errorf("time out")
 	}
@@ -178,6 +214,14 @@ func (proc *Process) Start() (err error) {
 	return
 }
 
+// clock returns the Clock of the process
+func (proc *Process) clock() gofast.Clock {
+	if proc.Clock == nil {
+		return gofast.SystemClock
+	}
+	return proc.Clock
+}
+
 // read pid from pid
 func (proc *Process) pid() (pid int, err error) {
 	f, err := os.Open(proc.PidFile)
@@ -201,7 +245,7 @@ func (proc *Process) waitPid() <-chan int {
 	go func() {
 		for {
 			if pid, err := proc.pid(); err != nil {
-				time.Sleep(time.Millisecond * 2)
+				<-proc.clock().After(time.Millisecond * 2)
 			} else {
 				cout <- pid
 				break
@@ -211,12 +255,34 @@ func (proc *Process) waitPid() <-chan int {
 	return cout
 }
 
+// waitReady waits until the service is connectable, then, if
+// the ping path is set, until the workers respond to the ping
+func (proc *Process) waitReady() <-chan struct{} {
+	ready := make(chan struct{})
+	go func() {
+		(<-proc.waitConn()).Close()
+		if proc.pool().PingPath != "" {
+			for {
+				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+				err := proc.Ping(ctx)
+				cancel()
+				if err == nil {
+					break
+				}
+				<-proc.clock().After(time.Millisecond * 2)
+			}
+		}
+		close(ready)
+	}()
+	return ready
+}
+
 func (proc *Process) waitConn() <-chan net.Conn {
 	chanConn := make(chan net.Conn)
 	go func() {
 		for {
 			if conn, err := net.Dial(proc.Address()); err != nil {
-				time.Sleep(time.Millisecond * 2)
+				<-proc.clock().After(time.Millisecond * 2)
 			} else {
 				chanConn <- conn
 				break
@@ -229,26 +295,107 @@ func (proc *Process) waitConn() <-chan net.Conn {
 // Address returns networkk and address that fits
 // the use of either net.Dial or net.Listen
 func (proc *Process) Address() (network, address string) {
+	listen := proc.Listen
+	if listen == "" && len(proc.Pools) > 0 {
+		listen = proc.Pools[0].Listen
+	}
 	reIP := regexp.MustCompile("^(\\d{1,3}\\.\\d{1,3}\\.\\d{1,3}\\.\\d{1,3})\\:(\\d{2,5}$)")
 	rePort := regexp.MustCompile("^(\\d+)$")
 	switch {
-	case reIP.MatchString(proc.Listen):
+	case isAbstract(listen):
+		network = "unix"
+		address = listenAddress(listen)
+	case reIP.MatchString(listen):
 		network = "tcp"
-		address = proc.Listen
-	case rePort.MatchString(proc.Listen):
+		address = listen
+	case rePort.MatchString(listen):
 		network = "tcp"
-		address = ":" + proc.Listen
+		address = ":" + listen
 	default:
 		network = "unix"
-		address = proc.Listen
+		address = listen
 	}
 	return
 }
 
-// Stop stops the php-fpm process with SIGINT
-// instead of killing
+// isAbstract checks if the address is in the
+// Linux abstract unix socket namespace
+func isAbstract(address string) bool {
+	return len(address) > 1 && (address[0] == '@' || address[0] == 0)
+}
+
+// listenAddress converts NUL byte prefixed abstract
+// unix socket address to the '@' prefixed form
+func listenAddress(address string) string {
+	if isAbstract(address) {
+		return "@" + address[1:]
+	}
+	return address
+}
+
+// errNotStarted is the error of signaling a process not started
+var errNotStarted = errors.New("process not started")
+
+// signal sends the signal to the php-fpm process
+func (proc *Process) signal(sig os.Signal) error {
+	if proc.cmd == nil || proc.cmd.Process == nil {
+		return errNotStarted
+	}
+	return proc.cmd.Process.Signal(sig)
+}
+
+// Stop stops the php-fpm process with the StopSignal
+// (SIGINT if nil) instead of killing
 func (proc *Process) Stop() error {
-	return proc.cmd.Process.Signal(os.Interrupt)
+	if proc.StopSignal != nil {
+		return proc.signal(proc.StopSignal)
+	}
+	return proc.signal(os.Interrupt)
+}
+
+// Reload saves the config file with the current process
+// attributes, then sends SIGUSR2 for php-fpm to reload
+// gracefully. The listening sockets are kept open, so no
+// request is dropped, and the workers are replaced after
+// serving the requests in-flight (up to the
+// process_control_timeout of php-fpm).
+//
+// The changes of PidFile and the addresses of the pools
+// are not applied by a reload. Use Restart instead.
+func (proc *Process) Reload() (err error) {
+	if reloadSignal == nil {
+		return fmt.Errorf("reload is not supported on %s", runtime.GOOS)
+	}
+	if proc.cmd == nil || proc.cmd.Process == nil {
+		return errNotStarted
+	}
+	if err = proc.SaveConfig(proc.ConfigFile); err != nil {
+		return
+	}
+	return proc.signal(reloadSignal)
+}
+
+// Restart saves the config file with the current process
+// attributes, stops the php-fpm process gracefully with
+// SIGQUIT, waits for the requests in-flight to drain and
+// the process to exit, then starts it again.
+//
+// The addresses are not served while the process restarts.
+// Prefer Reload unless the changes need a restart.
+func (proc *Process) Restart() (err error) {
+	if proc.cmd == nil || proc.cmd.Process == nil {
+		return errNotStarted
+	}
+	if err = proc.SaveConfig(proc.ConfigFile); err != nil {
+		return
+	}
+	if err = proc.signal(syscall.SIGQUIT); err != nil {
+		return
+	}
+	if err = proc.Wait(); err != nil {
+		return
+	}
+	return proc.Start()
 }
 
 // Wait wait for the process to finish
@@ -263,7 +410,7 @@ func (proc *Process) Wait() (err error) {
 			}
 			break
 		} else {
-			time.Sleep(time.Millisecond * 2)
+			<-proc.clock().After(time.Millisecond * 2)
 		}
 	}
 	return
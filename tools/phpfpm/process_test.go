@@ -1,12 +1,15 @@
 package phpfpm_test
 
 import (
+	"net"
 	"os"
 	"path"
+	"syscall"
 	"testing"
 	"time"
 
 	"github.com/yookoala/gofast/tools/phpfpm"
+	"gopkg.in/ini.v1"
 )
 
 var username, basepath, pathToPhpFpm string
@@ -92,6 +95,28 @@ func TestProcess_Address(t *testing.T) {
 		t.Errorf("expected %#v; got %#v", want, have)
 	}
 
+	for _, listen := range []string{"@php-fpm", "\x00php-fpm"} {
+		process.Listen = listen
+		network, address = process.Address()
+		if want, have := "unix", network; want != have {
+			t.Errorf("expected %#v; got %#v", want, have)
+		}
+		if want, have := "@php-fpm", address; want != have {
+			t.Errorf("expected %#v; got %#v", want, have)
+		}
+	}
+
+}
+
+func TestProcess_Config_abstract(t *testing.T) {
+	process := &phpfpm.Process{Listen: "\x00php-fpm"}
+	f, err := process.Config()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want, have := "@php-fpm", f.Section("www").Key("listen").String(); want != have {
+		t.Errorf("expected %#v; got %#v", want, have)
+	}
 }
 
 func TestProcess_StartStop(t *testing.T) {
@@ -123,6 +148,58 @@ func TestProcess_StartStop(t *testing.T) {
 	}
 }
 
+func TestProcess_notStarted(t *testing.T) {
+	process := phpfpm.NewProcess(pathToPhpFpm)
+	for name, fn := range map[string]func() error{
+		"Stop":    process.Stop,
+		"Reload":  process.Reload,
+		"Restart": process.Restart,
+	} {
+		if err := fn(); err == nil {
+			t.Errorf("%s: expected error, got nil", name)
+		}
+	}
+}
+
+func TestProcess_ReloadRestart(t *testing.T) {
+	path := pathToPhpFpm
+	process := phpfpm.NewProcess(path)
+	process.SetDatadir(basepath + "/var")
+	process.User = username
+	process.StopSignal = syscall.SIGQUIT
+	if err := process.SaveConfig(basepath + "/etc/test.reload.conf"); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if err := process.Start(); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	defer process.Wait()
+	defer process.Stop()
+
+	// the config file is saved with the changes
+	process.Worker = 2
+	if err := process.Reload(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	f, err := ini.Load(process.ConfigFile)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if want, have := "2", f.Section("www").Key("pm.max_children").String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	// connectable after the restart
+	if err := process.Restart(); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	conn, err := net.Dial(process.Address())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	conn.Close()
+}
+
 func ExampleProcess() {
 
 	process := phpfpm.NewProcess(pathToPhpFpm)
@@ -0,0 +1,13 @@
+//go:build !windows
+// +build !windows
+
+package phpfpm
+
+import (
+	"os"
+	"syscall"
+)
+
+// reloadSignal is the signal of php-fpm to reload the
+// config and the workers gracefully (see Reload)
+var reloadSignal os.Signal = syscall.SIGUSR2
@@ -0,0 +1,12 @@
+//go:build windows
+// +build windows
+
+package phpfpm
+
+import (
+	"os"
+)
+
+// reloadSignal is the signal of php-fpm to reload. None
+// on Windows, which has no SIGUSR2 to send.
+var reloadSignal os.Signal
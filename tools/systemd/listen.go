@@ -0,0 +1,93 @@
+// Package systemd implements the systemd socket activation and service
+// notification protocols, so a gateway built with gofast can be run as a
+// socket-activated systemd unit.
+//
+// See sd_listen_fds(3) and sd_notify(3) for the protocols.
+package systemd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// listenFdsStart is the first file descriptor passed by systemd
+// (SD_LISTEN_FDS_START)
+var listenFdsStart = 3
+
+// Files returns the files passed by systemd socket activation. The names
+// of the files are set by FileDescriptorName= in the socket unit (or
+// "unknown" if not set).
+//
+// Returns nil if the process is not socket activated. The related
+// environment variables are unset so they will not be passed to the
+// child processes.
+func Files() []*os.File {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil
+	}
+	nfds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || nfds <= 0 {
+		return nil
+	}
+	var names []string
+	if fdnames := os.Getenv("LISTEN_FDNAMES"); fdnames != "" {
+		names = strings.Split(fdnames, ":")
+	}
+
+	files := make([]*os.File, 0, nfds)
+	for i := 0; i < nfds; i++ {
+		name := "unknown"
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		files = append(files, os.NewFile(uintptr(listenFdsStart+i), name))
+	}
+	return files
+}
+
+// Listeners returns the net.Listener(s) passed by systemd socket
+// activation, in the order of the ListenStream= directives.
+//
+// Returns an empty slice if the process is not socket activated. Returns
+// error if any of the files is not a stream socket (e.g. ListenDatagram=).
+func Listeners() ([]net.Listener, error) {
+	files := Files()
+	listeners := make([]net.Listener, 0, len(files))
+	for i, f := range files {
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("systemd: file descriptor %d `%s` is not a listener: %s",
+				listenFdsStart+i, f.Name(), err)
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// ListenersWithNames returns the net.Listener(s) passed by systemd socket
+// activation, by the FileDescriptorName= of the socket units.
+func ListenersWithNames() (map[string][]net.Listener, error) {
+	files := Files()
+	listeners := make(map[string][]net.Listener, len(files))
+	for i, f := range files {
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("systemd: file descriptor %d `%s` is not a listener: %s",
+				listenFdsStart+i, f.Name(), err)
+		}
+		listeners[f.Name()] = append(listeners[f.Name()], l)
+	}
+	return listeners, nil
+}
@@ -0,0 +1,71 @@
+//go:build !windows
+// +build !windows
+
+package systemd
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+// passListener pretends the listener is passed by systemd with the name
+func passListener(t *testing.T, l *net.TCPListener, name string) {
+	f, err := l.File()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	fd, err := syscall.Dup(int(f.Fd()))
+	f.Close()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	listenFdsStart = fd
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	os.Setenv("LISTEN_FDS", "1")
+	os.Setenv("LISTEN_FDNAMES", name)
+}
+
+func TestListeners(t *testing.T) {
+	defer func() { listenFdsStart = 3 }()
+
+	l, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer l.Close()
+
+	passListener(t, l, "http")
+	listeners, err := ListenersWithNames()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want, have := 1, len(listeners["http"]); want != have {
+		t.Fatalf("expected %#v, got %#v", want, have)
+	}
+	passed := listeners["http"][0]
+	defer passed.Close()
+	if want, have := l.Addr().String(), passed.Addr().String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	// environment is unset after use
+	if want, have := "", os.Getenv("LISTEN_FDS"); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if listeners, err := Listeners(); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	} else if want, have := 0, len(listeners); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestFiles_otherProcess(t *testing.T) {
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	os.Setenv("LISTEN_FDS", "1")
+	if files := Files(); files != nil {
+		t.Errorf("expected nil, got %#v", files)
+	}
+}
@@ -0,0 +1,52 @@
+package systemd
+
+import (
+	"net"
+	"os"
+)
+
+// Service states to notify systemd of. See sd_notify(3).
+const (
+	// Ready tells systemd the service startup is finished.
+	Ready = "READY=1"
+
+	// Stopping tells systemd the service is beginning its shutdown.
+	Stopping = "STOPPING=1"
+
+	// Reloading tells systemd the service is reloading its
+	// configuration. Send Ready when done.
+	Reloading = "RELOADING=1"
+
+	// Watchdog updates the watchdog timestamp.
+	Watchdog = "WATCHDOG=1"
+)
+
+// Notify sends the state (e.g. Ready) to systemd through the socket in
+// NOTIFY_SOCKET environment variable. Multiple states can be sent at
+// once by separating them with newline.
+//
+// Returns false with nil error if the socket is not set (e.g. the unit
+// is not Type=notify, or not run by systemd).
+func Notify(state string) (sent bool, err error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+
+	// abstract socket address
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{
+		Name: socket,
+		Net:  "unixgram",
+	})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
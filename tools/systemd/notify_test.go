@@ -0,0 +1,55 @@
+//go:build !windows
+// +build !windows
+
+package systemd_test
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/yookoala/gofast/tools/systemd"
+)
+
+func TestNotify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gofast-systemd-")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer conn.Close()
+
+	os.Setenv("NOTIFY_SOCKET", socket)
+	defer os.Unsetenv("NOTIFY_SOCKET")
+	if sent, err := systemd.Notify(systemd.Ready); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if !sent {
+		t.Errorf("expected sent")
+	}
+
+	b := make([]byte, 64)
+	n, err := conn.Read(b)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want, have := "READY=1", string(b[:n]); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestNotify_noSocket(t *testing.T) {
+	os.Unsetenv("NOTIFY_SOCKET")
+	if sent, err := systemd.Notify(systemd.Ready); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	} else if sent {
+		t.Errorf("expected not sent")
+	}
+}
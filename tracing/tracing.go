@@ -0,0 +1,173 @@
+// Package tracing traces the FastCGI requests of gofast with
+// OpenTelemetry.
+//
+// The middleware creates a child span of the span in the context of the
+// http request (e.g. of otelhttp) for every FastCGI request, and passes
+// the trace context to the application as the CGI params of the headers
+// (e.g. HTTP_TRACEPARENT), so the instrumentation of the application
+// (e.g. of PHP) continues the trace. It should be the last of a Chain,
+// after the middlewares mapping SCRIPT_NAME and the headers.
+//
+//	sess := gofast.Chain(
+//		gofast.NewPHPFS("/var/www/html"),
+//		tracing.NewMiddleware(nil, nil),
+//	)(gofast.BasicSession)
+//	h := otelhttp.NewHandler(gofast.NewHandler(sess, clientFactory), "php")
+package tracing
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yookoala/gofast"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName is the name of the default tracer
+const instrumentationName = "github.com/yookoala/gofast/tracing"
+
+// Names of the span events
+const (
+	EventConnected   = "gofast.connected"
+	EventFirstStdout = "gofast.first_stdout"
+	EventEndRequest  = "gofast.end_request"
+)
+
+// NewMiddleware returns a gofast.Middleware which traces the FastCGI
+// requests with spans of the tracer (of otel.GetTracerProvider if nil)
+// and injects the trace context with the propagator (of
+// otel.GetTextMapPropagator if nil).
+//
+// The span is named by the SCRIPT_NAME, and starts when the Handler
+// starts getting the client (see gofast.Request.ConnectTime). The
+// events of the span are:
+//
+//	gofast.connected     the client is ready (the wait of ClientPool and the dial)
+//	gofast.first_stdout  the first FCGI_STDOUT record is received
+//	gofast.end_request   the request ends
+//
+// Each event has the attribute "gofast.duration_ms" of the time since
+// the start of the span.
+func NewMiddleware(tracer trace.Tracer, propagator propagation.TextMapPropagator) gofast.Middleware {
+	return func(inner gofast.SessionHandler) gofast.SessionHandler {
+		return func(client gofast.Client, req *gofast.Request) (*gofast.ResponsePipe, error) {
+			tr, prop := tracer, propagator
+			if tr == nil {
+				tr = otel.GetTracerProvider().Tracer(instrumentationName)
+			}
+			if prop == nil {
+				prop = otel.GetTextMapPropagator()
+			}
+
+			ctx := context.Background()
+			if req.Raw != nil {
+				ctx = req.Raw.Context()
+			}
+			start, connected, ok := req.ConnectTime()
+			if !ok {
+				start = time.Now()
+			}
+			ctx, span := tr.Start(ctx, spanName(req),
+				trace.WithSpanKind(trace.SpanKindClient),
+				trace.WithTimestamp(start),
+				trace.WithAttributes(attributes(req)...),
+			)
+			if ok {
+				addEvent(span, EventConnected, start, connected)
+			}
+
+			// the trace context as the params of the headers
+			carrier := propagation.MapCarrier{}
+			prop.Inject(ctx, carrier)
+			for k, v := range carrier {
+				req.Params["HTTP_"+strings.ToUpper(strings.Replace(k, "-", "_", -1))] = v
+			}
+
+			// the previous trace is restored for the request
+			// sent again (e.g. by a retry middleware)
+			prev := req.Trace
+			defer func() {
+				req.Trace = prev
+			}()
+			var firstStdout sync.Once
+			req.Trace = &gofast.ClientTrace{
+				GotStdout: func(n int) {
+					firstStdout.Do(func() {
+						addEvent(span, EventFirstStdout, start, time.Now())
+					})
+					if prev != nil && prev.GotStdout != nil {
+						prev.GotStdout(n)
+					}
+				},
+				GotStderr: func(n int) {
+					if prev != nil && prev.GotStderr != nil {
+						prev.GotStderr(n)
+					}
+				},
+				Done: func(err error) {
+					end := time.Now()
+					addEvent(span, EventEndRequest, start, end)
+					endSpan(span, err, end)
+					if prev != nil && prev.Done != nil {
+						prev.Done(err)
+					}
+				},
+			}
+
+			resp, err := inner(client, req)
+			if err != nil {
+				// the request is not sent, or
+				// failed before it is sent
+				endSpan(span, err, time.Now())
+			}
+			return resp, err
+		}
+	}
+}
+
+// spanName returns the name of the span of the request
+func spanName(req *gofast.Request) string {
+	if name := req.Params["SCRIPT_NAME"]; name != "" {
+		return name
+	}
+	return "fastcgi"
+}
+
+// attributes returns the attributes of the span of the request
+func attributes(req *gofast.Request) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		attribute.Int("fastcgi.role", int(req.Role)),
+	}
+	for _, p := range []struct{ name, key string }{
+		{"SCRIPT_FILENAME", "fastcgi.script_filename"},
+		{"REQUEST_METHOD", "http.request.method"},
+	} {
+		if v, ok := req.Params[p.name]; ok {
+			attrs = append(attrs, attribute.String(p.key, v))
+		}
+	}
+	return attrs
+}
+
+// addEvent adds the event at the time, with the duration since start
+func addEvent(span trace.Span, name string, start, t time.Time) {
+	span.AddEvent(name,
+		trace.WithTimestamp(t),
+		trace.WithAttributes(attribute.Float64("gofast.duration_ms", float64(t.Sub(start))/float64(time.Millisecond))),
+	)
+}
+
+// endSpan ends the span with the error of the request, if any
+func endSpan(span trace.Span, err error, end time.Time) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End(trace.WithTimestamp(end))
+}
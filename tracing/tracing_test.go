@@ -0,0 +1,75 @@
+package tracing_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yookoala/gofast"
+	"github.com/yookoala/gofast/tracing"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// pipeConnFactory connects to the server with net.Pipe
+func pipeConnFactory(s *gofast.Server) gofast.ConnFactory {
+	return func() (net.Conn, error) {
+		appConn, webConn := net.Pipe()
+		go s.ServeConn(appConn)
+		return webConn, nil
+	}
+}
+
+func TestNewMiddleware(t *testing.T) {
+	traceparent := make(chan string, 1)
+	s := gofast.NewServer(func(ctx context.Context, req *gofast.Request, stdout, stderr io.Writer) int {
+		traceparent <- req.Params["HTTP_TRACEPARENT"]
+		fmt.Fprint(stdout, "Content-Type: text/plain\r\n\r\nhello")
+		return 0
+	})
+	sr := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+	sess := gofast.Chain(
+		gofast.NewPHPFS("/var/www/html"),
+		tracing.NewMiddleware(tp.Tracer("test"), propagation.TraceContext{}),
+	)(gofast.BasicSession)
+	h := gofast.NewHandler(sess, gofast.SimpleClientFactory(pipeConnFactory(s)))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/index.php", nil))
+	if want, have := "hello", w.Body.String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	// the span may end after the response is written
+	for i := 0; i < 50 && len(sr.Ended()) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	spans := sr.Ended()
+	if want, have := 1, len(spans); want != have {
+		t.Fatalf("expected %#v, got %#v", want, have)
+	}
+	span := spans[0]
+	if want, have := "/index.php", span.Name(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	var events []string
+	for _, e := range span.Events() {
+		events = append(events, e.Name)
+	}
+	if want, have := "gofast.connected,gofast.first_stdout,gofast.end_request", strings.Join(events, ","); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	// the application continues the trace of the span
+	want := fmt.Sprintf("00-%s-%s-01", span.SpanContext().TraceID(), span.SpanContext().SpanID())
+	if have := <-traceparent; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
@@ -0,0 +1,139 @@
+package gofast
+
+import (
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+)
+
+// MapTryFiles returns a Middleware that routes requests like the nginx
+// directive "try_files $uri $uri/ /index.php?$args" for applications
+// with a front controller (e.g. Laravel, Symfony or WordPress):
+//
+//  1. A PHP script in the docroot (e.g. "/wp-login.php", with optional
+//     path info) is routed to the script itself.
+//  2. A directory with an "index.php" is routed to the index.
+//  3. Otherwise, the request is routed to the fallback script (e.g.
+//     "/index.php"), a web path in the docroot.
+//
+// The other regular files in the docroot are to be served before the
+// application is contacted, by the http.Handler of NewTryFilesHandler.
+//
+// The query string and REQUEST_URI of the original request are kept. On
+// fallback, PATH_INFO is the original request path for the router of
+// the front controller.
+//
+// Parameters included:
+//  PATH_INFO
+//  PATH_TRANSLATED
+//  SCRIPT_NAME
+//  SCRIPT_FILENAME
+//  DOCUMENT_URI
+//  DOCUMENT_ROOT
+//
+func MapTryFiles(docroot, fallback string) Middleware {
+	docroot = filepath.Join(docroot)
+	fallback = path.Clean("/" + fallback)
+	return Describe("gofast.MapTryFiles", "DocRoot="+docroot+" fallback="+fallback, func(inner SessionHandler) SessionHandler {
+		return func(client Client, req *Request) (*ResponsePipe, error) {
+			urlPath := path.Clean("/" + req.Raw.URL.Path)
+
+			fastcgiScriptName, fastcgiPathInfo := fallback, req.Raw.URL.Path
+			if scriptName, pathInfo, ok := tryScript(docroot, urlPath); ok {
+				fastcgiScriptName, fastcgiPathInfo = scriptName, pathInfo
+			}
+
+			req.Params["PATH_INFO"] = fastcgiPathInfo
+			req.Params["PATH_TRANSLATED"] = filepath.Join(docroot, fastcgiPathInfo)
+			req.Params["SCRIPT_NAME"] = fastcgiScriptName
+			req.Params["SCRIPT_FILENAME"] = filepath.Join(docroot, fastcgiScriptName)
+			req.Params["DOCUMENT_URI"] = fastcgiScriptName
+			req.Params["DOCUMENT_ROOT"] = docroot
+			return inner(client, req)
+		}
+	})
+}
+
+// NewTryFilesEndpoint chains BasicParamsMap, MapHeader and MapTryFiles to
+// implement Middleware that prepares a session environment for PHP
+// applications with the front controller fallback (e.g. "/index.php").
+func NewTryFilesEndpoint(docroot, fallback string) Middleware {
+	return Chain(
+		BasicParamsMap,
+		MapHeader,
+		MapTryFiles(docroot, fallback),
+	)
+}
+
+// NewTryFilesHandler returns an http.Handler for PHP applications with
+// a front controller (e.g. "/index.php"). The GET and HEAD requests of
+// the regular files in the docroot, other than PHP scripts, are served
+// directly (with Range and conditional request support), without a
+// client from the ClientFactory. The other requests are served by the
+// FastCGI application, as routed by NewTryFilesEndpoint.
+func NewTryFilesHandler(docroot, fallback string, clientFactory ClientFactory, options ...HandlerOption) http.Handler {
+	return NewFileServerHandler(
+		NewHandler(NewTryFilesEndpoint(docroot, fallback)(BasicSession), clientFactory, options...),
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			serveFile(w, r, filepath.Join(docroot, filepath.FromSlash(path.Clean("/"+r.URL.Path))))
+		}),
+		matchTryFiles(docroot),
+	)
+}
+
+// pathinfoRe splits the script and the path info of a PHP path
+var pathinfoRe = regexp.MustCompile(`^(.+\.php)(/.*)?$`)
+
+// tryScript returns the script and the path info of the url path, if
+// it is of a PHP script or a directory with an "index.php" in the docroot
+func tryScript(docroot, urlPath string) (scriptName, pathInfo string, ok bool) {
+	if matches := pathinfoRe.FindStringSubmatch(urlPath); len(matches) > 0 && isRegularFile(filepath.Join(docroot, matches[1])) {
+		return matches[1], matches[2], true
+	}
+	if fi, err := os.Stat(filepath.Join(docroot, filepath.FromSlash(urlPath))); err == nil && fi.IsDir() {
+		if index := path.Join(urlPath, "index.php"); isRegularFile(filepath.Join(docroot, index)) {
+			return index, "", true
+		}
+	}
+	return "", "", false
+}
+
+// matchTryFiles returns a function that matches the requests
+// not of the static files in the docroot
+func matchTryFiles(docroot string) func(r *http.Request) bool {
+	docroot = filepath.Join(docroot)
+	return func(r *http.Request) bool {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			return true
+		}
+		urlPath := path.Clean("/" + r.URL.Path)
+		if _, _, ok := tryScript(docroot, urlPath); ok {
+			return true
+		}
+		return !isRegularFile(filepath.Join(docroot, filepath.FromSlash(urlPath)))
+	}
+}
+
+// isRegularFile returns true if the file exists and is a regular file
+func isRegularFile(filename string) bool {
+	fi, err := os.Stat(filename)
+	return err == nil && fi.Mode().IsRegular()
+}
+
+// serveFile serves the file with http.ServeContent
+func serveFile(w http.ResponseWriter, r *http.Request, filename string) {
+	f, err := os.Open(filename)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil || !fi.Mode().IsRegular() {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeContent(w, r, fi.Name(), fi.ModTime(), f)
+}
@@ -0,0 +1,135 @@
+package gofast_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/yookoala/gofast"
+)
+
+func newTryFilesTest(t *testing.T) (root string) {
+	root, err := ioutil.TempDir("", "gofast-tryfiles-")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for _, dir := range []string{"blog", "css"} {
+		if err := os.Mkdir(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	for _, name := range []string{"index.php", "wp-login.php", "blog/index.php", "css/style.css"} {
+		if err := ioutil.WriteFile(filepath.Join(root, name), []byte("body {}"), 0644); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	return
+}
+
+func TestMapTryFiles(t *testing.T) {
+	root := newTryFilesTest(t)
+	defer os.RemoveAll(root)
+
+	tests := []struct {
+		method     string
+		url        string
+		scriptName string
+		pathInfo   string
+	}{
+		{"GET", "/users/42?page=2", "/index.php", "/users/42"},
+		{"GET", "/wp-login.php", "/wp-login.php", ""},
+		{"GET", "/index.php/users/42", "/index.php", "/users/42"},
+		{"GET", "/missing.php", "/index.php", "/missing.php"},
+		{"GET", "/blog/", "/blog/index.php", ""},
+		{"GET", "/css/", "/index.php", "/css/"},
+		{"POST", "/css/style.css", "/index.php", "/css/style.css"},
+
+		// the static files are served by NewTryFilesHandler before
+		{"GET", "/css/style.css", "/index.php", "/css/style.css"},
+	}
+	for _, test := range tests {
+		var params map[string]string
+		c := gofast.ClientFunc(func(req *gofast.Request) (*gofast.ResponsePipe, error) {
+			params = req.Params
+			return nil, nil
+		})
+		r := httptest.NewRequest(test.method, test.url, nil)
+		if _, err := gofast.NewTryFilesEndpoint(root, "index.php")(gofast.BasicSession)(c, gofast.NewRequest(r)); err != nil {
+			t.Errorf("%s: unexpected error: %s", test.url, err)
+			continue
+		}
+		if params == nil {
+			t.Errorf("%s: expected the request sent to the application", test.url)
+			continue
+		}
+		if want, have := test.scriptName, params["SCRIPT_NAME"]; want != have {
+			t.Errorf("%s: expected SCRIPT_NAME %#v, got %#v", test.url, want, have)
+		}
+		if want, have := filepath.Join(root, test.scriptName), params["SCRIPT_FILENAME"]; want != have {
+			t.Errorf("%s: expected SCRIPT_FILENAME %#v, got %#v", test.url, want, have)
+		}
+		if want, have := test.pathInfo, params["PATH_INFO"]; want != have {
+			t.Errorf("%s: expected PATH_INFO %#v, got %#v", test.url, want, have)
+		}
+		if want, have := test.url, params["REQUEST_URI"]; want != have {
+			t.Errorf("%s: expected REQUEST_URI %#v, got %#v", test.url, want, have)
+		}
+	}
+}
+
+func TestNewTryFilesHandler(t *testing.T) {
+	root := newTryFilesTest(t)
+	defer os.RemoveAll(root)
+
+	// the application is down
+	connected := 0
+	h := gofast.NewTryFilesHandler(root, "/index.php", func() (gofast.Client, error) {
+		connected++
+		return nil, fmt.Errorf("connection refused")
+	})
+
+	// the static files are served without a client
+	for _, method := range []string{"GET", "HEAD"} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(method, "/css/style.css", nil))
+		if want, have := http.StatusOK, w.Code; want != have {
+			t.Errorf("%s: expected %#v, got %#v", method, want, have)
+		}
+		if want, have := "text/css; charset=utf-8", w.Header().Get("Content-Type"); want != have {
+			t.Errorf("%s: expected %#v, got %#v", method, want, have)
+		}
+		if want, have := "7", w.Header().Get("Content-Length"); want != have {
+			t.Errorf("%s: expected %#v, got %#v", method, want, have)
+		}
+		body := "body {}"
+		if method == "HEAD" {
+			body = ""
+		}
+		if want, have := body, w.Body.String(); want != have {
+			t.Errorf("%s: expected %#v, got %#v", method, want, have)
+		}
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/css/style.css", nil))
+	if want, have := 0, connected; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	// the others are of the application
+	for _, url := range []string{"/wp-login.php", "/blog/", "/users/42"} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		if want, have := http.StatusBadGateway, w.Code; want != have {
+			t.Errorf("%s: expected %#v, got %#v", url, want, have)
+		}
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/css/style.css", nil))
+	if want, have := 4, connected; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
@@ -0,0 +1,10 @@
+module github.com/yookoala/gofast/wasmphp
+
+go 1.16
+
+require (
+	github.com/tetratelabs/wazero v1.6.0
+	github.com/yookoala/gofast v0.0.0
+)
+
+replace github.com/yookoala/gofast => ../
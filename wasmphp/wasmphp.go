@@ -0,0 +1,132 @@
+// Package wasmphp provides an experimental gofast backend which runs a
+// WASI build of php-cgi (e.g. the ones from VMware Wasm Labs) inside the
+// wazero runtime. No php-fpm binary (or any native PHP) is needed, which
+// makes fully self-contained test environments possible.
+//
+// Every request instantiates a fresh php-cgi module in CGI mode, with
+// the FastCGI params as environment variables. It is much slower than
+// php-fpm. Do not use it in production.
+package wasmphp
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/tetratelabs/wazero/sys"
+	"github.com/yookoala/gofast"
+)
+
+// Config configures the Backend
+type Config struct {
+
+	// DocRoot is the host directory of the PHP scripts. It is mounted
+	// in the guest at the same path, so the SCRIPT_FILENAME mapped by
+	// the usual middlewares (e.g. gofast.NewPHPFS) works as is.
+	DocRoot string
+
+	// Mounts are extra host directories to mount in the guest,
+	// by guest path (e.g. {"/tmp": "/path/on/host/tmp"}).
+	Mounts map[string]string
+
+	// Args are the extra command line arguments for php-cgi
+	// (e.g. "-d", "display_errors=stderr").
+	Args []string
+}
+
+// Backend runs the php-cgi WASM module for each request
+type Backend struct {
+	config   Config
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+	server   *gofast.Server
+}
+
+// New compiles the given php-cgi WASM binary and returns a *Backend.
+// The ctx is used in compilation only.
+func New(ctx context.Context, wasm []byte, config Config) (b *Backend, err error) {
+	r := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithCloseOnContextDone(true))
+	wasi_snapshot_preview1.MustInstantiate(ctx, r)
+
+	compiled, err := r.CompileModule(ctx, wasm)
+	if err != nil {
+		r.Close(ctx)
+		return nil, fmt.Errorf("wasmphp: unable to compile module: %s", err)
+	}
+	b = &Backend{
+		config:   config,
+		runtime:  r,
+		compiled: compiled,
+	}
+	b.server = gofast.NewServer(b.ServeFastCGI)
+	return b, nil
+}
+
+// ServeFastCGI implements gofast.ServerHandler. It runs php-cgi with the
+// request params as environment, and the request stdin as stdin.
+func (b *Backend) ServeFastCGI(ctx context.Context, req *gofast.Request, stdout, stderr io.Writer) (appStatus int) {
+	fsConfig := wazero.NewFSConfig()
+	if b.config.DocRoot != "" {
+		fsConfig = fsConfig.WithReadOnlyDirMount(b.config.DocRoot, b.config.DocRoot)
+	}
+	for guestPath, dir := range b.config.Mounts {
+		fsConfig = fsConfig.WithDirMount(dir, guestPath)
+	}
+
+	moduleConfig := wazero.NewModuleConfig().
+		WithName(""). // allows concurrent instances
+		WithArgs(append([]string{"php-cgi"}, b.config.Args...)...).
+		WithStdout(stdout).
+		WithStderr(stderr).
+		WithFSConfig(fsConfig).
+		WithSysWalltime().
+		WithSysNanotime().
+		WithRandSource(rand.Reader)
+	if req.Stdin != nil {
+		moduleConfig = moduleConfig.WithStdin(req.Stdin)
+	}
+	for k, v := range req.Params {
+		moduleConfig = moduleConfig.WithEnv(k, v)
+	}
+
+	mod, err := b.runtime.InstantiateModule(ctx, b.compiled, moduleConfig)
+	if mod != nil {
+		mod.Close(ctx)
+	}
+	if exitErr, ok := err.(*sys.ExitError); ok {
+		return int(exitErr.ExitCode())
+	} else if err != nil {
+		fmt.Fprintf(stderr, "wasmphp: %s", err)
+		return 1
+	}
+	return 0
+}
+
+// ConnFactory returns a gofast.ConnFactory of in-memory connections
+// to the Backend.
+func (b *Backend) ConnFactory() gofast.ConnFactory {
+	return func() (net.Conn, error) {
+		appConn, webConn := net.Pipe()
+		go b.server.ServeConn(appConn)
+		return webConn, nil
+	}
+}
+
+// ClientFactory returns a gofast.ClientFactory of clients to the
+// Backend. This allows the backend to be used with gofast.NewHandler
+// as if it is a php-fpm.
+func (b *Backend) ClientFactory() gofast.ClientFactory {
+	return gofast.SimpleClientFactory(b.ConnFactory())
+}
+
+// Close closes the in-memory connections
+// and the WASM runtime.
+func (b *Backend) Close(ctx context.Context) error {
+	b.server.Close()
+	return b.runtime.Close(ctx)
+}
@@ -0,0 +1,67 @@
+package wasmphp_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/yookoala/gofast"
+	"github.com/yookoala/gofast/wasmphp"
+)
+
+// newBackend creates a Backend with the php-cgi WASM binary
+// specified by environment TEST_PHP_WASM_PATH
+func newBackend(t *testing.T, docroot string) *wasmphp.Backend {
+	wasmPath := os.Getenv("TEST_PHP_WASM_PATH")
+	if wasmPath == "" {
+		t.Skip("TEST_PHP_WASM_PATH is not set")
+	}
+	wasm, err := ioutil.ReadFile(wasmPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	b, err := wasmphp.New(context.Background(), wasm, wasmphp.Config{
+		DocRoot: docroot,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return b
+}
+
+func TestBackend(t *testing.T) {
+	docroot, err := ioutil.TempDir("", "gofast-wasmphp-")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(docroot)
+	script := `<?php header("X-Powered-By: wasm"); echo "hello " . $_GET["name"];`
+	if err := ioutil.WriteFile(filepath.Join(docroot, "index.php"), []byte(script), 0644); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	b := newBackend(t, docroot)
+	defer b.Close(context.Background())
+
+	h := gofast.NewHandler(
+		gofast.NewPHPFS(docroot)(gofast.BasicSession),
+		b.ClientFactory(),
+	)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/index.php?name=world", nil))
+	if want, have := "hello world", w.Body.String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "wasm", w.Header().Get("X-Powered-By"); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestNew_invalidModule(t *testing.T) {
+	if _, err := wasmphp.New(context.Background(), []byte("not a wasm module"), wasmphp.Config{}); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+}